@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+type bunchView struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Desc      string    `json:"desc"`
+	Active    bool      `json:"active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newBunchView(b *storage.Bunch) *bunchView {
+	return &bunchView{b.ID, b.Name, b.Desc, b.Active.Bool, b.UpdatedAt}
+}
+
+var bunchHeaders = []string{"ID", "NAME", "DESC", "ACTIVE", "UPDATED AT"}
+
+func (v *bunchView) row() []string {
+	return []string{formatID(v.ID), v.Name, v.Desc, strconv.FormatBool(v.Active), formatTime(v.UpdatedAt)}
+}
+
+type bunchKeyView struct {
+	ID    int64  `json:"id"`
+	Bunch string `json:"bunch"`
+	Key   string `json:"key"`
+}
+
+type bunchParentView struct {
+	ID     int64  `json:"id"`
+	Bunch  string `json:"bunch"`
+	Parent string `json:"parent"`
+}
+
+func bunchCreate(ctx context.Context, a *app, args []string) error {
+	var b storage.CreateBunch
+
+	fs := newFlagSet(a, "bunch create")
+	fs.StringVar(&b.Name, "name", "", "")
+	fs.StringVar(&b.Desc, "desc", "", "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if len(b.Name) == 0 {
+		return errUsage
+	}
+
+	id, err := a.repo.Bunches().Insert(ctx, b)
+	if err != nil {
+		return err
+	}
+
+	bunch, err := a.repo.Bunches().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	v := newBunchView(bunch)
+	return a.out.table(v, bunchHeaders, [][]string{v.row()})
+}
+
+func bunchGrantKey(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "bunch grant-key"), args, 2)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = a.repo.WithTx(ctx, func(tx storage.Stores) error {
+		bunch, err := tx.Bunches().GetByName(ctx, values[0])
+		if err != nil {
+			return notFound(err, "bunch", values[0])
+		}
+
+		key, err := tx.Keys().GetByName(ctx, values[1])
+		if err != nil {
+			return notFound(err, "key", values[1])
+		}
+
+		id, err = tx.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: bunch.ID, KeyID: key.ID})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.out.done(&bunchKeyView{id, values[0], values[1]}, "key %s granted to bunch %s", values[1], values[0])
+}
+
+func bunchRevokeKey(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "bunch revoke-key"), args, 2)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = a.repo.WithTx(ctx, func(tx storage.Stores) error {
+		rows, _, err := tx.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: values[0], KeyName: values[1], Limit: 1},
+			storage.SortBunchKey{})
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return notFound(storage.ErrNotFound, "grant", values[1]+" of "+values[0])
+		}
+
+		id = rows[0].BunchKey.ID
+		return tx.BunchKeys().Delete(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.out.done(&bunchKeyView{id, values[0], values[1]}, "key %s revoked from bunch %s", values[1], values[0])
+}
+
+func bunchInherit(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "bunch inherit"), args, 2)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = a.repo.WithTx(ctx, func(tx storage.Stores) error {
+		bunch, err := tx.Bunches().GetByName(ctx, values[0])
+		if err != nil {
+			return notFound(err, "bunch", values[0])
+		}
+
+		parent, err := tx.Bunches().GetByName(ctx, values[1])
+		if err != nil {
+			return notFound(err, "bunch", values[1])
+		}
+
+		id, err = tx.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunch.ID, ParentID: parent.ID})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.out.done(&bunchParentView{id, values[0], values[1]}, "bunch %s inherits from bunch %s", values[0], values[1])
+}
+
+func bunchDisinherit(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "bunch disinherit"), args, 2)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = a.repo.WithTx(ctx, func(tx storage.Stores) error {
+		rows, _, err := tx.BunchParents().Query(ctx, storage.QueryBunchParent{BunchName: values[0], ParentName: values[1],
+			Limit: 1}, storage.SortBunchParent{})
+		if err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return notFound(storage.ErrNotFound, "parent", values[1]+" of "+values[0])
+		}
+
+		id = rows[0].BunchParent.ID
+		return tx.BunchParents().Delete(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.out.done(&bunchParentView{id, values[0], values[1]}, "bunch %s no longer inherits from bunch %s",
+		values[0], values[1])
+}
+
+func bunchList(ctx context.Context, a *app, args []string) error {
+	var q storage.QueryBunch
+
+	fs := newFlagSet(a, "bunch list")
+	fs.StringVar(&q.Name, "name", "", "")
+	fs.StringVar(&q.Desc, "desc", "", "")
+	fs.Var(booleanFlag{&q.Active}, "active", "")
+	fs.Int64Var(&q.Limit, "limit", share.DefaultLimit, "")
+	fs.Int64Var(&q.Offset, "offset", 0, "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	bunches, total, err := a.repo.Bunches().Query(ctx, q, storage.SortBunch{Name: share.Ascendant})
+	if err != nil {
+		return err
+	}
+
+	views := make([]*bunchView, 0, len(bunches))
+	rows := make([][]string, 0, len(bunches))
+	for _, b := range bunches {
+		v := newBunchView(b)
+		views = append(views, v)
+		rows = append(rows, v.row())
+	}
+
+	return a.out.list(views, total, bunchHeaders, rows)
+}
+
+func assign(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "assign"), args, 2)
+	if err != nil {
+		return err
+	}
+
+	var id int64
+	err = a.repo.WithTx(ctx, func(tx storage.Stores) error {
+		user, err := tx.Users().GetByName(ctx, values[0])
+		if err != nil {
+			return notFound(err, "user", values[0])
+		}
+
+		bunch, err := tx.Bunches().GetByName(ctx, values[1])
+		if err != nil {
+			return notFound(err, "bunch", values[1])
+		}
+
+		id, err = tx.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: user.ID, BunchID: bunch.ID})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return a.out.done(struct {
+		ID    int64  `json:"id"`
+		User  string `json:"user"`
+		Bunch string `json:"bunch"`
+	}{id, values[0], values[1]}, "user %s assigned to bunch %s", values[0], values[1])
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+type keyView struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Desc      string    `json:"desc"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newKeyView(k *storage.Key) *keyView {
+	return &keyView{k.ID, k.Name, k.Desc, k.UpdatedAt}
+}
+
+var keyHeaders = []string{"ID", "NAME", "DESC", "UPDATED AT"}
+
+func (v *keyView) row() []string {
+	return []string{formatID(v.ID), v.Name, v.Desc, formatTime(v.UpdatedAt)}
+}
+
+func keyCreate(ctx context.Context, a *app, args []string) error {
+	var k storage.CreateKey
+
+	fs := newFlagSet(a, "key create")
+	fs.StringVar(&k.Name, "name", "", "")
+	fs.StringVar(&k.Desc, "desc", "", "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if len(k.Name) == 0 {
+		return errUsage
+	}
+
+	id, err := a.repo.Keys().Insert(ctx, k)
+	if err != nil {
+		return err
+	}
+
+	key, err := a.repo.Keys().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	v := newKeyView(key)
+	return a.out.table(v, keyHeaders, [][]string{v.row()})
+}
+
+func keyDelete(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "key delete"), args, 1)
+	if err != nil {
+		return err
+	}
+
+	key, err := a.repo.Keys().GetByName(ctx, values[0])
+	if err != nil {
+		return notFound(err, "key", values[0])
+	}
+
+	if err := a.repo.Keys().Delete(ctx, key.ID); err != nil {
+		return notFound(err, "key", values[0])
+	}
+
+	return a.out.done(newKeyView(key), "key %s deleted", key.Name)
+}
+
+func keyList(ctx context.Context, a *app, args []string) error {
+	var q storage.QueryKey
+
+	fs := newFlagSet(a, "key list")
+	fs.StringVar(&q.Name, "name", "", "")
+	fs.StringVar(&q.Desc, "desc", "", "")
+	fs.Int64Var(&q.Limit, "limit", share.DefaultLimit, "")
+	fs.Int64Var(&q.Offset, "offset", 0, "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	keys, total, err := a.repo.Keys().Query(ctx, q, storage.SortKey{Name: share.Ascendant})
+	if err != nil {
+		return err
+	}
+
+	views := make([]*keyView, 0, len(keys))
+	rows := make([][]string, 0, len(keys))
+	for _, k := range keys {
+		v := newKeyView(k)
+		views = append(views, v)
+		rows = append(rows, v.row())
+	}
+
+	return a.out.list(views, total, keyHeaders, rows)
+}
@@ -0,0 +1,217 @@
+// Command authctl administers users, bunches and keys stored in the auth service's mysql database.
+//
+// Usage:
+//
+//	authctl [-dsn dsn] [-output table|json] <command> [arguments]
+//
+// The dsn defaults to the AUTH_DSN environment variable. Passwords are hashed with the algorithm named by
+// AUTH_PASSWORD_ALGORITHM (argon2id, bcrypt or scrypt) and the pepper in AUTH_PASSWORD_PEPPER. User passwords
+// never appear on the command line: they are read from the AUTH_USER_PASSWORD environment variable or else
+// from stdin, without echo when stdin is a terminal.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/mysql"
+)
+
+// dsnEnv is the environment variable holding the default dsn
+const dsnEnv = "AUTH_DSN"
+
+// passwordEnv is the environment variable holding the password of created or updated users
+const passwordEnv = "AUTH_USER_PASSWORD"
+
+// errUsage is returned when a command is called with wrong arguments, exec then prints its usage
+var errUsage = errors.New("usage")
+
+// migrator is the part of mysql.Migrator used by the migrate and seed commands
+type migrator interface {
+	Up(ctx context.Context, n int) ([]*mysql.Migration, error)
+	Down(ctx context.Context, n int) ([]*mysql.Migration, error)
+	Status(ctx context.Context) ([]*mysql.MigrationStatus, error)
+	Seed()
+}
+
+// app holds what commands need to run
+type app struct {
+	repo   storage.Repository
+	auth   *auth.Service
+	mig    migrator
+	out    *printer
+	stderr io.Writer
+
+	// password reads the password of a created or updated user
+	password func() (string, error)
+
+	// dryRun switches the migrator to dry run mode, it is nil when the migrator has no such mode
+	dryRun func(bool)
+}
+
+// command is a leaf of the command tree
+type command struct {
+	usage string
+	run   func(ctx context.Context, a *app, args []string) error
+}
+
+// commands maps "group action" or "name" to commands
+var commands = map[string]*command{
+	"user create":      {"-username name -email email [-full-name name]", userCreate},
+	"user update":      {"<username> [-username name] [-email email] [-full-name name] [-new-password] [-active true|false]", userUpdate},
+	"user deactivate":  {"<username>", userDeactivate},
+	"user list":        {"[-username text] [-email text] [-full-name text] [-active true|false] [-limit n] [-offset n]", userList},
+	"bunch create":     {"-name name [-desc desc]", bunchCreate},
+	"bunch grant-key":  {"<bunch> <key>", bunchGrantKey},
+	"bunch revoke-key": {"<bunch> <key>", bunchRevokeKey},
+	"bunch inherit":    {"<bunch> <parent>", bunchInherit},
+	"bunch disinherit": {"<bunch> <parent>", bunchDisinherit},
+	"bunch list":       {"[-name text] [-desc text] [-active true|false] [-limit n] [-offset n]", bunchList},
+	"key create":       {"-name name [-desc desc]", keyCreate},
+	"key delete":       {"<key>", keyDelete},
+	"key list":         {"[-name text] [-desc text] [-limit n] [-offset n]", keyList},
+	"assign":           {"<username> <bunch>", assign},
+	"migrate up":       {"[-n steps] [-dry-run]", migrateUp},
+	"migrate down":     {"[-n steps] [-dry-run]", migrateDown},
+	"migrate status":   {"", migrateStatus},
+	"seed":             {"", seed},
+}
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run parses the global flags, connects to the database and runs the command, it returns the exit code
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	fs := flag.NewFlagSet("authctl", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	dsn := fs.String("dsn", os.Getenv(dsnEnv), "mysql data source name, defaults to $"+dsnEnv)
+	output := fs.String("output", "table", "output format: table or json")
+	fs.Usage = func() { usage(stderr, fs) }
+
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	out, err := newPrinter(stdout, *output)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	if len(*dsn) == 0 {
+		fmt.Fprintf(stderr, "missing dsn, use -dsn or $%s\n", dsnEnv)
+		return 2
+	}
+
+	passwords, err := password.NewHasherFromEnv()
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 2
+	}
+
+	conn, err := connect(*dsn)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return 1
+	}
+	defer conn.Close()
+
+	repo := mysql.NewRepository(conn)
+	mig := mysql.NewMigrator(conn)
+	a := &app{
+		repo:   repo,
+		auth:   auth.NewService(repo, passwords, auth.Config{}),
+		mig:    mig,
+		out:    out,
+		stderr: stderr,
+		dryRun: func(on bool) { mig.DryRun = on },
+		password: func() (string, error) {
+			return readPassword(os.Stdin, stderr)
+		},
+	}
+
+	return a.exec(ctx, fs.Args())
+}
+
+// connect opens the mysql database, forcing the dsn parameters the storers and migrations rely on
+func connect(dsn string) (*sqlx.DB, error) {
+	cfg, err := driver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn: %w", err)
+	}
+	cfg.ParseTime = true
+	cfg.MultiStatements = true
+
+	db, err := mysql.InitDb(cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// exec finds the command named by args and runs it, it returns the exit code
+func (a *app) exec(ctx context.Context, args []string) int {
+	if len(args) == 0 {
+		usage(a.stderr, nil)
+		return 2
+	}
+
+	name, rest := args[0], args[1:]
+	cmd, ok := commands[name]
+	if !ok && len(args) > 1 {
+		name, rest = args[0]+" "+args[1], args[2:]
+		cmd, ok = commands[name]
+	}
+	if !ok {
+		fmt.Fprintf(a.stderr, "unknown command %q\n", strings.Join(args, " "))
+		usage(a.stderr, nil)
+		return 2
+	}
+
+	if err := cmd.run(ctx, a, rest); err != nil {
+		if errors.Is(err, errUsage) {
+			fmt.Fprintf(a.stderr, "usage: authctl %s %s\n", name, cmd.usage)
+			return 2
+		}
+		fmt.Fprintln(a.stderr, err)
+		return 1
+	}
+
+	return 0
+}
+
+func usage(w io.Writer, fs *flag.FlagSet) {
+	fmt.Fprintln(w, "usage: authctl [-dsn dsn] [-output table|json] <command> [arguments]")
+	if fs != nil {
+		fs.PrintDefaults()
+	}
+
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(w, "\ncommands:")
+	for _, name := range names {
+		fmt.Fprintln(w, strings.TrimRight("  "+name+" "+commands[name].usage, " "))
+	}
+}
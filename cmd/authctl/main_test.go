@@ -0,0 +1,310 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/storage/memory"
+	"github.com/vespaiach/auth_service/pkg/storage/mysql"
+)
+
+type fakeMigrator struct {
+	applied int
+	dryRun  bool
+	seeded  bool
+}
+
+var fakeMigrations = []*mysql.Migration{{Version: 1, Name: "one"}, {Version: 2, Name: "two"}}
+
+func (m *fakeMigrator) Up(ctx context.Context, n int) ([]*mysql.Migration, error) {
+	plan := fakeMigrations[m.applied:]
+	if n > 0 && n < len(plan) {
+		plan = plan[:n]
+	}
+	if !m.dryRun {
+		m.applied += len(plan)
+	}
+
+	return plan, nil
+}
+
+func (m *fakeMigrator) Down(ctx context.Context, n int) ([]*mysql.Migration, error) {
+	var plan []*mysql.Migration
+	for i := m.applied - 1; i >= 0 && (n <= 0 || len(plan) < n); i-- {
+		plan = append(plan, fakeMigrations[i])
+	}
+	if !m.dryRun {
+		m.applied -= len(plan)
+	}
+
+	return plan, nil
+}
+
+func (m *fakeMigrator) Status(ctx context.Context) ([]*mysql.MigrationStatus, error) {
+	results := make([]*mysql.MigrationStatus, 0)
+	for i, mg := range fakeMigrations {
+		results = append(results, &mysql.MigrationStatus{Version: mg.Version, Name: mg.Name, Applied: i < m.applied})
+	}
+
+	return results, nil
+}
+
+func (m *fakeMigrator) Seed() {
+	m.seeded = true
+}
+
+type testCli struct {
+	app    *app
+	secret string
+	mig    *fakeMigrator
+	db     *memory.DB
+	stdout *bytes.Buffer
+	stderr *bytes.Buffer
+}
+
+func newTestCli(format string) *testCli {
+	c := &testCli{secret: "secret", mig: new(fakeMigrator), db: memory.NewDB(), stdout: new(bytes.Buffer),
+		stderr: new(bytes.Buffer)}
+
+	out, _ := newPrinter(c.stdout, format)
+	repo := memory.NewRepository(c.db)
+	c.app = &app{
+		repo:   repo,
+		auth:   auth.NewService(repo, password.NewHasher(&password.Bcrypt{Cost: 4}, nil), auth.Config{}),
+		mig:    c.mig,
+		out:    out,
+		stderr: c.stderr,
+		dryRun: func(on bool) { c.mig.dryRun = on },
+		password: func() (string, error) {
+			return c.secret, nil
+		},
+	}
+
+	return c
+}
+
+// exec runs a command line and returns its exit code, the outputs are reset before running
+func (c *testCli) exec(line string) int {
+	c.stdout.Reset()
+	c.stderr.Reset()
+
+	return c.app.exec(context.Background(), strings.Fields(line))
+}
+
+func TestUserCommands(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_create_and_list_users", func(t *testing.T) {
+		c := newTestCli("json")
+
+		require.Equal(t, 0, c.exec("user create -username alice -email alice@test.com"))
+
+		var created userView
+		require.Nil(t, json.Unmarshal(c.stdout.Bytes(), &created))
+		require.Equal(t, "alice", created.Username)
+		require.True(t, created.Active)
+
+		user, err := c.app.auth.VerifyCredentials(ctx, auth.Credentials{Login: "alice", Password: "secret"})
+		require.Nil(t, err)
+		require.Equal(t, created.ID, user.ID)
+
+		require.Equal(t, 0, c.exec("user create -username bob -email bob@test.com"))
+		require.Equal(t, 0, c.exec("user list -limit 1"))
+
+		var page struct {
+			Total int64       `json:"total"`
+			Items []*userView `json:"items"`
+		}
+		require.Nil(t, json.Unmarshal(c.stdout.Bytes(), &page))
+		require.Equal(t, int64(2), page.Total)
+		require.Len(t, page.Items, 1)
+		require.Equal(t, "alice", page.Items[0].Username)
+	})
+
+	t.Run("success_update_and_deactivate_a_user", func(t *testing.T) {
+		c := newTestCli("table")
+
+		require.Equal(t, 0, c.exec("user create -username carol -email carol@test.com"))
+		require.Equal(t, 0, c.exec("user update carol -email carol@example.com"))
+		require.Contains(t, c.stdout.String(), "carol@example.com")
+
+		c.secret = "changed"
+		require.Equal(t, 0, c.exec("user update carol -new-password"))
+
+		_, err := c.app.auth.VerifyCredentials(ctx, auth.Credentials{Login: "carol", Password: "changed"})
+		require.Nil(t, err)
+
+		require.Equal(t, 0, c.exec("user deactivate carol"))
+		require.Equal(t, 0, c.exec("user list -active false"))
+		require.Contains(t, c.stdout.String(), "carol")
+		require.Contains(t, c.stdout.String(), "(1 of 1)")
+	})
+
+	t.Run("fail_create_a_user_without_password", func(t *testing.T) {
+		c := newTestCli("table")
+		c.secret = ""
+
+		require.Equal(t, 1, c.exec("user create -username dave -email dave@test.com"))
+		require.Contains(t, c.stderr.String(), "missing password")
+
+		require.Equal(t, 2, c.exec("user create -username dave -email dave@test.com -password secret"))
+		require.Contains(t, c.stderr.String(), "usage: authctl user create")
+	})
+
+	t.Run("success_read_a_password_from_stdin", func(t *testing.T) {
+		password, err := readPassword(strings.NewReader("secret\r\nignored\n"), new(bytes.Buffer))
+		require.Nil(t, err)
+		require.Equal(t, "secret", password)
+
+		password, err = readPassword(strings.NewReader(""), new(bytes.Buffer))
+		require.Nil(t, err)
+		require.Empty(t, password)
+	})
+
+	t.Run("fail_update_a_missing_user", func(t *testing.T) {
+		c := newTestCli("table")
+
+		require.Equal(t, 1, c.exec("user update nobody -email nobody@test.com"))
+		require.Contains(t, c.stderr.String(), `user "nobody" not found`)
+	})
+}
+
+func TestBunchCommands(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_grant_revoke_and_assign", func(t *testing.T) {
+		c := newTestCli("table")
+
+		require.Equal(t, 0, c.exec("key create -name read -desc read_things"))
+		require.Equal(t, 0, c.exec("bunch create -name readers"))
+		require.Equal(t, 0, c.exec("user create -username erin -email erin@test.com"))
+		require.Equal(t, 0, c.exec("bunch grant-key readers read"))
+		require.Equal(t, 0, c.exec("assign erin readers"))
+
+		user, err := c.app.repo.Users().GetByName(ctx, "erin")
+		require.Nil(t, err)
+
+		has, err := c.app.repo.Permissions().HasKey(ctx, user.ID, "read")
+		require.Nil(t, err)
+		require.True(t, has)
+
+		require.Equal(t, 0, c.exec("bunch revoke-key readers read"))
+
+		has, err = c.app.repo.Permissions().HasKey(ctx, user.ID, "read")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		require.Equal(t, 1, c.exec("bunch revoke-key readers read"))
+	})
+
+	t.Run("success_inherit_and_disinherit", func(t *testing.T) {
+		c := newTestCli("table")
+
+		require.Equal(t, 0, c.exec("key create -name read"))
+		require.Equal(t, 0, c.exec("bunch create -name readers"))
+		require.Equal(t, 0, c.exec("bunch create -name editors"))
+		require.Equal(t, 0, c.exec("user create -username frank -email frank@test.com"))
+		require.Equal(t, 0, c.exec("bunch grant-key readers read"))
+		require.Equal(t, 0, c.exec("assign frank editors"))
+		require.Equal(t, 0, c.exec("bunch inherit editors readers"))
+
+		user, err := c.app.repo.Users().GetByName(ctx, "frank")
+		require.Nil(t, err)
+
+		has, err := c.app.repo.Permissions().HasKey(ctx, user.ID, "read")
+		require.Nil(t, err)
+		require.True(t, has)
+
+		require.Equal(t, 1, c.exec("bunch inherit readers editors"))
+		require.Contains(t, c.stderr.String(), "inherits from itself")
+
+		require.Equal(t, 0, c.exec("bunch disinherit editors readers"))
+
+		has, err = c.app.repo.Permissions().HasKey(ctx, user.ID, "read")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		require.Equal(t, 1, c.exec("bunch disinherit editors readers"))
+	})
+
+	t.Run("fail_grant_a_missing_key", func(t *testing.T) {
+		c := newTestCli("table")
+
+		require.Equal(t, 0, c.exec("bunch create -name writers"))
+		require.Equal(t, 1, c.exec("bunch grant-key writers write"))
+		require.Contains(t, c.stderr.String(), `key "write" not found`)
+	})
+}
+
+func TestKeyCommands(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCli("table")
+
+	require.Equal(t, 0, c.exec("key create -name a_key"))
+	require.Equal(t, 0, c.exec("key create -name b_key"))
+	require.Equal(t, 0, c.exec("key delete a_key"))
+	require.Equal(t, 0, c.exec("key list"))
+	require.NotContains(t, c.stdout.String(), "a_key")
+	require.Contains(t, c.stdout.String(), "b_key")
+
+	require.Equal(t, 1, c.exec("key delete a_key"))
+	require.Equal(t, 2, c.exec("key delete"))
+}
+
+func TestMigrateCommands(t *testing.T) {
+	t.Parallel()
+
+	c := newTestCli("table")
+
+	require.Equal(t, 0, c.exec("migrate up -n 1 -dry-run"))
+	require.Contains(t, c.stdout.String(), "would be applied")
+	require.Equal(t, 0, c.mig.applied)
+	require.False(t, c.mig.dryRun)
+
+	require.Equal(t, 0, c.exec("migrate up"))
+	require.Equal(t, 2, c.mig.applied)
+
+	require.Equal(t, 0, c.exec("migrate down -n 1"))
+	require.Equal(t, 1, c.mig.applied)
+
+	require.Equal(t, 0, c.exec("migrate status"))
+	require.Contains(t, c.stdout.String(), "applied")
+	require.Contains(t, c.stdout.String(), "pending")
+
+	require.Equal(t, 0, c.exec("seed"))
+	require.True(t, c.mig.seeded)
+}
+
+func TestRun(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fail_unknown_command", func(t *testing.T) {
+		c := newTestCli("table")
+
+		require.Equal(t, 2, c.exec("user explode"))
+		require.Contains(t, c.stderr.String(), "unknown command")
+	})
+
+	t.Run("fail_unknown_output", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		require.Equal(t, 2, run(context.Background(), []string{"-output", "xml", "key", "list"}, &stdout, &stderr))
+		require.Contains(t, stderr.String(), "unknown output format")
+	})
+
+	t.Run("fail_invalid_dsn", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+
+		require.Equal(t, 1, run(context.Background(), []string{"-dsn", "not a dsn", "key", "list"}, &stdout, &stderr))
+		require.Contains(t, stderr.String(), "invalid dsn")
+	})
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/storage/mysql"
+)
+
+type migrationView struct {
+	Version   int        `json:"version"`
+	Name      string     `json:"name"`
+	Status    string     `json:"status"`
+	AppliedAt *time.Time `json:"applied_at,omitempty"`
+}
+
+func migrateUp(ctx context.Context, a *app, args []string) error {
+	return migrate(ctx, a, "migrate up", args, a.mig.Up, "applied")
+}
+
+func migrateDown(ctx context.Context, a *app, args []string) error {
+	return migrate(ctx, a, "migrate down", args, a.mig.Down, "reverted")
+}
+
+// migrate runs a direction of the migrations and lists the migrations it went through
+func migrate(ctx context.Context, a *app, name string, args []string,
+	fn func(ctx context.Context, n int) ([]*mysql.Migration, error), verb string) error {
+	fs := newFlagSet(a, name)
+	n := fs.Int("n", 0, "")
+	dryRun := fs.Bool("dry-run", false, "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	if *dryRun {
+		if a.dryRun == nil {
+			return errUsage
+		}
+		a.dryRun(true)
+		defer a.dryRun(false)
+		verb = "would be " + verb
+	}
+
+	migs, err := fn(ctx, *n)
+
+	views := make([]*migrationView, 0, len(migs))
+	rows := make([][]string, 0, len(migs))
+	for _, mg := range migs {
+		views = append(views, &migrationView{Version: mg.Version, Name: mg.Name, Status: verb})
+		rows = append(rows, []string{strconv.Itoa(mg.Version), mg.Name, verb})
+	}
+
+	// migrations which ran before a failure are reported along with the error
+	if len(migs) > 0 || err == nil {
+		if perr := a.out.table(views, []string{"VERSION", "NAME", "STATUS"}, rows); perr != nil && err == nil {
+			err = perr
+		}
+	}
+
+	return err
+}
+
+func migrateStatus(ctx context.Context, a *app, args []string) error {
+	if _, err := parse(newFlagSet(a, "migrate status"), args, 0); err != nil {
+		return err
+	}
+
+	statuses, err := a.mig.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	views := make([]*migrationView, 0, len(statuses))
+	rows := make([][]string, 0, len(statuses))
+	for _, st := range statuses {
+		v := &migrationView{Version: st.Version, Name: st.Name, Status: "pending"}
+		if st.Applied {
+			at := st.AppliedAt
+			v.Status, v.AppliedAt = "applied", &at
+		}
+		if st.Modified {
+			v.Status = "modified"
+		}
+		if st.Unknown {
+			v.Status = "unknown"
+		}
+
+		appliedAt := "-"
+		if v.AppliedAt != nil {
+			appliedAt = formatTime(*v.AppliedAt)
+		}
+
+		views = append(views, v)
+		rows = append(rows, []string{strconv.Itoa(st.Version), st.Name, v.Status, appliedAt})
+	}
+
+	return a.out.table(views, []string{"VERSION", "NAME", "STATUS", "APPLIED AT"}, rows)
+}
+
+func seed(ctx context.Context, a *app, args []string) error {
+	if _, err := parse(newFlagSet(a, "seed"), args, 0); err != nil {
+		return err
+	}
+
+	// Seed panics as Init does, turn it into an error
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = seedError{p}
+			}
+		}()
+
+		a.mig.Seed()
+		return nil
+	}()
+	if err != nil {
+		return err
+	}
+
+	return a.out.done(struct {
+		Seeded bool `json:"seeded"`
+	}{true}, "database seeded")
+}
+
+type seedError struct {
+	cause interface{}
+}
+
+func (e seedError) Error() string {
+	if err, ok := e.cause.(error); ok {
+		return "seed: " + err.Error()
+	}
+
+	return "seed failed"
+}
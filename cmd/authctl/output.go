@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+// printer writes command results as an aligned table or as json
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+func newPrinter(w io.Writer, format string) (*printer, error) {
+	switch format {
+	case "table":
+		return &printer{w: w}, nil
+	case "json":
+		return &printer{w: w, json: true}, nil
+	}
+
+	return nil, fmt.Errorf("unknown output format %q, use table or json", format)
+}
+
+// table prints rows under headers, or v in json
+func (p *printer) table(v interface{}, headers []string, rows [][]string) error {
+	if p.json {
+		return p.value(v)
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(headers, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+
+	return tw.Flush()
+}
+
+// list prints a page of rows followed by the total number of matching rows
+func (p *printer) list(items interface{}, total int64, headers []string, rows [][]string) error {
+	if p.json {
+		return p.value(struct {
+			Total int64       `json:"total"`
+			Items interface{} `json:"items"`
+		}{total, items})
+	}
+
+	if err := p.table(nil, headers, rows); err != nil {
+		return err
+	}
+
+	_, err := fmt.Fprintf(p.w, "(%d of %d)\n", len(rows), total)
+	return err
+}
+
+// done prints the outcome of a command which has no rows to show
+func (p *printer) done(v interface{}, format string, args ...interface{}) error {
+	if p.json {
+		return p.value(v)
+	}
+
+	_, err := fmt.Fprintf(p.w, format+"\n", args...)
+	return err
+}
+
+func (p *printer) value(v interface{}) error {
+	enc := json.NewEncoder(p.w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(v)
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Format(time.RFC3339)
+}
+
+func formatID(id int64) string {
+	return strconv.FormatInt(id, 10)
+}
+
+// booleanFlag is a flag.Value filling a share.Boolean, so an absent flag stays unset
+type booleanFlag struct {
+	b *share.Boolean
+}
+
+func (f booleanFlag) String() string {
+	if f.b == nil || !f.b.IsSet {
+		return ""
+	}
+
+	return strconv.FormatBool(f.b.Bool)
+}
+
+func (f booleanFlag) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+
+	f.b.IsSet = true
+	f.b.Bool = v
+
+	return nil
+}
+
+// newFlagSet creates the flag set of a command, parse errors are reported as errUsage
+func newFlagSet(a *app, name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(a.stderr)
+	fs.Usage = func() {}
+
+	return fs
+}
+
+// parse parses flags mixed with exactly positional arguments and returns the positional ones
+func parse(fs *flag.FlagSet, args []string, positional int) ([]string, error) {
+	var values []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, errUsage
+		}
+
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+
+		values = append(values, args[0])
+		args = args[1:]
+	}
+
+	if len(values) != positional {
+		return nil, errUsage
+	}
+
+	return values, nil
+}
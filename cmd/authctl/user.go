@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"golang.org/x/term"
+)
+
+// errMissingPassword is returned when no password is given to a command needing one
+var errMissingPassword = errors.New("missing password, set $" + passwordEnv + " or write it to stdin")
+
+type userView struct {
+	ID        int64     `json:"id"`
+	FullName  string    `json:"full_name"`
+	Username  string    `json:"username"`
+	Email     string    `json:"email"`
+	Active    bool      `json:"active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newUserView(u *storage.User) *userView {
+	return &userView{u.ID, u.FullName, u.Username, u.Email, u.Active.Bool, u.UpdatedAt}
+}
+
+var userHeaders = []string{"ID", "USERNAME", "EMAIL", "FULL NAME", "ACTIVE", "UPDATED AT"}
+
+func (v *userView) row() []string {
+	return []string{formatID(v.ID), v.Username, v.Email, v.FullName, strconv.FormatBool(v.Active),
+		formatTime(v.UpdatedAt)}
+}
+
+func userCreate(ctx context.Context, a *app, args []string) error {
+	var u auth.CreateUser
+
+	fs := newFlagSet(a, "user create")
+	fs.StringVar(&u.Username, "username", "", "")
+	fs.StringVar(&u.Email, "email", "", "")
+	fs.StringVar(&u.FullName, "full-name", "", "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+	if len(u.Username) == 0 || len(u.Email) == 0 {
+		return errUsage
+	}
+
+	var err error
+	if u.Password, err = a.readPassword(); err != nil {
+		return err
+	}
+
+	id, err := a.auth.CreateUser(ctx, u)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.repo.Users().Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	v := newUserView(user)
+	return a.out.table(v, userHeaders, [][]string{v.row()})
+}
+
+func userUpdate(ctx context.Context, a *app, args []string) error {
+	var u storage.UpdateUser
+
+	fs := newFlagSet(a, "user update")
+	fs.StringVar(&u.Username, "username", "", "")
+	fs.StringVar(&u.Email, "email", "", "")
+	fs.StringVar(&u.FullName, "full-name", "", "")
+	fs.Var(booleanFlag{&u.Active}, "active", "")
+	newPassword := fs.Bool("new-password", false, "")
+
+	values, err := parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+
+	if *newPassword {
+		password, err := a.readPassword()
+		if err != nil {
+			return err
+		}
+		if u.Hash, u.Salt, err = a.auth.HashPassword(password); err != nil {
+			return err
+		}
+	}
+
+	var user *storage.User
+	err = a.repo.WithTx(ctx, func(tx storage.Stores) error {
+		current, err := tx.Users().GetByName(ctx, values[0])
+		if err != nil {
+			return notFound(err, "user", values[0])
+		}
+
+		u.ID = current.ID
+		if err := tx.Users().Update(ctx, u); err != nil {
+			return err
+		}
+
+		user, err = tx.Users().Get(ctx, current.ID)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	v := newUserView(user)
+	return a.out.table(v, userHeaders, [][]string{v.row()})
+}
+
+func userDeactivate(ctx context.Context, a *app, args []string) error {
+	values, err := parse(newFlagSet(a, "user deactivate"), args, 1)
+	if err != nil {
+		return err
+	}
+
+	user, err := a.repo.Users().GetByName(ctx, values[0])
+	if err != nil {
+		return notFound(err, "user", values[0])
+	}
+
+	err = a.repo.Users().Update(ctx, storage.UpdateUser{ID: user.ID, Active: share.Boolean{IsSet: true}})
+	if err != nil {
+		return err
+	}
+
+	user.Active.Bool = false
+	return a.out.done(newUserView(user), "user %s deactivated", user.Username)
+}
+
+func userList(ctx context.Context, a *app, args []string) error {
+	var q storage.QueryUser
+
+	fs := newFlagSet(a, "user list")
+	fs.StringVar(&q.Username, "username", "", "")
+	fs.StringVar(&q.Email, "email", "", "")
+	fs.StringVar(&q.FullName, "full-name", "", "")
+	fs.Var(booleanFlag{&q.Active}, "active", "")
+	fs.Int64Var(&q.Limit, "limit", share.DefaultLimit, "")
+	fs.Int64Var(&q.Offset, "offset", 0, "")
+
+	if _, err := parse(fs, args, 0); err != nil {
+		return err
+	}
+
+	users, total, err := a.repo.Users().Query(ctx, q, storage.SortUser{Username: share.Ascendant})
+	if err != nil {
+		return err
+	}
+
+	views := make([]*userView, 0, len(users))
+	rows := make([][]string, 0, len(users))
+	for _, u := range users {
+		v := newUserView(u)
+		views = append(views, v)
+		rows = append(rows, v.row())
+	}
+
+	return a.out.list(views, total, userHeaders, rows)
+}
+
+// readPassword reads a user's password, it refuses an empty one
+func (a *app) readPassword() (string, error) {
+	password, err := a.password()
+	if err != nil {
+		return "", err
+	}
+	if len(password) == 0 {
+		return "", errMissingPassword
+	}
+
+	return password, nil
+}
+
+// readPassword reads a password from $AUTH_USER_PASSWORD or else from the first line of stdin, a terminal
+// is prompted on stderr and does not echo what is typed
+func readPassword(stdin io.Reader, stderr io.Writer) (string, error) {
+	if password, ok := os.LookupEnv(passwordEnv); ok {
+		return password, nil
+	}
+
+	if f, ok := stdin.(*os.File); ok && term.IsTerminal(int(f.Fd())) {
+		fmt.Fprint(stderr, "password: ")
+		password, err := term.ReadPassword(int(f.Fd()))
+		fmt.Fprintln(stderr)
+
+		return string(password), err
+	}
+
+	line, err := bufio.NewReader(stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// notFound names the missing record of a storage.ErrNotFound
+func notFound(err error, entity string, name string) error {
+	if errors.Is(err, storage.ErrNotFound) {
+		return &notFoundError{entity, name, err}
+	}
+
+	return err
+}
+
+type notFoundError struct {
+	entity string
+	name   string
+	err    error
+}
+
+func (e *notFoundError) Error() string {
+	return e.entity + " " + strconv.Quote(e.name) + " not found"
+}
+
+func (e *notFoundError) Unwrap() error {
+	return e.err
+}
@@ -0,0 +1,169 @@
+// Command authd serves the auth service's REST API on top of its mysql database.
+//
+// Usage:
+//
+//	authd [-addr address] [-dsn dsn]
+//
+// The address and dsn default to the AUTH_ADDR and AUTH_DSN environment variables. Passwords are hashed with
+// the algorithm named by AUTH_PASSWORD_ALGORITHM (argon2id, bcrypt or scrypt) and the pepper in
+// AUTH_PASSWORD_PEPPER. Access tokens are configured by the AUTH_TOKEN_* variables, see token.KeysFromEnv and
+// token.ConfigFromEnv. Either the signing key or the AUTH_TOKEN_ENCRYPTION_KEY of generated keys is required,
+// generated keys are rotated while authd runs and published at /.well-known/jwks.json. Failed logins are
+// throttled as told by the AUTH_LOCKOUT_* variables, see auth.LockoutConfigFromEnv, per client address: the
+// X-Forwarded-For and X-Real-IP headers are only believed from the proxies listed in AUTH_TRUSTED_PROXIES.
+// AUTH_MFA_REQUIRED_BUNCHES lists the bunches whose members must log in with a one-time password, see
+// auth.MFAConfigFromEnv. Password reset tokens expire after AUTH_RESET_TTL and link to AUTH_RESET_URL, see
+// auth.ResetConfigFromEnv, email verification tokens after AUTH_VERIFICATION_TTL and link to
+// AUTH_VERIFICATION_URL, see auth.VerificationConfigFromEnv. Mailed tokens are appended to the AUTH_MAIL_FILE
+// file, or written to stdout, until a real mail.Mailer is wired in. Expired tokens and the login attempts which
+// no longer count are deleted every hour.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/api"
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/storage/mysql"
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+const (
+	// shutdownTimeout is how long in-flight requests may run once the server is asked to stop
+	shutdownTimeout = 15 * time.Second
+
+	// keyMaintenanceInterval is how often generated signing keys are checked for rotation, it must be shorter
+	// than the rotation overlap
+	keyMaintenanceInterval = time.Hour
+
+	// purgeInterval is how often expired tokens and login attempts which no longer count are deleted
+	purgeInterval = time.Hour
+)
+
+func main() {
+	addr := flag.String("addr", envOr("AUTH_ADDR", ":8080"), "listen address, defaults to $AUTH_ADDR or :8080")
+	dsn := flag.String("dsn", os.Getenv("AUTH_DSN"), "mysql data source name, defaults to $AUTH_DSN")
+	flag.Parse()
+
+	if len(*dsn) == 0 {
+		log.Fatal("missing dsn, use -dsn or $AUTH_DSN")
+	}
+
+	passwords, err := password.NewHasherFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := connect(*dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	repo := mysql.NewRepository(db)
+	keys, err := token.KeysFromEnv(repo)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	config, err := token.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	authConfig, err := auth.ConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if ring, ok := keys.(*token.KeyRing); ok {
+		if err := ring.Maintain(ctx); err != nil {
+			log.Fatal(err)
+		}
+		go ring.Run(ctx, keyMaintenanceInterval)
+	}
+
+	authService := auth.NewService(repo, passwords, authConfig)
+	issuer := token.NewIssuer(repo, keys, config)
+	go authService.RunPurge(ctx, purgeInterval)
+	go issuer.RunPurge(ctx, purgeInterval)
+
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           api.NewServer(repo, authService, issuer),
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+
+	if err := serve(srv); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// serve runs srv until SIGINT or SIGTERM, then lets in-flight requests finish
+func serve(srv *http.Server) error {
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+
+	errs := make(chan error, 1)
+	go func() {
+		log.Printf("authd listening on %s", srv.Addr)
+		errs <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case <-stop:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	return srv.Shutdown(ctx)
+}
+
+// connect opens the mysql database, forcing the dsn parameters the storers rely on
+func connect(dsn string) (*sqlx.DB, error) {
+	cfg, err := driver.ParseDSN(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dsn: %w", err)
+	}
+	cfg.ParseTime = true
+
+	db, err := mysql.InitDb(cfg.FormatDSN())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+func envOr(name string, fallback string) string {
+	if v := os.Getenv(name); len(v) > 0 {
+		return v
+	}
+
+	return fallback
+}
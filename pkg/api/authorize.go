@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+)
+
+// keys guarding the administration routes, the seeded admin_role holds all but introspect_token which the
+// resource_server bunch holds. There is no key reading users, modify_user guards the routes listing users as well
+// as those changing them, add_user the route creating them.
+const (
+	keyAddKey      = "add_key"
+	keyModifyKey   = "modify_key"
+	keyGetKey      = "get_key"
+	keyQueryKey    = "query_key"
+	keyAddBunch    = "add_bunch"
+	keyModifyBunch = "modify_bunch"
+	keyGetBunch    = "get_bunch"
+	keyQueryBunch  = "query_bunch"
+	keyAddUser     = "add_user"
+	keyModifyUser  = "modify_user"
+
+	// keyIntrospectToken lets the resource servers holding it introspect tokens, RFC 7662 section 2.1
+	keyIntrospectToken = "introspect_token"
+)
+
+// withKey serves h for the active user authenticated by the bearer token when it holds the key. The key is
+// resolved from storage, so bunches and keys taken away apply before the token expires.
+func (s *Server) withKey(key string, h handler) handler {
+	return func(w http.ResponseWriter, r *http.Request, p params) error {
+		info, err := s.authenticate(w, r)
+		if err != nil {
+			return err
+		}
+
+		ok, err := s.repo.Permissions().HasKey(r.Context(), info.UserID, key)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errForbidden
+		}
+
+		return h(w, r, p)
+	}
+}
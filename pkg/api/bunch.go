@@ -0,0 +1,371 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// Bunch is the json representation of storage.Bunch
+type Bunch struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Desc      string    `json:"desc"`
+	Active    bool      `json:"active"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newBunch(b *storage.Bunch) *Bunch {
+	return &Bunch{ID: b.ID, Name: b.Name, Desc: b.Desc, Active: b.Active.Bool, UpdatedAt: b.UpdatedAt}
+}
+
+// BunchKey is the json representation of storage.AggregateBunchKey
+type BunchKey struct {
+	ID        int64     `json:"id"`
+	BunchID   int64     `json:"bunch_id"`
+	KeyID     int64     `json:"key_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Bunch     *Bunch    `json:"bunch,omitempty"`
+	Key       *Key      `json:"key,omitempty"`
+	Source    *Bunch    `json:"source,omitempty"`
+}
+
+func newBunchKey(bk *storage.AggregateBunchKey) *BunchKey {
+	v := &BunchKey{ID: bk.BunchKey.ID, BunchID: bk.BunchID, KeyID: bk.KeyID, UpdatedAt: bk.BunchKey.UpdatedAt}
+	if bk.Bunch != nil {
+		v.Bunch = newBunch(bk.Bunch)
+	}
+	if bk.Key != nil {
+		v.Key = newKey(bk.Key)
+	}
+	if bk.Source != nil {
+		v.Source = newBunch(bk.Source)
+	}
+
+	return v
+}
+
+// BunchParent is the json representation of storage.AggregateBunchParent
+type BunchParent struct {
+	ID        int64     `json:"id"`
+	BunchID   int64     `json:"bunch_id"`
+	ParentID  int64     `json:"parent_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Bunch     *Bunch    `json:"bunch,omitempty"`
+	Parent    *Bunch    `json:"parent,omitempty"`
+}
+
+func newBunchParent(bp *storage.AggregateBunchParent) *BunchParent {
+	v := &BunchParent{ID: bp.BunchParent.ID, BunchID: bp.BunchID, ParentID: bp.ParentID,
+		UpdatedAt: bp.BunchParent.UpdatedAt}
+	if bp.Bunch != nil {
+		v.Bunch = newBunch(bp.Bunch)
+	}
+	if bp.Parent != nil {
+		v.Parent = newBunch(bp.Parent)
+	}
+
+	return v
+}
+
+type createBunchRequest struct {
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+}
+
+type updateBunchRequest struct {
+	Name   *string `json:"name"`
+	Desc   *string `json:"desc"`
+	Active *bool   `json:"active"`
+}
+
+type createBunchKeyRequest struct {
+	BunchID int64 `json:"bunch_id"`
+	KeyID   int64 `json:"key_id"`
+}
+
+type createBunchParentRequest struct {
+	BunchID  int64 `json:"bunch_id"`
+	ParentID int64 `json:"parent_id"`
+}
+
+func (s *Server) createBunch(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createBunchRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("name", req.Name, maxBunchName)
+	v.length("desc", req.Desc, maxBunchDesc)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	id, err := s.repo.Bunches().Insert(r.Context(), storage.CreateBunch{Name: req.Name, Desc: req.Desc})
+	if err != nil {
+		return err
+	}
+
+	bunch, err := s.repo.Bunches().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newBunch(bunch))
+}
+
+func (s *Server) getBunch(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	bunch, err := s.repo.Bunches().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, newBunch(bunch))
+}
+
+func (s *Server) updateBunch(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	var req updateBunchRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.optional("name", req.Name, maxBunchName)
+	v.optional("desc", req.Desc, maxBunchDesc)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	u := storage.UpdateBunch{ID: id}
+	if req.Name != nil {
+		u.Name = *req.Name
+	}
+	if req.Desc != nil {
+		u.Desc = *req.Desc
+	}
+	if req.Active != nil {
+		u.Active = share.Boolean{IsSet: true, Bool: *req.Active}
+	}
+
+	var bunch *storage.Bunch
+	err = s.repo.WithTx(r.Context(), func(tx storage.Stores) error {
+		if _, err := tx.Bunches().Get(r.Context(), id); err != nil {
+			return err
+		}
+
+		if err := tx.Bunches().Update(r.Context(), u); err != nil {
+			return err
+		}
+
+		bunch, err = tx.Bunches().Get(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, newBunch(bunch))
+}
+
+func (s *Server) queryBunches(w http.ResponseWriter, r *http.Request, p params) error {
+	var (
+		q     = newQuery(r)
+		query storage.QueryBunch
+		sorts storage.SortBunch
+	)
+
+	query.Name = q.string("name")
+	query.Desc = q.string("desc")
+	query.Active = q.boolean("active")
+	query.From = q.time("from")
+	query.To = q.time("to")
+	query.Limit, query.Offset = q.page()
+	q.sort(map[string]*share.Direction{
+		"name":       &sorts.Name,
+		"desc":       &sorts.Desc,
+		"active":     &sorts.Active,
+		"updated_at": &sorts.UpdatedAt,
+	})
+	if err := q.err(); err != nil {
+		return err
+	}
+
+	bunches, total, err := s.repo.Bunches().Query(r.Context(), query, sorts)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*Bunch, 0, len(bunches))
+	for _, b := range bunches {
+		items = append(items, newBunch(b))
+	}
+
+	return writeList(w, items, total)
+}
+
+func (s *Server) createBunchKey(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createBunchKeyRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.positive("bunch_id", req.BunchID)
+	v.positive("key_id", req.KeyID)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	var created *storage.AggregateBunchKey
+	err := s.repo.WithTx(r.Context(), func(tx storage.Stores) error {
+		id, err := tx.BunchKeys().Insert(r.Context(), storage.BunchKey{BunchID: req.BunchID, KeyID: req.KeyID})
+		if err != nil {
+			return err
+		}
+
+		created, err = tx.BunchKeys().Get(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newBunchKey(created))
+}
+
+func (s *Server) deleteBunchKey(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.BunchKeys().Delete(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) queryBunchKeys(w http.ResponseWriter, r *http.Request, p params) error {
+	var (
+		q     = newQuery(r)
+		query storage.QueryBunchKey
+		sorts storage.SortBunchKey
+	)
+
+	query.BunchName = q.string("bunch_name")
+	query.KeyName = q.string("key_name")
+	query.BunchActive = q.boolean("bunch_active")
+	query.Inherited = q.boolean("inherited").Bool
+	query.Limit, query.Offset = q.page()
+	q.sort(map[string]*share.Direction{
+		"bunch_name":   &sorts.BunchName,
+		"key_name":     &sorts.KeyName,
+		"bunch_active": &sorts.BunchActive,
+	})
+	if err := q.err(); err != nil {
+		return err
+	}
+
+	rows, total, err := s.repo.BunchKeys().Query(r.Context(), query, sorts)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*BunchKey, 0, len(rows))
+	for _, bk := range rows {
+		items = append(items, newBunchKey(bk))
+	}
+
+	return writeList(w, items, total)
+}
+
+func (s *Server) createBunchParent(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createBunchParentRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.positive("bunch_id", req.BunchID)
+	v.positive("parent_id", req.ParentID)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	var created *storage.AggregateBunchParent
+	err := s.repo.WithTx(r.Context(), func(tx storage.Stores) error {
+		id, err := tx.BunchParents().Insert(r.Context(), storage.CreateBunchParent{BunchID: req.BunchID,
+			ParentID: req.ParentID})
+		if err != nil {
+			return err
+		}
+
+		created, err = tx.BunchParents().Get(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newBunchParent(created))
+}
+
+func (s *Server) deleteBunchParent(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.BunchParents().Delete(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) queryBunchParents(w http.ResponseWriter, r *http.Request, p params) error {
+	var (
+		q     = newQuery(r)
+		query storage.QueryBunchParent
+		sorts storage.SortBunchParent
+	)
+
+	query.BunchName = q.string("bunch_name")
+	query.ParentName = q.string("parent_name")
+	query.Limit, query.Offset = q.page()
+	q.sort(map[string]*share.Direction{
+		"bunch_name":  &sorts.BunchName,
+		"parent_name": &sorts.ParentName,
+	})
+	if err := q.err(); err != nil {
+		return err
+	}
+
+	rows, total, err := s.repo.BunchParents().Query(r.Context(), query, sorts)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*BunchParent, 0, len(rows))
+	for _, bp := range rows {
+		items = append(items, newBunchParent(bp))
+	}
+
+	return writeList(w, items, total)
+}
@@ -0,0 +1,140 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Bunches(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_create_and_deactivate_a_bunch", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var created Bunch
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "admins"}, &created))
+		require.True(t, created.Active)
+
+		var updated Bunch
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPatch, fmt.Sprintf("/bunches/%d", created.ID),
+			map[string]interface{}{"active": false}, &updated))
+		require.False(t, updated.Active)
+		require.Equal(t, "admins", updated.Name)
+
+		var page struct {
+			Total int64    `json:"total"`
+			Items []*Bunch `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunches?active=false", nil, &page))
+		require.Equal(t, int64(1), page.Total)
+		require.Equal(t, created.ID, page.Items[0].ID)
+
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunches?name=admins&active=true", nil, &page))
+		require.Equal(t, int64(0), page.Total)
+		require.Empty(t, page.Items)
+	})
+
+	t.Run("success_grant_query_and_revoke_a_bunch_key", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var (
+			bunch Bunch
+			key   Key
+		)
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "readers"}, &bunch))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys", map[string]string{"name": "read"}, &key))
+
+		var bk BunchKey
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunch-keys",
+			map[string]int64{"bunch_id": bunch.ID, "key_id": key.ID}, &bk))
+		require.Equal(t, bunch.ID, bk.BunchID)
+		require.Equal(t, "read", bk.Key.Name)
+		require.Equal(t, "readers", bk.Bunch.Name)
+
+		var page struct {
+			Total int64       `json:"total"`
+			Items []*BunchKey `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-keys?bunch_name=readers&sort=key_name", nil, &page))
+		require.Equal(t, int64(1), page.Total)
+		require.Equal(t, bk.ID, page.Items[0].ID)
+
+		require.Equal(t, http.StatusNoContent, admin.do(http.MethodDelete, fmt.Sprintf("/bunch-keys/%d", bk.ID), nil, nil))
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-keys?bunch_name=readers", nil, &page))
+		require.Equal(t, int64(0), page.Total)
+	})
+
+	t.Run("success_inherit_query_and_disinherit_a_parent", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var (
+			staff Bunch
+			boss  Bunch
+			key   Key
+		)
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "staff"}, &staff))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "admin"}, &boss))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys", map[string]string{"name": "read"}, &key))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunch-keys",
+			map[string]int64{"bunch_id": staff.ID, "key_id": key.ID}, nil))
+
+		var bp BunchParent
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunch-parents",
+			map[string]int64{"bunch_id": boss.ID, "parent_id": staff.ID}, &bp))
+		require.Equal(t, boss.ID, bp.BunchID)
+		require.Equal(t, "admin", bp.Bunch.Name)
+		require.Equal(t, "staff", bp.Parent.Name)
+
+		var parents struct {
+			Total int64          `json:"total"`
+			Items []*BunchParent `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-parents?parent_name=staff", nil, &parents))
+		require.Equal(t, int64(1), parents.Total)
+		require.Equal(t, bp.ID, parents.Items[0].ID)
+
+		var keys struct {
+			Total int64       `json:"total"`
+			Items []*BunchKey `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-keys?bunch_name=admin", nil, &keys))
+		require.Equal(t, int64(0), keys.Total)
+
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-keys?bunch_name=admin&inherited=true", nil, &keys))
+		require.Equal(t, int64(1), keys.Total)
+		require.Equal(t, "read", keys.Items[0].Key.Name)
+		require.Equal(t, "staff", keys.Items[0].Source.Name)
+
+		var body errorBody
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPost, "/bunch-parents",
+			map[string]int64{"bunch_id": staff.ID, "parent_id": boss.ID}, &body))
+		require.Equal(t, "bunch_cycle", body.Error.Code)
+
+		require.Equal(t, http.StatusNoContent, admin.do(http.MethodDelete, fmt.Sprintf("/bunch-parents/%d", bp.ID), nil, nil))
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-parents?parent_name=staff", nil, &parents))
+		require.Equal(t, int64(0), parents.Total)
+	})
+
+	t.Run("fail_grant_a_missing_key", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var bunch Bunch
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "writers"}, &bunch))
+
+		var body errorBody
+		require.Equal(t, http.StatusUnprocessableEntity, admin.do(http.MethodPost, "/bunch-keys",
+			map[string]int64{"bunch_id": bunch.ID, "key_id": 999}, &body))
+		require.Equal(t, "invalid_reference", body.Error.Code)
+		require.Equal(t, map[string]string{"key_id": "not found"}, body.Error.Fields)
+
+		require.Equal(t, http.StatusBadRequest, admin.do(http.MethodPost, "/bunch-keys", map[string]int64{}, &body))
+		require.Len(t, body.Error.Fields, 2)
+	})
+}
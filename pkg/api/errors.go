@@ -0,0 +1,146 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+// Error is the body of every failed response, wrapped as {"error": Error}
+type Error struct {
+	Status  int               `json:"-"`
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Fields  map[string]string `json:"fields,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+var (
+	errNotFound         = &Error{Status: http.StatusNotFound, Code: "not_found", Message: "resource not found"}
+	errMethodNotAllowed = &Error{Status: http.StatusMethodNotAllowed, Code: "method_not_allowed", Message: "method not allowed"}
+	errForbidden        = &Error{Status: http.StatusForbidden, Code: "forbidden", Message: "missing key for this request"}
+	errInternal         = &Error{Status: http.StatusInternalServerError, Code: "internal", Message: "internal error"}
+)
+
+// statusClientClosedRequest is logged when the client went away before the response
+const statusClientClosedRequest = 499
+
+// toError converts storage errors into response errors, unexpected errors become internal errors
+func toError(err error) *Error {
+	var (
+		apiErr *Error
+		dupErr *storage.DuplicateError
+		fkErr  *storage.ForeignKeyError
+	)
+
+	switch {
+	case errors.As(err, &apiErr):
+		return apiErr
+
+	case errors.Is(err, auth.ErrInvalidCredentials):
+		return &Error{Status: http.StatusUnauthorized, Code: "invalid_credentials", Message: "invalid login or password"}
+
+	case errors.Is(err, auth.ErrLockedOut):
+		return &Error{Status: http.StatusTooManyRequests, Code: "locked_out",
+			Message: "too many failed logins, try again later"}
+
+	case errors.Is(err, auth.ErrMFARequired):
+		return &Error{Status: http.StatusUnauthorized, Code: "mfa_required", Message: "one-time password required"}
+
+	case errors.Is(err, auth.ErrInvalidOTP):
+		return &Error{Status: http.StatusUnauthorized, Code: "invalid_otp", Message: "invalid one-time password"}
+
+	case errors.Is(err, auth.ErrMFAEnrollmentRequired):
+		return &Error{Status: http.StatusForbidden, Code: "mfa_enrollment_required",
+			Message: "a second factor must be enrolled first"}
+
+	case errors.Is(err, auth.ErrMFAEnrolled):
+		return &Error{Status: http.StatusConflict, Code: "mfa_enrolled", Message: "second factor already enrolled"}
+
+	case errors.Is(err, auth.ErrMFANotEnrolled):
+		return &Error{Status: http.StatusConflict, Code: "mfa_not_enrolled", Message: "no second factor enrolled"}
+
+	case errors.Is(err, auth.ErrInvalidResetToken):
+		return &Error{Status: http.StatusBadRequest, Code: "invalid_reset_token",
+			Message: "invalid or expired password reset token"}
+
+	case errors.Is(err, auth.ErrInvalidVerificationToken):
+		return &Error{Status: http.StatusBadRequest, Code: "invalid_verification_token",
+			Message: "invalid or expired email verification token"}
+
+	case errors.Is(err, auth.ErrEmailVerified):
+		return &Error{Status: http.StatusConflict, Code: "email_verified", Message: "email already verified"}
+
+	case errors.Is(err, auth.ErrInactiveUser):
+		return &Error{Status: http.StatusForbidden, Code: "inactive_user", Message: "user is inactive"}
+
+	case errors.Is(err, token.ErrInvalidToken), errors.Is(err, token.ErrTokenReused):
+		return &Error{Status: http.StatusUnauthorized, Code: "invalid_token", Message: "invalid or expired token"}
+
+	case errors.Is(err, storage.ErrNotFound):
+		return errNotFound
+
+	case errors.Is(err, storage.ErrBunchCycle):
+		return &Error{Status: http.StatusConflict, Code: "bunch_cycle", Message: "bunch would inherit from itself"}
+
+	case errors.As(err, &dupErr):
+		if len(dupErr.Field) == 0 {
+			return &Error{Status: http.StatusConflict, Code: "duplicate", Message: "record already exists"}
+		}
+		return &Error{Status: http.StatusConflict, Code: "duplicate", Message: dupErr.Entity + " already exists",
+			Fields: map[string]string{dupErr.Field: "already exists"}}
+
+	case errors.As(err, &fkErr):
+		if len(fkErr.Field) == 0 {
+			return &Error{Status: http.StatusUnprocessableEntity, Code: "invalid_reference",
+				Message: "refers to a missing record"}
+		}
+		return &Error{Status: http.StatusUnprocessableEntity, Code: "invalid_reference",
+			Message: fkErr.Field + " refers to a missing record", Fields: map[string]string{fkErr.Field: "not found"}}
+
+	case isCanceled(err):
+		return &Error{Status: statusClientClosedRequest, Code: "canceled", Message: "request canceled"}
+	}
+
+	log.Printf("api: %v", err)
+	return errInternal
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	e := toError(err)
+
+	var lockErr *auth.LockoutError
+	if errors.As(err, &lockErr) {
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfter(lockErr.Until)))
+	}
+
+	writeJSON(w, e.Status, struct {
+		Error *Error `json:"error"`
+	}{e})
+}
+
+// retryAfter returns the whole seconds to wait until t, at least one
+func retryAfter(t time.Time) int {
+	seconds := int(math.Ceil(time.Until(t).Seconds()))
+	if seconds < 1 {
+		return 1
+	}
+
+	return seconds
+}
+
+// isCanceled tells whether err comes from a request whose client went away
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled)
+}
@@ -0,0 +1,165 @@
+package api
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// Key is the json representation of storage.Key
+type Key struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	Desc      string    `json:"desc"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func newKey(k *storage.Key) *Key {
+	return &Key{ID: k.ID, Name: k.Name, Desc: k.Desc, UpdatedAt: k.UpdatedAt}
+}
+
+type createKeyRequest struct {
+	Name string `json:"name"`
+	Desc string `json:"desc"`
+}
+
+type updateKeyRequest struct {
+	Name *string `json:"name"`
+	Desc *string `json:"desc"`
+}
+
+func (s *Server) createKey(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createKeyRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("name", req.Name, maxKeyName)
+	v.length("desc", req.Desc, maxKeyDesc)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	id, err := s.repo.Keys().Insert(r.Context(), storage.CreateKey{Name: req.Name, Desc: req.Desc})
+	if err != nil {
+		return err
+	}
+
+	key, err := s.repo.Keys().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newKey(key))
+}
+
+func (s *Server) getKey(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	key, err := s.repo.Keys().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, newKey(key))
+}
+
+func (s *Server) updateKey(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	var req updateKeyRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.optional("name", req.Name, maxKeyName)
+	v.optional("desc", req.Desc, maxKeyDesc)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	u := storage.UpdateKey{ID: id}
+	if req.Name != nil {
+		u.Name = *req.Name
+	}
+	if req.Desc != nil {
+		u.Desc = *req.Desc
+	}
+
+	var key *storage.Key
+	err = s.repo.WithTx(r.Context(), func(tx storage.Stores) error {
+		if _, err := tx.Keys().Get(r.Context(), id); err != nil {
+			return err
+		}
+
+		if err := tx.Keys().Update(r.Context(), u); err != nil {
+			return err
+		}
+
+		key, err = tx.Keys().Get(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, newKey(key))
+}
+
+func (s *Server) deleteKey(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.Keys().Delete(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) queryKeys(w http.ResponseWriter, r *http.Request, p params) error {
+	var (
+		q     = newQuery(r)
+		query storage.QueryKey
+		sorts storage.SortKey
+	)
+
+	query.Name = q.string("name")
+	query.Desc = q.string("desc")
+	query.From = q.time("from")
+	query.To = q.time("to")
+	query.Limit, query.Offset = q.page()
+	q.sort(map[string]*share.Direction{
+		"name":       &sorts.Name,
+		"desc":       &sorts.Desc,
+		"updated_at": &sorts.UpdatedAt,
+	})
+	if err := q.err(); err != nil {
+		return err
+	}
+
+	keys, total, err := s.repo.Keys().Query(r.Context(), query, sorts)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*Key, 0, len(keys))
+	for _, k := range keys {
+		items = append(items, newKey(k))
+	}
+
+	return writeList(w, items, total)
+}
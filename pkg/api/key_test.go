@@ -0,0 +1,98 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Keys(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_create_get_update_delete_a_key", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var created Key
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys",
+			map[string]string{"name": "read", "desc": "read things"}, &created))
+		require.Equal(t, "read", created.Name)
+		require.False(t, created.UpdatedAt.IsZero())
+
+		path := fmt.Sprintf("/keys/%d", created.ID)
+
+		var got Key
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, path, nil, &got))
+		require.Equal(t, created, got)
+
+		var updated Key
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPatch, path, map[string]string{"desc": "read more"}, &updated))
+		require.Equal(t, "read", updated.Name)
+		require.Equal(t, "read more", updated.Desc)
+
+		require.Equal(t, http.StatusNoContent, admin.do(http.MethodDelete, path, nil, nil))
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodGet, path, nil, nil))
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodDelete, path, nil, nil))
+	})
+
+	t.Run("fail_create_an_invalid_key", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, admin.do(http.MethodPost, "/keys",
+			map[string]string{"name": strings.Repeat("k", maxKeyName+1), "desc": strings.Repeat("d", maxKeyDesc+1)}, &body))
+		require.Equal(t, "invalid_request", body.Error.Code)
+		require.Contains(t, body.Error.Fields, "name")
+		require.Contains(t, body.Error.Fields, "desc")
+	})
+
+	t.Run("fail_create_a_duplicated_key", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys", map[string]string{"name": "write"}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPost, "/keys", map[string]string{"name": "write"}, &body))
+		require.Equal(t, "duplicate", body.Error.Code)
+		require.Equal(t, map[string]string{"name": "already exists"}, body.Error.Fields)
+	})
+
+	t.Run("fail_update_a_key_to_an_empty_name", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var created Key
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys", map[string]string{"name": "old"}, &created))
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, admin.do(http.MethodPatch, fmt.Sprintf("/keys/%d", created.ID),
+			map[string]string{"name": ""}, &body))
+		require.Equal(t, map[string]string{"name": "is required"}, body.Error.Fields)
+
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodPatch, "/keys/999", map[string]string{"name": "new"}, nil))
+	})
+
+	t.Run("success_query_keys", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		for _, name := range []string{"b_scope", "a_scope", "c_scope", "other"} {
+			require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys", map[string]string{"name": name}, nil))
+		}
+
+		var page struct {
+			Total int64  `json:"total"`
+			Items []*Key `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/keys?name=_scope&sort=-name&limit=2&offset=1", nil, &page))
+		require.Equal(t, int64(3), page.Total)
+		require.Len(t, page.Items, 2)
+		require.Equal(t, "b_scope", page.Items[0].Name)
+		require.Equal(t, "a_scope", page.Items[1].Name)
+	})
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/auth"
+)
+
+// MFA is the json representation of auth.MFAStatus
+type MFA struct {
+	Enrolled      bool  `json:"enrolled"`
+	Pending       bool  `json:"pending"`
+	Required      bool  `json:"required"`
+	RecoveryCodes int64 `json:"recovery_codes"`
+}
+
+// TOTPEnrollment is the json representation of auth.Enrollment, the secret is shown once
+type TOTPEnrollment struct {
+	Secret string `json:"secret"`
+	URI    string `json:"uri"`
+}
+
+// RecoveryCodes is the body listing new recovery codes, they are shown once
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+// MFAEnrollmentRequired is the body refusing the login of a user who must enroll a second factor first. The
+// enrollment token, sent as a bearer token, authenticates the user to GET /mfa, POST /mfa/totp and
+// POST /mfa/totp/confirm until it expires or a secret is confirmed.
+type MFAEnrollmentRequired struct {
+	Error           *Error `json:"error"`
+	EnrollmentToken string `json:"enrollment_token"`
+	ExpiresIn       int64  `json:"expires_in"`
+}
+
+type confirmTOTPRequest struct {
+	Code string `json:"code"`
+}
+
+// deleteTOTPRequest reauthenticates a user removing its own second factor, OTP is a current code or a recovery
+// code
+type deleteTOTPRequest struct {
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+}
+
+// userHandler serves a route for a user resolved by the route
+type userHandler func(w http.ResponseWriter, r *http.Request, userID int64) error
+
+// forSelf serves h for the user authenticated by the bearer token
+func (s *Server) forSelf(h userHandler) handler {
+	return func(w http.ResponseWriter, r *http.Request, p params) error {
+		info, err := s.authenticate(w, r)
+		if err != nil {
+			return err
+		}
+
+		return h(w, r, info.UserID)
+	}
+}
+
+// forEnrollment serves h for the user authenticated by the bearer token, which may be the enrollment token
+// answered to a login refused for a missing second factor
+func (s *Server) forEnrollment(h userHandler) handler {
+	return func(w http.ResponseWriter, r *http.Request, p params) error {
+		header := r.Header.Get("Authorization")
+		if len(header) > len(bearerScheme) && strings.EqualFold(header[:len(bearerScheme)], bearerScheme) {
+			userID, err := s.auth.EnrollmentUser(r.Context(), strings.TrimSpace(header[len(bearerScheme):]))
+			if err == nil {
+				return h(w, r, userID)
+			}
+			if !errors.Is(err, auth.ErrInvalidEnrollmentToken) {
+				return err
+			}
+		}
+
+		return s.forSelf(h)(w, r, p)
+	}
+}
+
+// forUser serves h for the user of the ":id" segment when the bearer token authenticates that user, or a user
+// holding modify_user. Others are forbidden whether the user exists or not.
+func (s *Server) forUser(h userHandler) handler {
+	return func(w http.ResponseWriter, r *http.Request, p params) error {
+		info, err := s.authenticate(w, r)
+		if err != nil {
+			return err
+		}
+
+		id, err := p.id()
+		if err != nil {
+			return err
+		}
+
+		if id != info.UserID {
+			ok, err := s.repo.Permissions().HasKey(r.Context(), info.UserID, keyModifyUser)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errForbidden
+			}
+		}
+
+		if _, err := s.repo.Users().Get(r.Context(), id); err != nil {
+			return err
+		}
+
+		return h(w, r, id)
+	}
+}
+
+func (s *Server) getMFA(w http.ResponseWriter, r *http.Request, userID int64) error {
+	status, err := s.auth.MFA(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, &MFA{Enrolled: status.Enrolled, Pending: status.Pending,
+		Required: status.Required, RecoveryCodes: status.RecoveryCodes})
+}
+
+// enrollTOTP generates a TOTP secret, which guards logins once confirmed
+func (s *Server) enrollTOTP(w http.ResponseWriter, r *http.Request, userID int64) error {
+	enrollment, err := s.auth.EnrollTOTP(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusCreated, &TOTPEnrollment{Secret: enrollment.Secret, URI: enrollment.URI})
+}
+
+// confirmTOTP confirms an enrolled TOTP secret with a first code and answers the recovery codes
+func (s *Server) confirmTOTP(w http.ResponseWriter, r *http.Request, userID int64) error {
+	var req confirmTOTPRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("code", req.Code, maxOTP)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	codes, err := s.auth.ConfirmTOTP(r.Context(), userID, req.Code)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, &RecoveryCodes{RecoveryCodes: codes})
+}
+
+// deleteTOTP removes the second factor of the authenticated user, who logs in again with its password and a
+// code so a stolen access token alone cannot remove it
+func (s *Server) deleteTOTP(w http.ResponseWriter, r *http.Request, userID int64) error {
+	var req deleteTOTPRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.password("password", req.Password)
+	v.length("otp", req.OTP, maxOTP)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	err := s.auth.Reauthenticate(r.Context(), userID, auth.Credentials{Password: req.Password, OTP: req.OTP,
+		IP: s.tokens.ClientIP(clientOf(r))})
+	if err != nil {
+		return err
+	}
+
+	return s.disableTOTP(w, r, userID)
+}
+
+// deleteUserTOTP removes the second factor of a user who lost it, the route needs modify_user
+func (s *Server) deleteUserTOTP(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.repo.Users().Get(r.Context(), id); err != nil {
+		return err
+	}
+
+	return s.disableTOTP(w, r, id)
+}
+
+func (s *Server) disableTOTP(w http.ResponseWriter, r *http.Request, userID int64) error {
+	if err := s.auth.DisableTOTP(r.Context(), userID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// writeEnrollmentRequired refuses a login for a missing second factor with the token enrolling one
+func writeEnrollmentRequired(w http.ResponseWriter, err *auth.EnrollmentRequiredError) error {
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusForbidden, &MFAEnrollmentRequired{Error: toError(err),
+		EnrollmentToken: err.Token, ExpiresIn: int64(time.Until(err.ExpiredAt) / time.Second)})
+}
+
+// regenerateRecoveryCodes replaces the recovery codes, the previous ones stop working
+func (s *Server) regenerateRecoveryCodes(w http.ResponseWriter, r *http.Request, userID int64) error {
+	codes, err := s.auth.RegenerateRecoveryCodes(r.Context(), userID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, &RecoveryCodes{RecoveryCodes: codes})
+}
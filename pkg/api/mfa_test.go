@@ -0,0 +1,198 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/totp"
+)
+
+func TestServer_MFA(t *testing.T) {
+	t.Parallel()
+
+	// code returns a TOTP code of secret, steps away from the current one
+	code := func(t *testing.T, secret string, steps int64) string {
+		code, err := totp.Code(secret, totp.Step(time.Now())+steps)
+		require.Nil(t, err)
+		return code
+	}
+
+	t.Run("success_enroll_and_log_in_with_a_second_factor", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": "alice", "email": "alice@test.com", "password": "secret",
+		}, nil))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "secret"}, &tok))
+		me := ts.as(tok.AccessToken)
+
+		var enrollment TOTPEnrollment
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/mfa/totp", nil, nil))
+		require.Equal(t, http.StatusCreated, me.do(http.MethodPost, "/mfa/totp", nil, &enrollment))
+		require.NotEmpty(t, enrollment.Secret)
+		require.Contains(t, enrollment.URI, "otpauth://totp/")
+
+		var body errorBody
+		require.Equal(t, http.StatusUnauthorized, me.do(http.MethodPost, "/mfa/totp/confirm",
+			map[string]string{"code": "abcdef"}, &body))
+		require.Equal(t, "invalid_otp", body.Error.Code)
+
+		var codes RecoveryCodes
+		require.Equal(t, http.StatusOK, me.do(http.MethodPost, "/mfa/totp/confirm",
+			map[string]string{"code": code(t, enrollment.Secret, 0)}, &codes))
+		require.Len(t, codes.RecoveryCodes, 10)
+
+		var status MFA
+		require.Equal(t, http.StatusOK, me.do(http.MethodGet, "/mfa", nil, &status))
+		require.Equal(t, MFA{Enrolled: true, RecoveryCodes: 10}, status)
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "secret"}, &body))
+		require.Equal(t, "mfa_required", body.Error.Code)
+
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "secret", "otp": code(t, enrollment.Secret, 1)}, nil))
+
+		require.Equal(t, http.StatusConflict, me.do(http.MethodPost, "/mfa/totp", nil, &body))
+		require.Equal(t, "mfa_enrolled", body.Error.Code)
+
+		// the access token alone does not remove the second factor
+		require.Equal(t, http.StatusBadRequest, me.do(http.MethodDelete, "/mfa/totp", nil, nil))
+		require.Equal(t, http.StatusUnauthorized, me.do(http.MethodDelete, "/mfa/totp",
+			map[string]string{"password": "secret"}, &body))
+		require.Equal(t, "mfa_required", body.Error.Code)
+		require.Equal(t, http.StatusNoContent, me.do(http.MethodDelete, "/mfa/totp",
+			map[string]string{"password": "secret", "otp": codes.RecoveryCodes[0]}, nil))
+
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "secret"}, nil))
+
+		require.Equal(t, http.StatusUnauthorized, me.do(http.MethodDelete, "/mfa/totp",
+			map[string]string{"password": "wrong"}, &body))
+		require.Equal(t, "invalid_credentials", body.Error.Code)
+	})
+
+	t.Run("success_enroll_a_required_second_factor_with_the_enrollment_token", func(t *testing.T) {
+		ts := newTestServerWith(t, auth.Config{MFA: auth.MFAConfig{RequiredBunches: []string{"admin_role"}}})
+		admin := ts.admin()
+		ctx := context.Background()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "dave", "email": "dave@test.com", "password": "secret",
+		}, &user))
+		bunchID, err := ts.srv.repo.Bunches().Insert(ctx, storage.CreateBunch{Name: "admin_role"})
+		require.Nil(t, err)
+		_, err = ts.srv.repo.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: user.ID, BunchID: bunchID})
+		require.Nil(t, err)
+
+		var refused MFAEnrollmentRequired
+		require.Equal(t, http.StatusForbidden, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "dave", "password": "secret"}, &refused))
+		require.Equal(t, "mfa_enrollment_required", refused.Error.Code)
+		require.NotEmpty(t, refused.EnrollmentToken)
+		require.True(t, refused.ExpiresIn > 0)
+
+		// the enrollment token serves nothing but the enrollment
+		enrolling := ts.as(refused.EnrollmentToken)
+		require.Equal(t, http.StatusUnauthorized, enrolling.do(http.MethodGet, "/sessions", nil, nil))
+		require.Equal(t, http.StatusUnauthorized, enrolling.do(http.MethodPost, "/mfa/recovery-codes", nil, nil))
+		require.Equal(t, http.StatusUnauthorized, enrolling.do(http.MethodGet,
+			fmt.Sprintf("/users/%d/mfa", user.ID), nil, nil))
+
+		var status MFA
+		require.Equal(t, http.StatusOK, enrolling.do(http.MethodGet, "/mfa", nil, &status))
+		require.Equal(t, MFA{Required: true}, status)
+
+		var enrollment TOTPEnrollment
+		require.Equal(t, http.StatusCreated, enrolling.do(http.MethodPost, "/mfa/totp", nil, &enrollment))
+		require.Contains(t, enrollment.URI, "dave@test.com")
+
+		var codes RecoveryCodes
+		require.Equal(t, http.StatusOK, enrolling.do(http.MethodPost, "/mfa/totp/confirm",
+			map[string]string{"code": code(t, enrollment.Secret, 0)}, &codes))
+		require.Len(t, codes.RecoveryCodes, 10)
+
+		// a confirmed secret ends the enrollment token
+		require.Equal(t, http.StatusUnauthorized, enrolling.do(http.MethodGet, "/mfa", nil, nil))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens", map[string]string{
+			"login": "dave", "password": "secret", "otp": code(t, enrollment.Secret, 1),
+		}, &tok))
+		require.NotEmpty(t, tok.AccessToken)
+	})
+
+	t.Run("success_reset_the_second_factor_of_a_user", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "bob", "email": "bob@test.com", "password": "secret",
+		}, &user))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "carol", "email": "carol@test.com", "password": "secret",
+		}, nil))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "carol", "password": "secret"}, &tok))
+		carol := ts.as(tok.AccessToken)
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "bob", "password": "secret"}, &tok))
+		bob := ts.as(tok.AccessToken)
+		path := fmt.Sprintf("/users/%d/mfa", user.ID)
+
+		var body errorBody
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodGet, path, nil, nil))
+		require.Equal(t, http.StatusForbidden, carol.do(http.MethodPost, path+"/totp", nil, &body))
+		require.Equal(t, "forbidden", body.Error.Code)
+
+		var enrollment TOTPEnrollment
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, path+"/totp", nil, &enrollment))
+
+		var codes RecoveryCodes
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPost, path+"/totp/confirm",
+			map[string]string{"code": code(t, enrollment.Secret, 0)}, &codes))
+
+		var regenerated RecoveryCodes
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPost, path+"/recovery-codes", nil, &regenerated))
+		require.Len(t, regenerated.RecoveryCodes, 10)
+		require.NotEqual(t, codes.RecoveryCodes, regenerated.RecoveryCodes)
+
+		var status MFA
+		require.Equal(t, http.StatusOK, bob.do(http.MethodGet, path, nil, &status))
+		require.Equal(t, MFA{Enrolled: true, RecoveryCodes: 10}, status)
+
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens", map[string]string{
+			"login": "bob", "password": "secret", "otp": regenerated.RecoveryCodes[0],
+		}, nil))
+
+		require.Equal(t, http.StatusForbidden, carol.do(http.MethodDelete, path+"/totp", nil, nil))
+		require.Equal(t, http.StatusForbidden, bob.do(http.MethodDelete, path+"/totp", nil, nil))
+		require.Equal(t, http.StatusNoContent, admin.do(http.MethodDelete, path+"/totp", nil, nil))
+
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodDelete, path+"/totp", nil, &body))
+		require.Equal(t, "mfa_not_enrolled", body.Error.Code)
+
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, path, nil, &status))
+		require.Equal(t, MFA{}, status)
+
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "bob", "password": "secret"}, nil))
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodGet, fmt.Sprintf("/users/%d/mfa", user.ID+100),
+			nil, nil))
+		require.Equal(t, http.StatusForbidden, carol.do(http.MethodGet, fmt.Sprintf("/users/%d/mfa", user.ID+100),
+			nil, nil))
+	})
+}
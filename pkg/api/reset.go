@@ -0,0 +1,59 @@
+package api
+
+import (
+	"net/http"
+)
+
+type requestPasswordResetRequest struct {
+	Email string `json:"email"`
+}
+
+type resetPasswordRequest struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
+// requestPasswordReset mails a reset token to the user with the email. It answers the same whether the email
+// has an account or not.
+func (s *Server) requestPasswordReset(w http.ResponseWriter, r *http.Request, p params) error {
+	var req requestPasswordResetRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("email", req.Email, maxEmail)
+	v.email("email", req.Email)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	if err := s.auth.RequestPasswordReset(r.Context(), req.Email); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// resetPassword sets a new password with a mailed reset token, every session of the user is signed out
+func (s *Server) resetPassword(w http.ResponseWriter, r *http.Request, p params) error {
+	var req resetPasswordRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("token", req.Token, maxToken)
+	v.password("password", req.Password)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	if err := s.auth.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// resetTokenRegexp finds the reset and verification tokens mailed alone on their line
+var resetTokenRegexp = regexp.MustCompile(`(?m)^[A-Za-z0-9_-]{43}$`)
+
+func TestServer_PasswordReset(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_reset_a_password_signing_out_sessions", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": "alice", "email": "alice@test.com", "password": "secret",
+		}, nil))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "secret"}, &tok))
+
+		// drop the verification mailed on creation
+		ts.mails.Reset()
+		require.Equal(t, http.StatusAccepted, ts.do(http.MethodPost, "/password-resets",
+			map[string]string{"email": "alice@test.com"}, nil))
+		require.Contains(t, ts.mails.String(), "To: alice@test.com\n")
+		reset := resetTokenRegexp.FindString(ts.mails.String())
+		require.NotEmpty(t, reset)
+
+		require.Equal(t, http.StatusNoContent, ts.do(http.MethodPost, "/password-resets/confirm",
+			map[string]string{"token": reset, "password": "new secret"}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/password-resets/confirm",
+			map[string]string{"token": reset, "password": "other secret"}, &body))
+		require.Equal(t, "invalid_reset_token", body.Error.Code)
+
+		require.Equal(t, http.StatusUnauthorized, ts.as(tok.AccessToken).do(http.MethodGet, "/sessions", nil, nil))
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "new secret"}, nil))
+	})
+
+	t.Run("success_answer_unknown_emails_alike", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		require.Equal(t, http.StatusAccepted, ts.do(http.MethodPost, "/password-resets",
+			map[string]string{"email": "nobody@test.com"}, nil))
+		require.Zero(t, ts.mails.Len())
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/password-resets",
+			map[string]string{"email": "nobody"}, &body))
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/password-resets/confirm",
+			map[string]string{"token": "", "password": "new secret"}, &body))
+	})
+}
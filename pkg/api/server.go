@@ -0,0 +1,215 @@
+// Package api exposes the storage layer as a JSON REST API.
+//
+// Collections are listed with GET on their path. Filters are query parameters named after the fields of the
+// storage Query* structs, sorts are given as sort=field,-field where a leading "-" sorts descendant, and
+// pages are selected with limit and offset. A list responds {"total": n, "items": [...]} where total is the
+// number of matching records regardless of the page. Failures respond {"error": {...}}, see Error.
+//
+// Routes administering keys, bunches and users need a bearer access token of an active user holding the key
+// guarding the route, they respond 401 without a valid token and 403 without the key. The routes managing the
+// second factor and the email verification of a user also serve that user without the key, but for removing
+// the second factor, which the user does with DELETE /mfa/totp and its credentials. A login refused for a
+// second factor the user must enroll answers an enrollment token, which only serves the routes enrolling one.
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+// maxBodyBytes bounds the size of request bodies
+const maxBodyBytes = 1 << 20
+
+// handler serves a matched route, the returned error is written as an error body
+type handler func(w http.ResponseWriter, r *http.Request, p params) error
+
+type route struct {
+	method   string
+	segments []string
+	handle   handler
+}
+
+// params holds the values of the ":name" segments of a matched route
+type params map[string]string
+
+// id parses the ":id" segment
+func (p params) id() (int64, error) {
+	id, err := strconv.ParseInt(p["id"], 10, 64)
+	if err != nil || id <= 0 {
+		return 0, errNotFound
+	}
+
+	return id, nil
+}
+
+// Server serves the REST API on top of a storage repository
+type Server struct {
+	repo   storage.Repository
+	auth   *auth.Service
+	tokens *token.Issuer
+	routes []*route
+}
+
+// NewServer creates new instance of Server
+func NewServer(repo storage.Repository, authService *auth.Service, tokens *token.Issuer) *Server {
+	s := &Server{repo: repo, auth: authService, tokens: tokens}
+
+	s.handle(http.MethodGet, "/keys", s.withKey(keyQueryKey, s.queryKeys))
+	s.handle(http.MethodPost, "/keys", s.withKey(keyAddKey, s.createKey))
+	s.handle(http.MethodGet, "/keys/:id", s.withKey(keyGetKey, s.getKey))
+	s.handle(http.MethodPatch, "/keys/:id", s.withKey(keyModifyKey, s.updateKey))
+	s.handle(http.MethodDelete, "/keys/:id", s.withKey(keyModifyKey, s.deleteKey))
+
+	s.handle(http.MethodGet, "/bunches", s.withKey(keyQueryBunch, s.queryBunches))
+	s.handle(http.MethodPost, "/bunches", s.withKey(keyAddBunch, s.createBunch))
+	s.handle(http.MethodGet, "/bunches/:id", s.withKey(keyGetBunch, s.getBunch))
+	s.handle(http.MethodPatch, "/bunches/:id", s.withKey(keyModifyBunch, s.updateBunch))
+
+	s.handle(http.MethodGet, "/bunch-keys", s.withKey(keyQueryBunch, s.queryBunchKeys))
+	s.handle(http.MethodPost, "/bunch-keys", s.withKey(keyModifyBunch, s.createBunchKey))
+	s.handle(http.MethodDelete, "/bunch-keys/:id", s.withKey(keyModifyBunch, s.deleteBunchKey))
+
+	s.handle(http.MethodGet, "/bunch-parents", s.withKey(keyQueryBunch, s.queryBunchParents))
+	s.handle(http.MethodPost, "/bunch-parents", s.withKey(keyModifyBunch, s.createBunchParent))
+	s.handle(http.MethodDelete, "/bunch-parents/:id", s.withKey(keyModifyBunch, s.deleteBunchParent))
+
+	s.handle(http.MethodGet, "/users", s.withKey(keyModifyUser, s.queryUsers))
+	s.handle(http.MethodPost, "/users", s.withKey(keyAddUser, s.createUser))
+	s.handle(http.MethodGet, "/users/:id", s.withKey(keyModifyUser, s.getUser))
+	s.handle(http.MethodPatch, "/users/:id", s.withKey(keyModifyUser, s.updateUser))
+	s.handle(http.MethodPost, "/users/:id/unlock", s.withKey(keyModifyUser, s.unlockUser))
+	s.handle(http.MethodGet, "/users/:id/sessions", s.withKey(keyModifyUser, s.queryUserSessions))
+	s.handle(http.MethodDelete, "/users/:id/sessions", s.withKey(keyModifyUser, s.deleteUserSessions))
+	s.handle(http.MethodGet, "/users/:id/mfa", s.forUser(s.getMFA))
+	s.handle(http.MethodPost, "/users/:id/mfa/totp", s.forUser(s.enrollTOTP))
+	s.handle(http.MethodPost, "/users/:id/mfa/totp/confirm", s.forUser(s.confirmTOTP))
+	s.handle(http.MethodDelete, "/users/:id/mfa/totp", s.withKey(keyModifyUser, s.deleteUserTOTP))
+	s.handle(http.MethodPost, "/users/:id/mfa/recovery-codes", s.forUser(s.regenerateRecoveryCodes))
+	s.handle(http.MethodPost, "/users/:id/email-verifications", s.forUser(s.requestEmailVerification))
+
+	s.handle(http.MethodGet, "/user-bunches", s.withKey(keyModifyUser, s.queryUserBunches))
+	s.handle(http.MethodPost, "/user-bunches", s.withKey(keyModifyUser, s.createUserBunch))
+	s.handle(http.MethodDelete, "/user-bunches/:id", s.withKey(keyModifyUser, s.deleteUserBunch))
+
+	s.handle(http.MethodPost, "/tokens", s.createToken)
+	s.handle(http.MethodPost, "/tokens/refresh", s.refreshToken)
+	s.handle(http.MethodPost, "/tokens/revoke", s.revokeToken)
+	s.handle(http.MethodPost, "/tokens/introspect", s.withKey(keyIntrospectToken, s.introspectToken))
+	s.handle(http.MethodGet, "/.well-known/jwks.json", s.jwks)
+
+	s.handle(http.MethodGet, "/sessions", s.querySessions)
+	s.handle(http.MethodPost, "/sessions/revoke-others", s.revokeOtherSessions)
+	s.handle(http.MethodDelete, "/sessions/:id", s.deleteSession)
+
+	s.handle(http.MethodGet, "/mfa", s.forEnrollment(s.getMFA))
+	s.handle(http.MethodPost, "/mfa/totp", s.forEnrollment(s.enrollTOTP))
+	s.handle(http.MethodPost, "/mfa/totp/confirm", s.forEnrollment(s.confirmTOTP))
+	s.handle(http.MethodDelete, "/mfa/totp", s.forSelf(s.deleteTOTP))
+	s.handle(http.MethodPost, "/mfa/recovery-codes", s.forSelf(s.regenerateRecoveryCodes))
+
+	s.handle(http.MethodPost, "/password-resets", s.requestPasswordReset)
+	s.handle(http.MethodPost, "/password-resets/confirm", s.resetPassword)
+
+	s.handle(http.MethodPost, "/email-verifications", s.forSelf(s.requestEmailVerification))
+	s.handle(http.MethodPost, "/email-verifications/confirm", s.verifyEmail)
+
+	return s
+}
+
+func (s *Server) handle(method string, pattern string, h handler) {
+	s.routes = append(s.routes, &route{method, splitPath(pattern), h})
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := splitPath(r.URL.Path)
+
+	allowed := make([]string, 0)
+	for _, rt := range s.routes {
+		p, ok := rt.match(segments)
+		if !ok {
+			continue
+		}
+
+		if rt.method != r.Method {
+			allowed = append(allowed, rt.method)
+			continue
+		}
+
+		if err := rt.handle(w, r, p); err != nil {
+			writeError(w, err)
+		}
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		writeError(w, errMethodNotAllowed)
+		return
+	}
+
+	writeError(w, errNotFound)
+}
+
+func (rt *route) match(segments []string) (params, bool) {
+	if len(segments) != len(rt.segments) {
+		return nil, false
+	}
+
+	p := make(params)
+	for i, seg := range rt.segments {
+		if strings.HasPrefix(seg, ":") {
+			p[seg[1:]] = segments[i]
+		} else if seg != segments[i] {
+			return nil, false
+		}
+	}
+
+	return p, true
+}
+
+func splitPath(path string) []string {
+	return strings.Split(strings.Trim(path, "/"), "/")
+}
+
+// decode reads a json body into v, unknown fields are rejected
+func decode(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(r.Body, maxBodyBytes))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(v); err != nil {
+		return &Error{Status: http.StatusBadRequest, Code: "invalid_body", Message: err.Error()}
+	}
+
+	return nil
+}
+
+// writeJSON writes v with status, encoding errors happen after the status is sent so they are only logged
+func writeJSON(w http.ResponseWriter, status int, v interface{}) error {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("api: writing response: %v", err)
+	}
+
+	return nil
+}
+
+// list is the body of a page of records
+type list struct {
+	Total int64       `json:"total"`
+	Items interface{} `json:"items"`
+}
+
+func writeList(w http.ResponseWriter, items interface{}, total int64) error {
+	return writeJSON(w, http.StatusOK, &list{total, items})
+}
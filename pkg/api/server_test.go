@@ -0,0 +1,258 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/mail"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/memory"
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+type testServer struct {
+	t      *testing.T
+	srv    *Server
+	bearer string
+
+	// mails receives the messages mailed by the server
+	mails *bytes.Buffer
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerWith(t, auth.Config{})
+}
+
+// newTestServerWith creates a test server whose auth service runs with config, its mailer is replaced
+func newTestServerWith(t *testing.T, config auth.Config) *testServer {
+	repo := memory.NewRepository(memory.NewDB())
+	signer, err := token.NewHS256Signer([]byte("0123456789abcdef0123456789abcdef"))
+	require.Nil(t, err)
+
+	mails := new(bytes.Buffer)
+	config.Mailer = mail.NewWriterMailer(mails)
+	authService := auth.NewService(repo, password.NewHasher(&password.Bcrypt{Cost: 4}, nil), config)
+
+	return &testServer{t: t, srv: NewServer(repo, authService, token.NewIssuer(repo, signer, token.Config{})),
+		mails: mails}
+}
+
+// as returns a copy of the server whose requests are authenticated with an access token
+func (ts *testServer) as(accessToken string) *testServer {
+	return &testServer{t: ts.t, srv: ts.srv, bearer: accessToken, mails: ts.mails}
+}
+
+// adminKeys are the keys guarding the administration routes
+var adminKeys = []string{keyAddKey, keyModifyKey, keyGetKey, keyQueryKey, keyAddBunch, keyModifyBunch, keyGetBunch,
+	keyQueryBunch, keyAddUser, keyModifyUser, keyIntrospectToken}
+
+// admin returns a copy of the server whose requests are authenticated as a user named root holding every key
+// of the administration routes through a bunch named root_role, both are created by the first call
+func (ts *testServer) admin() *testServer {
+	ctx := context.Background()
+	repo := ts.srv.repo
+
+	if user, err := repo.Users().GetByName(ctx, "root"); err == nil {
+		tok, err := ts.srv.tokens.Issue(ctx, user, token.Client{})
+		require.Nil(ts.t, err)
+		return ts.as(tok.AccessToken)
+	}
+
+	userID, err := repo.Users().Insert(ctx, storage.CreateUser{Username: "root", Email: "root@example.org",
+		Hash: "hash", Salt: "salt"})
+	require.Nil(ts.t, err)
+	bunchID, err := repo.Bunches().Insert(ctx, storage.CreateBunch{Name: "root_role"})
+	require.Nil(ts.t, err)
+	_, err = repo.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: bunchID})
+	require.Nil(ts.t, err)
+
+	for _, name := range adminKeys {
+		keyID, err := repo.Keys().Insert(ctx, storage.CreateKey{Name: name})
+		require.Nil(ts.t, err)
+		_, err = repo.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: bunchID, KeyID: keyID})
+		require.Nil(ts.t, err)
+	}
+
+	user, err := repo.Users().Get(ctx, userID)
+	require.Nil(ts.t, err)
+	tok, err := ts.srv.tokens.Issue(ctx, user, token.Client{})
+	require.Nil(ts.t, err)
+
+	return ts.as(tok.AccessToken)
+}
+
+// do sends a request with body encoded as json and decodes the response into out when given
+func (ts *testServer) do(method string, path string, body interface{}, out interface{}) int {
+	var reader *bytes.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = bytes.NewReader(nil)
+	case string:
+		reader = bytes.NewReader([]byte(b))
+	default:
+		data, err := json.Marshal(b)
+		require.Nil(ts.t, err)
+		reader = bytes.NewReader(data)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if len(ts.bearer) > 0 {
+		req.Header.Set("Authorization", "Bearer "+ts.bearer)
+	}
+	rec := httptest.NewRecorder()
+	ts.srv.ServeHTTP(rec, req)
+
+	if out != nil && rec.Body.Len() > 0 {
+		require.Nil(ts.t, json.Unmarshal(rec.Body.Bytes(), out), rec.Body.String())
+	}
+
+	return rec.Code
+}
+
+type errorBody struct {
+	Error *Error `json:"error"`
+}
+
+func TestServer_Routing(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fail_unknown_path", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		var body errorBody
+		require.Equal(t, http.StatusNotFound, ts.do(http.MethodGet, "/nothing", nil, &body))
+		require.Equal(t, "not_found", body.Error.Code)
+	})
+
+	t.Run("fail_wrong_method", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		req := httptest.NewRequest(http.MethodPut, "/keys/1", nil)
+		rec := httptest.NewRecorder()
+		ts.srv.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+		require.Equal(t, "GET, PATCH, DELETE", rec.Header().Get("Allow"))
+	})
+
+	t.Run("fail_invalid_id", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodGet, "/keys/abc", nil, nil))
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodGet, "/keys/0", nil, nil))
+	})
+
+	t.Run("fail_invalid_body", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, admin.do(http.MethodPost, "/keys", "{", &body))
+		require.Equal(t, "invalid_body", body.Error.Code)
+
+		require.Equal(t, http.StatusBadRequest, admin.do(http.MethodPost, "/keys", `{"name":"a","color":"red"}`, &body))
+		require.Equal(t, "invalid_body", body.Error.Code)
+	})
+
+	t.Run("fail_invalid_query", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var body errorBody
+		status := admin.do(http.MethodGet, "/bunches?limit=0&active=maybe&from=yesterday&sort=color", nil, &body)
+		require.Equal(t, http.StatusBadRequest, status)
+		require.Equal(t, "invalid_request", body.Error.Code)
+		require.Len(t, body.Error.Fields, 4)
+		require.Contains(t, body.Error.Fields, "limit")
+		require.Contains(t, body.Error.Fields, "active")
+		require.Contains(t, body.Error.Fields, "from")
+		require.Contains(t, body.Error.Fields, "sort")
+	})
+}
+
+func TestServer_Authorization(t *testing.T) {
+	t.Parallel()
+
+	t.Run("fail_without_a_token", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		req := httptest.NewRequest(http.MethodGet, "/keys", nil)
+		rec := httptest.NewRecorder()
+		ts.srv.ServeHTTP(rec, req)
+
+		require.Equal(t, http.StatusUnauthorized, rec.Code)
+		require.Equal(t, "Bearer", rec.Header().Get("WWW-Authenticate"))
+		require.Equal(t, http.StatusUnauthorized, ts.as("nope").do(http.MethodPost, "/bunches",
+			map[string]string{"name": "staff"}, nil))
+	})
+
+	t.Run("fail_without_the_key", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "alice", "email": "alice@test.com", "password": "secret",
+		}, nil))
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice", "password": "secret"}, &tok))
+		alice := ts.as(tok.AccessToken)
+
+		var body errorBody
+		require.Equal(t, http.StatusForbidden, alice.do(http.MethodGet, "/keys", nil, &body))
+		require.Equal(t, "forbidden", body.Error.Code)
+		require.Equal(t, http.StatusForbidden, alice.do(http.MethodPatch, "/users/1",
+			map[string]interface{}{"active": false}, nil))
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/keys", nil, nil))
+	})
+
+	t.Run("fail_once_the_key_is_taken_away", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var page struct {
+			Items []*BunchKey `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/bunch-keys?key_name=query_key", nil, &page))
+		require.Len(t, page.Items, 1)
+		require.Equal(t, http.StatusNoContent, admin.do(http.MethodDelete,
+			fmt.Sprintf("/bunch-keys/%d", page.Items[0].ID), nil, nil))
+
+		require.Equal(t, http.StatusForbidden, admin.do(http.MethodGet, "/keys", nil, nil))
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/keys/1", nil, nil))
+	})
+}
+
+func TestValidator(t *testing.T) {
+	t.Parallel()
+
+	v := newValidator()
+	v.required("empty", "  ", 10)
+	v.required("ok", "fine", 10)
+	v.length("long", strings.Repeat("é", 11), 10)
+	v.length("runes", strings.Repeat("é", 10), 10)
+	v.email("email", "not an email")
+	v.email("named", "Bob <bob@test.com>")
+	v.email("good", "bob@test.com")
+	v.password("password", strings.Repeat("x", maxPassword+1))
+
+	err := v.err()
+	require.NotNil(t, err)
+	require.Equal(t, map[string]string{
+		"empty":    "is required",
+		"long":     "must be at most 10 characters",
+		"email":    "must be an email address",
+		"named":    "must be an email address",
+		"password": "must be at most 1024 bytes",
+	}, err.(*Error).Fields)
+}
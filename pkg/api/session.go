@@ -0,0 +1,147 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+// Session is the json representation of token.Session, Current marks the session of the requesting token
+type Session struct {
+	ID             string    `json:"id"`
+	Current        bool      `json:"current"`
+	IP             string    `json:"ip"`
+	UserAgent      string    `json:"user_agent"`
+	Browser        string    `json:"browser"`
+	BrowserVersion string    `json:"browser_version"`
+	OS             string    `json:"os"`
+	OSVersion      string    `json:"os_version"`
+	SignedInAt     time.Time `json:"signed_in_at"`
+	LastUsedAt     time.Time `json:"last_used_at"`
+	ExpiresAt      time.Time `json:"expires_at"`
+}
+
+func newSessions(sessions []*token.Session, current string) []*Session {
+	views := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		views = append(views, &Session{ID: s.ID, Current: s.ID == current, IP: s.IP, UserAgent: s.UserAgent,
+			Browser: s.Agent.Browser, BrowserVersion: s.Agent.BrowserVersion, OS: s.Agent.OS,
+			OSVersion: s.Agent.OSVersion, SignedInAt: s.SignedInAt, LastUsedAt: s.LastUsedAt, ExpiresAt: s.ExpiresAt})
+	}
+
+	return views
+}
+
+// revokedSessions is the body answering a sign-out of several sessions, Revoked counts the revoked tokens
+type revokedSessions struct {
+	Revoked int64 `json:"revoked"`
+}
+
+// bearerScheme prefixes the access token in the Authorization header, RFC 6750
+const bearerScheme = "Bearer "
+
+// authenticate returns the introspection of the active access token sent as bearer token with r
+func (s *Server) authenticate(w http.ResponseWriter, r *http.Request) (*token.Introspection, error) {
+	header := r.Header.Get("Authorization")
+	if len(header) <= len(bearerScheme) || !strings.EqualFold(header[:len(bearerScheme)], bearerScheme) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		return nil, token.ErrInvalidToken
+	}
+
+	info, err := s.tokens.Introspect(r.Context(), strings.TrimSpace(header[len(bearerScheme):]))
+	if err != nil {
+		return nil, err
+	}
+	if !info.Active || info.TokenType != token.TypeAccessToken {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		return nil, token.ErrInvalidToken
+	}
+
+	return info, nil
+}
+
+// querySessions lists the live sessions of the authenticated user
+func (s *Server) querySessions(w http.ResponseWriter, r *http.Request, p params) error {
+	info, err := s.authenticate(w, r)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := s.tokens.Sessions(r.Context(), info.UserID)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeList(w, newSessions(sessions, info.SessionID), int64(len(sessions)))
+}
+
+// deleteSession signs the authenticated user out of one of its sessions, the current one included
+func (s *Server) deleteSession(w http.ResponseWriter, r *http.Request, p params) error {
+	info, err := s.authenticate(w, r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokens.RevokeSession(r.Context(), info.UserID, p["id"]); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// revokeOtherSessions signs the authenticated user out of every session but the current one
+func (s *Server) revokeOtherSessions(w http.ResponseWriter, r *http.Request, p params) error {
+	info, err := s.authenticate(w, r)
+	if err != nil {
+		return err
+	}
+
+	revoked, err := s.tokens.RevokeSessions(r.Context(), info.UserID, info.SessionID)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, &revokedSessions{Revoked: revoked})
+}
+
+// queryUserSessions lists the live sessions of any user, the route needs modify_user
+func (s *Server) queryUserSessions(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.repo.Users().Get(r.Context(), id); err != nil {
+		return err
+	}
+
+	sessions, err := s.tokens.Sessions(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return writeList(w, newSessions(sessions, ""), int64(len(sessions)))
+}
+
+// deleteUserSessions forces any user to sign out of every session, the route needs modify_user
+func (s *Server) deleteUserSessions(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.repo.Users().Get(r.Context(), id); err != nil {
+		return err
+	}
+
+	revoked, err := s.tokens.RevokeSessions(r.Context(), id, "")
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, &revokedSessions{Revoked: revoked})
+}
@@ -0,0 +1,126 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_Sessions(t *testing.T) {
+	t.Parallel()
+
+	type sessionPage struct {
+		Total int64      `json:"total"`
+		Items []*Session `json:"items"`
+	}
+
+	// signIn creates a user and signs it in twice, the second login being the current session
+	signIn := func(ts *testServer, username string) (User, Token, Token) {
+		var user User
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": username, "email": username + "@test.com", "password": "secret",
+		}, &user))
+
+		var other, current Token
+		for _, tok := range []*Token{&other, &current} {
+			require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+				map[string]string{"login": username, "password": "secret"}, tok))
+		}
+
+		return user, other, current
+	}
+
+	t.Run("success_list_and_revoke_own_sessions", func(t *testing.T) {
+		ts := newTestServer(t)
+		_, other, current := signIn(ts, "alice")
+		_, _, foreign := signIn(ts, "bob")
+		me := ts.as(current.AccessToken)
+
+		var page sessionPage
+		require.Equal(t, http.StatusOK, me.do(http.MethodGet, "/sessions", nil, &page))
+		require.Equal(t, int64(2), page.Total)
+		require.True(t, page.Items[0].Current)
+		require.False(t, page.Items[1].Current)
+		require.Equal(t, "192.0.2.1", page.Items[0].IP)
+		require.False(t, page.Items[0].ExpiresAt.IsZero())
+
+		// sessions of others are not found
+		var bobs sessionPage
+		require.Equal(t, http.StatusOK, ts.as(foreign.AccessToken).do(http.MethodGet, "/sessions", nil, &bobs))
+		require.Equal(t, http.StatusNotFound, me.do(http.MethodDelete, "/sessions/"+bobs.Items[0].ID, nil, nil))
+
+		require.Equal(t, http.StatusNoContent, me.do(http.MethodDelete, "/sessions/"+page.Items[1].ID, nil, nil))
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens/refresh",
+			map[string]string{"refresh_token": other.RefreshToken}, nil))
+		require.Equal(t, http.StatusNotFound, me.do(http.MethodDelete, "/sessions/"+page.Items[1].ID, nil, nil))
+
+		require.Equal(t, http.StatusOK, me.do(http.MethodGet, "/sessions", nil, &page))
+		require.Equal(t, int64(1), page.Total)
+
+		// signing out of the current session ends the access token too
+		require.Equal(t, http.StatusNoContent, me.do(http.MethodDelete, "/sessions/"+page.Items[0].ID, nil, nil))
+		require.Equal(t, http.StatusUnauthorized, me.do(http.MethodGet, "/sessions", nil, nil))
+	})
+
+	t.Run("success_revoke_other_sessions", func(t *testing.T) {
+		ts := newTestServer(t)
+		_, other, current := signIn(ts, "alice")
+		me := ts.as(current.AccessToken)
+
+		var revoked revokedSessions
+		require.Equal(t, http.StatusOK, me.do(http.MethodPost, "/sessions/revoke-others", nil, &revoked))
+		require.Equal(t, int64(1), revoked.Revoked)
+
+		var page sessionPage
+		require.Equal(t, http.StatusOK, me.do(http.MethodGet, "/sessions", nil, &page))
+		require.Equal(t, int64(1), page.Total)
+		require.True(t, page.Items[0].Current)
+
+		require.Equal(t, http.StatusUnauthorized, ts.as(other.AccessToken).do(http.MethodGet, "/sessions", nil, nil))
+	})
+
+	t.Run("fail_use_sessions_without_an_access_token", func(t *testing.T) {
+		ts := newTestServer(t)
+		_, _, current := signIn(ts, "alice")
+
+		var body errorBody
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodGet, "/sessions", nil, &body))
+		require.Equal(t, "invalid_token", body.Error.Code)
+		require.Equal(t, http.StatusUnauthorized, ts.as("garbage").do(http.MethodGet, "/sessions", nil, nil))
+		require.Equal(t, http.StatusUnauthorized, ts.as(current.RefreshToken).do(http.MethodGet, "/sessions", nil, nil))
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/sessions/revoke-others", nil, nil))
+	})
+
+	t.Run("success_force_a_user_to_sign_out", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+		user, other, current := signIn(ts, "alice")
+		_, _, foreign := signIn(ts, "bob")
+		path := fmt.Sprintf("/users/%d/sessions", user.ID)
+
+		// users cannot sign others out, nor list their sessions
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodGet, path, nil, nil))
+		require.Equal(t, http.StatusForbidden, ts.as(foreign.AccessToken).do(http.MethodDelete, path, nil, nil))
+
+		var page sessionPage
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, path, nil, &page))
+		require.Equal(t, int64(2), page.Total)
+		require.False(t, page.Items[0].Current)
+
+		var revoked revokedSessions
+		require.Equal(t, http.StatusOK, admin.do(http.MethodDelete, path, nil, &revoked))
+		require.Equal(t, int64(2), revoked.Revoked)
+
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, path, nil, &page))
+		require.Zero(t, page.Total)
+		for _, tok := range []Token{other, current} {
+			require.Equal(t, http.StatusUnauthorized, ts.as(tok.AccessToken).do(http.MethodGet, "/sessions", nil, nil))
+		}
+		require.Equal(t, http.StatusOK, ts.as(foreign.AccessToken).do(http.MethodGet, "/sessions", nil, nil))
+
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodGet, "/users/999/sessions", nil, nil))
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodDelete, "/users/999/sessions", nil, nil))
+	})
+}
@@ -0,0 +1,217 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+// Token is the json representation of issued tokens, shaped as an OAuth 2.0 token response
+type Token struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	ExpiresIn        int64  `json:"expires_in"`
+	RefreshToken     string `json:"refresh_token"`
+	RefreshExpiresIn int64  `json:"refresh_expires_in"`
+}
+
+func newToken(t *token.Token, now time.Time) *Token {
+	return &Token{AccessToken: t.AccessToken, TokenType: "Bearer", ExpiresIn: int64(t.ExpiresAt.Sub(now) / time.Second),
+		RefreshToken: t.RefreshToken, RefreshExpiresIn: int64(t.RefreshExpiresAt.Sub(now) / time.Second)}
+}
+
+// jwksMaxAge is how long clients may cache the published keys, far below the key ring's overlap
+const jwksMaxAge = 5 * time.Minute
+
+type createTokenRequest struct {
+	Login    string `json:"login"`
+	Password string `json:"password"`
+	OTP      string `json:"otp"`
+}
+
+type refreshTokenRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
+// tokenRequest is the body of revocation and introspection requests, the hint is accepted as RFC 7009 asks but
+// the token type is told by its format
+type tokenRequest struct {
+	Token         string `json:"token"`
+	TokenTypeHint string `json:"token_type_hint"`
+}
+
+// Introspection is the json representation of an introspected token, shaped as an RFC 7662 response. Only
+// active is sent for tokens which are not active.
+type Introspection struct {
+	Active    bool     `json:"active"`
+	TokenType string   `json:"token_type,omitempty"`
+	Scope     string   `json:"scope,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Username  string   `json:"username,omitempty"`
+	Bunches   []string `json:"bunches,omitempty"`
+	JTI       string   `json:"jti,omitempty"`
+	Issuer    string   `json:"iss,omitempty"`
+	Audience  []string `json:"aud,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+}
+
+func newIntrospection(i *token.Introspection) *Introspection {
+	if !i.Active {
+		return &Introspection{}
+	}
+
+	return &Introspection{Active: true, TokenType: i.TokenType, Scope: strings.Join(i.Keys, " "),
+		Subject: strconv.FormatInt(i.UserID, 10), Username: i.Username, Bunches: i.Bunches, JTI: i.UID,
+		Issuer: i.Issuer, Audience: i.Audience, IssuedAt: i.IssuedAt.Unix(), ExpiresAt: i.ExpiresAt.Unix()}
+}
+
+// clientOf returns the client fields recorded with the tokens issued to r
+func clientOf(r *http.Request) token.Client {
+	return token.Client{
+		RemoteAddr:    r.RemoteAddr,
+		XForwardedFor: r.Header.Get("X-Forwarded-For"),
+		XRealIP:       r.Header.Get("X-Real-IP"),
+		UserAgent:     r.UserAgent(),
+	}
+}
+
+func (s *Server) createToken(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createTokenRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("login", req.Login, maxEmail)
+	v.password("password", req.Password)
+	v.length("otp", req.OTP, maxOTP)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	user, err := s.auth.VerifyCredentials(r.Context(), auth.Credentials{Login: req.Login, Password: req.Password,
+		OTP: req.OTP, IP: s.tokens.ClientIP(clientOf(r))})
+	var enrollErr *auth.EnrollmentRequiredError
+	if errors.As(err, &enrollErr) {
+		return writeEnrollmentRequired(w, enrollErr)
+	}
+	if err != nil {
+		return err
+	}
+
+	tok, err := s.tokens.Issue(r.Context(), user, clientOf(r))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, newToken(tok, time.Now()))
+}
+
+func (s *Server) refreshToken(w http.ResponseWriter, r *http.Request, p params) error {
+	var req refreshTokenRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("refresh_token", req.RefreshToken, maxToken)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	tok, err := s.tokens.Refresh(r.Context(), req.RefreshToken, clientOf(r))
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, newToken(tok, time.Now()))
+}
+
+// decodeTokenRequest reads and validates the body of revocation and introspection requests, sent form encoded
+// as RFC 7009 and RFC 7662 ask or as json
+func decodeTokenRequest(r *http.Request) (*tokenRequest, error) {
+	var req tokenRequest
+	if isForm(r) {
+		r.Body = http.MaxBytesReader(nil, r.Body, maxBodyBytes)
+		if err := r.ParseForm(); err != nil {
+			return nil, &Error{Status: http.StatusBadRequest, Code: "invalid_body", Message: err.Error()}
+		}
+
+		req.Token = r.PostForm.Get("token")
+		req.TokenTypeHint = r.PostForm.Get("token_type_hint")
+	} else if err := decode(r, &req); err != nil {
+		return nil, err
+	}
+
+	v := newValidator()
+	v.required("token", req.Token, maxToken)
+	if len(req.TokenTypeHint) > 0 && req.TokenTypeHint != token.TypeAccessToken &&
+		req.TokenTypeHint != token.TypeRefreshToken {
+		v.fail("token_type_hint", "must be %s or %s", token.TypeAccessToken, token.TypeRefreshToken)
+	}
+	if err := v.err(); err != nil {
+		return nil, err
+	}
+
+	return &req, nil
+}
+
+// revokeToken revokes an access or refresh token, unknown tokens are answered as revoked ones
+func (s *Server) revokeToken(w http.ResponseWriter, r *http.Request, p params) error {
+	req, err := decodeTokenRequest(r)
+	if err != nil {
+		return err
+	}
+
+	if err := s.tokens.Revoke(r.Context(), req.Token); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// introspectToken answers the state of a token to a client authenticated by its own access token, the route
+// needs introspect_token. The migrations provision the key in the resource_server bunch, a resource server
+// introspects as a user assigned to that bunch: authctl assign <username> resource_server, or POST /user-bunches.
+func (s *Server) introspectToken(w http.ResponseWriter, r *http.Request, p params) error {
+	req, err := decodeTokenRequest(r)
+	if err != nil {
+		return err
+	}
+
+	info, err := s.tokens.Introspect(r.Context(), req.Token)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	return writeJSON(w, http.StatusOK, newIntrospection(info))
+}
+
+// isForm tells whether the body of r is form encoded
+func isForm(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == "application/x-www-form-urlencoded"
+}
+
+// jwks publishes the public keys verifying access tokens
+func (s *Server) jwks(w http.ResponseWriter, r *http.Request, p params) error {
+	set, err := s.tokens.JWKS(r.Context())
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(jwksMaxAge/time.Second)))
+	return writeJSON(w, http.StatusOK, set)
+}
@@ -0,0 +1,255 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/token"
+)
+
+func TestServer_Tokens(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_issue_a_token", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var (
+			user  User
+			key   Key
+			bunch Bunch
+		)
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "alice", "email": "alice@test.com", "password": "secret",
+		}, &user))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/keys", map[string]string{"name": "read"}, &key))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "staff"}, &bunch))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunch-keys",
+			map[string]int64{"bunch_id": bunch.ID, "key_id": key.ID}, nil))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/user-bunches",
+			map[string]int64{"user_id": user.ID, "bunch_id": bunch.ID}, nil))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "alice@test.com", "password": "secret"}, &tok))
+		require.Equal(t, "Bearer", tok.TokenType)
+		require.InDelta(t, 15*60, tok.ExpiresIn, 1)
+
+		claims, err := ts.srv.tokens.Verify(context.Background(), tok.AccessToken)
+		require.Nil(t, err)
+		require.Equal(t, fmt.Sprint(user.ID), claims.Subject)
+		require.Equal(t, []string{"staff"}, claims.Bunches)
+		require.Equal(t, []string{"read"}, claims.Keys)
+
+		var refreshed Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens/refresh",
+			map[string]string{"refresh_token": tok.RefreshToken}, &refreshed))
+		require.NotEqual(t, tok.RefreshToken, refreshed.RefreshToken)
+		require.NotEmpty(t, refreshed.AccessToken)
+
+		var body errorBody
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens/refresh",
+			map[string]string{"refresh_token": tok.RefreshToken}, &body))
+		require.Equal(t, "invalid_token", body.Error.Code)
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens/refresh",
+			map[string]string{"refresh_token": refreshed.RefreshToken}, nil))
+	})
+
+	t.Run("fail_issue_with_wrong_credentials", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "bob", "email": "bob@test.com", "password": "secret",
+		}, &user))
+
+		var body errorBody
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "nobody", "password": "secret"}, &body))
+		require.Equal(t, "invalid_credentials", body.Error.Code)
+
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPatch, fmt.Sprintf("/users/%d", user.ID),
+			map[string]interface{}{"active": false}, nil))
+		require.Equal(t, http.StatusForbidden, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "bob", "password": "secret"}, &body))
+		require.Equal(t, "inactive_user", body.Error.Code)
+
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/tokens", map[string]string{"login": "bob"}, &body))
+		require.Equal(t, map[string]string{"password": "is required"}, body.Error.Fields)
+	})
+
+	t.Run("success_introspect_and_revoke_tokens", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "carol", "email": "carol@test.com", "password": "secret",
+		}, &user))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "carol", "password": "secret"}, &tok))
+
+		var info Introspection
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPost, "/tokens/introspect",
+			map[string]string{"token": tok.AccessToken, "token_type_hint": "access_token"}, &info))
+		require.True(t, info.Active)
+		require.Equal(t, "access_token", info.TokenType)
+		require.Equal(t, fmt.Sprint(user.ID), info.Subject)
+		require.Equal(t, "carol", info.Username)
+		require.NotZero(t, info.ExpiresAt)
+
+		// the hint does not decide the token type
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPost, "/tokens/introspect",
+			map[string]string{"token": tok.RefreshToken, "token_type_hint": "access_token"}, &info))
+		require.True(t, info.Active)
+		require.Equal(t, "refresh_token", info.TokenType)
+
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens/revoke",
+			map[string]string{"token": tok.RefreshToken}, nil))
+
+		var raw map[string]interface{}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPost, "/tokens/introspect",
+			map[string]string{"token": tok.AccessToken}, &raw))
+		require.Equal(t, map[string]interface{}{"active": false}, raw)
+
+		// unknown tokens are revoked silently
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens/revoke",
+			map[string]string{"token": "unknown"}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/tokens/revoke",
+			map[string]string{"token_type_hint": "id_token"}, &body))
+		require.Equal(t, map[string]string{
+			"token":           "is required",
+			"token_type_hint": "must be access_token or refresh_token",
+		}, body.Error.Fields)
+	})
+
+	t.Run("success_introspect_and_revoke_form_encoded_tokens", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "carol", "email": "carol@test.com", "password": "secret",
+		}, nil))
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "carol", "password": "secret"}, &tok))
+
+		// post sends a form encoded body as the given client
+		post := func(client *testServer, path string, form url.Values) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+			if len(client.bearer) > 0 {
+				req.Header.Set("Authorization", "Bearer "+client.bearer)
+			}
+			rec := httptest.NewRecorder()
+			ts.srv.ServeHTTP(rec, req)
+			return rec
+		}
+
+		rec := post(admin, "/tokens/introspect", url.Values{"token": {tok.AccessToken}, "token_type_hint": {"access_token"}})
+		require.Equal(t, http.StatusOK, rec.Code)
+		var info Introspection
+		require.Nil(t, json.Unmarshal(rec.Body.Bytes(), &info))
+		require.True(t, info.Active)
+		require.Equal(t, "carol", info.Username)
+
+		require.Equal(t, http.StatusOK, post(ts, "/tokens/revoke", url.Values{"token": {tok.RefreshToken}}).Code)
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens/refresh",
+			map[string]string{"refresh_token": tok.RefreshToken}, nil))
+
+		rec = post(ts, "/tokens/revoke", url.Values{"token_type_hint": {"id_token"}})
+		require.Equal(t, http.StatusBadRequest, rec.Code)
+		require.Contains(t, rec.Body.String(), `"token":"is required"`)
+	})
+
+	t.Run("fail_introspect_without_client_authentication", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": "carol", "email": "carol@test.com", "password": "secret",
+		}, nil))
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "carol", "password": "secret"}, &tok))
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens/introspect",
+			map[string]string{"token": tok.AccessToken}, nil))
+
+		// a token does not introspect others without introspect_token
+		var body errorBody
+		require.Equal(t, http.StatusForbidden, ts.as(tok.AccessToken).do(http.MethodPost, "/tokens/introspect",
+			map[string]string{"token": tok.AccessToken}, &body))
+		require.Equal(t, "forbidden", body.Error.Code)
+	})
+
+	t.Run("success_deactivating_a_user_revokes_its_tokens", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "dave", "email": "dave@test.com", "password": "secret",
+		}, &user))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "dave", "password": "secret"}, &tok))
+
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPatch, fmt.Sprintf("/users/%d", user.ID),
+			map[string]interface{}{"active": false}, nil))
+
+		for _, token := range []string{tok.AccessToken, tok.RefreshToken} {
+			var info Introspection
+			require.Equal(t, http.StatusOK, admin.do(http.MethodPost, "/tokens/introspect",
+				map[string]string{"token": token}, &info))
+			require.False(t, info.Active)
+		}
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens/refresh",
+			map[string]string{"refresh_token": tok.RefreshToken}, nil))
+	})
+
+	t.Run("success_publish_signing_keys", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		var set token.JWKS
+		require.Equal(t, http.StatusOK, ts.do(http.MethodGet, "/.well-known/jwks.json", nil, &set))
+		require.Empty(t, set.Keys)
+
+		ring, err := token.NewKeyRing(ts.srv.repo, bytes.Repeat([]byte{1}, 32), token.KeyRingConfig{})
+		require.Nil(t, err)
+		require.Nil(t, ring.Maintain(context.Background()))
+		ts.srv.tokens = token.NewIssuer(ts.srv.repo, ring, token.Config{})
+
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": "erin", "email": "erin@test.com", "password": "secret",
+		}, nil))
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "erin", "password": "secret"}, &tok))
+
+		require.Equal(t, http.StatusOK, ts.do(http.MethodGet, "/.well-known/jwks.json", nil, &set))
+		require.Len(t, set.Keys, 1)
+		require.Equal(t, "OKP", set.Keys[0].KeyType)
+
+		current, err := ring.Current(context.Background())
+		require.Nil(t, err)
+		require.Equal(t, current.KeyID(), set.Keys[0].KeyID)
+
+		_, err = ts.srv.tokens.Verify(context.Background(), tok.AccessToken)
+		require.Nil(t, err)
+	})
+}
@@ -0,0 +1,376 @@
+package api
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/auth"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// User is the json representation of storage.User, credentials are never exposed. LockedUntil is set by the
+// user endpoints while failed logins lock the user out.
+type User struct {
+	ID              int64      `json:"id"`
+	FullName        string     `json:"full_name"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	PendingEmail    string     `json:"pending_email,omitempty"`
+	Active          bool       `json:"active"`
+	LockedUntil     *time.Time `json:"locked_until,omitempty"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+func newUser(u *storage.User) *User {
+	v := &User{ID: u.ID, FullName: u.FullName, Username: u.Username, Email: u.Email, PendingEmail: u.PendingEmail,
+		Active: u.Active.Bool, UpdatedAt: u.UpdatedAt}
+	if !u.EmailVerifiedAt.IsZero() {
+		verifiedAt := u.EmailVerifiedAt
+		v.EmailVerifiedAt = &verifiedAt
+	}
+
+	return v
+}
+
+// newLockedUser returns the json representation of u along with its lockout
+func (s *Server) newLockedUser(ctx context.Context, u *storage.User) (*User, error) {
+	v := newUser(u)
+
+	until, err := s.auth.Lockout(ctx, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !until.IsZero() {
+		v.LockedUntil = &until
+	}
+
+	return v, nil
+}
+
+// UserBunch is the json representation of storage.AggregateUserBunch
+type UserBunch struct {
+	ID        int64     `json:"id"`
+	UserID    int64     `json:"user_id"`
+	BunchID   int64     `json:"bunch_id"`
+	UpdatedAt time.Time `json:"updated_at"`
+	User      *User     `json:"user,omitempty"`
+	Bunch     *Bunch    `json:"bunch,omitempty"`
+}
+
+func newUserBunch(ub *storage.AggregateUserBunch) *UserBunch {
+	v := &UserBunch{ID: ub.UserBunch.ID, UserID: ub.UserID, BunchID: ub.BunchID, UpdatedAt: ub.UserBunch.UpdatedAt}
+	if ub.User != nil {
+		v.User = newUser(ub.User)
+	}
+	if ub.Bunch != nil {
+		v.Bunch = newBunch(ub.Bunch)
+	}
+
+	return v
+}
+
+type createUserRequest struct {
+	FullName string `json:"full_name"`
+	Username string `json:"username"`
+	Email    string `json:"email"`
+	Password string `json:"password"`
+}
+
+type updateUserRequest struct {
+	FullName *string `json:"full_name"`
+	Username *string `json:"username"`
+	Email    *string `json:"email"`
+	Password *string `json:"password"`
+	Active   *bool   `json:"active"`
+}
+
+type createUserBunchRequest struct {
+	UserID  int64 `json:"user_id"`
+	BunchID int64 `json:"bunch_id"`
+}
+
+func (s *Server) createUser(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createUserRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.length("full_name", req.FullName, maxUserFullName)
+	v.required("username", req.Username, maxUsername)
+	v.required("email", req.Email, maxEmail)
+	v.email("email", req.Email)
+	v.password("password", req.Password)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	id, err := s.auth.CreateUser(r.Context(), auth.CreateUser{FullName: req.FullName, Username: req.Username,
+		Email: req.Email, Password: req.Password})
+	if err != nil {
+		return err
+	}
+
+	// the user exists either way, a verification mail can be asked again
+	if err := s.auth.RequestEmailVerification(r.Context(), id); err != nil {
+		log.Printf("api: mailing the email verification of user %d: %v", id, err)
+	}
+
+	user, err := s.repo.Users().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newUser(user))
+}
+
+func (s *Server) getUser(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	user, err := s.repo.Users().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	v, err := s.newLockedUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) updateUser(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	var req updateUserRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.optional("full_name", req.FullName, maxUserFullName)
+	v.optional("username", req.Username, maxUsername)
+	v.optional("email", req.Email, maxEmail)
+	if req.Email != nil {
+		v.email("email", *req.Email)
+	}
+	if req.Password != nil {
+		v.password("password", *req.Password)
+	}
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	u := storage.UpdateUser{ID: id}
+	if req.FullName != nil {
+		u.FullName = *req.FullName
+	}
+	if req.Username != nil {
+		u.Username = *req.Username
+	}
+	if req.Active != nil {
+		u.Active = share.Boolean{IsSet: true, Bool: *req.Active}
+	}
+	if req.Password != nil {
+		if u.Hash, u.Salt, err = s.auth.HashPassword(*req.Password); err != nil {
+			return err
+		}
+	}
+
+	// a new email is pending until verified, the current one stays in use meanwhile. It is checked and stored
+	// along with the other fields, so a taken email changes none of them.
+	var mailing bool
+	err = s.repo.WithTx(r.Context(), func(tx storage.Stores) error {
+		if _, err := tx.Users().Get(r.Context(), id); err != nil {
+			return err
+		}
+
+		if err := tx.Users().Update(r.Context(), u); err != nil {
+			return err
+		}
+
+		if req.Email == nil {
+			return nil
+		}
+
+		mailing, err = s.auth.StageEmail(r.Context(), tx, id, *req.Email)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	// the user is updated either way, the verification can be requested again
+	if mailing {
+		if err := s.auth.RequestEmailVerification(r.Context(), id); err != nil {
+			log.Printf("api: mailing the email verification of user %d: %v", id, err)
+		}
+	}
+
+	user, err := s.repo.Users().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	view, err := s.newLockedUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, view)
+}
+
+// unlockUser lifts the lockout of a user after failed logins
+func (s *Server) unlockUser(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if err := s.auth.Unlock(r.Context(), id); err != nil {
+		return err
+	}
+
+	user, err := s.repo.Users().Get(r.Context(), id)
+	if err != nil {
+		return err
+	}
+
+	v, err := s.newLockedUser(r.Context(), user)
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusOK, v)
+}
+
+func (s *Server) queryUsers(w http.ResponseWriter, r *http.Request, p params) error {
+	var (
+		q     = newQuery(r)
+		query storage.QueryUser
+		sorts storage.SortUser
+	)
+
+	query.FullName = q.string("full_name")
+	query.Username = q.string("username")
+	query.Email = q.string("email")
+	query.Active = q.boolean("active")
+	query.Verified = q.boolean("verified")
+	query.From = q.time("from")
+	query.To = q.time("to")
+	query.Limit, query.Offset = q.page()
+	q.sort(map[string]*share.Direction{
+		"full_name":  &sorts.FullName,
+		"username":   &sorts.Username,
+		"email":      &sorts.Email,
+		"active":     &sorts.Active,
+		"updated_at": &sorts.UpdatedAt,
+	})
+	if err := q.err(); err != nil {
+		return err
+	}
+
+	users, total, err := s.repo.Users().Query(r.Context(), query, sorts)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*User, 0, len(users))
+	for _, u := range users {
+		v, err := s.newLockedUser(r.Context(), u)
+		if err != nil {
+			return err
+		}
+		items = append(items, v)
+	}
+
+	return writeList(w, items, total)
+}
+
+func (s *Server) createUserBunch(w http.ResponseWriter, r *http.Request, p params) error {
+	var req createUserBunchRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.positive("user_id", req.UserID)
+	v.positive("bunch_id", req.BunchID)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	var created *storage.AggregateUserBunch
+	err := s.repo.WithTx(r.Context(), func(tx storage.Stores) error {
+		id, err := tx.UserBunches().Insert(r.Context(), storage.CreateUserBunch{UserID: req.UserID, BunchID: req.BunchID})
+		if err != nil {
+			return err
+		}
+
+		created, err = tx.UserBunches().Get(r.Context(), id)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return writeJSON(w, http.StatusCreated, newUserBunch(created))
+}
+
+func (s *Server) deleteUserBunch(w http.ResponseWriter, r *http.Request, p params) error {
+	id, err := p.id()
+	if err != nil {
+		return err
+	}
+
+	if err := s.repo.UserBunches().Delete(r.Context(), id); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+func (s *Server) queryUserBunches(w http.ResponseWriter, r *http.Request, p params) error {
+	var (
+		q     = newQuery(r)
+		query storage.QueryUserBunch
+		sorts storage.SortUserBunch
+	)
+
+	query.Username = q.string("username")
+	query.BunchName = q.string("bunch_name")
+	query.UserActive = q.boolean("user_active")
+	query.BunchActive = q.boolean("bunch_active")
+	query.Limit, query.Offset = q.page()
+	q.sort(map[string]*share.Direction{
+		"username":   &sorts.Username,
+		"bunch_name": &sorts.BunchName,
+	})
+	if err := q.err(); err != nil {
+		return err
+	}
+
+	rows, total, err := s.repo.UserBunches().Query(r.Context(), query, sorts)
+	if err != nil {
+		return err
+	}
+
+	items := make([]*UserBunch, 0, len(rows))
+	for _, ub := range rows {
+		items = append(items, newUserBunch(ub))
+	}
+
+	return writeList(w, items, total)
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/auth"
+)
+
+func TestServer_Users(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_create_and_update_a_user", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var created User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"full_name": "Alice", "username": "alice", "email": "alice@test.com", "password": "secret",
+		}, &created))
+		require.Equal(t, "alice", created.Username)
+		require.True(t, created.Active)
+
+		_, err := ts.srv.auth.VerifyCredentials(context.Background(), auth.Credentials{Login: "alice", Password: "secret"})
+		require.Nil(t, err)
+
+		var updated User
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPatch, fmt.Sprintf("/users/%d", created.ID),
+			map[string]interface{}{"email": "alice@example.com", "password": "changed", "active": false}, &updated))
+		require.Equal(t, "alice@test.com", updated.Email)
+		require.Equal(t, "alice@example.com", updated.PendingEmail)
+		require.False(t, updated.Active)
+
+		// the user is inactive now, which is only told to the right password
+		_, err = ts.srv.auth.VerifyCredentials(context.Background(), auth.Credentials{Login: "alice", Password: "changed"})
+		require.Equal(t, auth.ErrInactiveUser, err)
+	})
+
+	t.Run("fail_update_a_user_to_a_taken_email", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "dave", "email": "dave@test.com", "password": "secret",
+		}, nil))
+		var erin User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "erin", "email": "erin@test.com", "password": "secret",
+		}, &erin))
+
+		var body errorBody
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPatch, fmt.Sprintf("/users/%d", erin.ID),
+			map[string]interface{}{"username": "erina", "email": "dave@test.com"}, &body))
+		require.Equal(t, map[string]string{"email": "already exists"}, body.Error.Fields)
+
+		// the failed update changed none of the fields
+		var found User
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, fmt.Sprintf("/users/%d", erin.ID), nil, &found))
+		require.Equal(t, "erin", found.Username)
+		require.Equal(t, "erin@test.com", found.Email)
+		require.Empty(t, found.PendingEmail)
+	})
+
+	t.Run("success_never_expose_credentials", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		var raw map[string]interface{}
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": "bob", "email": "bob@test.com", "password": "secret",
+		}, &raw))
+		require.NotContains(t, raw, "hash")
+		require.NotContains(t, raw, "salt")
+		require.NotContains(t, raw, "password")
+	})
+
+	t.Run("fail_create_an_invalid_user", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"full_name": strings.Repeat("f", maxUserFullName+1), "username": "", "email": "nope",
+		}, &body))
+		require.Equal(t, map[string]string{
+			"full_name": "must be at most 64 characters",
+			"username":  "is required",
+			"email":     "must be an email address",
+			"password":  "is required",
+		}, body.Error.Fields)
+	})
+
+	t.Run("fail_create_a_duplicated_email", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "carol", "email": "carol@test.com", "password": "secret",
+		}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "carol2", "email": "carol@test.com", "password": "secret",
+		}, &body))
+		require.Equal(t, map[string]string{"email": "already exists"}, body.Error.Fields)
+	})
+
+	t.Run("fail_create_a_user_without_add_user", func(t *testing.T) {
+		ts := newTestServer(t)
+
+		require.Equal(t, http.StatusCreated, ts.admin().do(http.MethodPost, "/users", map[string]string{
+			"username": "frank", "email": "frank@test.com", "password": "secret",
+		}, nil))
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "frank", "password": "secret"}, &tok))
+
+		// neither tells whether the username or the email is taken
+		var body errorBody
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/users", map[string]string{
+			"username": "frank", "email": "frank@test.com", "password": "secret",
+		}, &body))
+		require.Equal(t, "invalid_token", body.Error.Code)
+		require.Equal(t, http.StatusForbidden, ts.as(tok.AccessToken).do(http.MethodPost, "/users",
+			map[string]string{"username": "frank", "email": "frank@test.com", "password": "secret"}, &body))
+		require.Equal(t, "forbidden", body.Error.Code)
+	})
+
+	t.Run("success_assign_and_query_user_bunches", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var (
+			user  User
+			bunch Bunch
+		)
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "dave", "email": "dave@test.com", "password": "secret",
+		}, &user))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/bunches", map[string]string{"name": "staff"}, &bunch))
+
+		var ub UserBunch
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/user-bunches",
+			map[string]int64{"user_id": user.ID, "bunch_id": bunch.ID}, &ub))
+		require.Equal(t, "dave", ub.User.Username)
+		require.Equal(t, "staff", ub.Bunch.Name)
+
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPost, "/user-bunches",
+			map[string]int64{"user_id": user.ID, "bunch_id": bunch.ID}, nil))
+
+		var page struct {
+			Total int64        `json:"total"`
+			Items []*UserBunch `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/user-bunches?username=dave&user_active=true", nil, &page))
+		require.Equal(t, int64(1), page.Total)
+		require.Equal(t, ub.ID, page.Items[0].ID)
+
+		require.Equal(t, http.StatusNoContent, admin.do(http.MethodDelete, fmt.Sprintf("/user-bunches/%d", ub.ID), nil, nil))
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/user-bunches?username=dave", nil, &page))
+		require.Equal(t, int64(0), page.Total)
+	})
+
+	t.Run("success_query_users", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		for _, name := range []string{"erin", "frank", "grace"} {
+			require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+				"username": name, "email": name + "@test.com", "password": "secret",
+			}, nil))
+		}
+
+		var page struct {
+			Total int64   `json:"total"`
+			Items []*User `json:"items"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/users?email=test.com&sort=-username&limit=1", nil, &page))
+		require.Equal(t, int64(3), page.Total)
+		require.Len(t, page.Items, 1)
+		require.Equal(t, "grace", page.Items[0].Username)
+	})
+
+	t.Run("success_lock_out_and_unlock_a_user", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "heidi", "email": "heidi@test.com", "password": "secret",
+		}, &user))
+		require.Nil(t, user.LockedUntil)
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "heidi", "password": "wrong"}, nil))
+
+		rec := httptest.NewRecorder()
+		ts.srv.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/tokens",
+			strings.NewReader(`{"login": "heidi", "password": "secret"}`)))
+		require.Equal(t, http.StatusTooManyRequests, rec.Code)
+		require.Equal(t, "1", rec.Header().Get("Retry-After"))
+		require.Contains(t, rec.Body.String(), `"locked_out"`)
+
+		var locked, unlocked User
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, fmt.Sprintf("/users/%d", user.ID), nil, &locked))
+		require.NotNil(t, locked.LockedUntil)
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, fmt.Sprintf("/users/%d/unlock", user.ID),
+			nil, nil))
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPost, fmt.Sprintf("/users/%d/unlock", user.ID), nil,
+			&unlocked))
+		require.Nil(t, unlocked.LockedUntil)
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "heidi", "password": "secret"}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusNotFound, admin.do(http.MethodPost, fmt.Sprintf("/users/%d/unlock", user.ID+100),
+			nil, &body))
+	})
+}
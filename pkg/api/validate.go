@@ -0,0 +1,194 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+// lengths of the VARCHAR columns in db_schema.go
+const (
+	maxKeyName      = 32
+	maxKeyDesc      = 64
+	maxBunchName    = 32
+	maxBunchDesc    = 64
+	maxUserFullName = 64
+	maxUsername     = 32
+	maxEmail        = 64
+
+	// passwords are mixed into a fixed size digest before hashing, the limit only bounds the work
+	maxPassword = 1024
+
+	// tokens carry the user's keys, the limit only rejects absurd values
+	maxToken = 8192
+
+	// one-time passwords are a TOTP code or a recovery code
+	maxOTP = 32
+
+	maxLimit = 1000
+)
+
+// validator collects the problems of a request by field
+type validator struct {
+	fields map[string]string
+}
+
+func newValidator() *validator {
+	return &validator{fields: make(map[string]string)}
+}
+
+func (v *validator) fail(field string, format string, args ...interface{}) {
+	if _, ok := v.fields[field]; !ok {
+		v.fields[field] = fmt.Sprintf(format, args...)
+	}
+}
+
+// required checks a mandatory string
+func (v *validator) required(field string, value string, max int) {
+	if len(strings.TrimSpace(value)) == 0 {
+		v.fail(field, "is required")
+		return
+	}
+
+	v.length(field, value, max)
+}
+
+// optional checks a string which is left unchanged when nil
+func (v *validator) optional(field string, value *string, max int) {
+	if value != nil {
+		v.required(field, *value, max)
+	}
+}
+
+// length counts characters as VARCHAR does
+func (v *validator) length(field string, value string, max int) {
+	if utf8.RuneCountInString(value) > max {
+		v.fail(field, "must be at most %d characters", max)
+	}
+}
+
+func (v *validator) email(field string, value string) {
+	if addr, err := mail.ParseAddress(value); err != nil || addr.Address != value {
+		v.fail(field, "must be an email address")
+	}
+}
+
+func (v *validator) password(field string, value string) {
+	if len(value) == 0 {
+		v.fail(field, "is required")
+	} else if len(value) > maxPassword {
+		v.fail(field, "must be at most %d bytes", maxPassword)
+	}
+}
+
+func (v *validator) positive(field string, value int64) {
+	if value <= 0 {
+		v.fail(field, "is required")
+	}
+}
+
+func (v *validator) err() error {
+	if len(v.fields) == 0 {
+		return nil
+	}
+
+	return &Error{Status: http.StatusBadRequest, Code: "invalid_request", Message: "request is invalid",
+		Fields: v.fields}
+}
+
+// query reads query parameters, reporting malformed ones to its validator
+type query struct {
+	values url.Values
+	v      *validator
+}
+
+func newQuery(r *http.Request) *query {
+	return &query{values: r.URL.Query(), v: newValidator()}
+}
+
+func (q *query) string(name string) string {
+	return q.values.Get(name)
+}
+
+func (q *query) int64(name string, min int64, max int64) int64 {
+	s := q.values.Get(name)
+	if len(s) == 0 {
+		return 0
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < min || n > max {
+		q.v.fail(name, "must be an integer between %d and %d", min, max)
+		return 0
+	}
+
+	return n
+}
+
+func (q *query) boolean(name string) share.Boolean {
+	s := q.values.Get(name)
+	if len(s) == 0 {
+		return share.Boolean{}
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		q.v.fail(name, "must be true or false")
+		return share.Boolean{}
+	}
+
+	return share.Boolean{IsSet: true, Bool: b}
+}
+
+func (q *query) time(name string) time.Time {
+	s := q.values.Get(name)
+	if len(s) == 0 {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		q.v.fail(name, "must be a RFC 3339 time")
+	}
+
+	return t
+}
+
+// page reads limit and offset
+func (q *query) page() (limit int64, offset int64) {
+	return q.int64("limit", 1, maxLimit), q.int64("offset", 0, 1<<62)
+}
+
+// sort reads sort=field,-field into the directions of fields. Storers order by their fields in a fixed
+// precedence, so the order of fields in the parameter does not matter.
+func (q *query) sort(fields map[string]*share.Direction) {
+	s := q.values.Get("sort")
+	if len(s) == 0 {
+		return
+	}
+
+	for _, name := range strings.Split(s, ",") {
+		dir := share.Ascendant
+		if strings.HasPrefix(name, "-") {
+			name, dir = name[1:], share.Descendant
+		}
+
+		d, ok := fields[name]
+		if !ok {
+			q.v.fail("sort", "cannot sort by %q", name)
+			continue
+		}
+		*d = dir
+	}
+}
+
+func (q *query) err() error {
+	return q.v.err()
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http"
+)
+
+type verifyEmailRequest struct {
+	Token string `json:"token"`
+}
+
+// requestEmailVerification mails a verification token to the pending email of a user, or to its email when
+// none is pending
+func (s *Server) requestEmailVerification(w http.ResponseWriter, r *http.Request, userID int64) error {
+	if err := s.auth.RequestEmailVerification(r.Context(), userID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+	return nil
+}
+
+// verifyEmail verifies the email a verification token was mailed to, a pending email becomes the email of the
+// user
+func (s *Server) verifyEmail(w http.ResponseWriter, r *http.Request, p params) error {
+	var req verifyEmailRequest
+	if err := decode(r, &req); err != nil {
+		return err
+	}
+
+	v := newValidator()
+	v.required("token", req.Token, maxToken)
+	if err := v.err(); err != nil {
+		return err
+	}
+
+	if err := s.auth.VerifyEmail(r.Context(), req.Token); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
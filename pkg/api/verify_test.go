@@ -0,0 +1,116 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestServer_EmailVerification(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_verify_the_email_mailed_on_creation", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "alice", "email": "alice@test.com", "password": "secret",
+		}, &user))
+		require.Nil(t, user.EmailVerifiedAt)
+		require.Contains(t, ts.mails.String(), "To: alice@test.com\n")
+		verification := resetTokenRegexp.FindString(ts.mails.String())
+		require.NotEmpty(t, verification)
+
+		require.Equal(t, http.StatusNoContent, ts.do(http.MethodPost, "/email-verifications/confirm",
+			map[string]string{"token": verification}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/email-verifications/confirm",
+			map[string]string{"token": verification}, &body))
+		require.Equal(t, "invalid_verification_token", body.Error.Code)
+
+		var verified User
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, fmt.Sprintf("/users/%d", user.ID), nil, &verified))
+		require.NotNil(t, verified.EmailVerifiedAt)
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost,
+			fmt.Sprintf("/users/%d/email-verifications", user.ID), nil, nil))
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPost,
+			fmt.Sprintf("/users/%d/email-verifications", user.ID), nil, &body))
+		require.Equal(t, "email_verified", body.Error.Code)
+
+		var list struct {
+			Items []*User `json:"items"`
+			Total int64   `json:"total"`
+		}
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/users?verified=true", nil, &list))
+		require.EqualValues(t, 1, list.Total)
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, "/users?username=alice&verified=false", nil, &list))
+		require.EqualValues(t, 0, list.Total)
+	})
+
+	t.Run("success_change_an_email_once_verified", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "bob", "email": "bob@test.com", "password": "secret",
+		}, &user))
+
+		var tok Token
+		require.Equal(t, http.StatusOK, ts.do(http.MethodPost, "/tokens",
+			map[string]string{"login": "bob", "password": "secret"}, &tok))
+
+		ts.mails.Reset()
+		require.Equal(t, http.StatusOK, admin.do(http.MethodPatch, fmt.Sprintf("/users/%d", user.ID),
+			map[string]string{"email": "robert@test.com"}, &user))
+		require.Equal(t, "bob@test.com", user.Email)
+		require.Equal(t, "robert@test.com", user.PendingEmail)
+		require.Contains(t, ts.mails.String(), "To: robert@test.com\n")
+
+		// asking again invalidates the first token
+		first := resetTokenRegexp.FindString(ts.mails.String())
+		ts.mails.Reset()
+		require.Equal(t, http.StatusAccepted, ts.as(tok.AccessToken).do(http.MethodPost, "/email-verifications",
+			nil, nil))
+		verification := resetTokenRegexp.FindString(ts.mails.String())
+		require.NotEqual(t, first, verification)
+
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/email-verifications/confirm",
+			map[string]string{"token": first}, nil))
+		require.Equal(t, http.StatusNoContent, ts.do(http.MethodPost, "/email-verifications/confirm",
+			map[string]string{"token": verification}, nil))
+
+		var verified User
+		require.Equal(t, http.StatusOK, admin.do(http.MethodGet, fmt.Sprintf("/users/%d", user.ID), nil, &verified))
+		require.Equal(t, "robert@test.com", verified.Email)
+		require.Empty(t, verified.PendingEmail)
+		require.NotNil(t, verified.EmailVerifiedAt)
+	})
+
+	t.Run("fail_change_to_a_taken_email", func(t *testing.T) {
+		ts := newTestServer(t)
+		admin := ts.admin()
+
+		var user User
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "carol", "email": "carol@test.com", "password": "secret",
+		}, &user))
+		require.Equal(t, http.StatusCreated, admin.do(http.MethodPost, "/users", map[string]string{
+			"username": "dave", "email": "dave@test.com", "password": "secret",
+		}, nil))
+
+		var body errorBody
+		require.Equal(t, http.StatusConflict, admin.do(http.MethodPatch, fmt.Sprintf("/users/%d", user.ID),
+			map[string]string{"email": "dave@test.com"}, &body))
+		require.Equal(t, "duplicate", body.Error.Code)
+
+		require.Equal(t, http.StatusUnauthorized, ts.do(http.MethodPost, "/email-verifications", nil, nil))
+		require.Equal(t, http.StatusBadRequest, ts.do(http.MethodPost, "/email-verifications/confirm",
+			map[string]string{"token": ""}, nil))
+	})
+}
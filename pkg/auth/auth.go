@@ -0,0 +1,322 @@
+// Package auth implements the authentication flows of the service on top of the storage layer
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/mail"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	// ErrInvalidCredentials is returned when the login or the password is wrong, without telling which
+	ErrInvalidCredentials = errors.New("auth: invalid credentials")
+
+	// ErrInactiveUser is returned when the credentials are right but the user is deactivated
+	ErrInactiveUser = errors.New("auth: user is inactive")
+)
+
+// Config tells how logins are throttled, which second factors they need and how password resets and email
+// verifications are mailed, zero fields take their defaults. A nil Mailer writes messages to stdout.
+type Config struct {
+	Lockout      LockoutConfig
+	MFA          MFAConfig
+	Reset        ResetConfig
+	Verification VerificationConfig
+	Mailer       mail.Mailer
+}
+
+// ConfigFromEnv reads the Config from the environment, see LockoutConfigFromEnv, MFAConfigFromEnv,
+// ResetConfigFromEnv, VerificationConfigFromEnv and mail.FromEnv
+func ConfigFromEnv() (Config, error) {
+	lockout, err := LockoutConfigFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	reset, err := ResetConfigFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	verification, err := VerificationConfigFromEnv()
+	if err != nil {
+		return Config{}, err
+	}
+
+	return Config{Lockout: lockout, MFA: MFAConfigFromEnv(), Reset: reset, Verification: verification,
+		Mailer: mail.FromEnv()}, nil
+}
+
+// Service implements the authentication flows
+type Service struct {
+	repo               storage.Repository
+	passwords          *password.Hasher
+	lockoutConfig      LockoutConfig
+	mfaConfig          MFAConfig
+	resetConfig        ResetConfig
+	verificationConfig VerificationConfig
+	mailer             mail.Mailer
+	now                func() time.Time
+
+	// dummy is hashed once and verified when a login is unknown, so unknown logins take as long as wrong passwords
+	dummyOnce sync.Once
+	dummyHash string
+	dummySalt string
+}
+
+// NewService creates new instance of Service
+func NewService(repo storage.Repository, passwords *password.Hasher, config Config) *Service {
+	if config.Mailer == nil {
+		config.Mailer = mail.NewWriterMailer(os.Stdout)
+	}
+
+	return &Service{
+		repo:               repo,
+		passwords:          passwords,
+		lockoutConfig:      config.Lockout.withDefaults(),
+		mfaConfig:          config.MFA.withDefaults(),
+		resetConfig:        config.Reset.withDefaults(),
+		verificationConfig: config.Verification.withDefaults(),
+		mailer:             config.Mailer,
+		now:                time.Now,
+	}
+}
+
+// CreateUser model, Password is hashed before the user is stored
+type CreateUser struct {
+	FullName string
+	Username string
+	Email    string
+	Password string
+}
+
+// CreateUser stores a new user with a hash of its password
+func (s *Service) CreateUser(ctx context.Context, u CreateUser) (int64, error) {
+	hash, salt, err := s.passwords.Hash(u.Password)
+	if err != nil {
+		return 0, err
+	}
+
+	return s.repo.Users().Insert(ctx, storage.CreateUser{
+		FullName: u.FullName,
+		Username: u.Username,
+		Email:    u.Email,
+		Hash:     hash,
+		Salt:     salt,
+	})
+}
+
+// HashPassword returns the hash and salt of a new password, to be set with storage.UpdateUser
+func (s *Service) HashPassword(password string) (hash string, salt string, err error) {
+	return s.passwords.Hash(password)
+}
+
+// Credentials of a login. Login is a username or an email, OTP a TOTP code or a recovery code and IP the
+// address of the client.
+type Credentials struct {
+	Login    string
+	Password string
+	OTP      string
+	IP       string
+}
+
+// VerifyCredentials returns the user identified by c.Login when the password is theirs and, for users with a
+// second factor, the one-time password is right. Every attempt is recorded with the address of the client,
+// and a *LockoutError is returned without checking the password while the account or the address is locked
+// out. Wrong one-time passwords count as failed logins. The logins of an account are checked one after the
+// other, so concurrent ones cannot all pass the lockout before their failures are recorded. Hashes made with
+// outdated settings are replaced by a fresh hash of the password.
+func (s *Service) VerifyCredentials(ctx context.Context, c Credentials) (*storage.User, error) {
+	var (
+		user    *storage.User
+		rehash  bool
+		refusal error
+	)
+
+	// the attempt of a refused login is recorded, so its transaction commits and the refusal is returned after
+	err := s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		var err error
+		user, rehash, err = s.verifyCredentials(ctx, tx, c)
+		if refused(err) {
+			refusal, err = err, nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if refusal != nil {
+		if errors.Is(refusal, ErrMFAEnrollmentRequired) && user != nil && user.Active.Bool {
+			return nil, s.enrollmentRequired(ctx, user.ID)
+		}
+		return nil, refusal
+	}
+
+	if rehash {
+		// a failed upgrade leaves the old hash, which still verifies, it is retried on the next login
+		if hash, salt, err := s.passwords.Hash(c.Password); err == nil {
+			if err := s.repo.Users().Update(ctx, storage.UpdateUser{ID: user.ID, Hash: hash, Salt: salt}); err == nil {
+				user.Hash, user.Salt = hash, salt
+			}
+		}
+	}
+
+	return user, nil
+}
+
+// verifyCredentials checks and records a login in tx, it tells whether the hash of the password is outdated.
+// The user is locked before its lockout is checked until the attempt is recorded. Logins matching no account
+// are not serialized, they never succeed. The user is returned along with a refusal of its second factor.
+func (s *Service) verifyCredentials(ctx context.Context, tx storage.Stores, c Credentials) (*storage.User, bool, error) {
+	var (
+		user *storage.User
+		err  error
+	)
+
+	if strings.Contains(c.Login, "@") {
+		user, err = tx.Users().GetByEmail(ctx, c.Login)
+	} else {
+		user, err = tx.Users().GetByName(ctx, c.Login)
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		user, err = nil, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if user != nil {
+		if err := tx.Users().Lock(ctx, user.ID); err != nil {
+			return nil, false, err
+		}
+	}
+
+	now := s.now()
+	until, err := s.lockout(ctx, tx, user, c.Login, c.IP, now)
+	if err != nil {
+		return nil, false, err
+	}
+	if until.After(now) {
+		if err := s.record(ctx, tx, user, c.Login, c.IP, storage.LoginLockedOut); err != nil {
+			return nil, false, err
+		}
+		return nil, false, &LockoutError{Until: until}
+	}
+
+	if user == nil {
+		s.verifyDummy(c.Password)
+		if err := s.record(ctx, tx, nil, c.Login, c.IP, storage.LoginFailed); err != nil {
+			return nil, false, err
+		}
+		return nil, false, ErrInvalidCredentials
+	}
+
+	ok, rehash, err := s.passwords.Verify(user.Hash, user.Salt, c.Password)
+	if err != nil {
+		return nil, false, err
+	}
+	if !ok {
+		if err := s.record(ctx, tx, user, c.Login, c.IP, storage.LoginFailed); err != nil {
+			return nil, false, err
+		}
+		return nil, false, ErrInvalidCredentials
+	}
+
+	// a missing code neither fails nor ends a row of failures, the client asks the user for one and retries
+	if err := s.verifySecondFactor(ctx, tx, user, c.OTP); err != nil {
+		if errors.Is(err, ErrInvalidOTP) {
+			if err := s.record(ctx, tx, user, c.Login, c.IP, storage.LoginFailed); err != nil {
+				return nil, false, err
+			}
+		}
+		return user, false, err
+	}
+
+	// the right password ends a row of failures, even for an inactive user
+	if err := s.record(ctx, tx, user, c.Login, c.IP, storage.LoginSucceeded); err != nil {
+		return nil, false, err
+	}
+
+	if !user.Active.Bool {
+		return nil, false, ErrInactiveUser
+	}
+
+	return user, rehash, nil
+}
+
+// Reauthenticate checks the credentials of a signed in user before a change its access token alone must not
+// allow. It is a login of the user, recorded and locked out alike, so c.Login is ignored.
+func (s *Service) Reauthenticate(ctx context.Context, userID int64, c Credentials) error {
+	user, err := s.repo.Users().Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	c.Login = user.Username
+	verified, err := s.VerifyCredentials(ctx, c)
+	if err != nil {
+		return err
+	}
+	if verified.ID != userID {
+		return ErrInvalidCredentials
+	}
+
+	return nil
+}
+
+// refused tells whether err refuses a login, rather than failing to check it
+func refused(err error) bool {
+	for _, refusal := range []error{ErrInvalidCredentials, ErrInactiveUser, ErrLockedOut, ErrMFARequired,
+		ErrInvalidOTP, ErrMFAEnrollmentRequired} {
+		if errors.Is(err, refusal) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (s *Service) verifyDummy(password string) {
+	s.dummyOnce.Do(func() {
+		s.dummyHash, s.dummySalt, _ = s.passwords.Hash("dummy password")
+	})
+
+	s.passwords.Verify(s.dummyHash, s.dummySalt, password)
+}
+
+// Purge deletes the login attempts which no longer count towards a lockout and the mailed tokens which expired
+func (s *Service) Purge(ctx context.Context) error {
+	now := s.now()
+
+	if _, err := s.repo.LoginAttempts().Purge(ctx, now.Add(-s.lockoutConfig.Window)); err != nil {
+		return err
+	}
+
+	_, err := s.repo.UserTokens().Purge(ctx, now)
+	return err
+}
+
+// RunPurge calls Purge every interval until ctx is done, failures are logged and retried at the next tick
+func (s *Service) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := s.Purge(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("auth: purge login attempts and mailed tokens: %v", err)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/memory"
+)
+
+// seedHash is the hash of "password" in the seed data of the storage backends, stored with the salt "123"
+const seedHash = "$2a$10$AdPyZkgVv70bXz9JvZLpH.CCQEzb8MbK8vMHIVQyCKtWestIyK46K"
+
+func newTestService() *Service {
+	hasher := password.NewHasher(&password.Bcrypt{Cost: 4}, nil)
+	return NewService(memory.NewRepository(memory.NewDB()), hasher, Config{})
+}
+
+func TestService_VerifyCredentials(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_verify_by_username_and_email", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "alice", Email: "alice@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		user, err := s.repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.NotEqual(t, "secret", user.Hash)
+		require.NotEmpty(t, user.Salt)
+
+		user, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+
+		user, err = s.VerifyCredentials(ctx, Credentials{Login: "alice@test.com", Password: "secret"})
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+	})
+
+	t.Run("fail_verify_wrong_password_or_unknown_login", func(t *testing.T) {
+		s := newTestService()
+
+		_, err := s.CreateUser(ctx, CreateUser{Username: "bob", Email: "bob@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "Secret"})
+		require.Equal(t, ErrInvalidCredentials, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "nobody", Password: "secret"})
+		require.Equal(t, ErrInvalidCredentials, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "nobody@test.com", Password: "secret"})
+		require.Equal(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("fail_verify_inactive_user", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "carol", Email: "carol@test.com", Password: "secret"})
+		require.Nil(t, err)
+		require.Nil(t, s.repo.Users().Update(ctx, storage.UpdateUser{ID: id, Active: share.Boolean{IsSet: true}}))
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "carol", Password: "secret"})
+		require.Equal(t, ErrInactiveUser, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "carol", Password: "wrong"})
+		require.Equal(t, ErrInvalidCredentials, err)
+	})
+
+	t.Run("success_rehash_legacy_hash_on_login", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.repo.Users().Insert(ctx, storage.CreateUser{Username: "admin", Email: "admin@test.com",
+			Hash: seedHash, Salt: "123"})
+		require.Nil(t, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "admin", Password: "password"})
+		require.Nil(t, err)
+
+		user, err := s.repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.NotEqual(t, seedHash, user.Hash)
+		require.NotEqual(t, "123", user.Salt)
+
+		ok, rehash, err := s.passwords.Verify(user.Hash, user.Salt, "password")
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.False(t, rehash)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "admin", Password: "password"})
+		require.Nil(t, err)
+	})
+}
+
+func TestService_Purge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	s := newTestService()
+	id, err := s.CreateUser(ctx, CreateUser{Username: "alice", Email: "alice@test.com", Password: "secret"})
+	require.Nil(t, err)
+
+	_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "wrong"})
+	require.Equal(t, ErrInvalidCredentials, err)
+	_, _, err = s.issueToken(ctx, id, storage.PurposePasswordReset, time.Hour)
+	require.Nil(t, err)
+
+	// nothing expired yet
+	require.Nil(t, s.Purge(ctx))
+	_, total, err := s.repo.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: id}, storage.SortLoginAttempt{})
+	require.Nil(t, err)
+	require.Equal(t, int64(1), total)
+
+	after(s, 2*time.Hour)
+	require.Nil(t, s.Purge(ctx))
+
+	_, total, err = s.repo.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: id}, storage.SortLoginAttempt{})
+	require.Nil(t, err)
+	require.Zero(t, total)
+
+	// the expired token is gone, nothing is left to purge
+	purged, err := s.repo.UserTokens().Purge(ctx, time.Now().Add(3*time.Hour))
+	require.Nil(t, err)
+	require.Zero(t, purged)
+}
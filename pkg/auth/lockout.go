@@ -0,0 +1,268 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// ErrLockedOut is returned, as a *LockoutError, for logins refused after too many failed ones
+var ErrLockedOut = errors.New("auth: too many failed logins")
+
+// LockoutError tells until when the logins of an account or from an address are refused
+type LockoutError struct {
+	Until time.Time
+}
+
+func (e *LockoutError) Error() string {
+	return ErrLockedOut.Error()
+}
+
+// Unwrap makes a LockoutError match ErrLockedOut
+func (e *LockoutError) Unwrap() error {
+	return ErrLockedOut
+}
+
+// defaults of LockoutConfig
+const (
+	DefaultMaxFailures   = 5
+	DefaultMaxIPFailures = 20
+	DefaultFailureWindow = 15 * time.Minute
+	DefaultFailureDelay  = time.Second
+	DefaultLockDuration  = 15 * time.Minute
+)
+
+// environment variables read by LockoutConfigFromEnv
+const (
+	MaxFailuresEnv   = "AUTH_LOCKOUT_MAX_FAILURES"
+	MaxIPFailuresEnv = "AUTH_LOCKOUT_MAX_IP_FAILURES"
+	WindowEnv        = "AUTH_LOCKOUT_WINDOW"
+	DelayEnv         = "AUTH_LOCKOUT_DELAY"
+	LockDurationEnv  = "AUTH_LOCKOUT_DURATION"
+)
+
+// maxLoginField is the size of the username column of login_attempts
+const maxLoginField = 64
+
+// LockoutConfig tells how failed logins are throttled. Each failed login of an account in a row refuses its
+// next logins for a delay, doubled by each further failure, until the account is locked out. An address is
+// locked out once too many logins from it failed, whichever accounts they tried.
+type LockoutConfig struct {
+	// MaxFailures is how many failed logins in a row lock an account out, DefaultMaxFailures when zero
+	MaxFailures int
+
+	// MaxIPFailures is how many failed logins lock an address out, DefaultMaxIPFailures when zero
+	MaxIPFailures int
+
+	// Window is how long failed logins count, DefaultFailureWindow when zero
+	Window time.Duration
+
+	// Delay is how long logins are refused after the first failure, DefaultFailureDelay when zero
+	Delay time.Duration
+
+	// LockDuration is how long a lockout lasts after the last failure, DefaultLockDuration when zero
+	LockDuration time.Duration
+}
+
+func (c LockoutConfig) withDefaults() LockoutConfig {
+	if c.MaxFailures <= 0 {
+		c.MaxFailures = DefaultMaxFailures
+	}
+	if c.MaxIPFailures <= 0 {
+		c.MaxIPFailures = DefaultMaxIPFailures
+	}
+	if c.Window <= 0 {
+		c.Window = DefaultFailureWindow
+	}
+	if c.Delay <= 0 {
+		c.Delay = DefaultFailureDelay
+	}
+	if c.LockDuration <= 0 {
+		c.LockDuration = DefaultLockDuration
+	}
+
+	return c
+}
+
+// LockoutConfigFromEnv reads the LockoutConfig from the environment, $AUTH_LOCKOUT_MAX_FAILURES and
+// $AUTH_LOCKOUT_MAX_IP_FAILURES are counts and $AUTH_LOCKOUT_WINDOW, $AUTH_LOCKOUT_DELAY and
+// $AUTH_LOCKOUT_DURATION durations. Unset variables take their defaults.
+func LockoutConfigFromEnv() (LockoutConfig, error) {
+	var config LockoutConfig
+
+	for env, n := range map[string]*int{MaxFailuresEnv: &config.MaxFailures, MaxIPFailuresEnv: &config.MaxIPFailures} {
+		if value := os.Getenv(env); len(value) > 0 {
+			parsed, err := strconv.Atoi(value)
+			if err != nil || parsed <= 0 {
+				return config, fmt.Errorf("auth: invalid $%s: %q", env, value)
+			}
+			*n = parsed
+		}
+	}
+
+	for env, d := range map[string]*time.Duration{WindowEnv: &config.Window, DelayEnv: &config.Delay,
+		LockDurationEnv: &config.LockDuration} {
+		if value := os.Getenv(env); len(value) > 0 {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return config, fmt.Errorf("auth: invalid $%s: %w", env, err)
+			}
+			*d = parsed
+		}
+	}
+
+	return config, nil
+}
+
+// Lockout returns until when the logins of a user are refused, the zero time when they are not
+func (s *Service) Lockout(ctx context.Context, userID int64) (time.Time, error) {
+	now := s.now()
+
+	until, err := s.accountLockout(ctx, s.repo, storage.QueryLoginAttempt{UserID: userID}, now)
+	if err != nil || !until.After(now) {
+		return time.Time{}, err
+	}
+
+	return until, nil
+}
+
+// Unlock lifts the lockout of a user, its failed logins so far no longer count
+func (s *Service) Unlock(ctx context.Context, userID int64) error {
+	user, err := s.repo.Users().Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.record(ctx, s.repo, user, user.Username, "", storage.LoginUnlocked)
+}
+
+// lockout returns until when a login is refused, by the lockout of its account or of the client's address.
+// Logins matching no account are throttled by the login they tried.
+func (s *Service) lockout(ctx context.Context, stores storage.Stores, user *storage.User, login string, ip string,
+	now time.Time) (time.Time, error) {
+	query := storage.QueryLoginAttempt{Username: truncate(login)}
+	if user != nil {
+		query = storage.QueryLoginAttempt{UserID: user.ID}
+	}
+
+	until, err := s.accountLockout(ctx, stores, query, now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if len(ip) > 0 {
+		ipUntil, err := s.ipLockout(ctx, stores, ip, now)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if ipUntil.After(until) {
+			until = ipUntil
+		}
+	}
+
+	return until, nil
+}
+
+// accountLockout returns until when the logins matching query are refused, after its failures in a row
+func (s *Service) accountLockout(ctx context.Context, stores storage.Stores, query storage.QueryLoginAttempt,
+	now time.Time) (time.Time, error) {
+	query.From = now.Add(-s.lockoutConfig.Window)
+	query.Limit = share.DefaultLimit
+
+	var (
+		failures int
+		last     time.Time
+	)
+
+paging:
+	for {
+		rows, total, err := stores.LoginAttempts().Query(ctx, query, storage.SortLoginAttempt{})
+		if err != nil {
+			return time.Time{}, err
+		}
+
+		for _, a := range rows {
+			switch a.Outcome {
+			case storage.LoginFailed:
+				failures++
+				if a.CreatedAt.After(last) {
+					last = a.CreatedAt
+				}
+			case storage.LoginLockedOut:
+				// refused logins neither count nor break the row of failures
+			default:
+				break paging
+			}
+
+			if failures >= s.lockoutConfig.MaxFailures {
+				break paging
+			}
+		}
+
+		query.Offset += int64(len(rows))
+		if len(rows) == 0 || query.Offset >= total {
+			break
+		}
+	}
+
+	switch {
+	case failures >= s.lockoutConfig.MaxFailures:
+		return last.Add(s.lockoutConfig.LockDuration), nil
+	case failures > 0:
+		// the delay never outgrows a lockout, nor overflows with a large MaxFailures
+		delay := s.lockoutConfig.Delay << uint(failures-1)
+		if delay <= 0 || delay > s.lockoutConfig.LockDuration {
+			delay = s.lockoutConfig.LockDuration
+		}
+		return last.Add(delay), nil
+	default:
+		return time.Time{}, nil
+	}
+}
+
+// ipLockout returns until when the logins from an address are refused
+func (s *Service) ipLockout(ctx context.Context, stores storage.Stores, ip string, now time.Time) (time.Time, error) {
+	rows, total, err := stores.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{
+		IP:      ip,
+		Outcome: storage.LoginFailed,
+		From:    now.Add(-s.lockoutConfig.Window),
+		Limit:   1,
+	}, storage.SortLoginAttempt{})
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	if total < int64(s.lockoutConfig.MaxIPFailures) || len(rows) == 0 {
+		return time.Time{}, nil
+	}
+
+	return rows[0].CreatedAt.Add(s.lockoutConfig.LockDuration), nil
+}
+
+// record records a login attempt, user is nil when the login matched no user
+func (s *Service) record(ctx context.Context, stores storage.Stores, user *storage.User, login string, ip string,
+	outcome string) error {
+	a := storage.CreateLoginAttempt{Username: truncate(login), IP: truncate(ip), Outcome: outcome}
+	if user != nil {
+		a.UserID = user.ID
+	}
+
+	_, err := stores.LoginAttempts().Insert(ctx, a)
+	return err
+}
+
+// truncate cuts s to the size of the login_attempts columns, which count characters
+func truncate(s string) string {
+	if utf8.RuneCountInString(s) <= maxLoginField {
+		return s
+	}
+
+	return string([]rune(s)[:maxLoginField])
+}
@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// after makes s behave as if d has passed
+func after(s *Service, d time.Duration) {
+	s.now = func() time.Time { return time.Now().Add(d) }
+}
+
+func TestService_Lockout(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_delay_then_lock_out_failed_logins", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "alice", Email: "alice@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "wrong"})
+		require.Equal(t, ErrInvalidCredentials, err)
+
+		// the next login is delayed, even with the right password
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		require.True(t, errors.Is(err, ErrLockedOut))
+
+		until, err := s.Lockout(ctx, id)
+		require.Nil(t, err)
+		require.False(t, until.IsZero())
+		require.True(t, until.Before(time.Now().Add(2*time.Second)))
+
+		// waiting out each delay, which doubles up to 8s
+		for i := 1; i < DefaultMaxFailures; i++ {
+			after(s, time.Duration(i)*10*time.Second)
+			_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "wrong"})
+			require.Equal(t, ErrInvalidCredentials, err, "failure %d", i)
+		}
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		var lockErr *LockoutError
+		require.True(t, errors.As(err, &lockErr))
+		require.True(t, lockErr.Until.After(time.Now().Add(DefaultLockDuration-time.Minute)))
+
+		until, err = s.Lockout(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, lockErr.Until, until)
+
+		rows, _, err := s.repo.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: id,
+			Outcome: storage.LoginLockedOut}, storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Len(t, rows, 2)
+
+		after(s, DefaultLockDuration+time.Minute)
+		user, err := s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+	})
+
+	t.Run("success_check_concurrent_logins_one_after_the_other", func(t *testing.T) {
+		s := newTestService()
+
+		_, err := s.CreateUser(ctx, CreateUser{Username: "grace", Email: "grace@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		// the first failure delays the others, none may pass the lockout before it is recorded
+		var wg sync.WaitGroup
+		errs := make(chan error, 10)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := s.VerifyCredentials(ctx, Credentials{Login: "grace", Password: "wrong"})
+				errs <- err
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		failures := 0
+		for err := range errs {
+			if err == ErrInvalidCredentials {
+				failures++
+				continue
+			}
+			require.True(t, errors.Is(err, ErrLockedOut))
+		}
+		require.Equal(t, 1, failures)
+	})
+
+	t.Run("success_unlock_a_user", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "bob", Email: "bob@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		for i := 0; i < DefaultMaxFailures; i++ {
+			after(s, time.Duration(i)*10*time.Second)
+			_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "wrong"})
+			require.Equal(t, ErrInvalidCredentials, err, "failure %d", i)
+		}
+
+		until, err := s.Lockout(ctx, id)
+		require.Nil(t, err)
+		require.False(t, until.IsZero())
+
+		require.Nil(t, s.Unlock(ctx, id))
+
+		until, err = s.Lockout(ctx, id)
+		require.Nil(t, err)
+		require.True(t, until.IsZero())
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Equal(t, storage.ErrNotFound, s.Unlock(ctx, id+100))
+	})
+
+	t.Run("fail_lock_out_an_address", func(t *testing.T) {
+		s := newTestService()
+		s.lockoutConfig.MaxIPFailures = 3
+
+		_, err := s.CreateUser(ctx, CreateUser{Username: "carol", Email: "carol@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		for _, login := range []string{"dave", "erin", "frank"} {
+			_, err = s.VerifyCredentials(ctx, Credentials{Login: login, Password: "secret", IP: "192.0.2.1"})
+			require.Equal(t, ErrInvalidCredentials, err)
+		}
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "carol", Password: "secret", IP: "192.0.2.1"})
+		require.True(t, errors.Is(err, ErrLockedOut))
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "carol", Password: "secret", IP: "192.0.2.2"})
+		require.Nil(t, err)
+	})
+}
+
+func TestLockoutConfigFromEnv(t *testing.T) {
+	t.Run("success_read_the_config", func(t *testing.T) {
+		defer os.Unsetenv(MaxFailuresEnv)
+		defer os.Unsetenv(WindowEnv)
+		os.Setenv(MaxFailuresEnv, "3")
+		os.Setenv(WindowEnv, "1h")
+
+		config, err := LockoutConfigFromEnv()
+		require.Nil(t, err)
+		require.Equal(t, LockoutConfig{MaxFailures: 3, Window: time.Hour}, config)
+		require.Equal(t, DefaultLockDuration, config.withDefaults().LockDuration)
+	})
+
+	t.Run("fail_read_an_invalid_config", func(t *testing.T) {
+		defer os.Unsetenv(DelayEnv)
+		os.Setenv(DelayEnv, "soon")
+
+		_, err := LockoutConfigFromEnv()
+		require.NotNil(t, err)
+	})
+}
@@ -0,0 +1,458 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/totp"
+)
+
+var (
+	// ErrMFARequired is returned when the password is right but the one-time password of the user is missing
+	ErrMFARequired = errors.New("auth: one-time password required")
+
+	// ErrInvalidOTP is returned when the one-time password or recovery code is wrong or was already used
+	ErrInvalidOTP = errors.New("auth: invalid one-time password")
+
+	// ErrMFAEnrollmentRequired is returned when the policy requires a second factor the user has not enrolled,
+	// VerifyCredentials returns it as an *EnrollmentRequiredError to active users
+	ErrMFAEnrollmentRequired = errors.New("auth: second factor enrollment required")
+
+	// ErrInvalidEnrollmentToken is returned when an enrollment token is unknown, expired or was used, or its
+	// user is no longer active
+	ErrInvalidEnrollmentToken = errors.New("auth: invalid enrollment token")
+
+	// ErrMFAEnrolled is returned when enrolling or confirming a second factor which is already confirmed
+	ErrMFAEnrolled = errors.New("auth: second factor already enrolled")
+
+	// ErrMFANotEnrolled is returned when confirming or using a second factor which was never enrolled
+	ErrMFANotEnrolled = errors.New("auth: second factor not enrolled")
+)
+
+// DefaultMFAIssuer labels the accounts of the service in authenticator apps when MFAConfig.Issuer is empty
+const DefaultMFAIssuer = "auth_service"
+
+// DefaultEnrollmentTTL is how long an enrollment token is valid when MFAConfig.EnrollmentTTL is zero
+const DefaultEnrollmentTTL = 10 * time.Minute
+
+// RecoveryCodes is how many recovery codes are generated at once
+const RecoveryCodes = 10
+
+// environment variables read by MFAConfigFromEnv
+const (
+	MFAIssuerEnv          = "AUTH_MFA_ISSUER"
+	MFARequiredBunchesEnv = "AUTH_MFA_REQUIRED_BUNCHES"
+)
+
+// MFAConfig tells how second factors are enrolled and who must use one. A user who confirmed a TOTP secret
+// always logs in with a code, the members of RequiredBunches cannot log in until they have one. Their logins
+// answer an enrollment token instead, which only lets them enroll one.
+type MFAConfig struct {
+	// Issuer labels the accounts in authenticator apps, DefaultMFAIssuer when empty
+	Issuer string
+
+	// RequiredBunches names the bunches whose active members must log in with a second factor
+	RequiredBunches []string
+
+	// EnrollmentTTL is how long an enrollment token is valid, DefaultEnrollmentTTL when zero
+	EnrollmentTTL time.Duration
+}
+
+func (c MFAConfig) withDefaults() MFAConfig {
+	if len(c.Issuer) == 0 {
+		c.Issuer = DefaultMFAIssuer
+	}
+	if c.EnrollmentTTL <= 0 {
+		c.EnrollmentTTL = DefaultEnrollmentTTL
+	}
+
+	return c
+}
+
+// MFAConfigFromEnv reads the MFAConfig from the environment, $AUTH_MFA_ISSUER is the issuer and
+// $AUTH_MFA_REQUIRED_BUNCHES a comma separated list of bunch names
+func MFAConfigFromEnv() MFAConfig {
+	config := MFAConfig{Issuer: os.Getenv(MFAIssuerEnv)}
+
+	for _, name := range strings.Split(os.Getenv(MFARequiredBunchesEnv), ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 {
+			config.RequiredBunches = append(config.RequiredBunches, name)
+		}
+	}
+
+	return config
+}
+
+// EnrollmentRequiredError refuses the login of an active user who must enroll a second factor first. Token
+// authenticates the user to the enrollment of a TOTP secret until ExpiredAt or until a secret is confirmed.
+type EnrollmentRequiredError struct {
+	Token     string
+	ExpiredAt time.Time
+}
+
+func (e *EnrollmentRequiredError) Error() string {
+	return ErrMFAEnrollmentRequired.Error()
+}
+
+// Unwrap makes an EnrollmentRequiredError match ErrMFAEnrollmentRequired
+func (e *EnrollmentRequiredError) Unwrap() error {
+	return ErrMFAEnrollmentRequired
+}
+
+// Enrollment is a new TOTP secret, to be shown once to the user along with the URI provisioning it in an app
+type Enrollment struct {
+	Secret string
+	URI    string
+}
+
+// MFAStatus tells about the second factor of a user
+type MFAStatus struct {
+	// Enrolled is whether the user has a confirmed TOTP secret
+	Enrolled bool
+
+	// Pending is whether the user has a TOTP secret waiting for its first code
+	Pending bool
+
+	// Required is whether the policy requires a second factor from the user
+	Required bool
+
+	// RecoveryCodes is how many recovery codes of the user are left
+	RecoveryCodes int64
+}
+
+// EnrollTOTP generates a TOTP secret for a user, replacing the one waiting for confirmation. The secret guards
+// the logins of the user once ConfirmTOTP confirms it.
+func (s *Service) EnrollTOTP(ctx context.Context, userID int64) (*Enrollment, error) {
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	var user *storage.User
+	err = s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		if user, err = tx.Users().Get(ctx, userID); err != nil {
+			return err
+		}
+
+		existing, err := tx.TOTPs().Get(ctx, userID)
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+		case err != nil:
+			return err
+		case !existing.ConfirmedAt.IsZero():
+			return ErrMFAEnrolled
+		default:
+			if err := tx.TOTPs().Delete(ctx, userID); err != nil {
+				return err
+			}
+		}
+
+		return tx.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: secret})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &Enrollment{Secret: secret, URI: totp.URI(secret, s.mfaConfig.Issuer, user.Email)}, nil
+}
+
+// ConfirmTOTP confirms the enrolled secret of a user with a first code and returns its recovery codes, which
+// are only ever shown here and by RegenerateRecoveryCodes. The enrollment tokens of the user stop working.
+func (s *Service) ConfirmTOTP(ctx context.Context, userID int64, code string) ([]string, error) {
+	secret, err := s.repo.TOTPs().Get(ctx, userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, ErrMFANotEnrolled
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !secret.ConfirmedAt.IsZero() {
+		return nil, ErrMFAEnrolled
+	}
+
+	step, ok, err := totp.Validate(secret.Secret, code, s.now(), totp.DefaultSkew)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidOTP
+	}
+
+	var codes []string
+	err = s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		confirmed, err := tx.TOTPs().Confirm(ctx, userID, step)
+		if err != nil {
+			return err
+		}
+		if !confirmed {
+			return ErrMFAEnrolled
+		}
+
+		if _, err := tx.UserTokens().Invalidate(ctx, userID, storage.PurposeMFAEnrollment); err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP deletes the TOTP secret and the recovery codes of a user, a user removing its own should be
+// reauthenticated first
+func (s *Service) DisableTOTP(ctx context.Context, userID int64) error {
+	return s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		if err := tx.TOTPs().Delete(ctx, userID); err != nil {
+			if errors.Is(err, storage.ErrNotFound) {
+				return ErrMFANotEnrolled
+			}
+			return err
+		}
+
+		_, err := tx.RecoveryCodes().DeleteUser(ctx, userID)
+		return err
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user who has a confirmed TOTP secret
+func (s *Service) RegenerateRecoveryCodes(ctx context.Context, userID int64) ([]string, error) {
+	var codes []string
+	err := s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		secret, err := tx.TOTPs().Get(ctx, userID)
+		if errors.Is(err, storage.ErrNotFound) || (err == nil && secret.ConfirmedAt.IsZero()) {
+			return ErrMFANotEnrolled
+		}
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// MFA returns the second factor status of a user
+func (s *Service) MFA(ctx context.Context, userID int64) (*MFAStatus, error) {
+	user, err := s.repo.Users().Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var status MFAStatus
+
+	secret, err := s.repo.TOTPs().Get(ctx, userID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return nil, err
+	}
+	if err == nil {
+		status.Enrolled = !secret.ConfirmedAt.IsZero()
+		status.Pending = secret.ConfirmedAt.IsZero()
+	}
+
+	if status.Required, err = s.inRequiredBunch(ctx, s.repo, user); err != nil {
+		return nil, err
+	}
+
+	_, status.RecoveryCodes, err = s.repo.RecoveryCodes().Query(ctx, storage.QueryRecoveryCode{UserID: userID,
+		Used: share.Boolean{IsSet: true, Bool: false}, Limit: 1}, storage.SortRecoveryCode{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// EnrollmentUser returns the id of the active user an enrollment token was issued to
+func (s *Service) EnrollmentUser(ctx context.Context, token string) (int64, error) {
+	ut, err := s.findToken(ctx, token, storage.PurposeMFAEnrollment)
+	if err != nil {
+		return 0, err
+	}
+	if ut == nil {
+		return 0, ErrInvalidEnrollmentToken
+	}
+
+	user, err := s.repo.Users().Get(ctx, ut.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return 0, ErrInvalidEnrollmentToken
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !user.Active.Bool {
+		return 0, ErrInvalidEnrollmentToken
+	}
+
+	return user.ID, nil
+}
+
+// enrollmentRequired issues an enrollment token to a user whose login was refused for a missing second factor,
+// it invalidates the tokens issued before
+func (s *Service) enrollmentRequired(ctx context.Context, userID int64) error {
+	token, expiredAt, err := s.issueToken(ctx, userID, storage.PurposeMFAEnrollment, s.mfaConfig.EnrollmentTTL)
+	if err != nil {
+		return err
+	}
+
+	return &EnrollmentRequiredError{Token: token, ExpiredAt: expiredAt}
+}
+
+// verifySecondFactor checks the one-time password of a user whose password is right. Users without a
+// confirmed secret pass unless the policy requires one from them.
+func (s *Service) verifySecondFactor(ctx context.Context, stores storage.Stores, user *storage.User, otp string) error {
+	secret, err := stores.TOTPs().Get(ctx, user.ID)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return err
+	}
+
+	if err != nil || secret.ConfirmedAt.IsZero() {
+		required, err := s.inRequiredBunch(ctx, stores, user)
+		if err != nil {
+			return err
+		}
+		if required {
+			return ErrMFAEnrollmentRequired
+		}
+		return nil
+	}
+
+	otp = strings.TrimSpace(otp)
+	if len(otp) == 0 {
+		return ErrMFARequired
+	}
+
+	var ok bool
+	if isTOTPCode(otp) {
+		step, valid, err := totp.Validate(secret.Secret, otp, s.now(), totp.DefaultSkew)
+		if err != nil {
+			return err
+		}
+		// a code is accepted once, so a code seen by someone else cannot be replayed within its window
+		if valid {
+			if ok, err = stores.TOTPs().Use(ctx, user.ID, step); err != nil {
+				return err
+			}
+		}
+	} else if ok, err = stores.RecoveryCodes().Use(ctx, user.ID, hashRecoveryCode(otp)); err != nil {
+		return err
+	}
+
+	if !ok {
+		return ErrInvalidOTP
+	}
+
+	return nil
+}
+
+// inRequiredBunch tells whether user is an active member of a bunch requiring a second factor
+func (s *Service) inRequiredBunch(ctx context.Context, stores storage.Stores, user *storage.User) (bool, error) {
+	if len(s.mfaConfig.RequiredBunches) == 0 {
+		return false, nil
+	}
+
+	required := make(map[string]bool, len(s.mfaConfig.RequiredBunches))
+	for _, name := range s.mfaConfig.RequiredBunches {
+		required[name] = true
+	}
+
+	query := storage.QueryUserBunch{
+		UserID:      user.ID,
+		BunchActive: share.Boolean{IsSet: true, Bool: true},
+		Limit:       share.DefaultLimit,
+	}
+	for {
+		rows, total, err := stores.UserBunches().Query(ctx, query, storage.SortUserBunch{})
+		if err != nil {
+			return false, err
+		}
+
+		for _, ub := range rows {
+			if required[ub.Bunch.Name] {
+				return true, nil
+			}
+		}
+
+		query.Offset += int64(len(rows))
+		if len(rows) == 0 || query.Offset >= total {
+			return false, nil
+		}
+	}
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and stores the hashes of new ones
+func replaceRecoveryCodes(ctx context.Context, tx storage.Stores, userID int64) ([]string, error) {
+	if _, err := tx.RecoveryCodes().DeleteUser(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, RecoveryCodes)
+	for i := 0; i < RecoveryCodes; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := tx.RecoveryCodes().Insert(ctx, storage.CreateRecoveryCode{UserID: userID,
+			Hash: hashRecoveryCode(code)}); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCode returns a random code of 80 bits, shown as four groups of four characters
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	encoded := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+
+	return encoded[0:4] + "-" + encoded[4:8] + "-" + encoded[8:12] + "-" + encoded[12:16], nil
+}
+
+// hashRecoveryCode returns the hex encoded SHA-256 digest of a code, ignoring case and separators. Codes are
+// random enough that a fast digest cannot be reversed.
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// isTOTPCode tells a TOTP code, which is all digits, from a recovery code
+func isTOTPCode(otp string) bool {
+	otp = strings.Replace(otp, " ", "", -1)
+	if len(otp) != totp.Digits {
+		return false
+	}
+
+	for _, r := range otp {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/memory"
+	"github.com/vespaiach/auth_service/pkg/totp"
+)
+
+// codeAt returns the TOTP code of secret for the time s believes it is
+func codeAt(t *testing.T, s *Service, secret string) string {
+	t.Helper()
+
+	code, err := totp.Code(secret, totp.Step(s.now()))
+	require.Nil(t, err)
+
+	return code
+}
+
+func TestService_MFA(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_enroll_confirm_and_log_in", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "alice", Email: "alice@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		enrollment, err := s.EnrollTOTP(ctx, id)
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(enrollment.URI, "otpauth://totp/auth_service:alice@test.com?"))
+		require.Contains(t, enrollment.URI, "secret="+enrollment.Secret)
+
+		// an unconfirmed secret does not guard logins yet
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		require.Nil(t, err)
+
+		_, err = s.ConfirmTOTP(ctx, id, "000000")
+		require.Equal(t, ErrInvalidOTP, err)
+
+		code := codeAt(t, s, enrollment.Secret)
+		codes, err := s.ConfirmTOTP(ctx, id, code)
+		require.Nil(t, err)
+		require.Len(t, codes, RecoveryCodes)
+
+		status, err := s.MFA(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, &MFAStatus{Enrolled: true, RecoveryCodes: RecoveryCodes}, status)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		require.Equal(t, ErrMFARequired, err)
+
+		// the confirming code was used already
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret", OTP: code})
+		require.Equal(t, ErrInvalidOTP, err)
+
+		after(s, time.Minute)
+		code = codeAt(t, s, enrollment.Secret)
+		user, err := s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret", OTP: code})
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+
+		after(s, 2*time.Minute)
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret", OTP: code})
+		require.Equal(t, ErrInvalidOTP, err)
+	})
+
+	t.Run("success_log_in_with_recovery_codes", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "bob", Email: "bob@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		enrollment, err := s.EnrollTOTP(ctx, id)
+		require.Nil(t, err)
+		codes, err := s.ConfirmTOTP(ctx, id, codeAt(t, s, enrollment.Secret))
+		require.Nil(t, err)
+
+		// codes are accepted whatever their case and separators
+		otp := strings.ToUpper(strings.Replace(codes[0], "-", "", -1))
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "secret", OTP: otp})
+		require.Nil(t, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "secret", OTP: codes[0]})
+		require.Equal(t, ErrInvalidOTP, err)
+
+		status, err := s.MFA(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, int64(RecoveryCodes-1), status.RecoveryCodes)
+
+		fresh, err := s.RegenerateRecoveryCodes(ctx, id)
+		require.Nil(t, err)
+		require.Len(t, fresh, RecoveryCodes)
+
+		after(s, time.Minute)
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "secret", OTP: codes[1]})
+		require.Equal(t, ErrInvalidOTP, err)
+
+		after(s, 2*time.Minute)
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob", Password: "secret", OTP: fresh[1]})
+		require.Nil(t, err)
+	})
+
+	t.Run("success_replace_and_disable_a_secret", func(t *testing.T) {
+		s := newTestService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "carol", Email: "carol@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		_, err = s.ConfirmTOTP(ctx, id, "123456")
+		require.Equal(t, ErrMFANotEnrolled, err)
+
+		first, err := s.EnrollTOTP(ctx, id)
+		require.Nil(t, err)
+		second, err := s.EnrollTOTP(ctx, id)
+		require.Nil(t, err)
+		require.NotEqual(t, first.Secret, second.Secret)
+
+		_, err = s.ConfirmTOTP(ctx, id, codeAt(t, s, second.Secret))
+		require.Nil(t, err)
+
+		_, err = s.EnrollTOTP(ctx, id)
+		require.Equal(t, ErrMFAEnrolled, err)
+
+		require.Nil(t, s.DisableTOTP(ctx, id))
+		require.Equal(t, ErrMFANotEnrolled, s.DisableTOTP(ctx, id))
+
+		status, err := s.MFA(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, &MFAStatus{}, status)
+
+		_, err = s.RegenerateRecoveryCodes(ctx, id)
+		require.Equal(t, ErrMFANotEnrolled, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "carol", Password: "secret"})
+		require.Nil(t, err)
+
+		_, err = s.EnrollTOTP(ctx, id+100)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("fail_log_in_without_a_required_second_factor", func(t *testing.T) {
+		repo := memory.NewRepository(memory.NewDB())
+		s := NewService(repo, password.NewHasher(&password.Bcrypt{Cost: 4}, nil),
+			Config{MFA: MFAConfig{RequiredBunches: []string{"admin_role"}}})
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "dave", Email: "dave@test.com", Password: "secret"})
+		require.Nil(t, err)
+		bunchID, err := repo.Bunches().Insert(ctx, storage.CreateBunch{Name: "admin_role"})
+		require.Nil(t, err)
+		_, err = repo.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: id, BunchID: bunchID})
+		require.Nil(t, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "dave", Password: "secret"})
+		var first *EnrollmentRequiredError
+		require.True(t, errors.As(err, &first))
+		require.True(t, errors.Is(err, ErrMFAEnrollmentRequired))
+		require.WithinDuration(t, s.now().Add(DefaultEnrollmentTTL), first.ExpiredAt, time.Second)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "dave", Password: "secret"})
+		var second *EnrollmentRequiredError
+		require.True(t, errors.As(err, &second))
+
+		// a new token replaces the previous one
+		_, err = s.EnrollmentUser(ctx, first.Token)
+		require.Equal(t, ErrInvalidEnrollmentToken, err)
+		userID, err := s.EnrollmentUser(ctx, second.Token)
+		require.Nil(t, err)
+		require.Equal(t, id, userID)
+
+		require.Nil(t, repo.Users().Update(ctx, storage.UpdateUser{ID: id, Active: share.Boolean{IsSet: true}}))
+		_, err = s.EnrollmentUser(ctx, second.Token)
+		require.Equal(t, ErrInvalidEnrollmentToken, err)
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "dave", Password: "secret"})
+		require.Equal(t, ErrMFAEnrollmentRequired, err)
+	})
+}
+
+func TestMFAConfigFromEnv(t *testing.T) {
+	t.Run("success_read_the_config", func(t *testing.T) {
+		defer os.Unsetenv(MFAIssuerEnv)
+		defer os.Unsetenv(MFARequiredBunchesEnv)
+		os.Setenv(MFAIssuerEnv, "Acme")
+		os.Setenv(MFARequiredBunchesEnv, "admin_role, staff_role,")
+
+		require.Equal(t, MFAConfig{Issuer: "Acme", RequiredBunches: []string{"admin_role", "staff_role"}},
+			MFAConfigFromEnv())
+	})
+}
@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/mail"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// ErrInvalidResetToken is returned when a password reset token is unknown, expired or was already used
+var ErrInvalidResetToken = errors.New("auth: invalid password reset token")
+
+// DefaultResetTTL is how long a password reset token is valid when ResetConfig.TTL is zero
+const DefaultResetTTL = 30 * time.Minute
+
+// environment variables read by ResetConfigFromEnv
+const (
+	ResetTTLEnv = "AUTH_RESET_TTL"
+	ResetURLEnv = "AUTH_RESET_URL"
+)
+
+// ResetConfig tells how password reset tokens are mailed
+type ResetConfig struct {
+	// TTL is how long a token is valid, DefaultResetTTL when zero
+	TTL time.Duration
+
+	// URL is the page where users choose their new password, the token is mailed alone when it is empty and
+	// as the token parameter of the URL otherwise
+	URL string
+}
+
+func (c ResetConfig) withDefaults() ResetConfig {
+	if c.TTL <= 0 {
+		c.TTL = DefaultResetTTL
+	}
+
+	return c
+}
+
+// ResetConfigFromEnv reads the ResetConfig from the environment, $AUTH_RESET_TTL is a duration and
+// $AUTH_RESET_URL an absolute URL. Unset variables take their defaults.
+func ResetConfigFromEnv() (ResetConfig, error) {
+	ttl, link, err := readTokenEnv(ResetTTLEnv, ResetURLEnv)
+	if err != nil {
+		return ResetConfig{}, err
+	}
+
+	return ResetConfig{TTL: ttl, URL: link}, nil
+}
+
+// RequestPasswordReset mails a password reset token to the active user with the email, invalidating the
+// tokens mailed before. Unknown emails and inactive users are ignored without error, so callers cannot tell
+// which emails have an account.
+func (s *Service) RequestPasswordReset(ctx context.Context, email string) error {
+	user, err := s.repo.Users().GetByEmail(ctx, email)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !user.Active.Bool {
+		return nil
+	}
+
+	token, expiredAt, err := s.issueToken(ctx, user.ID, storage.PurposePasswordReset, s.resetConfig.TTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, s.resetMessage(user, token, expiredAt))
+}
+
+// ResetPassword sets the password of the user a reset token was mailed to and revokes all of their tokens,
+// so every session has to log in again. The reset token is used up, a token which is unknown, expired,
+// used or whose user was deactivated since returns ErrInvalidResetToken.
+func (s *Service) ResetPassword(ctx context.Context, token string, password string) error {
+	ut, err := s.findToken(ctx, token, storage.PurposePasswordReset)
+	if err != nil {
+		return err
+	}
+	if ut == nil {
+		return ErrInvalidResetToken
+	}
+
+	hash, salt, err := s.passwords.Hash(password)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		user, err := tx.Users().Get(ctx, ut.UserID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrInvalidResetToken
+		}
+		if err != nil {
+			return err
+		}
+		if !user.Active.Bool {
+			return ErrInvalidResetToken
+		}
+
+		// only one of concurrent resets with the same token gets to use it
+		used, err := tx.UserTokens().Use(ctx, ut.ID)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidResetToken
+		}
+
+		if err := tx.Users().Update(ctx, storage.UpdateUser{ID: user.ID, Hash: hash, Salt: salt}); err != nil {
+			return err
+		}
+
+		_, err = tx.TokenHistories().RevokeUser(ctx, user.ID)
+		return err
+	})
+}
+
+// resetMessage returns the mail carrying a password reset token
+func (s *Service) resetMessage(user *storage.User, token string, expiredAt time.Time) mail.Message {
+	return mail.Message{
+		To:      user.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf("A password reset was requested for the account %s. Use this token to choose a new "+
+			"password before %s:\n\n%s\n\nIf you did not request it, ignore this message and your password stays "+
+			"unchanged.", user.Username, expiredAt.UTC().Format(time.RFC1123), tokenLink(s.resetConfig.URL, token)),
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"context"
+	"os"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/mail"
+	"github.com/vespaiach/auth_service/pkg/password"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/memory"
+)
+
+// outbox is a mail.Mailer keeping the messages it is given
+type outbox struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (o *outbox) Send(ctx context.Context, m mail.Message) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = append(o.messages, m)
+	return nil
+}
+
+// lastToken returns the reset token of the last message sent to an email
+func (o *outbox) lastToken(t *testing.T, email string) string {
+	t.Helper()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i := len(o.messages) - 1; i >= 0; i-- {
+		if o.messages[i].To == email {
+			m := regexp.MustCompile(`token=([A-Za-z0-9_-]+)`).FindStringSubmatch(o.messages[i].Body)
+			require.NotNil(t, m)
+			return m[1]
+		}
+	}
+
+	t.Fatalf("no message to %s", email)
+	return ""
+}
+
+// newMailService returns a service keeping its mails in an outbox
+func newMailService() (*Service, *outbox) {
+	box := new(outbox)
+	s := NewService(memory.NewRepository(memory.NewDB()), password.NewHasher(&password.Bcrypt{Cost: 4}, nil),
+		Config{Reset: ResetConfig{URL: "https://example.com/reset?lang=en"},
+			Verification: VerificationConfig{URL: "https://example.com/verify"}, Mailer: box})
+
+	return s, box
+}
+
+func TestService_ResetPassword(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_reset_a_password_revoking_sessions", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "alice", Email: "alice@test.com", Password: "secret"})
+		require.Nil(t, err)
+		uid, err := s.repo.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UID: share.NewUID(), UserID: id,
+			ExpiredAt: time.Now().Add(time.Hour)})
+		require.Nil(t, err)
+
+		require.Nil(t, s.RequestPasswordReset(ctx, "alice@test.com"))
+		require.Len(t, box.messages, 1)
+		require.Equal(t, "alice@test.com", box.messages[0].To)
+		require.Contains(t, box.messages[0].Body, "https://example.com/reset?lang=en&token=")
+		token := box.lastToken(t, "alice@test.com")
+
+		// only a digest of the token is stored
+		_, err = s.repo.UserTokens().GetByHash(ctx, token)
+		require.Equal(t, storage.ErrNotFound, err)
+
+		require.Nil(t, s.ResetPassword(ctx, token, "new secret"))
+		require.Equal(t, ErrInvalidResetToken, s.ResetPassword(ctx, token, "other secret"))
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "secret"})
+		require.Equal(t, ErrInvalidCredentials, err)
+		after(s, time.Minute)
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "alice", Password: "new secret"})
+		require.Nil(t, err)
+
+		th, err := s.repo.TokenHistories().Get(ctx, uid)
+		require.Nil(t, err)
+		require.False(t, th.RevokedAt.IsZero())
+	})
+
+	t.Run("success_ignore_unknown_and_inactive_users", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "bob", Email: "bob@test.com", Password: "secret"})
+		require.Nil(t, err)
+		require.Nil(t, s.repo.Users().Update(ctx, storage.UpdateUser{ID: id, Active: share.Boolean{IsSet: true}}))
+
+		require.Nil(t, s.RequestPasswordReset(ctx, "bob@test.com"))
+		require.Nil(t, s.RequestPasswordReset(ctx, "nobody@test.com"))
+		require.Empty(t, box.messages)
+	})
+
+	t.Run("fail_reset_with_a_replaced_or_expired_token", func(t *testing.T) {
+		s, box := newMailService()
+
+		_, err := s.CreateUser(ctx, CreateUser{Username: "carol", Email: "carol@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Nil(t, s.RequestPasswordReset(ctx, "carol@test.com"))
+		first := box.lastToken(t, "carol@test.com")
+		require.Nil(t, s.RequestPasswordReset(ctx, "carol@test.com"))
+		second := box.lastToken(t, "carol@test.com")
+
+		require.Equal(t, ErrInvalidResetToken, s.ResetPassword(ctx, first, "new secret"))
+		require.Equal(t, ErrInvalidResetToken, s.ResetPassword(ctx, "unknown", "new secret"))
+
+		after(s, DefaultResetTTL)
+		require.Equal(t, ErrInvalidResetToken, s.ResetPassword(ctx, second, "new secret"))
+
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "carol", Password: "secret"})
+		require.Nil(t, err)
+	})
+
+	t.Run("fail_reset_after_the_password_changed", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "dave", Email: "dave@test.com", Password: "secret"})
+		require.Nil(t, err)
+		require.Nil(t, s.RequestPasswordReset(ctx, "dave@test.com"))
+
+		hash, salt, err := s.HashPassword("changed")
+		require.Nil(t, err)
+		require.Nil(t, s.repo.Users().Update(ctx, storage.UpdateUser{ID: id, Hash: hash, Salt: salt}))
+
+		require.Equal(t, ErrInvalidResetToken, s.ResetPassword(ctx, box.lastToken(t, "dave@test.com"), "new secret"))
+	})
+}
+
+func TestResetConfigFromEnv(t *testing.T) {
+	defer os.Unsetenv(ResetTTLEnv)
+	defer os.Unsetenv(ResetURLEnv)
+
+	t.Run("success_read_the_config", func(t *testing.T) {
+		os.Setenv(ResetTTLEnv, "15m")
+		os.Setenv(ResetURLEnv, "https://example.com/reset")
+
+		config, err := ResetConfigFromEnv()
+		require.Nil(t, err)
+		require.Equal(t, ResetConfig{TTL: 15 * time.Minute, URL: "https://example.com/reset"}, config)
+	})
+
+	t.Run("fail_read_an_invalid_config", func(t *testing.T) {
+		os.Setenv(ResetTTLEnv, "soon")
+		_, err := ResetConfigFromEnv()
+		require.NotNil(t, err)
+
+		os.Setenv(ResetTTLEnv, "15m")
+		os.Setenv(ResetURLEnv, "/reset")
+		_, err = ResetConfigFromEnv()
+		require.NotNil(t, err)
+	})
+}
@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// readTokenEnv reads the lifetime and link of mailed tokens from the environment, ttlEnv is a duration and
+// urlEnv an absolute URL, unset variables are left zero
+func readTokenEnv(ttlEnv string, urlEnv string) (time.Duration, string, error) {
+	var ttl time.Duration
+
+	if value := os.Getenv(ttlEnv); len(value) > 0 {
+		parsed, err := time.ParseDuration(value)
+		if err != nil || parsed <= 0 {
+			return 0, "", fmt.Errorf("auth: invalid $%s: %q", ttlEnv, value)
+		}
+		ttl = parsed
+	}
+
+	link := os.Getenv(urlEnv)
+	if len(link) > 0 {
+		u, err := url.Parse(link)
+		if err != nil || !u.IsAbs() {
+			return 0, "", fmt.Errorf("auth: invalid $%s: %q", urlEnv, link)
+		}
+	}
+
+	return ttl, link, nil
+}
+
+// issueToken stores a new token of a user for a purpose, valid for ttl, and invalidates the ones issued before.
+// Only a digest of the returned token is stored.
+func (s *Service) issueToken(ctx context.Context, userID int64, purpose string, ttl time.Duration) (string, time.Time, error) {
+	token, err := newUserToken()
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	expiredAt := s.now().Add(ttl)
+	err = s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		if _, err := tx.UserTokens().Invalidate(ctx, userID, purpose); err != nil {
+			return err
+		}
+
+		_, err := tx.UserTokens().Insert(ctx, storage.CreateUserToken{UserID: userID, Purpose: purpose,
+			Hash: hashUserToken(token), ExpiredAt: expiredAt})
+		return err
+	})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+
+	return token, expiredAt, nil
+}
+
+// findToken returns the stored token of a purpose when it is neither used nor expired, nil otherwise
+func (s *Service) findToken(ctx context.Context, token string, purpose string) (*storage.UserToken, error) {
+	ut, err := s.repo.UserTokens().GetByHash(ctx, hashUserToken(token))
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ut.Purpose != purpose || !ut.UsedAt.IsZero() || !s.now().Before(ut.ExpiredAt) {
+		return nil, nil
+	}
+
+	return ut, nil
+}
+
+// tokenLink returns the token as the token parameter of link, or alone when link is empty
+func tokenLink(link string, token string) string {
+	if len(link) == 0 {
+		return token
+	}
+
+	u, _ := url.Parse(link)
+	q := u.Query()
+	q.Set("token", token)
+	u.RawQuery = q.Encode()
+
+	return u.String()
+}
+
+// newUserToken returns a random token of 256 bits
+func newUserToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// hashUserToken returns the hex encoded SHA-256 digest of a token, tokens are random enough that a fast
+// digest cannot be reversed
+func hashUserToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
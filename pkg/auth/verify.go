@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/mail"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	// ErrInvalidVerificationToken is returned when an email verification token is unknown, expired or was
+	// already used
+	ErrInvalidVerificationToken = errors.New("auth: invalid email verification token")
+
+	// ErrEmailVerified is returned when asking to verify an email which is verified already
+	ErrEmailVerified = errors.New("auth: email already verified")
+)
+
+// DefaultVerificationTTL is how long an email verification token is valid when VerificationConfig.TTL is zero
+const DefaultVerificationTTL = 24 * time.Hour
+
+// environment variables read by VerificationConfigFromEnv
+const (
+	VerificationTTLEnv = "AUTH_VERIFICATION_TTL"
+	VerificationURLEnv = "AUTH_VERIFICATION_URL"
+)
+
+// VerificationConfig tells how email verification tokens are mailed
+type VerificationConfig struct {
+	// TTL is how long a token is valid, DefaultVerificationTTL when zero
+	TTL time.Duration
+
+	// URL is the page confirming the email, the token is mailed alone when it is empty and as the token
+	// parameter of the URL otherwise
+	URL string
+}
+
+func (c VerificationConfig) withDefaults() VerificationConfig {
+	if c.TTL <= 0 {
+		c.TTL = DefaultVerificationTTL
+	}
+
+	return c
+}
+
+// VerificationConfigFromEnv reads the VerificationConfig from the environment, $AUTH_VERIFICATION_TTL is a
+// duration and $AUTH_VERIFICATION_URL an absolute URL. Unset variables take their defaults.
+func VerificationConfigFromEnv() (VerificationConfig, error) {
+	ttl, link, err := readTokenEnv(VerificationTTLEnv, VerificationURLEnv)
+	if err != nil {
+		return VerificationConfig{}, err
+	}
+
+	return VerificationConfig{TTL: ttl, URL: link}, nil
+}
+
+// RequestEmailVerification mails a verification token to the pending email of a user or, without one, to its
+// email, invalidating the tokens mailed before. A verified email without a pending one returns
+// ErrEmailVerified.
+func (s *Service) RequestEmailVerification(ctx context.Context, userID int64) error {
+	user, err := s.repo.Users().Get(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	email := user.PendingEmail
+	if len(email) == 0 {
+		if !user.EmailVerifiedAt.IsZero() {
+			return ErrEmailVerified
+		}
+		email = user.Email
+	}
+
+	token, expiredAt, err := s.issueToken(ctx, user.ID, storage.PurposeEmailVerification, s.verificationConfig.TTL)
+	if err != nil {
+		return err
+	}
+
+	return s.mailer.Send(ctx, s.verificationMessage(user, email, token, expiredAt))
+}
+
+// ChangeEmail makes email the pending email of a user and mails it a verification token, the current email
+// stays in use until the new one is verified. Changing back to the current email cancels the pending one.
+// An email used by another user returns a *storage.DuplicateError.
+func (s *Service) ChangeEmail(ctx context.Context, userID int64, email string) error {
+	var mailing bool
+	err := s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		var err error
+		mailing, err = s.StageEmail(ctx, tx, userID, email)
+		return err
+	})
+	if err != nil || !mailing {
+		return err
+	}
+
+	return s.RequestEmailVerification(ctx, userID)
+}
+
+// StageEmail stores the change of ChangeEmail in tx, so it commits or rolls back with the other changes of the
+// user made there. It tells whether the new email awaits a verification, to be mailed by
+// RequestEmailVerification once tx committed.
+func (s *Service) StageEmail(ctx context.Context, tx storage.Stores, userID int64, email string) (bool, error) {
+	user, err := tx.Users().Get(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+
+	if email == user.Email {
+		if len(user.PendingEmail) == 0 {
+			return false, nil
+		}
+		return false, tx.Users().SetPendingEmail(ctx, user.ID, "")
+	}
+
+	other, err := tx.Users().GetByEmail(ctx, email)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		return false, err
+	}
+	if other != nil {
+		return false, &storage.DuplicateError{Entity: "user", Field: "email"}
+	}
+
+	if err := tx.Users().SetPendingEmail(ctx, user.ID, email); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// VerifyEmail verifies the email a verification token was mailed to, a pending email replaces the email of
+// the user. The token is used up, a token which is unknown, expired, used or whose user was deactivated since
+// returns ErrInvalidVerificationToken. A pending email taken by another user since returns a
+// *storage.DuplicateError.
+func (s *Service) VerifyEmail(ctx context.Context, token string) error {
+	ut, err := s.findToken(ctx, token, storage.PurposeEmailVerification)
+	if err != nil {
+		return err
+	}
+	if ut == nil {
+		return ErrInvalidVerificationToken
+	}
+
+	return s.repo.WithTx(ctx, func(tx storage.Stores) error {
+		user, err := tx.Users().Get(ctx, ut.UserID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrInvalidVerificationToken
+		}
+		if err != nil {
+			return err
+		}
+		if !user.Active.Bool {
+			return ErrInvalidVerificationToken
+		}
+
+		used, err := tx.UserTokens().Use(ctx, ut.ID)
+		if err != nil {
+			return err
+		}
+		if !used {
+			return ErrInvalidVerificationToken
+		}
+
+		// changing the email invalidates the tokens, so the token was mailed to the current target
+		email := user.PendingEmail
+		if len(email) == 0 {
+			email = user.Email
+		}
+
+		verified, err := tx.Users().VerifyEmail(ctx, user.ID, email)
+		if err != nil {
+			return err
+		}
+		if !verified {
+			return ErrInvalidVerificationToken
+		}
+
+		return nil
+	})
+}
+
+// verificationMessage returns the mail carrying an email verification token
+func (s *Service) verificationMessage(user *storage.User, email string, token string, expiredAt time.Time) mail.Message {
+	return mail.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body: fmt.Sprintf("Confirm that %s is the email of the account %s with this token before %s:\n\n%s\n\n"+
+			"If you do not know this account, ignore this message.", email, user.Username,
+			expiredAt.UTC().Format(time.RFC1123), tokenLink(s.verificationConfig.URL, token)),
+	}
+}
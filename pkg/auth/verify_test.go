@@ -0,0 +1,117 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+func TestService_VerifyEmail(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_verify_an_email", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "alice", Email: "alice@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Nil(t, s.RequestEmailVerification(ctx, id))
+		require.Len(t, box.messages, 1)
+		require.Contains(t, box.messages[0].Body, "https://example.com/verify?token=")
+		token := box.lastToken(t, "alice@test.com")
+
+		require.Equal(t, ErrInvalidVerificationToken, s.VerifyEmail(ctx, "unknown"))
+		require.Nil(t, s.VerifyEmail(ctx, token))
+		require.Equal(t, ErrInvalidVerificationToken, s.VerifyEmail(ctx, token))
+
+		user, err := s.repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.False(t, user.EmailVerifiedAt.IsZero())
+
+		require.Equal(t, ErrEmailVerified, s.RequestEmailVerification(ctx, id))
+	})
+
+	t.Run("success_change_an_email_once_verified", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "bob", Email: "bob@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Nil(t, s.ChangeEmail(ctx, id, "robert@test.com"))
+		token := box.lastToken(t, "robert@test.com")
+
+		// the current email stays in use until the new one is verified
+		user, err := s.repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, "bob@test.com", user.Email)
+		require.Equal(t, "robert@test.com", user.PendingEmail)
+		_, err = s.VerifyCredentials(ctx, Credentials{Login: "bob@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Nil(t, s.VerifyEmail(ctx, token))
+
+		user, err = s.repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, "robert@test.com", user.Email)
+		require.Empty(t, user.PendingEmail)
+		require.False(t, user.EmailVerifiedAt.IsZero())
+	})
+
+	t.Run("success_cancel_a_pending_email", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "carol", Email: "carol@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Nil(t, s.ChangeEmail(ctx, id, "caroline@test.com"))
+		token := box.lastToken(t, "caroline@test.com")
+		require.Nil(t, s.ChangeEmail(ctx, id, "carol@test.com"))
+
+		require.Equal(t, ErrInvalidVerificationToken, s.VerifyEmail(ctx, token))
+
+		user, err := s.repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, "carol@test.com", user.Email)
+		require.Empty(t, user.PendingEmail)
+	})
+
+	t.Run("fail_change_to_a_taken_or_expired_email", func(t *testing.T) {
+		s, box := newMailService()
+
+		id, err := s.CreateUser(ctx, CreateUser{Username: "dave", Email: "dave@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		require.Nil(t, s.ChangeEmail(ctx, id, "erin@test.com"))
+		_, err = s.CreateUser(ctx, CreateUser{Username: "erin", Email: "erin@test.com", Password: "secret"})
+		require.Nil(t, err)
+
+		err = s.ChangeEmail(ctx, id, "erin@test.com")
+		require.True(t, errors.Is(err, storage.ErrDuplicate), "%v", err)
+
+		err = s.VerifyEmail(ctx, box.lastToken(t, "erin@test.com"))
+		require.True(t, errors.Is(err, storage.ErrDuplicate), "%v", err)
+
+		require.Nil(t, s.RequestEmailVerification(ctx, id))
+		after(s, DefaultVerificationTTL)
+		require.Equal(t, ErrInvalidVerificationToken, s.VerifyEmail(ctx, box.lastToken(t, "erin@test.com")))
+	})
+}
+
+func TestVerificationConfigFromEnv(t *testing.T) {
+	t.Run("success_read_the_config", func(t *testing.T) {
+		defer os.Unsetenv(VerificationTTLEnv)
+		defer os.Unsetenv(VerificationURLEnv)
+		os.Setenv(VerificationTTLEnv, "48h")
+		os.Setenv(VerificationURLEnv, "https://example.com/verify")
+
+		config, err := VerificationConfigFromEnv()
+		require.Nil(t, err)
+		require.Equal(t, VerificationConfig{TTL: 48 * time.Hour, URL: "https://example.com/verify"}, config)
+	})
+}
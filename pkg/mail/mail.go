@@ -0,0 +1,98 @@
+// Package mail sends the messages of the service to its users. Mailer is the extension point for a real
+// delivery, the mailers of this package write messages locally and stand in for one in development.
+package mail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// FileEnv names the file messages are appended to by FromEnv
+const FileEnv = "AUTH_MAIL_FILE"
+
+// Message is a plain text mail
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages, Send returns once the message is handed over
+type Mailer interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// FromEnv returns a FileMailer appending to $AUTH_MAIL_FILE, or a WriterMailer writing to stdout when it is unset
+func FromEnv() Mailer {
+	if path := os.Getenv(FileEnv); len(path) > 0 {
+		return &FileMailer{Path: path}
+	}
+
+	return NewWriterMailer(os.Stdout)
+}
+
+// WriterMailer writes messages to a writer instead of delivering them
+type WriterMailer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterMailer creates new instance of WriterMailer
+func NewWriterMailer(w io.Writer) *WriterMailer {
+	return &WriterMailer{w: w}
+}
+
+func (m *WriterMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return write(m.w, msg)
+}
+
+// FileMailer appends messages to the file at Path, creating it when needed
+type FileMailer struct {
+	Path string
+
+	mu sync.Mutex
+}
+
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// messages carry secrets, the file is readable by its owner only
+	f, err := os.OpenFile(m.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+
+	if err := write(f, msg); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// write writes msg in the layout of a mail, a blank line separates messages
+func write(w io.Writer, msg Message) error {
+	_, err := fmt.Fprintf(w, "Date: %s\nTo: %s\nSubject: %s\n\n%s\n\n", time.Now().Format(time.RFC1123Z), msg.To,
+		msg.Subject, msg.Body)
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package mail
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMailers(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+	msg := Message{To: "alice@test.com", Subject: "Hello", Body: "Hello Alice"}
+
+	t.Run("success_write_messages", func(t *testing.T) {
+		var buf bytes.Buffer
+		m := NewWriterMailer(&buf)
+
+		require.Nil(t, m.Send(ctx, msg))
+		require.Contains(t, buf.String(), "To: alice@test.com\nSubject: Hello\n\nHello Alice\n")
+	})
+
+	t.Run("success_append_messages_to_a_file", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "mail")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		m := &FileMailer{Path: filepath.Join(dir, "mails.txt")}
+		require.Nil(t, m.Send(ctx, msg))
+		require.Nil(t, m.Send(ctx, Message{To: "bob@test.com", Subject: "Hi", Body: "Hi Bob"}))
+
+		content, err := ioutil.ReadFile(m.Path)
+		require.Nil(t, err)
+		require.Equal(t, 2, strings.Count(string(content), "To: "))
+		require.Contains(t, string(content), "To: bob@test.com\nSubject: Hi\n\nHi Bob\n")
+
+		info, err := os.Stat(m.Path)
+		require.Nil(t, err)
+		require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+	})
+
+	t.Run("fail_send_with_a_cancelled_context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+
+		var buf bytes.Buffer
+		require.Equal(t, context.Canceled, NewWriterMailer(&buf).Send(cancelled, msg))
+		require.Zero(t, buf.Len())
+	})
+}
@@ -0,0 +1,271 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// names of the algorithms, as found in their PHC strings
+const (
+	bcryptName   = "bcrypt"
+	argon2idName = "argon2id"
+	scryptName   = "scrypt"
+)
+
+// Algorithm hashes passwords into self-describing strings, so hashes made with older parameters can still be
+// verified and recognised for rehashing
+type Algorithm interface {
+	// Name is the PHC identifier of the algorithm
+	Name() string
+
+	// Hash hashes a password with a new random salt
+	Hash(password []byte) (string, error)
+
+	// Verify tells in constant time whether password matches encoded, whatever parameters encoded was made with
+	Verify(encoded string, password []byte) (bool, error)
+
+	// NeedsRehash tells whether encoded was made with other parameters than the algorithm's
+	NeedsRehash(encoded string) bool
+}
+
+// Default parameters of the algorithms
+var (
+	DefaultBcrypt   = &Bcrypt{Cost: bcrypt.DefaultCost}
+	DefaultArgon2id = &Argon2id{Memory: 64 * 1024, Iterations: 3, Parallelism: 2, SaltLength: 16, KeyLength: 32}
+	DefaultScrypt   = &Scrypt{LogN: 15, R: 8, P: 1, SaltLength: 16, KeyLength: 32}
+)
+
+// ParseAlgorithm returns the algorithm of a name with its default parameters
+func ParseAlgorithm(name string) (Algorithm, error) {
+	switch name {
+	case bcryptName:
+		return DefaultBcrypt, nil
+	case argon2idName:
+		return DefaultArgon2id, nil
+	case scryptName:
+		return DefaultScrypt, nil
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, name)
+}
+
+func randomSalt(n uint32) ([]byte, error) {
+	salt := make([]byte, n)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	return salt, nil
+}
+
+// Bcrypt keeps bcrypt's own modular crypt format, which is what the PHC format grew from
+type Bcrypt struct {
+	Cost int
+}
+
+func (a *Bcrypt) Name() string {
+	return bcryptName
+}
+
+func (a *Bcrypt) Hash(password []byte) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(password, a.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hash), nil
+}
+
+func (a *Bcrypt) Verify(encoded string, password []byte) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encoded), password)
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	}
+
+	return false, ErrMalformedHash
+}
+
+func (a *Bcrypt) NeedsRehash(encoded string) bool {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	return err != nil || cost != a.Cost
+}
+
+// Argon2id hashes with argon2id version 19, Memory is in KiB
+type Argon2id struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+	SaltLength  uint32
+	KeyLength   uint32
+}
+
+func (a *Argon2id) Name() string {
+	return argon2idName
+}
+
+func (a *Argon2id) Hash(password []byte) (string, error) {
+	salt, err := randomSalt(a.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	p := &phc{
+		id:      argon2idName,
+		version: argon2.Version,
+		params: []param{
+			{"m", strconv.FormatUint(uint64(a.Memory), 10)},
+			{"t", strconv.FormatUint(uint64(a.Iterations), 10)},
+			{"p", strconv.FormatUint(uint64(a.Parallelism), 10)},
+		},
+		salt: salt,
+		hash: argon2.IDKey(password, salt, a.Iterations, a.Memory, a.Parallelism, a.KeyLength),
+	}
+
+	return p.String(), nil
+}
+
+// decode reads the parameters of an argon2id PHC string
+func (a *Argon2id) decode(encoded string) (p *phc, m uint32, t uint32, par uint8, err error) {
+	if p, err = parsePHC(encoded); err != nil {
+		return
+	}
+	if p.id != argon2idName || p.version != argon2.Version {
+		err = ErrMalformedHash
+		return
+	}
+
+	var v uint64
+	if v, err = p.uint("m", 1<<32-1); err != nil {
+		return
+	}
+	m = uint32(v)
+	if v, err = p.uint("t", 1<<32-1); err != nil {
+		return
+	}
+	t = uint32(v)
+	if v, err = p.uint("p", 1<<8-1); err != nil {
+		return
+	}
+	par = uint8(v)
+
+	return
+}
+
+func (a *Argon2id) Verify(encoded string, password []byte) (bool, error) {
+	p, m, t, par, err := a.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey(password, p.salt, t, m, par, uint32(len(p.hash)))
+
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (a *Argon2id) NeedsRehash(encoded string) bool {
+	p, m, t, par, err := a.decode(encoded)
+	if err != nil {
+		return true
+	}
+
+	return m != a.Memory || t != a.Iterations || par != a.Parallelism ||
+		uint32(len(p.salt)) != a.SaltLength || uint32(len(p.hash)) != a.KeyLength
+}
+
+// Scrypt hashes with scrypt, the cost N is 2^LogN
+type Scrypt struct {
+	LogN       uint8
+	R          int
+	P          int
+	SaltLength uint32
+	KeyLength  uint32
+}
+
+func (a *Scrypt) Name() string {
+	return scryptName
+}
+
+func (a *Scrypt) Hash(password []byte) (string, error) {
+	salt, err := randomSalt(a.SaltLength)
+	if err != nil {
+		return "", err
+	}
+
+	key, err := scrypt.Key(password, salt, 1<<a.LogN, a.R, a.P, int(a.KeyLength))
+	if err != nil {
+		return "", err
+	}
+
+	p := &phc{
+		id: scryptName,
+		params: []param{
+			{"ln", strconv.Itoa(int(a.LogN))},
+			{"r", strconv.Itoa(a.R)},
+			{"p", strconv.Itoa(a.P)},
+		},
+		salt: salt,
+		hash: key,
+	}
+
+	return p.String(), nil
+}
+
+// decode reads the parameters of a scrypt PHC string
+func (a *Scrypt) decode(encoded string) (p *phc, ln uint8, r int, par int, err error) {
+	if p, err = parsePHC(encoded); err != nil {
+		return
+	}
+	if p.id != scryptName {
+		err = ErrMalformedHash
+		return
+	}
+
+	var v uint64
+	if v, err = p.uint("ln", 62); err != nil {
+		return
+	}
+	ln = uint8(v)
+	if v, err = p.uint("r", 1<<30); err != nil {
+		return
+	}
+	r = int(v)
+	if v, err = p.uint("p", 1<<30); err != nil {
+		return
+	}
+	par = int(v)
+
+	return
+}
+
+func (a *Scrypt) Verify(encoded string, password []byte) (bool, error) {
+	p, ln, r, par, err := a.decode(encoded)
+	if err != nil {
+		return false, err
+	}
+
+	key, err := scrypt.Key(password, p.salt, 1<<ln, r, par, len(p.hash))
+	if err != nil {
+		return false, ErrMalformedHash
+	}
+
+	return subtle.ConstantTimeCompare(key, p.hash) == 1, nil
+}
+
+func (a *Scrypt) NeedsRehash(encoded string) bool {
+	p, ln, r, par, err := a.decode(encoded)
+	if err != nil {
+		return true
+	}
+
+	return ln != a.LogN || r != a.R || par != a.P ||
+		uint32(len(p.salt)) != a.SaltLength || uint32(len(p.hash)) != a.KeyLength
+}
@@ -0,0 +1,147 @@
+// Package password hashes and verifies user passwords.
+//
+// A user row keeps two strings. Hash is the output of an Algorithm, a PHC string naming the algorithm and its
+// parameters, so hashes made with older settings keep verifying while being recognised for rehashing. Salt is a
+// random per-user salt. Before hashing, the password is mixed with the salt and, when configured, a server-side
+// pepper which never reaches the database:
+//
+//	HMAC-SHA256(key: pepper, message: salt || 0x00 || password)
+//
+// Salt values are prefixed with whether a pepper was used. Rows written before this package, such as the seed
+// data, have an unprefixed salt and a hash of the bare password. They still verify and are reported for rehashing.
+package password
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+var (
+	// ErrUnknownAlgorithm is returned for hashes made by an algorithm this package does not implement
+	ErrUnknownAlgorithm = errors.New("password: unknown algorithm")
+
+	// ErrMalformedHash is returned for hashes which cannot be decoded
+	ErrMalformedHash = errors.New("password: malformed hash")
+)
+
+// environment variables read by NewHasherFromEnv
+const (
+	AlgorithmEnv = "AUTH_PASSWORD_ALGORITHM"
+	PepperEnv    = "AUTH_PASSWORD_PEPPER"
+)
+
+// prefixes of the salt column telling how the password was mixed
+const (
+	saltPrefix         = "s."
+	pepperedSaltPrefix = "p."
+)
+
+// saltLength is the length of new per-user salts in bytes, their encoding fits the 32 characters of users.salt
+const saltLength = 16
+
+// Hasher hashes new passwords with its algorithm and verifies passwords hashed by any algorithm
+type Hasher struct {
+	algorithm  Algorithm
+	algorithms map[string]Algorithm
+	pepper     []byte
+}
+
+// NewHasher creates new instance of Hasher, pepper may be empty
+func NewHasher(algorithm Algorithm, pepper []byte) *Hasher {
+	h := &Hasher{
+		algorithm: algorithm,
+		algorithms: map[string]Algorithm{
+			bcryptName:   DefaultBcrypt,
+			argon2idName: DefaultArgon2id,
+			scryptName:   DefaultScrypt,
+		},
+		pepper: pepper,
+	}
+	h.algorithms[algorithm.Name()] = algorithm
+
+	return h
+}
+
+// NewHasherFromEnv creates a Hasher configured by $AUTH_PASSWORD_ALGORITHM, argon2id by default,
+// and $AUTH_PASSWORD_PEPPER
+func NewHasherFromEnv() (*Hasher, error) {
+	name := os.Getenv(AlgorithmEnv)
+	if len(name) == 0 {
+		name = argon2idName
+	}
+
+	algorithm, err := ParseAlgorithm(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewHasher(algorithm, []byte(os.Getenv(PepperEnv))), nil
+}
+
+// Hash hashes a password with a new salt, both are to be stored in the user's row
+func (h *Hasher) Hash(password string) (hash string, salt string, err error) {
+	raw, err := randomSalt(saltLength)
+	if err != nil {
+		return "", "", err
+	}
+
+	salt = saltPrefix + b64.EncodeToString(raw)
+	if len(h.pepper) > 0 {
+		salt = pepperedSaltPrefix + b64.EncodeToString(raw)
+	}
+
+	hash, err = h.algorithm.Hash(h.mix(salt, password))
+	if err != nil {
+		return "", "", err
+	}
+
+	return hash, salt, nil
+}
+
+// Verify tells whether password matches a stored hash and salt, and whether they should be replaced by a new
+// Hash of the password because the algorithm, its parameters or the pepper changed
+func (h *Hasher) Verify(hash string, salt string, password string) (ok bool, rehash bool, err error) {
+	algorithm, found := h.algorithms[identify(hash)]
+	if !found {
+		return false, false, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, identify(hash))
+	}
+
+	peppered := strings.HasPrefix(salt, pepperedSaltPrefix)
+	if peppered && len(h.pepper) == 0 {
+		return false, false, errors.New("password: hash needs a pepper, none is configured")
+	}
+
+	input := []byte(password)
+	legacy := !peppered && !strings.HasPrefix(salt, saltPrefix)
+	if !legacy {
+		input = h.mix(salt, password)
+	}
+
+	if ok, err = algorithm.Verify(hash, input); err != nil || !ok {
+		return false, false, err
+	}
+
+	rehash = legacy || peppered != (len(h.pepper) > 0) ||
+		algorithm.Name() != h.algorithm.Name() || h.algorithm.NeedsRehash(hash)
+
+	return true, rehash, nil
+}
+
+// mix binds a password to its salt and the pepper, the result is short enough for bcrypt's 72 bytes limit
+func (h *Hasher) mix(salt string, password string) []byte {
+	key := h.pepper
+	if !strings.HasPrefix(salt, pepperedSaltPrefix) {
+		key = nil
+	}
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(salt))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+
+	return []byte(b64.EncodeToString(mac.Sum(nil)))
+}
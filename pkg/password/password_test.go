@@ -0,0 +1,150 @@
+package password
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// cheap parameters keep the tests fast
+var (
+	testBcrypt   = &Bcrypt{Cost: 4}
+	testArgon2id = &Argon2id{Memory: 1024, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+	testScrypt   = &Scrypt{LogN: 4, R: 8, P: 1, SaltLength: 16, KeyLength: 32}
+)
+
+// seedHash is the hash of "password" in the seed data of the storage backends, stored with the salt "123"
+const seedHash = "$2a$10$AdPyZkgVv70bXz9JvZLpH.CCQEzb8MbK8vMHIVQyCKtWestIyK46K"
+
+func TestHasher_HashAndVerify(t *testing.T) {
+	t.Parallel()
+
+	for _, algorithm := range []Algorithm{testBcrypt, testArgon2id, testScrypt} {
+		algorithm := algorithm
+
+		t.Run("success_"+algorithm.Name(), func(t *testing.T) {
+			t.Parallel()
+			h := NewHasher(algorithm, nil)
+
+			hash, salt, err := h.Hash("correct horse")
+			require.Nil(t, err)
+			require.Equal(t, algorithm.Name(), identify(hash))
+			require.True(t, strings.HasPrefix(salt, saltPrefix))
+			require.True(t, len(hash) <= 128)
+			require.True(t, len(salt) <= 32)
+
+			ok, rehash, err := h.Verify(hash, salt, "correct horse")
+			require.Nil(t, err)
+			require.True(t, ok)
+			require.False(t, rehash)
+
+			ok, _, err = h.Verify(hash, salt, "wrong horse")
+			require.Nil(t, err)
+			require.False(t, ok)
+
+			hash2, salt2, err := h.Hash("correct horse")
+			require.Nil(t, err)
+			require.NotEqual(t, hash, hash2)
+			require.NotEqual(t, salt, salt2)
+		})
+	}
+}
+
+func TestHasher_Verify(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_verify_legacy_seed_hash", func(t *testing.T) {
+		h := NewHasher(testArgon2id, nil)
+
+		ok, rehash, err := h.Verify(seedHash, "123", "password")
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.True(t, rehash)
+
+		ok, _, err = h.Verify(seedHash, "123", "Password")
+		require.Nil(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("success_rehash_after_algorithm_upgrade", func(t *testing.T) {
+		hash, salt, err := NewHasher(testBcrypt, nil).Hash("secret")
+		require.Nil(t, err)
+
+		ok, rehash, err := NewHasher(testArgon2id, nil).Verify(hash, salt, "secret")
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.True(t, rehash)
+	})
+
+	t.Run("success_rehash_after_parameters_upgrade", func(t *testing.T) {
+		hash, salt, err := NewHasher(testArgon2id, nil).Hash("secret")
+		require.Nil(t, err)
+
+		stronger := &Argon2id{Memory: 2048, Iterations: 1, Parallelism: 1, SaltLength: 16, KeyLength: 32}
+		ok, rehash, err := NewHasher(stronger, nil).Verify(hash, salt, "secret")
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.True(t, rehash)
+	})
+
+	t.Run("success_verify_with_pepper", func(t *testing.T) {
+		h := NewHasher(testScrypt, []byte("pepper"))
+
+		hash, salt, err := h.Hash("secret")
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(salt, pepperedSaltPrefix))
+
+		ok, rehash, err := h.Verify(hash, salt, "secret")
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.False(t, rehash)
+
+		ok, _, err = NewHasher(testScrypt, []byte("other pepper")).Verify(hash, salt, "secret")
+		require.Nil(t, err)
+		require.False(t, ok)
+
+		_, _, err = NewHasher(testScrypt, nil).Verify(hash, salt, "secret")
+		require.NotNil(t, err)
+	})
+
+	t.Run("success_rehash_when_pepper_is_added", func(t *testing.T) {
+		hash, salt, err := NewHasher(testScrypt, nil).Hash("secret")
+		require.Nil(t, err)
+
+		ok, rehash, err := NewHasher(testScrypt, []byte("pepper")).Verify(hash, salt, "secret")
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.True(t, rehash)
+	})
+
+	t.Run("fail_verify_unknown_algorithm", func(t *testing.T) {
+		_, _, err := NewHasher(testBcrypt, nil).Verify("$md5$abc$def", "s.abc", "secret")
+		require.True(t, errors.Is(err, ErrUnknownAlgorithm))
+
+		_, _, err = NewHasher(testBcrypt, nil).Verify("plain text", "s.abc", "secret")
+		require.True(t, errors.Is(err, ErrUnknownAlgorithm))
+	})
+
+	t.Run("fail_verify_malformed_hash", func(t *testing.T) {
+		_, _, err := NewHasher(testBcrypt, nil).Verify("$argon2id$v=19$m=1024$abc$def", "s.abc", "secret")
+		require.True(t, errors.Is(err, ErrMalformedHash))
+
+		_, _, err = NewHasher(testBcrypt, nil).Verify("$2a$10$short", "s.abc", "secret")
+		require.True(t, errors.Is(err, ErrMalformedHash))
+	})
+}
+
+func TestParseAlgorithm(t *testing.T) {
+	t.Parallel()
+
+	for _, name := range []string{"bcrypt", "argon2id", "scrypt"} {
+		algorithm, err := ParseAlgorithm(name)
+		require.Nil(t, err)
+		require.Equal(t, name, algorithm.Name())
+	}
+
+	_, err := ParseAlgorithm("md5")
+	require.True(t, errors.Is(err, ErrUnknownAlgorithm))
+}
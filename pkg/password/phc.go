@@ -0,0 +1,121 @@
+package password
+
+import (
+	"encoding/base64"
+	"strconv"
+	"strings"
+)
+
+// phc is a hash in the PHC string format: $id[$v=version][$param=value(,param=value)*][$salt[$hash]]
+type phc struct {
+	id      string
+	version int
+	params  []param
+	salt    []byte
+	hash    []byte
+}
+
+type param struct {
+	name  string
+	value string
+}
+
+var b64 = base64.RawStdEncoding
+
+func (p *phc) String() string {
+	var sb strings.Builder
+
+	sb.WriteString("$" + p.id)
+	if p.version > 0 {
+		sb.WriteString("$v=" + strconv.Itoa(p.version))
+	}
+
+	for i, pr := range p.params {
+		if i == 0 {
+			sb.WriteString("$")
+		} else {
+			sb.WriteString(",")
+		}
+		sb.WriteString(pr.name + "=" + pr.value)
+	}
+
+	sb.WriteString("$" + b64.EncodeToString(p.salt))
+	sb.WriteString("$" + b64.EncodeToString(p.hash))
+
+	return sb.String()
+}
+
+// parsePHC decodes a PHC string holding both a salt and a hash
+func parsePHC(s string) (*phc, error) {
+	fields := strings.Split(s, "$")
+	if len(fields) < 4 || len(fields[0]) != 0 || len(fields[1]) == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	p := &phc{id: fields[1]}
+	fields = fields[2:]
+
+	if strings.HasPrefix(fields[0], "v=") {
+		v, err := strconv.Atoi(fields[0][2:])
+		if err != nil || v <= 0 {
+			return nil, ErrMalformedHash
+		}
+		p.version = v
+		fields = fields[1:]
+	}
+
+	if len(fields) == 3 {
+		for _, kv := range strings.Split(fields[0], ",") {
+			i := strings.IndexByte(kv, '=')
+			if i <= 0 {
+				return nil, ErrMalformedHash
+			}
+			p.params = append(p.params, param{kv[:i], kv[i+1:]})
+		}
+		fields = fields[1:]
+	}
+
+	if len(fields) != 2 {
+		return nil, ErrMalformedHash
+	}
+
+	var err error
+	if p.salt, err = b64.DecodeString(fields[0]); err != nil {
+		return nil, ErrMalformedHash
+	}
+	if p.hash, err = b64.DecodeString(fields[1]); err != nil || len(p.hash) == 0 {
+		return nil, ErrMalformedHash
+	}
+
+	return p, nil
+}
+
+// uint reads a numeric parameter no larger than max
+func (p *phc) uint(name string, max uint64) (uint64, error) {
+	for _, pr := range p.params {
+		if pr.name == name {
+			v, err := strconv.ParseUint(pr.value, 10, 64)
+			if err != nil || v == 0 || v > max {
+				return 0, ErrMalformedHash
+			}
+			return v, nil
+		}
+	}
+
+	return 0, ErrMalformedHash
+}
+
+// identify returns the PHC identifier of an encoded hash, bcrypt's variants are all named bcrypt
+func identify(encoded string) string {
+	fields := strings.SplitN(encoded, "$", 3)
+	if len(fields) < 3 || len(fields[0]) != 0 {
+		return ""
+	}
+
+	switch fields[1] {
+	case "2", "2a", "2b", "2y":
+		return bcryptName
+	}
+
+	return fields[1]
+}
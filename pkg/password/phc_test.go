@@ -0,0 +1,70 @@
+package password
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPHC(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_encode_and_parse", func(t *testing.T) {
+		p := &phc{
+			id:      "argon2id",
+			version: 19,
+			params:  []param{{"m", "65536"}, {"t", "3"}, {"p", "2"}},
+			salt:    []byte("0123456789abcdef"),
+			hash:    []byte("fedcba9876543210"),
+		}
+
+		encoded := p.String()
+		require.Equal(t, "$argon2id$v=19$m=65536,t=3,p=2$MDEyMzQ1Njc4OWFiY2RlZg$ZmVkY2JhOTg3NjU0MzIxMA", encoded)
+
+		parsed, err := parsePHC(encoded)
+		require.Nil(t, err)
+		require.Equal(t, p, parsed)
+
+		m, err := parsed.uint("m", 1<<32-1)
+		require.Nil(t, err)
+		require.Equal(t, uint64(65536), m)
+
+		_, err = parsed.uint("x", 10)
+		require.Equal(t, ErrMalformedHash, err)
+
+		_, err = parsed.uint("m", 10)
+		require.Equal(t, ErrMalformedHash, err)
+	})
+
+	t.Run("success_parse_without_version", func(t *testing.T) {
+		parsed, err := parsePHC("$scrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA")
+		require.Nil(t, err)
+		require.Equal(t, 0, parsed.version)
+		require.Equal(t, []byte("salt"), parsed.salt)
+		require.Equal(t, []byte("hash"), parsed.hash)
+	})
+
+	t.Run("fail_parse_malformed", func(t *testing.T) {
+		for _, s := range []string{
+			"",
+			"argon2id$v=19$m=1$c2FsdA$aGFzaA",
+			"$argon2id$c2FsdA",
+			"$argon2id$v=x$m=1$c2FsdA$aGFzaA",
+			"$argon2id$v=19$m$c2FsdA$aGFzaA",
+			"$scrypt$ln=15$c2Fsd!$aGFzaA",
+			"$scrypt$ln=15$c2FsdA$",
+			"$scrypt$ln=15$a$b$c$d",
+		} {
+			_, err := parsePHC(s)
+			require.Equal(t, ErrMalformedHash, err, s)
+		}
+	})
+
+	t.Run("success_identify", func(t *testing.T) {
+		require.Equal(t, "bcrypt", identify(seedHash))
+		require.Equal(t, "bcrypt", identify("$2b$04$abc"))
+		require.Equal(t, "argon2id", identify("$argon2id$v=19$m=1$a$b"))
+		require.Equal(t, "scrypt", identify("$scrypt$ln=1$a$b"))
+		require.Equal(t, "", identify("plain"))
+	})
+}
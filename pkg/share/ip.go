@@ -0,0 +1,96 @@
+package share
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+//Proxies lists the networks of the proxies trusted to set X-Forwarded-For and X-Real-IP, the headers of any
+//other peer are ignored since a client can send whatever it likes
+type Proxies []*net.IPNet
+
+//ParseProxies parses a comma separated list of addresses and CIDR networks
+func ParseProxies(list string) (Proxies, error) {
+	var proxies Proxies
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("share: invalid proxy address %q", entry)
+			}
+
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("share: invalid proxy network %q: %w", entry, err)
+		}
+		proxies = append(proxies, network)
+	}
+
+	return proxies, nil
+}
+
+//Trusts tells whether ip is the address of a trusted proxy
+func (p Proxies) Trusts(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+
+	for _, network := range p {
+		if network.Contains(parsed) {
+			return true
+		}
+	}
+
+	return false
+}
+
+//ClientIP returns the address of a client. It is the host of the remote address unless that is a trusted proxy,
+//then it is the rightmost X-Forwarded-For entry which is not a trusted proxy, else X-Real-IP. Entries left of it
+//were sent by the client and are not looked at.
+func (p Proxies) ClientIP(remoteAddr string, xForwardedFor string, xRealIP string) string {
+	ip := remoteAddr
+	if host, _, err := net.SplitHostPort(remoteAddr); err == nil {
+		ip = host
+	}
+	if !p.Trusts(ip) {
+		return ip
+	}
+
+	if len(strings.TrimSpace(xForwardedFor)) > 0 {
+		hops := strings.Split(xForwardedFor, ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			hop := strings.TrimSpace(hops[i])
+			if net.ParseIP(hop) == nil {
+				break
+			}
+
+			ip = hop
+			if !p.Trusts(hop) {
+				break
+			}
+		}
+
+		return ip
+	}
+
+	if realIP := strings.TrimSpace(xRealIP); net.ParseIP(realIP) != nil {
+		return realIP
+	}
+
+	return ip
+}
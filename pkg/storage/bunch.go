@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"time"
 
 	"github.com/vespaiach/auth_service/pkg/share"
@@ -56,13 +57,16 @@ type BunchKey struct {
 	UpdatedAt time.Time
 }
 
-//QueryBunchKey model
+//QueryBunchKey model, Inherited adds the keys bunches inherit from their parents, through active ones only.
+//ID matches the bunch_keys row.
 type QueryBunchKey struct {
 	Limit       int64
 	Offset      int64
+	ID          int64
 	BunchName   string
 	KeyName     string
 	BunchActive share.Boolean
+	Inherited   bool
 }
 
 //SortBunchKey model
@@ -72,25 +76,74 @@ type SortBunchKey struct {
 	BunchActive share.Direction
 }
 
-//AggregateBunchKey model
+//AggregateBunchKey model. Source is the ancestor which Bunch inherits the key from, BunchKey being the row
+//of Source, it is nil for the bunch's own keys.
 type AggregateBunchKey struct {
 	*BunchKey
 	*Key
 	*Bunch
+	Source *Bunch
+}
+
+//BunchParent model, the bunch inherits the keys of the parent
+type BunchParent struct {
+	ID        int64
+	BunchID   int64
+	ParentID  int64
+	UpdatedAt time.Time
+}
+
+//CreateBunchParent model
+type CreateBunchParent struct {
+	BunchID  int64
+	ParentID int64
+}
+
+//QueryBunchParent model
+type QueryBunchParent struct {
+	Limit      int64
+	Offset     int64
+	ID         int64
+	BunchName  string
+	ParentName string
+}
+
+//SortBunchParent model
+type SortBunchParent struct {
+	BunchName  share.Direction
+	ParentName share.Direction
+}
+
+//AggregateBunchParent model
+type AggregateBunchParent struct {
+	*BunchParent
+	Bunch  *Bunch
+	Parent *Bunch
 }
 
 //BunchStorer defines fundamental functions to interact with storage repository
 type BunchStorer interface {
-	Insert(b CreateBunch) (*Bunch, error)
-	Update(b UpdateBunch) (*Bunch, error)
-	Get(id int64) (*Bunch, error)
-	GetByName(name string) (*Bunch, error)
-	Query(queries QueryBunch, sorts SortBunch) ([]*Bunch, int64, error)
+	Insert(ctx context.Context, b CreateBunch) (int64, error)
+	Update(ctx context.Context, b UpdateBunch) error
+	Get(ctx context.Context, id int64) (*Bunch, error)
+	GetByName(ctx context.Context, name string) (*Bunch, error)
+	Query(ctx context.Context, queries QueryBunch, sorts SortBunch) ([]*Bunch, int64, error)
 }
 
 //BunchKeyStorer defines fundamental functions to interact with storage repository
 type BunchKeyStorer interface {
-	Insert(bk BunchKey) (*BunchKey, error)
-	Delete(id int64) error
-	Query(queries QueryBunchKey, sorts SortBunchKey) ([]*AggregateBunchKey, error)
+	Insert(ctx context.Context, bk BunchKey) (int64, error)
+	Delete(ctx context.Context, id int64) error
+	Get(ctx context.Context, id int64) (*AggregateBunchKey, error)
+	Query(ctx context.Context, queries QueryBunchKey, sorts SortBunchKey) ([]*AggregateBunchKey, int64, error)
+}
+
+//BunchParentStorer defines fundamental functions to interact with storage repository. A bunch inherits the keys
+//of its parents and of their own parents, Insert returns ErrBunchCycle when the parent inherits from the bunch
+//already or is the bunch itself.
+type BunchParentStorer interface {
+	Insert(ctx context.Context, bp CreateBunchParent) (int64, error)
+	Delete(ctx context.Context, id int64) error
+	Get(ctx context.Context, id int64) (*AggregateBunchParent, error)
+	Query(ctx context.Context, queries QueryBunchParent, sorts SortBunchParent) ([]*AggregateBunchParent, int64, error)
 }
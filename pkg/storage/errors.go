@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+)
+
+//ErrNotFound is returned when the requested record does not exist
+var ErrNotFound = errors.New("storage: record not found")
+
+//ErrDuplicate is matched by errors.Is when a unique constraint is violated
+var ErrDuplicate = errors.New("storage: duplicated record")
+
+//ErrForeignKey is matched by errors.Is when a referenced record does not exist or is still referenced
+var ErrForeignKey = errors.New("storage: foreign key violation")
+
+//ErrBunchCycle is returned when a bunch would inherit from itself, directly or through its parents
+var ErrBunchCycle = errors.New("storage: bunch inherits from itself")
+
+//DuplicateError tells which field of which entity violated a unique constraint, both are empty when the backend
+//does not tell
+type DuplicateError struct {
+	Entity string
+	Field  string
+	Err    error
+}
+
+func (e *DuplicateError) Error() string {
+	if len(e.Entity) == 0 {
+		return ErrDuplicate.Error()
+	}
+
+	return fmt.Sprintf("storage: %s with the same %s already exists", e.Entity, e.Field)
+}
+
+//Unwrap returns the backend error
+func (e *DuplicateError) Unwrap() error {
+	return e.Err
+}
+
+//Is reports whether target is ErrDuplicate
+func (e *DuplicateError) Is(target error) bool {
+	return target == ErrDuplicate
+}
+
+//ForeignKeyError tells which field of which entity violated a foreign key constraint, both are empty when the
+//backend does not tell
+type ForeignKeyError struct {
+	Entity string
+	Field  string
+	Err    error
+}
+
+func (e *ForeignKeyError) Error() string {
+	if len(e.Entity) == 0 {
+		return ErrForeignKey.Error()
+	}
+
+	return fmt.Sprintf("storage: %s of %s violates a foreign key constraint", e.Field, e.Entity)
+}
+
+//Unwrap returns the backend error
+func (e *ForeignKeyError) Unwrap() error {
+	return e.Err
+}
+
+//Is reports whether target is ErrForeignKey
+func (e *ForeignKeyError) Is(target error) bool {
+	return target == ErrForeignKey
+}
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"time"
 
 	"github.com/vespaiach/auth_service/pkg/share"
@@ -46,10 +47,10 @@ type SortKey struct {
 
 //KeyStorer defines fundamental functions to interact with storage repository
 type KeyStorer interface {
-	Insert(k CreateKey) (int64, error)
-	Update(k UpdateKey) error
-	Delete(id int64) error
-	Get(id int64) (*Key, error)
-	GetByName(name string) (*Key, error)
-	Query(queries QueryKey, sorts SortKey) ([]*Key, error)
+	Insert(ctx context.Context, k CreateKey) (int64, error)
+	Update(ctx context.Context, k UpdateKey) error
+	Delete(ctx context.Context, id int64) error
+	Get(ctx context.Context, id int64) (*Key, error)
+	GetByName(ctx context.Context, name string) (*Key, error)
+	Query(ctx context.Context, queries QueryKey, sorts SortKey) ([]*Key, int64, error)
 }
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+//outcomes of a login attempt. LoginLockedOut is an attempt refused without checking its password and
+//LoginUnlocked records an administrator lifting the lockout of a user.
+const (
+	LoginSucceeded = "succeeded"
+	LoginFailed    = "failed"
+	LoginLockedOut = "locked_out"
+	LoginUnlocked  = "unlocked"
+)
+
+//LoginAttempt model. Username is the login which was tried, a username or an email, and UserID the user
+//it matched, zero when it matched none. IP is the address of the client.
+type LoginAttempt struct {
+	ID        int64
+	UserID    int64
+	Username  string
+	IP        string
+	Outcome   string
+	CreatedAt time.Time
+}
+
+//CreateLoginAttempt model
+type CreateLoginAttempt struct {
+	UserID   int64
+	Username string
+	IP       string
+	Outcome  string
+}
+
+//QueryLoginAttempt model, Username and IP are matched exactly
+type QueryLoginAttempt struct {
+	Limit    int64
+	Offset   int64
+	UserID   int64
+	Username string
+	IP       string
+	Outcome  string
+	From     time.Time
+	To       time.Time
+}
+
+//SortLoginAttempt model, attempts made at the same time keep the order they were made in
+type SortLoginAttempt struct {
+	CreatedAt share.Direction
+}
+
+//LoginAttemptStorer defines fundamental functions to interact with storage repository. Attempts are never
+//updated, Purge deletes the ones made at or before the given time.
+type LoginAttemptStorer interface {
+	Insert(ctx context.Context, a CreateLoginAttempt) (int64, error)
+	Query(ctx context.Context, queries QueryLoginAttempt, sorts SortLoginAttempt) ([]*LoginAttempt, int64, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,538 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	_ storage.BunchStorer       = (*BunchMemoryStorer)(nil)
+	_ storage.BunchKeyStorer    = (*BunchKeyMemoryStorer)(nil)
+	_ storage.BunchParentStorer = (*BunchParentMemoryStorer)(nil)
+)
+
+// BunchMemoryStorer implements bunch's storage in memory
+type BunchMemoryStorer struct {
+	db *DB
+}
+
+// BunchKeyMemoryStorer implements bunch-key's storage in memory
+type BunchKeyMemoryStorer struct {
+	db *DB
+}
+
+// BunchParentMemoryStorer implements bunch-parent's storage in memory
+type BunchParentMemoryStorer struct {
+	db *DB
+}
+
+// NewBunchMemoryStorer create new instance of BunchMemoryStorer
+func NewBunchMemoryStorer(db *DB) *BunchMemoryStorer {
+	return &BunchMemoryStorer{
+		db,
+	}
+}
+
+// NewBunchKeyMemoryStorer create new instance of BunchKeyMemoryStorer
+func NewBunchKeyMemoryStorer(db *DB) *BunchKeyMemoryStorer {
+	return &BunchKeyMemoryStorer{
+		db,
+	}
+}
+
+// NewBunchParentMemoryStorer create new instance of BunchParentMemoryStorer
+func NewBunchParentMemoryStorer(db *DB) *BunchParentMemoryStorer {
+	return &BunchParentMemoryStorer{
+		db,
+	}
+}
+
+func (st *BunchMemoryStorer) Insert(ctx context.Context, b storage.CreateBunch) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := st.db.write(func(t *tables) error {
+		if t.bunchByName(b.Name) != nil {
+			return &storage.DuplicateError{Entity: "bunch", Field: "name"}
+		}
+
+		id = t.nextID("bunches")
+		t.bunches[id] = &storage.Bunch{
+			ID:        id,
+			Name:      b.Name,
+			Desc:      b.Desc,
+			Active:    share.Boolean{IsSet: true, Bool: true},
+			UpdatedAt: time.Now(),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (st *BunchMemoryStorer) Update(ctx context.Context, b storage.UpdateBunch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(b.Name) == 0 && len(b.Desc) == 0 && !b.Active.IsSet {
+		return nil
+	}
+
+	return st.db.write(func(t *tables) error {
+		bunch, ok := t.bunches[b.ID]
+		if !ok {
+			return nil
+		}
+
+		if len(b.Name) > 0 {
+			if found := t.bunchByName(b.Name); found != nil && found.ID != b.ID {
+				return &storage.DuplicateError{Entity: "bunch", Field: "name"}
+			}
+			bunch.Name = b.Name
+		}
+
+		if len(b.Desc) > 0 {
+			bunch.Desc = b.Desc
+		}
+
+		if b.Active.IsSet {
+			bunch.Active.Bool = b.Active.Bool
+		}
+
+		bunch.UpdatedAt = time.Now()
+
+		return nil
+	})
+}
+
+func (st *BunchMemoryStorer) Get(ctx context.Context, id int64) (*storage.Bunch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var bunch *storage.Bunch
+	err := st.db.read(func(t *tables) error {
+		found, ok := t.bunches[id]
+		if !ok {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		bunch = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bunch, nil
+}
+
+func (st *BunchMemoryStorer) GetByName(ctx context.Context, name string) (*storage.Bunch, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var bunch *storage.Bunch
+	err := st.db.read(func(t *tables) error {
+		found := t.bunchByName(name)
+		if found == nil {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		bunch = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return bunch, nil
+}
+
+func (st *BunchMemoryStorer) Query(ctx context.Context, queries storage.QueryBunch, sorts storage.SortBunch) ([]*storage.Bunch, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.Bunch
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.Bunch, 0, len(t.bunches))
+		for _, b := range t.sortedBunches() {
+			if len(queries.Name) > 0 && !contains(b.Name, queries.Name) {
+				continue
+			}
+			if len(queries.Desc) > 0 && !contains(b.Desc, queries.Desc) {
+				continue
+			}
+			if queries.Active.IsSet && b.Active.Bool != queries.Active.Bool {
+				continue
+			}
+			if !between(b.UpdatedAt, queries.From, queries.To) {
+				continue
+			}
+
+			row := *b
+			rows = append(rows, &row)
+		}
+
+		return nil
+	})
+
+	order := ordering{}.
+		by(sorts.Name, func(i, j int) int { return compareStrings(rows[i].Name, rows[j].Name) }).
+		by(sorts.Desc, func(i, j int) int { return compareStrings(rows[i].Desc, rows[j].Desc) }).
+		by(sorts.UpdatedAt, func(i, j int) int { return compareTimes(rows[i].UpdatedAt, rows[j].UpdatedAt) }).
+		by(sorts.Active, func(i, j int) int { return compareBools(rows[i].Active.Bool, rows[j].Active.Bool) })
+	if len(order) == 0 {
+		order = order.by(share.Descendant, func(i, j int) int { return compareInts(rows[i].ID, rows[j].ID) })
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+func (st *BunchKeyMemoryStorer) Insert(ctx context.Context, bk storage.BunchKey) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := st.db.write(func(t *tables) error {
+		if _, ok := t.bunches[bk.BunchID]; !ok {
+			return &storage.ForeignKeyError{Entity: "bunch_key", Field: "bunch_id"}
+		}
+		if _, ok := t.keys[bk.KeyID]; !ok {
+			return &storage.ForeignKeyError{Entity: "bunch_key", Field: "key_id"}
+		}
+
+		for _, found := range t.bunchKeys {
+			if found.BunchID == bk.BunchID && found.KeyID == bk.KeyID {
+				return &storage.DuplicateError{Entity: "bunch_key", Field: "key_id"}
+			}
+		}
+
+		id = t.nextID("bunch_keys")
+		t.bunchKeys[id] = &storage.BunchKey{ID: id, BunchID: bk.BunchID, KeyID: bk.KeyID, UpdatedAt: time.Now()}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (st *BunchKeyMemoryStorer) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if _, ok := t.bunchKeys[id]; !ok {
+			return storage.ErrNotFound
+		}
+
+		delete(t.bunchKeys, id)
+
+		return nil
+	})
+}
+
+func (st *BunchKeyMemoryStorer) Get(ctx context.Context, id int64) (*storage.AggregateBunchKey, error) {
+	rows, _, err := st.Query(ctx, storage.QueryBunchKey{ID: id, Limit: 1}, storage.SortBunchKey{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *BunchKeyMemoryStorer) Query(ctx context.Context, queries storage.QueryBunchKey, sorts storage.SortBunchKey) ([]*storage.AggregateBunchKey, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.AggregateBunchKey
+	st.db.read(func(t *tables) error {
+		all := t.aggregateBunchKeys()
+		if queries.Inherited {
+			all = t.inheritedBunchKeys()
+		}
+
+		rows = make([]*storage.AggregateBunchKey, 0, len(all))
+		for _, agg := range all {
+			if queries.ID > 0 && agg.BunchKey.ID != queries.ID {
+				continue
+			}
+			if len(queries.BunchName) > 0 && !strings.EqualFold(agg.Bunch.Name, queries.BunchName) {
+				continue
+			}
+			if len(queries.KeyName) > 0 && !strings.EqualFold(agg.Key.Name, queries.KeyName) {
+				continue
+			}
+			if queries.BunchActive.IsSet && agg.Bunch.Active.Bool != queries.BunchActive.Bool {
+				continue
+			}
+
+			rows = append(rows, agg)
+		}
+
+		return nil
+	})
+
+	order := ordering{}.
+		by(sorts.BunchName, func(i, j int) int { return compareStrings(rows[i].Bunch.Name, rows[j].Bunch.Name) }).
+		by(sorts.KeyName, func(i, j int) int { return compareStrings(rows[i].Key.Name, rows[j].Key.Name) }).
+		by(sorts.BunchActive, func(i, j int) int {
+			return compareBools(rows[i].Bunch.Active.Bool, rows[j].Bunch.Active.Bool)
+		})
+	if len(order) == 0 {
+		// an inherited key is listed once per bunch inheriting it
+		order = order.by(share.Descendant, func(i, j int) int {
+			return compareInts(rows[i].BunchKey.ID, rows[j].BunchKey.ID)
+		}).by(share.Descendant, func(i, j int) int { return compareInts(rows[i].Bunch.ID, rows[j].Bunch.ID) })
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// Insert makes a bunch inherit the keys of a parent unless the parent inherits from the bunch already
+func (st *BunchParentMemoryStorer) Insert(ctx context.Context, bp storage.CreateBunchParent) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	if bp.BunchID == bp.ParentID {
+		return 0, storage.ErrBunchCycle
+	}
+
+	var id int64
+	err := st.db.write(func(t *tables) error {
+		if _, ok := t.bunches[bp.BunchID]; !ok {
+			return &storage.ForeignKeyError{Entity: "bunch_parent", Field: "bunch_id"}
+		}
+		if _, ok := t.bunches[bp.ParentID]; !ok {
+			return &storage.ForeignKeyError{Entity: "bunch_parent", Field: "parent_id"}
+		}
+
+		for _, a := range t.ancestors(bp.ParentID) {
+			if a.ID == bp.BunchID {
+				return storage.ErrBunchCycle
+			}
+		}
+
+		for _, found := range t.bunchParents {
+			if found.BunchID == bp.BunchID && found.ParentID == bp.ParentID {
+				return &storage.DuplicateError{Entity: "bunch_parent", Field: "parent_id"}
+			}
+		}
+
+		id = t.nextID("bunch_parents")
+		t.bunchParents[id] = &storage.BunchParent{ID: id, BunchID: bp.BunchID, ParentID: bp.ParentID,
+			UpdatedAt: time.Now()}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (st *BunchParentMemoryStorer) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if _, ok := t.bunchParents[id]; !ok {
+			return storage.ErrNotFound
+		}
+
+		delete(t.bunchParents, id)
+
+		return nil
+	})
+}
+
+func (st *BunchParentMemoryStorer) Get(ctx context.Context, id int64) (*storage.AggregateBunchParent, error) {
+	rows, _, err := st.Query(ctx, storage.QueryBunchParent{ID: id, Limit: 1}, storage.SortBunchParent{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *BunchParentMemoryStorer) Query(ctx context.Context, queries storage.QueryBunchParent, sorts storage.SortBunchParent) ([]*storage.AggregateBunchParent, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.AggregateBunchParent
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.AggregateBunchParent, 0, len(t.bunchParents))
+		for _, bp := range t.bunchParents {
+			bunchParent, bunch, parent := *bp, *t.bunches[bp.BunchID], *t.bunches[bp.ParentID]
+			if queries.ID > 0 && bp.ID != queries.ID {
+				continue
+			}
+			if len(queries.BunchName) > 0 && !strings.EqualFold(bunch.Name, queries.BunchName) {
+				continue
+			}
+			if len(queries.ParentName) > 0 && !strings.EqualFold(parent.Name, queries.ParentName) {
+				continue
+			}
+
+			rows = append(rows, &storage.AggregateBunchParent{BunchParent: &bunchParent, Bunch: &bunch, Parent: &parent})
+		}
+
+		return nil
+	})
+
+	order := ordering{}.
+		by(sorts.BunchName, func(i, j int) int { return compareStrings(rows[i].Bunch.Name, rows[j].Bunch.Name) }).
+		by(sorts.ParentName, func(i, j int) int { return compareStrings(rows[i].Parent.Name, rows[j].Parent.Name) })
+	if len(order) == 0 {
+		order = order.by(share.Descendant, func(i, j int) int {
+			return compareInts(rows[i].BunchParent.ID, rows[j].BunchParent.ID)
+		})
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// bunchByName finds a bunch by name, names are compared case insensitively as mysql's default collation does
+func (t *tables) bunchByName(name string) *storage.Bunch {
+	for _, b := range t.bunches {
+		if strings.EqualFold(b.Name, name) {
+			return b
+		}
+	}
+
+	return nil
+}
+
+// sortedBunches returns the bunches ordered by id
+func (t *tables) sortedBunches() []*storage.Bunch {
+	bunches := make([]*storage.Bunch, 0, len(t.bunches))
+	for _, b := range t.bunches {
+		bunches = append(bunches, b)
+	}
+	sort.Slice(bunches, func(i, j int) bool { return bunches[i].ID < bunches[j].ID })
+
+	return bunches
+}
+
+// aggregateBunchKeys joins copies of every bunch_keys row to its bunch and key ordered by id
+func (t *tables) aggregateBunchKeys() []*storage.AggregateBunchKey {
+	results := make([]*storage.AggregateBunchKey, 0, len(t.bunchKeys))
+	for _, bk := range t.bunchKeys {
+		bunchKey, bunch, key := *bk, *t.bunches[bk.BunchID], *t.keys[bk.KeyID]
+		results = append(results, &storage.AggregateBunchKey{BunchKey: &bunchKey, Key: &key, Bunch: &bunch})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].BunchKey.ID < results[j].BunchKey.ID })
+
+	return results
+}
+
+// ancestor is a bunch a bunch inherits from, Inactive is the first inactive bunch on the way to it, the
+// ancestor included but not the bunch itself, or 0 when there is none
+type ancestor struct {
+	ID       int64
+	Inactive int64
+}
+
+// ancestors returns a bunch followed by the bunches it inherits from, directly or not, once for each first
+// inactive bunch on the way so it ends even on a cycle
+func (t *tables) ancestors(bunchID int64) []ancestor {
+	found := []ancestor{{ID: bunchID}}
+	seen := map[ancestor]bool{found[0]: true}
+
+	for i := 0; i < len(found); i++ {
+		for _, bp := range t.bunchParents {
+			if bp.BunchID != found[i].ID {
+				continue
+			}
+
+			next := ancestor{ID: bp.ParentID, Inactive: found[i].Inactive}
+			if next.Inactive == 0 && !t.bunches[bp.ParentID].Active.Bool {
+				next.Inactive = bp.ParentID
+			}
+			if !seen[next] {
+				seen[next] = true
+				found = append(found, next)
+			}
+		}
+	}
+
+	return found
+}
+
+// inheritedBunchKeys joins copies of the bunch_keys rows of every bunch and of its ancestors to the bunch, the
+// key and, for inherited keys, the ancestor as source. Inactive ancestors pass on no keys.
+func (t *tables) inheritedBunchKeys() []*storage.AggregateBunchKey {
+	results := make([]*storage.AggregateBunchKey, 0, len(t.bunchKeys))
+	for _, b := range t.sortedBunches() {
+		for _, a := range t.ancestors(b.ID) {
+			if a.Inactive == 0 {
+				results = append(results, t.ancestorBunchKeys(b, a.ID)...)
+			}
+		}
+	}
+
+	return results
+}
+
+// ancestorBunchKeys joins copies of the bunch_keys rows of an ancestor to a bunch, the key and, when the
+// ancestor is not the bunch, the ancestor as source
+func (t *tables) ancestorBunchKeys(b *storage.Bunch, ancestorID int64) []*storage.AggregateBunchKey {
+	var results []*storage.AggregateBunchKey
+	for _, bk := range t.bunchKeys {
+		if bk.BunchID != ancestorID {
+			continue
+		}
+
+		bunchKey, bunch, key := *bk, *b, *t.keys[bk.KeyID]
+		row := &storage.AggregateBunchKey{BunchKey: &bunchKey, Key: &key, Bunch: &bunch}
+		if ancestorID != b.ID {
+			source := *t.bunches[ancestorID]
+			row.Source = &source
+		}
+		results = append(results, row)
+	}
+
+	return results
+}
@@ -0,0 +1,28 @@
+package memory
+
+import (
+	"testing"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/storagetest"
+)
+
+// TestConformance runs the storage conformance suite, it does not run in parallel with the other tests
+func TestConformance(t *testing.T) {
+	storagetest.Run(t, storagetest.Factories{
+		Keys:           func() storage.KeyStorer { return test.kst },
+		Bunches:        func() storage.BunchStorer { return test.bst },
+		BunchKeys:      func() storage.BunchKeyStorer { return test.bkst },
+		BunchParents:   func() storage.BunchParentStorer { return test.bpst },
+		Users:          func() storage.UserStorer { return test.ust },
+		UserBunches:    func() storage.UserBunchStorer { return test.ubst },
+		TokenHistories: func() storage.TokenHistoryStorer { return test.thst },
+		SigningKeys:    func() storage.SigningKeyStorer { return test.skst },
+		LoginAttempts:  func() storage.LoginAttemptStorer { return test.last },
+		TOTPs:          func() storage.TOTPStorer { return test.tost },
+		RecoveryCodes:  func() storage.RecoveryCodeStorer { return test.rcst },
+		UserTokens:     func() storage.UserTokenStorer { return test.utst },
+		Permissions:    func() storage.PermissionResolver { return test.prst },
+		Repository:     func() storage.Repository { return test.repo },
+	})
+}
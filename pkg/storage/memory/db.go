@@ -0,0 +1,133 @@
+package memory
+
+import (
+	"sync"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// tables holds the rows of every entity, rows are never shared outside of the package
+type tables struct {
+	keys           map[int64]*storage.Key
+	bunches        map[int64]*storage.Bunch
+	bunchKeys      map[int64]*storage.BunchKey
+	bunchParents   map[int64]*storage.BunchParent
+	users          map[int64]*storage.User
+	userBunches    map[int64]*storage.UserBunch
+	tokenHistories map[string]*storage.TokenHistory
+	signingKeys    map[string]*storage.SigningKey
+	loginAttempts  map[int64]*storage.LoginAttempt
+	totps          map[int64]*storage.TOTP
+	recoveryCodes  map[int64]*storage.RecoveryCode
+	userTokens     map[int64]*storage.UserToken
+	sequences      map[string]int64
+}
+
+func newTables() *tables {
+	return &tables{
+		keys:           make(map[int64]*storage.Key),
+		bunches:        make(map[int64]*storage.Bunch),
+		bunchKeys:      make(map[int64]*storage.BunchKey),
+		bunchParents:   make(map[int64]*storage.BunchParent),
+		users:          make(map[int64]*storage.User),
+		userBunches:    make(map[int64]*storage.UserBunch),
+		tokenHistories: make(map[string]*storage.TokenHistory),
+		signingKeys:    make(map[string]*storage.SigningKey),
+		loginAttempts:  make(map[int64]*storage.LoginAttempt),
+		totps:          make(map[int64]*storage.TOTP),
+		recoveryCodes:  make(map[int64]*storage.RecoveryCode),
+		userTokens:     make(map[int64]*storage.UserToken),
+		sequences:      make(map[string]int64),
+	}
+}
+
+// nextID returns the next auto increment id of a table
+func (t *tables) nextID(table string) int64 {
+	t.sequences[table]++
+	return t.sequences[table]
+}
+
+// clone deep copies every table so a transaction can work on its own snapshot
+func (t *tables) clone() *tables {
+	c := newTables()
+
+	for id, k := range t.keys {
+		row := *k
+		c.keys[id] = &row
+	}
+	for id, b := range t.bunches {
+		row := *b
+		c.bunches[id] = &row
+	}
+	for id, bk := range t.bunchKeys {
+		row := *bk
+		c.bunchKeys[id] = &row
+	}
+	for id, bp := range t.bunchParents {
+		row := *bp
+		c.bunchParents[id] = &row
+	}
+	for id, u := range t.users {
+		row := *u
+		c.users[id] = &row
+	}
+	for id, ub := range t.userBunches {
+		row := *ub
+		c.userBunches[id] = &row
+	}
+	for uid, th := range t.tokenHistories {
+		row := *th
+		c.tokenHistories[uid] = &row
+	}
+	for kid, k := range t.signingKeys {
+		c.signingKeys[kid] = copySigningKey(k)
+	}
+	for id, a := range t.loginAttempts {
+		row := *a
+		c.loginAttempts[id] = &row
+	}
+	for userID, totp := range t.totps {
+		row := *totp
+		c.totps[userID] = &row
+	}
+	for id, rc := range t.recoveryCodes {
+		row := *rc
+		c.recoveryCodes[id] = &row
+	}
+	for id, ut := range t.userTokens {
+		row := *ut
+		c.userTokens[id] = &row
+	}
+	for table, seq := range t.sequences {
+		c.sequences[table] = seq
+	}
+
+	return c
+}
+
+// DB is an in-memory database safe for concurrent use
+type DB struct {
+	mu   sync.RWMutex
+	data *tables
+}
+
+// NewDB creates an empty in-memory database
+func NewDB() *DB {
+	return &DB{data: newTables()}
+}
+
+// read runs fn holding the read lock
+func (db *DB) read(fn func(t *tables) error) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	return fn(db.data)
+}
+
+// write runs fn holding the write lock
+func (db *DB) write(fn func(t *tables) error) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	return fn(db.data)
+}
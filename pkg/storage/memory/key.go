@@ -0,0 +1,212 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.KeyStorer = (*KeyMemoryStorer)(nil)
+
+// KeyMemoryStorer implements key's storages in memory
+type KeyMemoryStorer struct {
+	db *DB
+}
+
+// NewKeyMemoryStorer creates a new instance of KeyMemoryStorer
+func NewKeyMemoryStorer(db *DB) *KeyMemoryStorer {
+	return &KeyMemoryStorer{
+		db,
+	}
+}
+
+func (st *KeyMemoryStorer) Insert(ctx context.Context, k storage.CreateKey) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := st.db.write(func(t *tables) error {
+		if t.keyByName(k.Name) != nil {
+			return &storage.DuplicateError{Entity: "key", Field: "name"}
+		}
+
+		id = t.nextID("keys")
+		t.keys[id] = &storage.Key{ID: id, Name: k.Name, Desc: k.Desc, UpdatedAt: time.Now()}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (st *KeyMemoryStorer) Update(ctx context.Context, k storage.UpdateKey) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(k.Name) == 0 && len(k.Desc) == 0 {
+		return nil
+	}
+
+	return st.db.write(func(t *tables) error {
+		key, ok := t.keys[k.ID]
+		if !ok {
+			return nil
+		}
+
+		if len(k.Name) > 0 {
+			if found := t.keyByName(k.Name); found != nil && found.ID != k.ID {
+				return &storage.DuplicateError{Entity: "key", Field: "name"}
+			}
+			key.Name = k.Name
+		}
+
+		if len(k.Desc) > 0 {
+			key.Desc = k.Desc
+		}
+
+		key.UpdatedAt = time.Now()
+
+		return nil
+	})
+}
+
+// Delete removes a key together with its bunch_keys rows
+func (st *KeyMemoryStorer) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if _, ok := t.keys[id]; !ok {
+			return storage.ErrNotFound
+		}
+
+		for bkID, bk := range t.bunchKeys {
+			if bk.KeyID == id {
+				delete(t.bunchKeys, bkID)
+			}
+		}
+		delete(t.keys, id)
+
+		return nil
+	})
+}
+
+func (st *KeyMemoryStorer) Get(ctx context.Context, id int64) (*storage.Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var key *storage.Key
+	err := st.db.read(func(t *tables) error {
+		found, ok := t.keys[id]
+		if !ok {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		key = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (st *KeyMemoryStorer) GetByName(ctx context.Context, name string) (*storage.Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var key *storage.Key
+	err := st.db.read(func(t *tables) error {
+		found := t.keyByName(name)
+		if found == nil {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		key = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (st *KeyMemoryStorer) Query(ctx context.Context, queries storage.QueryKey, sorts storage.SortKey) ([]*storage.Key, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.Key
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.Key, 0, len(t.keys))
+		for _, k := range t.sortedKeys() {
+			if len(queries.Name) > 0 && !contains(k.Name, queries.Name) {
+				continue
+			}
+			if len(queries.Desc) > 0 && !contains(k.Desc, queries.Desc) {
+				continue
+			}
+			if !between(k.UpdatedAt, queries.From, queries.To) {
+				continue
+			}
+
+			row := *k
+			rows = append(rows, &row)
+		}
+
+		return nil
+	})
+
+	order := ordering{}.
+		by(sorts.Name, func(i, j int) int { return compareStrings(rows[i].Name, rows[j].Name) }).
+		by(sorts.Desc, func(i, j int) int { return compareStrings(rows[i].Desc, rows[j].Desc) }).
+		by(sorts.UpdatedAt, func(i, j int) int { return compareTimes(rows[i].UpdatedAt, rows[j].UpdatedAt) })
+	if len(order) == 0 {
+		order = order.by(share.Descendant, func(i, j int) int { return compareInts(rows[i].ID, rows[j].ID) })
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// keyByName finds a key by name, names are compared case insensitively as mysql's default collation does
+func (t *tables) keyByName(name string) *storage.Key {
+	for _, k := range t.keys {
+		if strings.EqualFold(k.Name, name) {
+			return k
+		}
+	}
+
+	return nil
+}
+
+// sortedKeys returns the keys ordered by id
+func (t *tables) sortedKeys() []*storage.Key {
+	keys := make([]*storage.Key, 0, len(t.keys))
+	for _, k := range t.keys {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].ID < keys[j].ID })
+
+	return keys
+}
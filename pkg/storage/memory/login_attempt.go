@@ -0,0 +1,109 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.LoginAttemptStorer = (*LoginAttemptMemoryStorer)(nil)
+
+// LoginAttemptMemoryStorer implements login attempt's storage in memory
+type LoginAttemptMemoryStorer struct {
+	db *DB
+}
+
+// NewLoginAttemptMemoryStorer creates new instance of LoginAttemptMemoryStorer
+func NewLoginAttemptMemoryStorer(db *DB) *LoginAttemptMemoryStorer {
+	return &LoginAttemptMemoryStorer{
+		db,
+	}
+}
+
+func (st *LoginAttemptMemoryStorer) Insert(ctx context.Context, a storage.CreateLoginAttempt) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	st.db.write(func(t *tables) error {
+		id = t.nextID("login_attempts")
+		t.loginAttempts[id] = &storage.LoginAttempt{ID: id, UserID: a.UserID, Username: a.Username, IP: a.IP,
+			Outcome: a.Outcome, CreatedAt: time.Now()}
+
+		return nil
+	})
+
+	return id, nil
+}
+
+func (st *LoginAttemptMemoryStorer) Query(ctx context.Context, queries storage.QueryLoginAttempt, sorts storage.SortLoginAttempt) ([]*storage.LoginAttempt, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.LoginAttempt
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.LoginAttempt, 0, len(t.loginAttempts))
+		for _, a := range t.loginAttempts {
+			if queries.UserID > 0 && a.UserID != queries.UserID {
+				continue
+			}
+			if len(queries.Username) > 0 && a.Username != queries.Username {
+				continue
+			}
+			if len(queries.IP) > 0 && a.IP != queries.IP {
+				continue
+			}
+			if len(queries.Outcome) > 0 && a.Outcome != queries.Outcome {
+				continue
+			}
+			if !between(a.CreatedAt, queries.From, queries.To) {
+				continue
+			}
+
+			row := *a
+			rows = append(rows, &row)
+		}
+
+		return nil
+	})
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	direction := sorts.CreatedAt
+	if direction == share.BiDirection {
+		direction = share.Descendant
+	}
+	ordering{}.
+		by(direction, func(i, j int) int { return compareTimes(rows[i].CreatedAt, rows[j].CreatedAt) }).
+		by(direction, func(i, j int) int { return compareInts(rows[i].ID, rows[j].ID) }).
+		sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// Purge deletes attempts made at or before the given time and returns the number of deleted rows
+func (st *LoginAttemptMemoryStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	st.db.write(func(t *tables) error {
+		for id, a := range t.loginAttempts {
+			if !a.CreatedAt.After(before) {
+				delete(t.loginAttempts, id)
+				purged++
+			}
+		}
+
+		return nil
+	})
+
+	return purged, nil
+}
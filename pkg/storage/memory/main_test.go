@@ -0,0 +1,49 @@
+package memory
+
+import (
+	"os"
+	"testing"
+)
+
+type testApp struct {
+	kst  *KeyMemoryStorer
+	bst  *BunchMemoryStorer
+	bkst *BunchKeyMemoryStorer
+	bpst *BunchParentMemoryStorer
+	ust  *UserMemoryStorage
+	ubst *UserBunchMemoryStorage
+	thst *TokenHistoryMemoryStorer
+	skst *SigningKeyMemoryStorer
+	last *LoginAttemptMemoryStorer
+	tost *TOTPMemoryStorer
+	rcst *RecoveryCodeMemoryStorer
+	utst *UserTokenMemoryStorer
+	prst *PermissionMemoryResolver
+	repo *Repository
+}
+
+var test *testApp
+
+// TestMain setup testing env for memory repository
+func TestMain(m *testing.M) {
+	db := NewDB()
+
+	test = &testApp{
+		kst:  NewKeyMemoryStorer(db),
+		bst:  NewBunchMemoryStorer(db),
+		bkst: NewBunchKeyMemoryStorer(db),
+		bpst: NewBunchParentMemoryStorer(db),
+		ust:  NewUserMemoryStorage(db),
+		ubst: NewUserBunchMemoryStorage(db),
+		thst: NewTokenHistoryMemoryStorer(db),
+		skst: NewSigningKeyMemoryStorer(db),
+		last: NewLoginAttemptMemoryStorer(db),
+		tost: NewTOTPMemoryStorer(db),
+		rcst: NewRecoveryCodeMemoryStorer(db),
+		utst: NewUserTokenMemoryStorer(db),
+		prst: NewPermissionMemoryResolver(db),
+		repo: NewRepository(db),
+	}
+
+	os.Exit(m.Run())
+}
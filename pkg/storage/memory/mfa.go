@@ -0,0 +1,231 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	_ storage.TOTPStorer         = (*TOTPMemoryStorer)(nil)
+	_ storage.RecoveryCodeStorer = (*RecoveryCodeMemoryStorer)(nil)
+)
+
+// TOTPMemoryStorer implements totp secret's storage in memory
+type TOTPMemoryStorer struct {
+	db *DB
+}
+
+// NewTOTPMemoryStorer creates new instance of TOTPMemoryStorer
+func NewTOTPMemoryStorer(db *DB) *TOTPMemoryStorer {
+	return &TOTPMemoryStorer{
+		db,
+	}
+}
+
+func (st *TOTPMemoryStorer) Insert(ctx context.Context, totp storage.CreateTOTP) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if _, ok := t.totps[totp.UserID]; ok {
+			return &storage.DuplicateError{Entity: "totp", Field: "user_id"}
+		}
+
+		t.totps[totp.UserID] = &storage.TOTP{UserID: totp.UserID, Secret: totp.Secret, CreatedAt: time.Now()}
+
+		return nil
+	})
+}
+
+func (st *TOTPMemoryStorer) Get(ctx context.Context, userID int64) (*storage.TOTP, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var totp *storage.TOTP
+	err := st.db.read(func(t *tables) error {
+		found, ok := t.totps[userID]
+		if !ok {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		totp = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return totp, nil
+}
+
+// Confirm confirms the unconfirmed secret of a user with the step of its first code
+func (st *TOTPMemoryStorer) Confirm(ctx context.Context, userID int64, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var confirmed bool
+	st.db.write(func(t *tables) error {
+		if totp, ok := t.totps[userID]; ok && totp.ConfirmedAt.IsZero() {
+			totp.ConfirmedAt = time.Now()
+			totp.LastStep = step
+			confirmed = true
+		}
+
+		return nil
+	})
+
+	return confirmed, nil
+}
+
+// Use records the step of an accepted code when it follows the last one
+func (st *TOTPMemoryStorer) Use(ctx context.Context, userID int64, step int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var used bool
+	st.db.write(func(t *tables) error {
+		if totp, ok := t.totps[userID]; ok && !totp.ConfirmedAt.IsZero() && totp.LastStep < step {
+			totp.LastStep = step
+			used = true
+		}
+
+		return nil
+	})
+
+	return used, nil
+}
+
+func (st *TOTPMemoryStorer) Delete(ctx context.Context, userID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if _, ok := t.totps[userID]; !ok {
+			return storage.ErrNotFound
+		}
+
+		delete(t.totps, userID)
+
+		return nil
+	})
+}
+
+// RecoveryCodeMemoryStorer implements recovery code's storage in memory
+type RecoveryCodeMemoryStorer struct {
+	db *DB
+}
+
+// NewRecoveryCodeMemoryStorer creates new instance of RecoveryCodeMemoryStorer
+func NewRecoveryCodeMemoryStorer(db *DB) *RecoveryCodeMemoryStorer {
+	return &RecoveryCodeMemoryStorer{
+		db,
+	}
+}
+
+func (st *RecoveryCodeMemoryStorer) Insert(ctx context.Context, c storage.CreateRecoveryCode) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	st.db.write(func(t *tables) error {
+		id = t.nextID("recovery_codes")
+		t.recoveryCodes[id] = &storage.RecoveryCode{ID: id, UserID: c.UserID, Hash: c.Hash, CreatedAt: time.Now()}
+
+		return nil
+	})
+
+	return id, nil
+}
+
+func (st *RecoveryCodeMemoryStorer) Query(ctx context.Context, queries storage.QueryRecoveryCode, sorts storage.SortRecoveryCode) ([]*storage.RecoveryCode, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.RecoveryCode
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.RecoveryCode, 0, len(t.recoveryCodes))
+		for _, c := range t.recoveryCodes {
+			if queries.UserID > 0 && c.UserID != queries.UserID {
+				continue
+			}
+			if queries.Used.IsSet && queries.Used.Bool == c.UsedAt.IsZero() {
+				continue
+			}
+
+			row := *c
+			rows = append(rows, &row)
+		}
+
+		return nil
+	})
+	sort.Slice(rows, func(i, j int) bool { return rows[i].ID < rows[j].ID })
+
+	direction := sorts.CreatedAt
+	if direction == share.BiDirection {
+		direction = share.Ascendant
+	}
+	ordering{}.
+		by(direction, func(i, j int) int { return compareTimes(rows[i].CreatedAt, rows[j].CreatedAt) }).
+		by(direction, func(i, j int) int { return compareInts(rows[i].ID, rows[j].ID) }).
+		sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// Use marks the unused code of a user matching hash as used
+func (st *RecoveryCodeMemoryStorer) Use(ctx context.Context, userID int64, hash string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var used bool
+	st.db.write(func(t *tables) error {
+		for _, c := range t.recoveryCodes {
+			if c.UserID == userID && c.Hash == hash && c.UsedAt.IsZero() {
+				c.UsedAt = time.Now()
+				used = true
+				break
+			}
+		}
+
+		return nil
+	})
+
+	return used, nil
+}
+
+// DeleteUser deletes the codes of a user and returns the number of deleted rows
+func (st *RecoveryCodeMemoryStorer) DeleteUser(ctx context.Context, userID int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	st.db.write(func(t *tables) error {
+		for id, c := range t.recoveryCodes {
+			if c.UserID == userID {
+				delete(t.recoveryCodes, id)
+				deleted++
+			}
+		}
+
+		return nil
+	})
+
+	return deleted, nil
+}
@@ -0,0 +1,209 @@
+package memory
+
+import (
+	"context"
+	"sort"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.PermissionResolver = (*PermissionMemoryResolver)(nil)
+
+// PermissionMemoryResolver resolves user's keys in memory
+type PermissionMemoryResolver struct {
+	db *DB
+}
+
+// NewPermissionMemoryResolver creates new instance of PermissionMemoryResolver
+func NewPermissionMemoryResolver(db *DB) *PermissionMemoryResolver {
+	return &PermissionMemoryResolver{
+		db,
+	}
+}
+
+// Keys returns the deduplicated keys held by a user ordered by name
+func (rs *PermissionMemoryResolver) Keys(ctx context.Context, userID int64) ([]*storage.Key, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var results []*storage.Key
+	rs.db.read(func(t *tables) error {
+		keys := t.userKeys(userID)
+
+		results = make([]*storage.Key, 0, len(keys))
+		for _, k := range keys {
+			row := *k
+			results = append(results, &row)
+		}
+
+		return nil
+	})
+	sort.Slice(results, func(i, j int) bool { return compareStrings(results[i].Name, results[j].Name) < 0 })
+
+	return results, nil
+}
+
+// HasKey tells whether a user holds a key
+func (rs *PermissionMemoryResolver) HasKey(ctx context.Context, userID int64, keyName string) (bool, error) {
+	return rs.HasAll(ctx, userID, keyName)
+}
+
+// HasAll tells whether a user holds every given key, it is true when no key is given
+func (rs *PermissionMemoryResolver) HasAll(ctx context.Context, userID int64, keyNames ...string) (bool, error) {
+	names := uniqueStrings(keyNames)
+	if len(names) == 0 {
+		return true, nil
+	}
+
+	count, err := rs.countKeys(ctx, userID, names)
+	if err != nil {
+		return false, err
+	}
+
+	return count == len(names), nil
+}
+
+// HasAny tells whether a user holds at least one of the given keys, it is false when no key is given
+func (rs *PermissionMemoryResolver) HasAny(ctx context.Context, userID int64, keyNames ...string) (bool, error) {
+	names := uniqueStrings(keyNames)
+	if len(names) == 0 {
+		return false, nil
+	}
+
+	count, err := rs.countKeys(ctx, userID, names)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// countKeys counts how many of the given key names a user holds
+func (rs *PermissionMemoryResolver) countKeys(ctx context.Context, userID int64, names []string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var count int
+	rs.db.read(func(t *tables) error {
+		keys := t.userKeys(userID)
+		for _, name := range names {
+			if found := t.keyByName(name); found != nil && keys[found.ID] != nil {
+				count++
+			}
+		}
+
+		return nil
+	})
+
+	return count, nil
+}
+
+// Explain tells every path granting a key to a user or, when the key is not granted, the reasons
+func (rs *PermissionMemoryResolver) Explain(ctx context.Context, userID int64, keyName string) (*storage.PermissionExplanation, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var (
+		user  *storage.User
+		key   *storage.Key
+		paths = make([]*storage.PermissionGrant, 0)
+	)
+
+	err := rs.db.read(func(t *tables) error {
+		foundUser, ok := t.users[userID]
+		if !ok {
+			return storage.ErrNotFound
+		}
+
+		u := *foundUser
+		user = &u
+
+		foundKey := t.keyByName(keyName)
+		if foundKey == nil {
+			return nil
+		}
+
+		k := *foundKey
+		key = &k
+
+		for _, ub := range t.aggregateUserBunches() {
+			if ub.UserBunch.UserID != userID {
+				continue
+			}
+
+			// paths through inactive ancestors are kept, they tell why the key is denied
+			for _, a := range t.ancestors(ub.UserBunch.BunchID) {
+				for _, bk := range t.ancestorBunchKeys(ub.Bunch, a.ID) {
+					if bk.BunchKey.KeyID != key.ID {
+						continue
+					}
+
+					bk.Key = key
+					bk.Bunch = ub.Bunch
+					grant := &storage.PermissionGrant{UserBunch: ub, BunchKey: bk}
+					if a.Inactive != 0 {
+						inactive := *t.bunches[a.Inactive]
+						grant.Inactive = &inactive
+					}
+					paths = append(paths, grant)
+				}
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(paths, func(i, j int) bool {
+		if c := compareStrings(paths[i].UserBunch.Bunch.Name, paths[j].UserBunch.Bunch.Name); c != 0 {
+			return c < 0
+		}
+		return compareStrings(sourceName(paths[i]), sourceName(paths[j])) < 0
+	})
+
+	return storage.ExplainPaths(user, key, paths), nil
+}
+
+// sourceName returns the name of the bunch holding the key of a path
+func sourceName(p *storage.PermissionGrant) string {
+	if p.BunchKey.Source != nil {
+		return p.BunchKey.Source.Name
+	}
+
+	return p.BunchKey.Bunch.Name
+}
+
+// userKeys returns the keys of an active user's active bunches and of their active ancestors by key id
+func (t *tables) userKeys(userID int64) map[int64]*storage.Key {
+	keys := make(map[int64]*storage.Key)
+
+	user, ok := t.users[userID]
+	if !ok || !user.Active.Bool {
+		return keys
+	}
+
+	for _, ub := range t.userBunches {
+		if ub.UserID != userID || !t.bunches[ub.BunchID].Active.Bool {
+			continue
+		}
+
+		for _, a := range t.ancestors(ub.BunchID) {
+			if a.Inactive != 0 {
+				continue
+			}
+
+			for _, bk := range t.bunchKeys {
+				if bk.BunchID == a.ID {
+					keys[bk.KeyID] = t.keys[bk.KeyID]
+				}
+			}
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,100 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.Repository = (*Repository)(nil)
+
+// stores implements storage.Stores on top of a database
+type stores struct {
+	db *DB
+}
+
+func (s *stores) Keys() storage.KeyStorer {
+	return NewKeyMemoryStorer(s.db)
+}
+
+func (s *stores) Bunches() storage.BunchStorer {
+	return NewBunchMemoryStorer(s.db)
+}
+
+func (s *stores) BunchKeys() storage.BunchKeyStorer {
+	return NewBunchKeyMemoryStorer(s.db)
+}
+
+func (s *stores) BunchParents() storage.BunchParentStorer {
+	return NewBunchParentMemoryStorer(s.db)
+}
+
+func (s *stores) Users() storage.UserStorer {
+	return NewUserMemoryStorage(s.db)
+}
+
+func (s *stores) UserBunches() storage.UserBunchStorer {
+	return NewUserBunchMemoryStorage(s.db)
+}
+
+func (s *stores) TokenHistories() storage.TokenHistoryStorer {
+	return NewTokenHistoryMemoryStorer(s.db)
+}
+
+func (s *stores) SigningKeys() storage.SigningKeyStorer {
+	return NewSigningKeyMemoryStorer(s.db)
+}
+
+func (s *stores) LoginAttempts() storage.LoginAttemptStorer {
+	return NewLoginAttemptMemoryStorer(s.db)
+}
+
+func (s *stores) TOTPs() storage.TOTPStorer {
+	return NewTOTPMemoryStorer(s.db)
+}
+
+func (s *stores) RecoveryCodes() storage.RecoveryCodeStorer {
+	return NewRecoveryCodeMemoryStorer(s.db)
+}
+
+func (s *stores) UserTokens() storage.UserTokenStorer {
+	return NewUserTokenMemoryStorer(s.db)
+}
+
+func (s *stores) Permissions() storage.PermissionResolver {
+	return NewPermissionMemoryResolver(s.db)
+}
+
+// Repository implements storage.Repository in memory
+type Repository struct {
+	*stores
+	db *DB
+}
+
+// NewRepository creates new instance of Repository
+func NewRepository(db *DB) *Repository {
+	return &Repository{
+		stores: &stores{db},
+		db:     db,
+	}
+}
+
+// WithTx runs fn on a snapshot of the database which replaces the database when fn returns nil. The
+// database is locked until fn returns, so fn must only use tx and transactions never need a retry.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx storage.Stores) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.db.mu.Lock()
+	defer r.db.mu.Unlock()
+
+	tx := &DB{data: r.db.data.clone()}
+	if err := fn(&stores{tx}); err != nil {
+		return err
+	}
+
+	r.db.data = tx.data
+
+	return nil
+}
@@ -0,0 +1,161 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.SigningKeyStorer = (*SigningKeyMemoryStorer)(nil)
+
+// SigningKeyMemoryStorer implements signing key's storage in memory
+type SigningKeyMemoryStorer struct {
+	db *DB
+}
+
+// NewSigningKeyMemoryStorer creates new instance of SigningKeyMemoryStorer
+func NewSigningKeyMemoryStorer(db *DB) *SigningKeyMemoryStorer {
+	return &SigningKeyMemoryStorer{
+		db,
+	}
+}
+
+func (st *SigningKeyMemoryStorer) Insert(ctx context.Context, k storage.CreateSigningKey) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	kid := k.KID
+	if len(kid) == 0 {
+		kid = share.NewUID()
+	}
+
+	err := st.db.write(func(t *tables) error {
+		if _, ok := t.signingKeys[kid]; ok {
+			return &storage.DuplicateError{Entity: "signing_key", Field: "kid"}
+		}
+
+		t.signingKeys[kid] = copySigningKey(&storage.SigningKey{
+			KID:         kid,
+			Algorithm:   k.Algorithm,
+			PublicKey:   k.PublicKey,
+			PrivateKey:  k.PrivateKey,
+			CreatedAt:   time.Now(),
+			ActivatedAt: k.ActivatedAt,
+		})
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return kid, nil
+}
+
+func (st *SigningKeyMemoryStorer) Get(ctx context.Context, kid string) (*storage.SigningKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var k *storage.SigningKey
+	err := st.db.read(func(t *tables) error {
+		found, ok := t.signingKeys[kid]
+		if !ok {
+			return storage.ErrNotFound
+		}
+
+		k = copySigningKey(found)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return k, nil
+}
+
+func (st *SigningKeyMemoryStorer) Query(ctx context.Context, queries storage.QuerySigningKey, sorts storage.SortSigningKey) ([]*storage.SigningKey, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.SigningKey
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.SigningKey, 0, len(t.signingKeys))
+		for _, k := range t.signingKeys {
+			if len(queries.Algorithm) > 0 && k.Algorithm != queries.Algorithm {
+				continue
+			}
+			if !queries.ExpiredAfter.IsZero() && !k.ExpiredAt.IsZero() && !k.ExpiredAt.After(queries.ExpiredAfter) {
+				continue
+			}
+
+			rows = append(rows, copySigningKey(k))
+		}
+
+		return nil
+	})
+	sort.Slice(rows, func(i, j int) bool { return rows[i].KID < rows[j].KID })
+
+	direction := sorts.ActivatedAt
+	if direction == share.BiDirection {
+		direction = share.Descendant
+	}
+	ordering{}.
+		by(direction, func(i, j int) int { return compareTimes(rows[i].ActivatedAt, rows[j].ActivatedAt) }).
+		sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// Expire sets the expiry of a key which has none yet
+func (st *SigningKeyMemoryStorer) Expire(ctx context.Context, kid string, at time.Time) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if k, ok := t.signingKeys[kid]; ok && k.ExpiredAt.IsZero() {
+			k.ExpiredAt = at
+		}
+
+		return nil
+	})
+}
+
+// Purge deletes keys which expired at or before the given time and returns the number of deleted rows
+func (st *SigningKeyMemoryStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	st.db.write(func(t *tables) error {
+		for kid, k := range t.signingKeys {
+			if !k.ExpiredAt.IsZero() && !k.ExpiredAt.After(before) {
+				delete(t.signingKeys, kid)
+				purged++
+			}
+		}
+
+		return nil
+	})
+
+	return purged, nil
+}
+
+// copySigningKey copies a key with its key material, so callers never share the bytes of a row
+func copySigningKey(k *storage.SigningKey) *storage.SigningKey {
+	row := *k
+	row.PublicKey = append([]byte(nil), k.PublicKey...)
+	row.PrivateKey = append([]byte(nil), k.PrivateKey...)
+
+	return &row
+}
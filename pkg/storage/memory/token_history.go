@@ -0,0 +1,262 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.TokenHistoryStorer = (*TokenHistoryMemoryStorer)(nil)
+
+// TokenHistoryMemoryStorer implements token history's storage in memory
+type TokenHistoryMemoryStorer struct {
+	db *DB
+}
+
+// NewTokenHistoryMemoryStorer creates new instance of TokenHistoryMemoryStorer
+func NewTokenHistoryMemoryStorer(db *DB) *TokenHistoryMemoryStorer {
+	return &TokenHistoryMemoryStorer{
+		db,
+	}
+}
+
+func (st *TokenHistoryMemoryStorer) Insert(ctx context.Context, th storage.CreateTokenHistory) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	uid := th.UID
+	if len(uid) == 0 {
+		uid = share.NewUID()
+	}
+
+	familyID := th.FamilyID
+	if len(familyID) == 0 {
+		familyID = uid
+	}
+
+	err := st.db.write(func(t *tables) error {
+		if _, ok := t.tokenHistories[uid]; ok {
+			return &storage.DuplicateError{Entity: "token_history", Field: "uid"}
+		}
+
+		t.tokenHistories[uid] = &storage.TokenHistory{
+			UID:              uid,
+			UserID:           th.UserID,
+			AccessToken:      th.AccessToken,
+			RefreshToken:     th.RefreshToken,
+			RemoteAddr:       th.RemoteAddr,
+			XForwardedFor:    th.XForwardedFor,
+			XRealIP:          th.XRealIP,
+			UserAgent:        th.UserAgent,
+			FamilyID:         familyID,
+			CreatedAt:        time.Now(),
+			ExpiredAt:        th.ExpiredAt,
+			RefreshExpiredAt: th.RefreshExpiredAt,
+			FamilyExpiredAt:  th.FamilyExpiredAt,
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return uid, nil
+}
+
+func (st *TokenHistoryMemoryStorer) Get(ctx context.Context, uid string) (*storage.TokenHistory, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var th *storage.TokenHistory
+	err := st.db.read(func(t *tables) error {
+		found, ok := t.tokenHistories[uid]
+		if !ok {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		th = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return th, nil
+}
+
+func (st *TokenHistoryMemoryStorer) Query(ctx context.Context, queries storage.QueryTokenHistory, sorts storage.SortTokenHistory) ([]*storage.TokenHistory, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	now := time.Now()
+
+	var rows []*storage.TokenHistory
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.TokenHistory, 0, len(t.tokenHistories))
+		for _, th := range t.tokenHistories {
+			if queries.UserID > 0 && th.UserID != queries.UserID {
+				continue
+			}
+			if len(queries.FamilyID) > 0 && th.FamilyID != queries.FamilyID {
+				continue
+			}
+			if queries.Active.IsSet && isActiveToken(th, now) != queries.Active.Bool {
+				continue
+			}
+			if queries.Live.IsSet && isLiveToken(th, now) != queries.Live.Bool {
+				continue
+			}
+			if !between(th.CreatedAt, queries.From, queries.To) {
+				continue
+			}
+
+			row := *th
+			rows = append(rows, &row)
+		}
+
+		return nil
+	})
+	sort.Slice(rows, func(i, j int) bool { return rows[i].UID < rows[j].UID })
+
+	order := ordering{}.
+		by(sorts.CreatedAt, func(i, j int) int { return compareTimes(rows[i].CreatedAt, rows[j].CreatedAt) }).
+		by(sorts.ExpiredAt, func(i, j int) int { return compareTimes(rows[i].ExpiredAt, rows[j].ExpiredAt) })
+	if len(order) == 0 {
+		order = order.by(share.Descendant, func(i, j int) int {
+			return compareTimes(rows[i].CreatedAt, rows[j].CreatedAt)
+		})
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// Revoke marks a token as revoked, revoking an already revoked token keeps its original revoked time
+func (st *TokenHistoryMemoryStorer) Revoke(ctx context.Context, uid string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if th, ok := t.tokenHistories[uid]; ok && th.RevokedAt.IsZero() {
+			th.RevokedAt = time.Now()
+		}
+
+		return nil
+	})
+}
+
+// Rotate marks the refresh token of a token as exchanged, it returns false when it already was or the token
+// does not exist
+func (st *TokenHistoryMemoryStorer) Rotate(ctx context.Context, uid string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var rotated bool
+	st.db.write(func(t *tables) error {
+		if th, ok := t.tokenHistories[uid]; ok && th.RotatedAt.IsZero() {
+			th.RotatedAt = time.Now()
+			rotated = true
+		}
+
+		return nil
+	})
+
+	return rotated, nil
+}
+
+// RevokeFamily revokes the tokens of a family which are not revoked yet and returns how many were revoked
+func (st *TokenHistoryMemoryStorer) RevokeFamily(ctx context.Context, familyID string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	st.db.write(func(t *tables) error {
+		now := time.Now()
+		for _, th := range t.tokenHistories {
+			if th.FamilyID == familyID && th.RevokedAt.IsZero() {
+				th.RevokedAt = now
+				revoked++
+			}
+		}
+
+		return nil
+	})
+
+	return revoked, nil
+}
+
+// RevokeUser revokes the tokens of a user which are not revoked yet and returns how many were revoked
+func (st *TokenHistoryMemoryStorer) RevokeUser(ctx context.Context, userID int64) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	st.db.write(func(t *tables) error {
+		revoked = t.revokeUserTokens(userID, time.Now())
+
+		return nil
+	})
+
+	return revoked, nil
+}
+
+// Purge deletes tokens whose access and refresh tokens expired at or before the given time and returns the
+// number of deleted rows
+func (st *TokenHistoryMemoryStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var purged int64
+	st.db.write(func(t *tables) error {
+		for uid, th := range t.tokenHistories {
+			if !th.ExpiredAt.After(before) && !th.RefreshExpiredAt.After(before) {
+				delete(t.tokenHistories, uid)
+				purged++
+			}
+		}
+
+		return nil
+	})
+
+	return purged, nil
+}
+
+// isActiveToken tells whether a token is neither revoked nor expired at the given time
+func isActiveToken(th *storage.TokenHistory, now time.Time) bool {
+	return th.RevokedAt.IsZero() && th.ExpiredAt.After(now)
+}
+
+// isLiveToken tells whether a token still holds a session at the given time: it is not revoked and either
+// its access token or its unexchanged refresh token has not expired
+func isLiveToken(th *storage.TokenHistory, now time.Time) bool {
+	return th.RevokedAt.IsZero() && (th.ExpiredAt.After(now) || (th.RotatedAt.IsZero() && th.RefreshExpiredAt.After(now)))
+}
+
+// revokeUserTokens revokes the tokens of a user which are not revoked yet and returns how many were revoked
+func (t *tables) revokeUserTokens(userID int64, at time.Time) int64 {
+	var revoked int64
+	for _, th := range t.tokenHistories {
+		if th.UserID == userID && th.RevokedAt.IsZero() {
+			th.RevokedAt = at
+			revoked++
+		}
+	}
+
+	return revoked
+}
@@ -0,0 +1,460 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	_ storage.UserStorer      = (*UserMemoryStorage)(nil)
+	_ storage.UserBunchStorer = (*UserBunchMemoryStorage)(nil)
+)
+
+// UserMemoryStorage implements user's storage in memory
+type UserMemoryStorage struct {
+	db *DB
+}
+
+// UserBunchMemoryStorage implements user-bunch's storage in memory
+type UserBunchMemoryStorage struct {
+	db *DB
+}
+
+// NewUserMemoryStorage create new instance of UserMemoryStorage
+func NewUserMemoryStorage(db *DB) *UserMemoryStorage {
+	return &UserMemoryStorage{
+		db,
+	}
+}
+
+// NewUserBunchMemoryStorage create new instance of UserBunchMemoryStorage
+func NewUserBunchMemoryStorage(db *DB) *UserBunchMemoryStorage {
+	return &UserBunchMemoryStorage{
+		db,
+	}
+}
+
+func (st *UserMemoryStorage) Insert(ctx context.Context, u storage.CreateUser) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := st.db.write(func(t *tables) error {
+		if t.userByUsername(u.Username) != nil {
+			return &storage.DuplicateError{Entity: "user", Field: "username"}
+		}
+		if t.userByEmail(u.Email) != nil {
+			return &storage.DuplicateError{Entity: "user", Field: "email"}
+		}
+
+		id = t.nextID("users")
+		t.users[id] = &storage.User{
+			ID:        id,
+			FullName:  u.FullName,
+			Username:  u.Username,
+			Email:     u.Email,
+			Hash:      u.Hash,
+			Salt:      u.Salt,
+			Active:    share.Boolean{IsSet: true, Bool: true},
+			UpdatedAt: time.Now(),
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (st *UserMemoryStorage) Update(ctx context.Context, u storage.UpdateUser) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if len(u.FullName) == 0 && len(u.Username) == 0 && len(u.Email) == 0 && len(u.Hash) == 0 &&
+		len(u.Salt) == 0 && !u.Active.IsSet {
+		return nil
+	}
+
+	return st.db.write(func(t *tables) error {
+		user, ok := t.users[u.ID]
+		if !ok {
+			return nil
+		}
+
+		if len(u.Username) > 0 {
+			if found := t.userByUsername(u.Username); found != nil && found.ID != u.ID {
+				return &storage.DuplicateError{Entity: "user", Field: "username"}
+			}
+		}
+
+		if len(u.Email) > 0 {
+			if found := t.userByEmail(u.Email); found != nil && found.ID != u.ID {
+				return &storage.DuplicateError{Entity: "user", Field: "email"}
+			}
+		}
+
+		if len(u.FullName) > 0 {
+			user.FullName = u.FullName
+		}
+		if len(u.Username) > 0 {
+			user.Username = u.Username
+		}
+		if len(u.Email) > 0 {
+			if u.Email != user.Email {
+				user.EmailVerifiedAt = time.Time{}
+			}
+			user.Email = u.Email
+			user.PendingEmail = ""
+		}
+		if len(u.Hash) > 0 {
+			user.Hash = u.Hash
+		}
+		if len(u.Salt) > 0 {
+			user.Salt = u.Salt
+		}
+		if u.Active.IsSet {
+			user.Active.Bool = u.Active.Bool
+		}
+
+		user.UpdatedAt = time.Now()
+
+		// a deactivated user loses its outstanding tokens
+		if u.Active.IsSet && !u.Active.Bool {
+			t.revokeUserTokens(u.ID, user.UpdatedAt)
+		}
+
+		// a new password voids the outstanding reset tokens
+		if len(u.Hash) > 0 {
+			t.invalidateUserTokens(u.ID, storage.PurposePasswordReset, user.UpdatedAt)
+		}
+
+		// a new email voids the outstanding verification tokens
+		if len(u.Email) > 0 {
+			t.invalidateUserTokens(u.ID, storage.PurposeEmailVerification, user.UpdatedAt)
+		}
+
+		return nil
+	})
+}
+
+// SetPendingEmail sets the pending email of a user, an empty one clears it
+func (st *UserMemoryStorage) SetPendingEmail(ctx context.Context, id int64, email string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok {
+			return nil
+		}
+
+		user.PendingEmail = email
+		user.UpdatedAt = time.Now()
+		t.invalidateUserTokens(id, storage.PurposeEmailVerification, user.UpdatedAt)
+
+		return nil
+	})
+}
+
+// VerifyEmail marks email verified when it is the email or the pending email of a user
+func (st *UserMemoryStorage) VerifyEmail(ctx context.Context, id int64, email string) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var verified bool
+	err := st.db.write(func(t *tables) error {
+		user, ok := t.users[id]
+		if !ok || len(email) == 0 {
+			return nil
+		}
+
+		now := time.Now()
+		switch email {
+		case user.Email:
+			if user.EmailVerifiedAt.IsZero() {
+				user.EmailVerifiedAt = now
+			}
+
+		case user.PendingEmail:
+			if found := t.userByEmail(email); found != nil && found.ID != id {
+				return &storage.DuplicateError{Entity: "user", Field: "email"}
+			}
+
+			user.Email = email
+			user.PendingEmail = ""
+			user.EmailVerifiedAt = now
+
+		default:
+			return nil
+		}
+
+		user.UpdatedAt = now
+		verified = true
+
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	return verified, nil
+}
+
+func (st *UserMemoryStorage) Get(ctx context.Context, id int64) (*storage.User, error) {
+	return st.getBy(ctx, func(t *tables) *storage.User { return t.users[id] })
+}
+
+// Lock checks the user exists, Repository.WithTx holds the lock of the whole database
+func (st *UserMemoryStorage) Lock(ctx context.Context, id int64) error {
+	_, err := st.Get(ctx, id)
+	return err
+}
+
+func (st *UserMemoryStorage) GetByName(ctx context.Context, username string) (*storage.User, error) {
+	return st.getBy(ctx, func(t *tables) *storage.User { return t.userByUsername(username) })
+}
+
+func (st *UserMemoryStorage) GetByEmail(ctx context.Context, email string) (*storage.User, error) {
+	return st.getBy(ctx, func(t *tables) *storage.User { return t.userByEmail(email) })
+}
+
+func (st *UserMemoryStorage) getBy(ctx context.Context, find func(t *tables) *storage.User) (*storage.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var user *storage.User
+	err := st.db.read(func(t *tables) error {
+		found := find(t)
+		if found == nil {
+			return storage.ErrNotFound
+		}
+
+		row := *found
+		user = &row
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func (st *UserMemoryStorage) Query(ctx context.Context, queries storage.QueryUser, sorts storage.SortUser) ([]*storage.User, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.User
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.User, 0, len(t.users))
+		for _, u := range t.sortedUsers() {
+			if len(queries.FullName) > 0 && !contains(u.FullName, queries.FullName) {
+				continue
+			}
+			if len(queries.Username) > 0 && !contains(u.Username, queries.Username) {
+				continue
+			}
+			if len(queries.Email) > 0 && !contains(u.Email, queries.Email) {
+				continue
+			}
+			if queries.Active.IsSet && u.Active.Bool != queries.Active.Bool {
+				continue
+			}
+			if queries.Verified.IsSet && queries.Verified.Bool == u.EmailVerifiedAt.IsZero() {
+				continue
+			}
+			if !between(u.UpdatedAt, queries.From, queries.To) {
+				continue
+			}
+
+			row := *u
+			rows = append(rows, &row)
+		}
+
+		return nil
+	})
+
+	order := ordering{}.
+		by(sorts.Username, func(i, j int) int { return compareStrings(rows[i].Username, rows[j].Username) }).
+		by(sorts.FullName, func(i, j int) int { return compareStrings(rows[i].FullName, rows[j].FullName) }).
+		by(sorts.Email, func(i, j int) int { return compareStrings(rows[i].Email, rows[j].Email) }).
+		by(sorts.UpdatedAt, func(i, j int) int { return compareTimes(rows[i].UpdatedAt, rows[j].UpdatedAt) }).
+		by(sorts.Active, func(i, j int) int { return compareBools(rows[i].Active.Bool, rows[j].Active.Bool) })
+	if len(order) == 0 {
+		order = order.by(share.Descendant, func(i, j int) int { return compareInts(rows[i].ID, rows[j].ID) })
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+func (st *UserBunchMemoryStorage) Insert(ctx context.Context, ub storage.CreateUserBunch) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err := st.db.write(func(t *tables) error {
+		if _, ok := t.users[ub.UserID]; !ok {
+			return &storage.ForeignKeyError{Entity: "user_bunch", Field: "user_id"}
+		}
+		if _, ok := t.bunches[ub.BunchID]; !ok {
+			return &storage.ForeignKeyError{Entity: "user_bunch", Field: "bunch_id"}
+		}
+
+		for _, found := range t.userBunches {
+			if found.UserID == ub.UserID && found.BunchID == ub.BunchID {
+				return &storage.DuplicateError{Entity: "user_bunch", Field: "bunch_id"}
+			}
+		}
+
+		id = t.nextID("user_bunches")
+		t.userBunches[id] = &storage.UserBunch{ID: id, UserID: ub.UserID, BunchID: ub.BunchID, UpdatedAt: time.Now()}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+func (st *UserBunchMemoryStorage) Delete(ctx context.Context, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return st.db.write(func(t *tables) error {
+		if _, ok := t.userBunches[id]; !ok {
+			return storage.ErrNotFound
+		}
+
+		delete(t.userBunches, id)
+
+		return nil
+	})
+}
+
+func (st *UserBunchMemoryStorage) Get(ctx context.Context, id int64) (*storage.AggregateUserBunch, error) {
+	rows, _, err := st.Query(ctx, storage.QueryUserBunch{ID: id, Limit: 1}, storage.SortUserBunch{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *UserBunchMemoryStorage) Query(ctx context.Context, queries storage.QueryUserBunch, sorts storage.SortUserBunch) ([]*storage.AggregateUserBunch, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var rows []*storage.AggregateUserBunch
+	st.db.read(func(t *tables) error {
+		rows = make([]*storage.AggregateUserBunch, 0, len(t.userBunches))
+		for _, agg := range t.aggregateUserBunches() {
+			if queries.ID > 0 && agg.UserBunch.ID != queries.ID {
+				continue
+			}
+			if queries.UserID > 0 && agg.UserBunch.UserID != queries.UserID {
+				continue
+			}
+			if len(queries.Username) > 0 && !contains(agg.User.Username, queries.Username) {
+				continue
+			}
+			if len(queries.BunchName) > 0 && !contains(agg.Bunch.Name, queries.BunchName) {
+				continue
+			}
+			if queries.UserActive.IsSet && agg.User.Active.Bool != queries.UserActive.Bool {
+				continue
+			}
+			if queries.BunchActive.IsSet && agg.Bunch.Active.Bool != queries.BunchActive.Bool {
+				continue
+			}
+
+			rows = append(rows, agg)
+		}
+
+		return nil
+	})
+
+	order := ordering{}.
+		by(sorts.Username, func(i, j int) int { return compareStrings(rows[i].User.Username, rows[j].User.Username) }).
+		by(sorts.BunchName, func(i, j int) int { return compareStrings(rows[i].Bunch.Name, rows[j].Bunch.Name) })
+	if len(order) == 0 {
+		order = order.by(share.Descendant, func(i, j int) int {
+			return compareInts(rows[i].UserBunch.ID, rows[j].UserBunch.ID)
+		})
+	}
+	order.sort(rows)
+
+	start, end := page(len(rows), queries.Offset, queries.Limit)
+
+	return rows[start:end], int64(len(rows)), nil
+}
+
+// userByUsername finds a user by username, usernames are compared case insensitively as mysql's default
+// collation does
+func (t *tables) userByUsername(username string) *storage.User {
+	for _, u := range t.users {
+		if strings.EqualFold(u.Username, username) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// userByEmail finds a user by email, emails are compared case insensitively as mysql's default collation does
+func (t *tables) userByEmail(email string) *storage.User {
+	for _, u := range t.users {
+		if strings.EqualFold(u.Email, email) {
+			return u
+		}
+	}
+
+	return nil
+}
+
+// sortedUsers returns the users ordered by id
+func (t *tables) sortedUsers() []*storage.User {
+	users := make([]*storage.User, 0, len(t.users))
+	for _, u := range t.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users
+}
+
+// aggregateUserBunches joins copies of every user_bunches row to its user and bunch ordered by id
+func (t *tables) aggregateUserBunches() []*storage.AggregateUserBunch {
+	results := make([]*storage.AggregateUserBunch, 0, len(t.userBunches))
+	for _, ub := range t.userBunches {
+		userBunch, user, bunch := *ub, *t.users[ub.UserID], *t.bunches[ub.BunchID]
+		results = append(results, &storage.AggregateUserBunch{User: &user, Bunch: &bunch, UserBunch: &userBunch})
+	}
+	sort.Slice(results, func(i, j int) bool { return results[i].UserBunch.ID < results[j].UserBunch.ID })
+
+	return results
+}
@@ -0,0 +1,132 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.UserTokenStorer = (*UserTokenMemoryStorer)(nil)
+
+// UserTokenMemoryStorer implements user token's storage in memory
+type UserTokenMemoryStorer struct {
+	db *DB
+}
+
+// NewUserTokenMemoryStorer creates new instance of UserTokenMemoryStorer
+func NewUserTokenMemoryStorer(db *DB) *UserTokenMemoryStorer {
+	return &UserTokenMemoryStorer{
+		db,
+	}
+}
+
+func (st *UserTokenMemoryStorer) Insert(ctx context.Context, ut storage.CreateUserToken) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var id int64
+	st.db.write(func(t *tables) error {
+		id = t.nextID("user_tokens")
+		t.userTokens[id] = &storage.UserToken{ID: id, UserID: ut.UserID, Purpose: ut.Purpose, Hash: ut.Hash,
+			CreatedAt: time.Now(), ExpiredAt: ut.ExpiredAt}
+
+		return nil
+	})
+
+	return id, nil
+}
+
+func (st *UserTokenMemoryStorer) GetByHash(ctx context.Context, hash string) (*storage.UserToken, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	var token *storage.UserToken
+	err := st.db.read(func(t *tables) error {
+		for _, ut := range t.userTokens {
+			if ut.Hash == hash {
+				row := *ut
+				token = &row
+
+				return nil
+			}
+		}
+
+		return storage.ErrNotFound
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// Use marks an unused token as used
+func (st *UserTokenMemoryStorer) Use(ctx context.Context, id int64) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+
+	var used bool
+	st.db.write(func(t *tables) error {
+		if ut, ok := t.userTokens[id]; ok && ut.UsedAt.IsZero() {
+			ut.UsedAt = time.Now()
+			used = true
+		}
+
+		return nil
+	})
+
+	return used, nil
+}
+
+// Invalidate marks the unused tokens of a user for a purpose as used and returns how many were
+func (st *UserTokenMemoryStorer) Invalidate(ctx context.Context, userID int64, purpose string) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var invalidated int64
+	st.db.write(func(t *tables) error {
+		invalidated = t.invalidateUserTokens(userID, purpose, time.Now())
+		return nil
+	})
+
+	return invalidated, nil
+}
+
+// Purge deletes tokens which expired at or before the given time and returns the number of deleted rows
+func (st *UserTokenMemoryStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	st.db.write(func(t *tables) error {
+		for id, ut := range t.userTokens {
+			if !ut.ExpiredAt.After(before) {
+				delete(t.userTokens, id)
+				deleted++
+			}
+		}
+
+		return nil
+	})
+
+	return deleted, nil
+}
+
+// invalidateUserTokens marks the unused tokens of a user for a purpose as used and returns how many were
+func (t *tables) invalidateUserTokens(userID int64, purpose string, at time.Time) int64 {
+	var invalidated int64
+	for _, ut := range t.userTokens {
+		if ut.UserID == userID && ut.Purpose == purpose && ut.UsedAt.IsZero() {
+			ut.UsedAt = at
+			invalidated++
+		}
+	}
+
+	return invalidated
+}
@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+// like reports whether value matches a sql LIKE pattern, it is case insensitive as mysql's default collation
+func like(value string, pattern string) bool {
+	var expr strings.Builder
+
+	expr.WriteString("(?is)^")
+	for _, r := range pattern {
+		switch r {
+		case '%':
+			expr.WriteString(".*")
+		case '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	expr.WriteString("$")
+
+	matched, err := regexp.MatchString(expr.String(), value)
+
+	return err == nil && matched
+}
+
+// contains reports whether value matches the filter the same way as `LIKE '%filter%'`
+func contains(value string, filter string) bool {
+	return like(value, "%"+filter+"%")
+}
+
+// between reports whether t is in the (from, to] range, a zero bound is ignored
+func between(t time.Time, from time.Time, to time.Time) bool {
+	if !from.IsZero() && !t.After(from) {
+		return false
+	}
+	if !to.IsZero() && t.After(to) {
+		return false
+	}
+
+	return true
+}
+
+func compareStrings(a string, b string) int {
+	return strings.Compare(strings.ToLower(a), strings.ToLower(b))
+}
+
+func compareInts(a int64, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	default:
+		return 0
+	}
+}
+
+func compareBools(a bool, b bool) int {
+	switch {
+	case a == b:
+		return 0
+	case b:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func compareTimes(a time.Time, b time.Time) int {
+	switch {
+	case a.Before(b):
+		return -1
+	case a.After(b):
+		return 1
+	default:
+		return 0
+	}
+}
+
+// ordering is a list of comparisons applied one after another like the columns of an ORDER BY
+type ordering []func(i, j int) int
+
+// by appends a comparison in the given direction, it is skipped for share.BiDirection
+func (o ordering) by(d share.Direction, cmp func(i, j int) int) ordering {
+	switch d {
+	case share.Ascendant:
+		return append(o, cmp)
+	case share.Descendant:
+		return append(o, func(i, j int) int { return -cmp(i, j) })
+	default:
+		return o
+	}
+}
+
+// sort sorts a slice of rows, the original order is kept between equal rows
+func (o ordering) sort(rows interface{}) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		for _, cmp := range o {
+			if r := cmp(i, j); r != 0 {
+				return r < 0
+			}
+		}
+		return false
+	})
+}
+
+// page returns the bounds of the page of a result of n rows
+func page(n int, offset int64, limit int64) (int, int) {
+	if limit == 0 {
+		limit = share.DefaultLimit
+	}
+
+	start := int(offset)
+	if start > n {
+		start = n
+	}
+
+	end := start + int(limit)
+	if end > n {
+		end = n
+	}
+
+	return start, end
+}
+
+// uniqueStrings removes duplicated and empty strings keeping the original order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	results := make([]string, 0, len(values))
+
+	for _, v := range values {
+		if len(v) == 0 || seen[v] {
+			continue
+		}
+		seen[v] = true
+		results = append(results, v)
+	}
+
+	return results
+}
@@ -0,0 +1,74 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+//TOTP model, the time-based one-time password secret of a user. A secret is enrolled unconfirmed and guards
+//the logins of its user once a first code confirms it. LastStep is the time step of the latest accepted
+//code, codes of that step or an earlier one are never accepted again.
+type TOTP struct {
+	UserID      int64
+	Secret      string
+	LastStep    int64
+	CreatedAt   time.Time
+	ConfirmedAt time.Time
+}
+
+//CreateTOTP model
+type CreateTOTP struct {
+	UserID int64
+	Secret string
+}
+
+//TOTPStorer defines fundamental functions to interact with storage repository. A user has one secret at
+//most. Confirm and Use tell whether the secret was updated: Confirm only updates an unconfirmed secret and
+//Use only a confirmed one whose last step is before step, so a code is accepted once.
+type TOTPStorer interface {
+	Insert(ctx context.Context, t CreateTOTP) error
+	Get(ctx context.Context, userID int64) (*TOTP, error)
+	Confirm(ctx context.Context, userID int64, step int64) (bool, error)
+	Use(ctx context.Context, userID int64, step int64) (bool, error)
+	Delete(ctx context.Context, userID int64) error
+}
+
+//RecoveryCode model, Hash is a digest of a one-time code accepted in place of a TOTP code
+type RecoveryCode struct {
+	ID        int64
+	UserID    int64
+	Hash      string
+	CreatedAt time.Time
+	UsedAt    time.Time
+}
+
+//CreateRecoveryCode model
+type CreateRecoveryCode struct {
+	UserID int64
+	Hash   string
+}
+
+//QueryRecoveryCode model
+type QueryRecoveryCode struct {
+	Limit  int64
+	Offset int64
+	UserID int64
+	Used   share.Boolean
+}
+
+//SortRecoveryCode model
+type SortRecoveryCode struct {
+	CreatedAt share.Direction
+}
+
+//RecoveryCodeStorer defines fundamental functions to interact with storage repository. Use marks the unused
+//code of a user matching hash as used and tells whether there was one. DeleteUser deletes every code of a
+//user and returns how many were deleted.
+type RecoveryCodeStorer interface {
+	Insert(ctx context.Context, c CreateRecoveryCode) (int64, error)
+	Query(ctx context.Context, queries QueryRecoveryCode, sorts SortRecoveryCode) ([]*RecoveryCode, int64, error)
+	Use(ctx context.Context, userID int64, hash string) (bool, error)
+	DeleteUser(ctx context.Context, userID int64) (int64, error)
+}
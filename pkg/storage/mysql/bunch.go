@@ -1,8 +1,8 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -10,41 +10,75 @@ import (
 	"github.com/vespaiach/auth_service/pkg/storage"
 )
 
+var (
+	_ storage.BunchStorer       = (*BunchMysqlStorer)(nil)
+	_ storage.BunchKeyStorer    = (*BunchKeyMysqlStorer)(nil)
+	_ storage.BunchParentStorer = (*BunchParentMysqlStorer)(nil)
+)
+
+// bunchAncestors returns a CTE pairing the bunches selected by seed, a query of their ids named id, with
+// themselves and with each bunch they inherit from, directly or not. inactive_id is the first inactive bunch on
+// the way to the ancestor, the ancestor included but not the bunch itself, or 0 when there is none. UNION drops
+// the rows found again, so it ends even on a cycle.
+func bunchAncestors(seed string) string {
+	return "WITH RECURSIVE bunch_ancestors (bunch_id, ancestor_id, inactive_id) AS (" +
+		"SELECT seeds.id, seeds.id, CAST(0 AS SIGNED) FROM (" + seed + ") AS seeds " +
+		"UNION " +
+		"SELECT bunch_ancestors.bunch_id, bunch_parents.parent_id, " +
+		"CASE WHEN bunch_ancestors.inactive_id = 0 AND parents.`active` = 0 THEN parents.id " +
+		"ELSE bunch_ancestors.inactive_id END FROM bunch_ancestors " +
+		"INNER JOIN bunch_parents ON bunch_parents.bunch_id = bunch_ancestors.ancestor_id " +
+		"INNER JOIN bunches AS parents ON parents.id = bunch_parents.parent_id) "
+}
+
 // BunchMysqlStorer implements db's storage for bunch
 type BunchMysqlStorer struct {
-	db *sqlx.DB
+	db executor
 }
 
 // BunchKeyMysqlStorer implements db's storage for bunch-key
 type BunchKeyMysqlStorer struct {
-	db *sqlx.DB
+	db executor
+}
+
+// BunchParentMysqlStorer implements db's storage for bunch-parent
+type BunchParentMysqlStorer struct {
+	db executor
 }
 
 // NewBunchMysqlStorer create new instance of BunchMysqlStorer
-func NewBunchMysqlStorer(db *sqlx.DB) *BunchMysqlStorer {
+func NewBunchMysqlStorer(db executor) *BunchMysqlStorer {
 	return &BunchMysqlStorer{
 		db,
 	}
 }
 
 // BunchKeyMysqlStorer create new instance of BunchKeyMysqlStorer
-func NewBunchKeyMysqlStorer(db *sqlx.DB) *BunchKeyMysqlStorer {
+func NewBunchKeyMysqlStorer(db executor) *BunchKeyMysqlStorer {
 	return &BunchKeyMysqlStorer{
 		db,
 	}
 }
 
-func (st *BunchMysqlStorer) Insert(u storage.CreateBunch) (int64, error) {
+// NewBunchParentMysqlStorer create new instance of BunchParentMysqlStorer
+func NewBunchParentMysqlStorer(db executor) *BunchParentMysqlStorer {
+	return &BunchParentMysqlStorer{
+		db,
+	}
+}
+
+func (st *BunchMysqlStorer) Insert(ctx context.Context, u storage.CreateBunch) (int64, error) {
 	sql := "INSERT INTO bunches (`name`, `desc`, `active`, updated_at) VALUES (?, ?, ?, ?);"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return 0, err
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(u.Name, u.Desc, true, time.Now())
+	res, err := stmt.ExecContext(ctx, u.Name, u.Desc, true, time.Now())
 	if err != nil {
-		return 0, err
+		return 0, mapError(err)
 	}
 
 	lastID, err := res.LastInsertId()
@@ -55,7 +89,7 @@ func (st *BunchMysqlStorer) Insert(u storage.CreateBunch) (int64, error) {
 	return lastID, nil
 }
 
-func (st *BunchMysqlStorer) Update(u storage.UpdateBunch) error {
+func (st *BunchMysqlStorer) Update(ctx context.Context, u storage.UpdateBunch) error {
 	var (
 		sql      string = "UPDATE bunches SET %s WHERE id = :id;"
 		fields   string
@@ -71,11 +105,13 @@ func (st *BunchMysqlStorer) Update(u storage.UpdateBunch) error {
 
 	if len(u.Desc) > 0 {
 		fields += prefix + " `desc` = :desc "
+		prefix = ","
 		updating["desc"] = u.Desc
 	}
 
 	if u.Active.IsSet {
 		fields += prefix + " `active` = :active "
+		prefix = ","
 		updating["active"] = u.Active.Bool
 	}
 
@@ -84,25 +120,25 @@ func (st *BunchMysqlStorer) Update(u storage.UpdateBunch) error {
 		updating["updated_at"] = time.Now()
 		updating["id"] = u.ID
 
-		_, err := st.db.NamedExec(fmt.Sprintf(sql, fields), updating)
+		_, err := st.db.NamedExecContext(ctx, fmt.Sprintf(sql, fields), updating)
 		if err != nil {
-			return err
+			return mapError(err)
 		}
 	}
 
 	return nil
 }
 
-func (st *BunchMysqlStorer) Get(id int64) (*storage.Bunch, error) {
+func (st *BunchMysqlStorer) Get(ctx context.Context, id int64) (*storage.Bunch, error) {
 	sql := "SELECT id, `name`, `desc`, active, updated_at FROM `bunches` WHERE id = ? LIMIT 1;"
-	rows, err := st.db.Queryx(sql, id)
+	rows, err := st.db.QueryxContext(ctx, sql, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
@@ -113,16 +149,16 @@ func (st *BunchMysqlStorer) Get(id int64) (*storage.Bunch, error) {
 	return b, nil
 }
 
-func (st *BunchMysqlStorer) GetByName(name string) (*storage.Bunch, error) {
+func (st *BunchMysqlStorer) GetByName(ctx context.Context, name string) (*storage.Bunch, error) {
 	sql := "SELECT id, `name`, `desc`, active, updated_at FROM `bunches` WHERE name = ? LIMIT 1;"
-	rows, err := st.db.Queryx(sql, name)
+	rows, err := st.db.QueryxContext(ctx, sql, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
@@ -133,24 +169,21 @@ func (st *BunchMysqlStorer) GetByName(name string) (*storage.Bunch, error) {
 	return b, nil
 }
 
-func (st *BunchMysqlStorer) Query(queries storage.QueryBunch, sorts storage.SortBunch) ([]*storage.Bunch, int64, error) {
+func (st *BunchMysqlStorer) Query(ctx context.Context, queries storage.QueryBunch, sorts storage.SortBunch) ([]*storage.Bunch, int64, error) {
 	var (
-		sql           = "SELECT id, `name`, `desc`, active, updated_at FROM `bunches` %s ORDER BY %s LIMIT :offset, :limit;"
-		sqlcount      = "SELECT count(id) FROM `bunches` %s;"
-		orderPrefix   string
-		order         string
-		wherePrefix   = "WHERE "
-		where         string
-		wg            sync.WaitGroup
-		queryErr      error
-		countTotalErr error
-		results       []*storage.Bunch
-		total         int64
+		sql         = "SELECT id, `name`, `desc`, active, updated_at FROM `bunches` %s ORDER BY %s LIMIT :offset, :limit;"
+		sqlcount    = "SELECT count(id) FROM `bunches` %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.Bunch
+		total       int64
 	)
 
 	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
 	if queries.Limit == 0 {
-		filter["queries"] = share.DefaultLimit
+		filter["limit"] = share.DefaultLimit
 	}
 
 	if len(queries.Name) > 0 {
@@ -210,13 +243,10 @@ func (st *BunchMysqlStorer) Query(queries storage.QueryBunch, sorts storage.Sort
 	sql = fmt.Sprintf(sql, where, order)
 	sqlcount = fmt.Sprintf(sqlcount, where)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		rows, err := st.db.NamedQuery(sql, filter)
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
 		if err != nil {
-			queryErr = err
-			return
+			return err
 		}
 		defer rows.Close()
 
@@ -225,61 +255,34 @@ func (st *BunchMysqlStorer) Query(queries storage.QueryBunch, sorts storage.Sort
 			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
 			err := rows.Scan(&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt)
 			if err != nil {
-				queryErr = err
-				return
+				return err
 			}
 			results = append(results, b)
 		}
 
-		if rows.Err() != nil {
-			queryErr = rows.Err()
-			return
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		rows, err := st.db.NamedQuery(sqlcount, filter)
-		if err != nil {
-			countTotalErr = err
-			return
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			err := rows.Scan(&total)
-			if err != nil {
-				countTotalErr = err
-				return
-			}
-		}
-	}()
-
-	wg.Wait()
-
-	if queryErr != nil {
-		return nil, 0, queryErr
-	}
-	if countTotalErr != nil {
-		return nil, 0, countTotalErr
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return results, total, nil
 }
 
-func (st *BunchKeyMysqlStorer) Insert(bk storage.BunchKey) (int64, error) {
+func (st *BunchKeyMysqlStorer) Insert(ctx context.Context, bk storage.BunchKey) (int64, error) {
 	sql := "INSERT INTO `bunch_keys` (bunch_id, key_id, updated_at) VALUES (?, ?, ?);"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return 0, err
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(bk.BunchID, bk.KeyID, time.Now())
+	res, err := stmt.ExecContext(ctx, bk.BunchID, bk.KeyID, time.Now())
 	if err != nil {
-		return 0, err
+		return 0, mapError(err)
 	}
 
 	lastID, err := res.LastInsertId()
@@ -290,59 +293,101 @@ func (st *BunchKeyMysqlStorer) Insert(bk storage.BunchKey) (int64, error) {
 	return lastID, nil
 }
 
-func (st *BunchKeyMysqlStorer) Delete(id int64) error {
+func (st *BunchKeyMysqlStorer) Delete(ctx context.Context, id int64) error {
 	sql := "DELETE FROM `bunch_keys` WHERE id=?"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return mapError(err)
+	}
 
-	_, err = stmt.Exec(id)
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
 
 	return nil
 }
 
-func (st *BunchKeyMysqlStorer) Query(queries storage.QueryBunchKey, sorts storage.SortBunchKey) ([]*storage.AggregateBunchKey, int64, error) {
+func (st *BunchKeyMysqlStorer) Get(ctx context.Context, id int64) (*storage.AggregateBunchKey, error) {
+	rows, _, err := st.Query(ctx, storage.QueryBunchKey{ID: id, Limit: 1}, storage.SortBunchKey{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *BunchKeyMysqlStorer) Query(ctx context.Context, queries storage.QueryBunchKey, sorts storage.SortBunchKey) ([]*storage.AggregateBunchKey, int64, error) {
 	var (
-		sql = "SELECT `keys`.id, `keys`.`name`, `keys`.`desc`, `keys`.updated_at, " +
+		sql = "%sSELECT `keys`.id, `keys`.`name`, `keys`.`desc`, `keys`.updated_at, " +
 			"bunches.`id`, bunches.`name`, bunches.`desc`, bunches.`active`, bunches.updated_at, " +
-			"bunch_keys.`id`, bunch_keys.bunch_id, bunch_keys.key_id, bunch_keys.updated_at " +
-			"FROM bunch_keys " +
-			"INNER JOIN `keys` ON `keys`.id = bunch_keys.key_id " +
-			"INNER JOIN `bunches` ON `bunches`.id = bunch_keys.bunch_id " +
-			"%s ORDER BY %s LIMIT :offset, :limit;"
-		sqlcount = "SELECT count(bunch_keys.`id`) " +
-			"FROM bunch_keys " +
-			"INNER JOIN `keys` ON `keys`.`id` = bunch_keys.key_id " +
-			"INNER JOIN `bunches` ON `bunches`.id = bunch_keys.bunch_id %s"
-		orderPrefix   string
-		order         string
-		wherePrefix   = "WHERE "
-		where         string
-		wg            sync.WaitGroup
-		queryErr      error
-		countTotalErr error
-		results       []*storage.AggregateBunchKey
-		total         int64
+			"bunch_keys.`id`, bunch_keys.bunch_id, bunch_keys.key_id, bunch_keys.updated_at, " +
+			"sources.`id`, sources.`name`, sources.`desc`, sources.`active`, sources.updated_at " +
+			"%s %s ORDER BY %s LIMIT :offset, :limit;"
+		sqlcount    = "%sSELECT count(bunch_keys.`id`) %s %s"
+		cte         string
+		seed        string
+		seedPrefix  = " WHERE "
+		from        string
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.AggregateBunchKey
+		total       int64
 	)
 
 	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
 	if queries.Limit == 0 {
-		filter["queries"] = share.DefaultLimit
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.Inherited {
+		// sources are the ancestors holding the keys, the bunch itself for its own keys. An inactive ancestor
+		// passes on none of the keys it holds or inherits.
+		from = "FROM bunch_ancestors " +
+			"INNER JOIN bunch_keys ON bunch_keys.bunch_id = bunch_ancestors.ancestor_id " +
+			"INNER JOIN `keys` ON `keys`.id = bunch_keys.key_id " +
+			"INNER JOIN `bunches` ON `bunches`.id = bunch_ancestors.bunch_id " +
+			"INNER JOIN `bunches` AS sources ON sources.id = bunch_ancestors.ancestor_id"
+		where += wherePrefix + "bunch_ancestors.inactive_id = 0"
+		wherePrefix = " AND "
+	} else {
+		from = "FROM bunch_keys " +
+			"INNER JOIN `keys` ON `keys`.id = bunch_keys.key_id " +
+			"INNER JOIN `bunches` ON `bunches`.id = bunch_keys.bunch_id " +
+			"INNER JOIN `bunches` AS sources ON sources.id = bunch_keys.bunch_id"
+	}
+
+	if queries.ID > 0 {
+		filter["id"] = queries.ID
+		where += wherePrefix + "bunch_keys.`id` = :id"
+		wherePrefix = " AND "
 	}
 
 	if len(queries.BunchName) > 0 {
 		filter["bunch_name"] = queries.BunchName
 		where += wherePrefix + "bunches.`name` = :bunch_name"
+		seed += seedPrefix + "`name` = :bunch_name"
 		wherePrefix = " AND "
+		seedPrefix = " AND "
 	}
 
 	if len(queries.KeyName) > 0 {
-		filter["key_name"] = queries.BunchName
+		filter["key_name"] = queries.KeyName
 		where += wherePrefix + "`keys`.`name` = :key_name"
 		wherePrefix = " AND "
 	}
@@ -350,7 +395,14 @@ func (st *BunchKeyMysqlStorer) Query(queries storage.QueryBunchKey, sorts storag
 	if queries.BunchActive.IsSet {
 		filter["active"] = queries.BunchActive.Bool
 		where += wherePrefix + "bunches.`active` = :active"
+		seed += seedPrefix + "`active` = :active"
 		wherePrefix = " AND "
+		seedPrefix = " AND "
+	}
+
+	if queries.Inherited {
+		// only the ancestors of the bunches filtered are looked for
+		cte = bunchAncestors("SELECT `id` FROM `bunches`" + seed)
 	}
 
 	if sorts.BunchName != share.BiDirection {
@@ -369,19 +421,17 @@ func (st *BunchKeyMysqlStorer) Query(queries storage.QueryBunchKey, sorts storag
 	}
 
 	if len(order) == 0 {
-		order = "bunch_keys.`id` DESC"
+		// an inherited key is listed once per bunch inheriting it
+		order = "bunch_keys.`id` DESC, bunches.`id` DESC"
 	}
 
-	sql = fmt.Sprintf(sql, where, order)
-	sqlcount = fmt.Sprintf(sqlcount, where)
+	sql = fmt.Sprintf(sql, cte, from, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, cte, from, where)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		rows, err := st.db.NamedQuery(sql, filter)
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
 		if err != nil {
-			queryErr = err
-			return
+			return err
 		}
 		defer rows.Close()
 
@@ -391,50 +441,249 @@ func (st *BunchKeyMysqlStorer) Query(queries storage.QueryBunchKey, sorts storag
 			k := new(storage.Key)
 			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
 			bk := new(storage.BunchKey)
+			source := &storage.Bunch{Active: share.Boolean{IsSet: true}}
 
 			err := rows.Scan(&k.ID, &k.Name, &k.Desc, &k.UpdatedAt,
 				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
-				&bk.ID, &bk.BunchID, &bk.KeyID, &bk.UpdatedAt)
+				&bk.ID, &bk.BunchID, &bk.KeyID, &bk.UpdatedAt,
+				&source.ID, &source.Name, &source.Desc, &source.Active.Bool, &source.UpdatedAt)
 			if err != nil {
-				queryErr = err
-				return
+				return err
+			}
+
+			row := &storage.AggregateBunchKey{
+				BunchKey: bk,
+				Key:      k,
+				Bunch:    b,
 			}
-			results = append(results, &storage.AggregateBunchKey{bk, k, b})
+			if source.ID != b.ID {
+				row.Source = source
+			}
+			results = append(results, row)
 		}
 
-		if rows.Err() != nil {
-			queryErr = rows.Err()
-			return
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Insert makes a bunch inherit the keys of a parent unless the parent inherits from the bunch already. The
+// inserts hold the bunch_parents row of storage_locks from before the check until they commit, so concurrent
+// ones cannot close a cycle together. The check reads the ancestors with locking reads, which see the rows the
+// inserts before committed even in a transaction whose snapshot is older.
+func (st *BunchParentMysqlStorer) Insert(ctx context.Context, bp storage.CreateBunchParent) (int64, error) {
+	sql := "INSERT INTO bunch_parents (bunch_id, parent_id, updated_at) VALUES (?, ?, ?);"
+
+	if bp.BunchID == bp.ParentID {
+		return 0, storage.ErrBunchCycle
+	}
+
+	var lastID int64
+	err := inTx(ctx, st.db, func(tx executor) error {
+		_, err := tx.ExecContext(ctx, "SELECT `name` FROM `storage_locks` WHERE `name` = 'bunch_parents' FOR UPDATE;")
+		if err != nil {
+			return err
 		}
-	}()
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
+		cycle, err := inheritsFrom(ctx, tx, bp.ParentID, bp.BunchID)
+		if err != nil {
+			return err
+		}
+		if cycle {
+			return storage.ErrBunchCycle
+		}
 
-		rows, err := st.db.NamedQuery(sqlcount, filter)
+		stmt, err := tx.PrepareContext(ctx, sql)
 		if err != nil {
-			countTotalErr = err
-			return
+			return err
 		}
-		defer rows.Close()
+		defer stmt.Close()
 
-		if rows.Next() {
-			err := rows.Scan(&total)
-			if err != nil {
-				countTotalErr = err
-				return
+		res, err := stmt.ExecContext(ctx, bp.BunchID, bp.ParentID, time.Now())
+		if err != nil {
+			return mapError(err)
+		}
+
+		lastID, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return lastID, nil
+}
+
+// inheritsFrom tells whether a bunch inherits from an ancestor, directly or not. It walks the parents one
+// generation at a time with locking reads.
+func inheritsFrom(ctx context.Context, tx executor, bunchID int64, ancestorID int64) (bool, error) {
+	seen := map[int64]bool{bunchID: true}
+	generation := []int64{bunchID}
+
+	for len(generation) > 0 {
+		sql, args, err := sqlx.In("SELECT `parent_id` FROM `bunch_parents` WHERE `bunch_id` IN (?) "+
+			"LOCK IN SHARE MODE;", generation)
+		if err != nil {
+			return false, err
+		}
+
+		var parents []int64
+		if err := sqlx.SelectContext(ctx, tx, &parents, sql, args...); err != nil {
+			return false, err
+		}
+
+		generation = generation[:0]
+		for _, parentID := range parents {
+			if parentID == ancestorID {
+				return true, nil
+			}
+			if !seen[parentID] {
+				seen[parentID] = true
+				generation = append(generation, parentID)
 			}
 		}
-	}()
+	}
+
+	return false, nil
+}
 
-	wg.Wait()
+func (st *BunchParentMysqlStorer) Delete(ctx context.Context, id int64) error {
+	sql := "DELETE FROM `bunch_parents` WHERE id=?"
 
-	if queryErr != nil {
-		return nil, 0, queryErr
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (st *BunchParentMysqlStorer) Get(ctx context.Context, id int64) (*storage.AggregateBunchParent, error) {
+	rows, _, err := st.Query(ctx, storage.QueryBunchParent{ID: id, Limit: 1}, storage.SortBunchParent{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *BunchParentMysqlStorer) Query(ctx context.Context, queries storage.QueryBunchParent, sorts storage.SortBunchParent) ([]*storage.AggregateBunchParent, int64, error) {
+	var (
+		sql = "SELECT bunch_parents.`id`, bunch_parents.bunch_id, bunch_parents.parent_id, bunch_parents.updated_at, " +
+			"bunches.`id`, bunches.`name`, bunches.`desc`, bunches.`active`, bunches.updated_at, " +
+			"parents.`id`, parents.`name`, parents.`desc`, parents.`active`, parents.updated_at " +
+			"FROM bunch_parents " +
+			"INNER JOIN `bunches` ON `bunches`.id = bunch_parents.bunch_id " +
+			"INNER JOIN `bunches` AS parents ON parents.id = bunch_parents.parent_id " +
+			"%s ORDER BY %s LIMIT :offset, :limit;"
+		sqlcount = "SELECT count(bunch_parents.`id`) " +
+			"FROM bunch_parents " +
+			"INNER JOIN `bunches` ON `bunches`.id = bunch_parents.bunch_id " +
+			"INNER JOIN `bunches` AS parents ON parents.id = bunch_parents.parent_id %s"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.AggregateBunchParent
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
 	}
-	if countTotalErr != nil {
-		return nil, 0, countTotalErr
+
+	if queries.ID > 0 {
+		filter["id"] = queries.ID
+		where += wherePrefix + "bunch_parents.`id` = :id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.BunchName) > 0 {
+		filter["bunch_name"] = queries.BunchName
+		where += wherePrefix + "bunches.`name` = :bunch_name"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.ParentName) > 0 {
+		filter["parent_name"] = queries.ParentName
+		where += wherePrefix + "parents.`name` = :parent_name"
+		wherePrefix = " AND "
+	}
+
+	if sorts.BunchName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("bunches.`name` %s", getOrderDirection(sorts.BunchName))
+		orderPrefix = " , "
+	}
+
+	if sorts.ParentName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("parents.`name` %s", getOrderDirection(sorts.ParentName))
+		orderPrefix = " , "
+	}
+
+	if len(order) == 0 {
+		order = "bunch_parents.`id` DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.AggregateBunchParent, 0, queries.Limit)
+
+		for rows.Next() {
+			bp := new(storage.BunchParent)
+			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+			parent := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+
+			err := rows.Scan(&bp.ID, &bp.BunchID, &bp.ParentID, &bp.UpdatedAt,
+				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
+				&parent.ID, &parent.Name, &parent.Desc, &parent.Active.Bool, &parent.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			results = append(results, &storage.AggregateBunchParent{
+				BunchParent: bp,
+				Bunch:       b,
+				Parent:      parent,
+			})
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return results, total, nil
@@ -1,6 +1,8 @@
 package mysql
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -17,7 +19,7 @@ func TestBunchMysqlStorer_Insert(t *testing.T) {
 		bunch := test.mig.createUniqueString("bunch")
 		desc := test.mig.createUniqueString("desc")
 
-		id, err := test.bst.Insert(storage.CreateBunch{Name: bunch, Desc: desc})
+		id, err := test.bst.Insert(context.Background(), storage.CreateBunch{Name: bunch, Desc: desc})
 		require.Nil(t, err)
 		require.NotZero(t, id)
 	})
@@ -33,8 +35,8 @@ func TestBunchMysqlStorer_Insert(t *testing.T) {
 			fields["desc"] = desc
 		})
 
-		id, err := test.bst.Insert(storage.CreateBunch{Name: bunch, Desc: desc})
-		require.NotNil(t, err)
+		id, err := test.bst.Insert(context.Background(), storage.CreateBunch{Name: bunch, Desc: desc})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
 		require.Zero(t, id)
 	})
 }
@@ -52,7 +54,7 @@ func TestBunchMysqlStorer_Update(t *testing.T) {
 			fields["desc"] = desc
 		})
 
-		err := test.bst.Update(storage.UpdateBunch{ID: id, Name: bunch + "updated", Desc: desc})
+		err := test.bst.Update(context.Background(), storage.UpdateBunch{ID: id, Name: bunch + "updated", Desc: desc})
 		require.Nil(t, err)
 	})
 
@@ -67,7 +69,7 @@ func TestBunchMysqlStorer_Update(t *testing.T) {
 		})
 		id := test.mig.createSeedingBunch(nil)
 
-		err := test.bst.Update(storage.UpdateBunch{ID: id, Name: bunch, Desc: desc})
+		err := test.bst.Update(context.Background(), storage.UpdateBunch{ID: id, Name: bunch, Desc: desc})
 		require.NotNil(t, err)
 	})
 }
@@ -84,7 +86,7 @@ func TestBunchMysqlStorer_Get(t *testing.T) {
 			fields["name"] = name
 		})
 
-		bunch, err := test.bst.Get(id)
+		bunch, err := test.bst.Get(context.Background(), id)
 		require.Nil(t, err)
 		require.NotNil(t, bunch)
 		require.Equal(t, id, bunch.ID)
@@ -104,7 +106,7 @@ func TestBunchMysqlStorer_GetByName(t *testing.T) {
 			fields["name"] = name
 		})
 
-		bunch, err := test.bst.GetByName(name)
+		bunch, err := test.bst.GetByName(context.Background(), name)
 		require.Nil(t, err)
 		require.NotNil(t, bunch)
 		require.Equal(t, id, bunch.ID)
@@ -137,7 +139,7 @@ func TestBunchMysqlStorer_Query(t *testing.T) {
 			fields["active"] = false
 		})
 
-		bunches, total, err := test.bst.Query(storage.QueryBunch{
+		bunches, total, err := test.bst.Query(context.Background(), storage.QueryBunch{
 			Limit:  2,
 			Offset: 2,
 			Name:   prefix,
@@ -159,7 +161,7 @@ func TestBunchKeyMysqlStorer_Insert(t *testing.T) {
 		bunchID := test.mig.createSeedingBunch(nil)
 		keyID := test.mig.createSeedingServiceKey(nil)
 
-		id, err := test.bkst.Insert(storage.BunchKey{BunchID: bunchID, KeyID: keyID})
+		id, err := test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID, KeyID: keyID})
 		require.Nil(t, err)
 		require.NotZero(t, id)
 	})
@@ -167,10 +169,24 @@ func TestBunchKeyMysqlStorer_Insert(t *testing.T) {
 	t.Run("fail_insert_a_bunch_key", func(t *testing.T) {
 		t.Parallel()
 
-		id, err := test.bkst.Insert(storage.BunchKey{BunchID: -1, KeyID: -2})
+		id, err := test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: -1, KeyID: -2})
 		require.NotNil(t, err)
 		require.Zero(t, id)
 	})
+
+	t.Run("fail_insert_a_bunch_key_of_missing_key", func(t *testing.T) {
+		t.Parallel()
+
+		bunchID := test.mig.createSeedingBunch(nil)
+
+		id, err := test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID, KeyID: 1 << 40})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+		require.Zero(t, id)
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "key_id", fk.Field)
+	})
 }
 
 func TestBunchKeyMysqlStorer_Delete(t *testing.T) {
@@ -182,11 +198,11 @@ func TestBunchKeyMysqlStorer_Delete(t *testing.T) {
 		bunchID := test.mig.createSeedingBunch(nil)
 		keyID := test.mig.createSeedingServiceKey(nil)
 
-		id, err := test.bkst.Insert(storage.BunchKey{BunchID: bunchID, KeyID: keyID})
+		id, err := test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID, KeyID: keyID})
 		require.Nil(t, err)
 		require.NotZero(t, id)
 
-		err = test.bkst.Delete(id)
+		err = test.bkst.Delete(context.Background(), id)
 		require.Nil(t, err)
 	})
 }
@@ -212,22 +228,22 @@ func TestBunchKeyMysqlStorer_Query(t *testing.T) {
 		keyID4 := test.mig.createSeedingServiceKey(func(fields map[string]interface{}) { fields["name"] = name6 })
 		keyID5 := test.mig.createSeedingServiceKey(func(fields map[string]interface{}) { fields["name"] = name2 })
 
-		_, err := test.bkst.Insert(storage.BunchKey{BunchID: bunchID1, KeyID: keyID1})
+		_, err := test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID1, KeyID: keyID1})
 		require.Nil(t, err)
 
-		_, err = test.bkst.Insert(storage.BunchKey{BunchID: bunchID1, KeyID: keyID2})
+		_, err = test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID1, KeyID: keyID2})
 		require.Nil(t, err)
 
-		_, err = test.bkst.Insert(storage.BunchKey{BunchID: bunchID1, KeyID: keyID3})
+		_, err = test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID1, KeyID: keyID3})
 		require.Nil(t, err)
 
-		_, err = test.bkst.Insert(storage.BunchKey{BunchID: bunchID1, KeyID: keyID4})
+		_, err = test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID1, KeyID: keyID4})
 		require.Nil(t, err)
 
-		_, err = test.bkst.Insert(storage.BunchKey{BunchID: bunchID1, KeyID: keyID5})
+		_, err = test.bkst.Insert(context.Background(), storage.BunchKey{BunchID: bunchID1, KeyID: keyID5})
 		require.Nil(t, err)
 
-		rows, total, err := test.bkst.Query(storage.QueryBunchKey{
+		rows, total, err := test.bkst.Query(context.Background(), storage.QueryBunchKey{
 			Limit:     2,
 			Offset:    1,
 			BunchName: name1,
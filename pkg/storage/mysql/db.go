@@ -1,13 +1,16 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
 )
 
 type uniqInt struct {
@@ -37,19 +40,25 @@ type Script struct {
 
 // Migrator struct
 type Migrator struct {
-	db   *sqlx.DB
-	init []*Script
-	drop []*Script
-	seed []*Script
+	db         *sqlx.DB
+	migrations []*Migration
+	table      string
+	drop       []*Script
+	seed       []*Script
+
+	// DryRun makes Up and Down report what they would do without touching the database
+	DryRun bool
+
+	// LockTimeout is how long Up and Down wait for a concurrent migrator
+	LockTimeout time.Duration
 }
 
 // NewMigrator return struct instance
 func NewMigrator(db *sqlx.DB) *Migrator {
+	return newMigrator(db, DefaultMigrationTable, migrations)
+}
 
-	var initScripts = []*Script{
-		&Script{Name: "init_database", Text: initDatabase},
-	}
-
+func newMigrator(db *sqlx.DB, table string, migrations []*Migration) *Migrator {
 	var dropScripts = []*Script{
 		&Script{Name: "drop_database", Text: dropDatabase},
 	}
@@ -58,23 +67,25 @@ func NewMigrator(db *sqlx.DB) *Migrator {
 		&Script{Name: "seed_database", Text: seedingData},
 	}
 
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
 	return &Migrator{
-		db,
-		initScripts,
-		dropScripts,
-		seedScripts,
+		db:          db,
+		migrations:  sorted,
+		table:       table,
+		drop:        dropScripts,
+		seed:        seedScripts,
+		LockTimeout: DefaultLockTimeout,
 	}
 }
 
-// Init database
+// Init database by applying every pending migration
 func (m *Migrator) Init() {
-	tx := m.db.MustBegin()
-
-	for _, s := range m.init {
-		tx.MustExec(santizeSQL(s.Text))
+	if _, err := m.Up(context.Background(), 0); err != nil {
+		panic(err)
 	}
-
-	tx.Commit()
 }
 
 // Drop database
@@ -160,6 +171,41 @@ func (m *Migrator) createSeedingUser(beforeCreate func(map[string]interface{}))
 	return id
 }
 
+func (m *Migrator) createSeedingBunchKey(bunchID int64, keyID int64) int64 {
+	result, _ := m.db.Exec("INSERT INTO bunch_keys (bunch_id, key_id, updated_at) VALUES (?, ?, ?);",
+		bunchID, keyID, time.Now())
+	id, _ := result.LastInsertId()
+
+	return id
+}
+
+func (m *Migrator) createSeedingUserBunch(userID int64, bunchID int64) int64 {
+	result, _ := m.db.Exec("INSERT INTO user_bunches (user_id, bunch_id, updated_at) VALUES (?, ?, ?);",
+		userID, bunchID, time.Now())
+	id, _ := result.LastInsertId()
+
+	return id
+}
+
+func (m *Migrator) createSeedingTokenHistory(beforeCreate func(map[string]interface{})) string {
+	fields := map[string]interface{}{
+		"uid":          share.NewUID(),
+		"user_id":      inc.New(),
+		"access_token": fmt.Sprintf("access_token_%s", strconv.Itoa(inc.New())),
+		"created_at":   time.Now(),
+		"expired_at":   time.Now().Add(time.Hour),
+	}
+
+	if beforeCreate != nil {
+		beforeCreate(fields)
+	}
+
+	m.db.NamedExec("INSERT INTO token_histories (uid, user_id, access_token, created_at, expired_at) "+
+		"VALUES (:uid, :user_id, :access_token, :created_at, :expired_at);", fields)
+
+	return fields["uid"].(string)
+}
+
 func (m *Migrator) getServiceKeyByID(id int64) (key string, desc string) {
 	rows, err := m.db.Queryx("SELECT `key`, `desc` FROM `keys` WHERE id = ?", id)
 	defer rows.Close()
@@ -1,6 +1,14 @@
 package mysql
 
-var initDatabase = `
+// migrations are the versioned changes of the schema, applied in order of Version. Released migrations must
+// never be edited: their checksums are recorded in schema_migrations, add a new migration instead.
+// The first ones create tables only when missing, so databases created before versioning are adopted as is: they
+// create the tables as they were before versioning and later changes, even to those tables, are new migrations.
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "create_keys",
+		Up: `
 CREATE TABLE IF NOT EXISTS "keys" (
   	"id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
 	"name" VARCHAR(32) NOT NULL,
@@ -11,7 +19,15 @@ CREATE TABLE IF NOT EXISTS "keys" (
 ENGINE = InnoDB
 AUTO_INCREMENT = 1
 DEFAULT CHARACTER SET = utf8;
-
+`,
+		Down: `
+DROP TABLE IF EXISTS "keys";
+`,
+	},
+	{
+		Version: 2,
+		Name:    "create_bunches",
+		Up: `
 CREATE TABLE IF NOT EXISTS "bunches" (
   "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
   "name" VARCHAR(32) NOT NULL,
@@ -22,7 +38,15 @@ CREATE TABLE IF NOT EXISTS "bunches" (
   UNIQUE INDEX "bunch_name_uniq" ("name" ASC),
   INDEX "bunch_active_idx" ("active" ASC))
 ENGINE = InnoDB;
-
+`,
+		Down: `
+DROP TABLE IF EXISTS "bunches";
+`,
+	},
+	{
+		Version: 3,
+		Name:    "create_users",
+		Up: `
 CREATE TABLE IF NOT EXISTS "users" (
   "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
   "full_name" VARCHAR(64) NOT NULL,
@@ -39,7 +63,15 @@ CREATE TABLE IF NOT EXISTS "users" (
 ENGINE = InnoDB
 AUTO_INCREMENT = 1
 DEFAULT CHARACTER SET = utf8;
-
+`,
+		Down: `
+DROP TABLE IF EXISTS "users";
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create_token_histories",
+		Up: `
 CREATE TABLE IF NOT EXISTS "token_histories" (
   "uid" VARCHAR(36) NOT NULL,
   "user_id" BIGINT(20) UNSIGNED NOT NULL,
@@ -55,7 +87,15 @@ CREATE TABLE IF NOT EXISTS "token_histories" (
   UNIQUE INDEX "uid_uniq" ("uid" ASC))
 ENGINE = InnoDB
 DEFAULT CHARACTER SET = utf8;
-
+`,
+		Down: `
+DROP TABLE IF EXISTS "token_histories";
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create_bunch_keys",
+		Up: `
 CREATE TABLE IF NOT EXISTS "bunch_keys" (
   "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
   "bunch_id" BIGINT(20) UNSIGNED NOT NULL,
@@ -78,7 +118,15 @@ CREATE TABLE IF NOT EXISTS "bunch_keys" (
 ENGINE = InnoDB
 AUTO_INCREMENT = 1
 DEFAULT CHARACTER SET = utf8;
-
+`,
+		Down: `
+DROP TABLE IF EXISTS "bunch_keys";
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create_user_bunches",
+		Up: `
 CREATE TABLE IF NOT EXISTS "user_bunches" (
   "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
   "user_id" BIGINT(20) UNSIGNED NOT NULL,
@@ -99,19 +147,251 @@ CREATE TABLE IF NOT EXISTS "user_bunches" (
     ON DELETE CASCADE
     ON UPDATE CASCADE)
 ENGINE = InnoDB;
-`
+`,
+		Down: `
+DROP TABLE IF EXISTS "user_bunches";
+`,
+	},
+	{
+		Version: 7,
+		Name:    "add_token_families",
+		Up: `
+ALTER TABLE "token_histories"
+  ADD COLUMN "family_id" VARCHAR(36) NOT NULL DEFAULT '' AFTER "user_agent",
+  ADD COLUMN "refresh_expired_at" TIMESTAMP NULL DEFAULT NULL AFTER "expired_at",
+  ADD COLUMN "family_expired_at" TIMESTAMP NULL DEFAULT NULL AFTER "refresh_expired_at",
+  ADD COLUMN "rotated_at" TIMESTAMP NULL DEFAULT NULL AFTER "family_expired_at",
+  ADD INDEX "token_history_family_id_idx" ("family_id" ASC);
+UPDATE "token_histories" SET "family_id" = "uid" WHERE "family_id" = '';
+`,
+		Down: `
+ALTER TABLE "token_histories"
+  DROP INDEX "token_history_family_id_idx",
+  DROP COLUMN "rotated_at",
+  DROP COLUMN "family_expired_at",
+  DROP COLUMN "refresh_expired_at",
+  DROP COLUMN "family_id";
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create_signing_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS "signing_keys" (
+  "kid" VARCHAR(36) NOT NULL,
+  "algorithm" VARCHAR(16) NOT NULL,
+  "public_key" BLOB NOT NULL,
+  "private_key" BLOB NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "activated_at" TIMESTAMP NOT NULL,
+  "expired_at" TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY ("kid"),
+  INDEX "signing_key_expired_at_idx" ("expired_at" ASC))
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+`,
+		Down: `
+DROP TABLE IF EXISTS "signing_keys";
+`,
+	},
+	{
+		Version: 9,
+		Name:    "create_login_attempts",
+		Up: `
+CREATE TABLE IF NOT EXISTS "login_attempts" (
+  "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+  "user_id" BIGINT(20) UNSIGNED NOT NULL DEFAULT 0,
+  "username" VARCHAR(64) NOT NULL DEFAULT '',
+  "ip" VARCHAR(64) NOT NULL DEFAULT '',
+  "outcome" VARCHAR(16) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("id"),
+  INDEX "login_attempt_user_id_idx" ("user_id" ASC, "created_at" ASC),
+  INDEX "login_attempt_username_idx" ("username" ASC, "created_at" ASC),
+  INDEX "login_attempt_ip_idx" ("ip" ASC, "created_at" ASC),
+  INDEX "login_attempt_created_at_idx" ("created_at" ASC))
+ENGINE = InnoDB
+AUTO_INCREMENT = 1
+DEFAULT CHARACTER SET = utf8;
+`,
+		Down: `
+DROP TABLE IF EXISTS "login_attempts";
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create_mfa",
+		Up: `
+CREATE TABLE IF NOT EXISTS "user_totps" (
+  "user_id" BIGINT(20) UNSIGNED NOT NULL,
+  "secret" VARCHAR(64) NOT NULL,
+  "last_step" BIGINT(20) NOT NULL DEFAULT 0,
+  "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "confirmed_at" TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY ("user_id"))
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+CREATE TABLE IF NOT EXISTS "recovery_codes" (
+  "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+  "user_id" BIGINT(20) UNSIGNED NOT NULL,
+  "hash" VARCHAR(64) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "used_at" TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY ("id"),
+  INDEX "recovery_code_user_id_idx" ("user_id" ASC, "hash" ASC))
+ENGINE = InnoDB
+AUTO_INCREMENT = 1
+DEFAULT CHARACTER SET = utf8;
+`,
+		Down: `
+DROP TABLE IF EXISTS "recovery_codes";
+DROP TABLE IF EXISTS "user_totps";
+`,
+	},
+	{
+		Version: 11,
+		Name:    "create_user_tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS "user_tokens" (
+  "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+  "user_id" BIGINT(20) UNSIGNED NOT NULL,
+  "purpose" VARCHAR(32) NOT NULL,
+  "hash" VARCHAR(64) NOT NULL,
+  "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  "expired_at" TIMESTAMP NOT NULL,
+  "used_at" TIMESTAMP NULL DEFAULT NULL,
+  PRIMARY KEY ("id"),
+  INDEX "user_token_hash_idx" ("hash" ASC),
+  INDEX "user_token_user_id_idx" ("user_id" ASC, "purpose" ASC))
+ENGINE = InnoDB
+AUTO_INCREMENT = 1
+DEFAULT CHARACTER SET = utf8;
+`,
+		Down: `
+DROP TABLE IF EXISTS "user_tokens";
+`,
+	},
+	{
+		Version: 12,
+		Name:    "add_email_verification",
+		Up: `
+ALTER TABLE "users"
+  ADD COLUMN "email_verified_at" TIMESTAMP NULL DEFAULT NULL AFTER "updated_at",
+  ADD COLUMN "pending_email" VARCHAR(64) NOT NULL DEFAULT '' AFTER "email_verified_at";
+`,
+		Down: `
+ALTER TABLE "users"
+  DROP COLUMN "pending_email",
+  DROP COLUMN "email_verified_at";
+`,
+	},
+	{
+		Version: 13,
+		Name:    "create_bunch_parents",
+		Up: `
+CREATE TABLE IF NOT EXISTS "bunch_parents" (
+  "id" BIGINT(20) UNSIGNED NOT NULL AUTO_INCREMENT,
+  "bunch_id" BIGINT(20) UNSIGNED NOT NULL,
+  "parent_id" BIGINT(20) UNSIGNED NOT NULL,
+  "updated_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY ("id"),
+  INDEX "bunch_parent_parent_id_idx" ("parent_id" ASC),
+  UNIQUE INDEX "bunch_parent_uniq" ("bunch_id" ASC, "parent_id" ASC),
+  CONSTRAINT "bunch_id_on_bunch_parent"
+    FOREIGN KEY ("bunch_id")
+    REFERENCES "bunches" ("id")
+    ON DELETE CASCADE
+    ON UPDATE CASCADE,
+  CONSTRAINT "parent_id_on_bunch_parent"
+    FOREIGN KEY ("parent_id")
+    REFERENCES "bunches" ("id")
+    ON DELETE CASCADE
+    ON UPDATE CASCADE)
+ENGINE = InnoDB
+AUTO_INCREMENT = 1
+DEFAULT CHARACTER SET = utf8;
+`,
+		Down: `
+DROP TABLE IF EXISTS "bunch_parents";
+`,
+	},
+	{
+		Version: 14,
+		Name:    "add_token_revocation",
+		Up: `
+ALTER TABLE "token_histories"
+  MODIFY COLUMN "created_at" TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  ADD COLUMN "revoked_at" TIMESTAMP NULL DEFAULT NULL AFTER "rotated_at",
+  ADD INDEX "token_history_user_id_idx" ("user_id" ASC),
+  ADD INDEX "token_history_expired_at_idx" ("expired_at" ASC);
+`,
+		Down: `
+ALTER TABLE "token_histories"
+  DROP INDEX "token_history_expired_at_idx",
+  DROP INDEX "token_history_user_id_idx",
+  DROP COLUMN "revoked_at",
+  MODIFY COLUMN "created_at" TIMESTAMP NOT NULL;
+`,
+	},
+	{
+		Version: 15,
+		Name:    "create_storage_locks",
+		Up: `
+CREATE TABLE IF NOT EXISTS "storage_locks" (
+  "name" VARCHAR(32) NOT NULL,
+  PRIMARY KEY ("name"))
+ENGINE = InnoDB
+DEFAULT CHARACTER SET = utf8;
+INSERT INTO "storage_locks" ("name") VALUES ('bunch_parents');
+`,
+		Down: `
+DROP TABLE IF EXISTS "storage_locks";
+`,
+	},
+	{
+		Version: 16,
+		Name:    "provision_resource_server",
+		Up: `
+INSERT IGNORE INTO "keys" ("name", "desc") VALUES ('introspect_token', 'Introspect a token');
+INSERT IGNORE INTO "bunches" ("name", "desc", "active") VALUES ('resource_server', 'Resource server', 1);
+INSERT IGNORE INTO "bunch_keys" ("bunch_id", "key_id")
+  SELECT b."id", k."id" FROM "bunches" b, "keys" k
+  WHERE b."name" = 'resource_server' AND k."name" = 'introspect_token';
+`,
+		Down: `
+DELETE FROM "bunch_keys" WHERE "bunch_id" IN (SELECT "id" FROM "bunches" WHERE "name" = 'resource_server');
+DELETE FROM "user_bunches" WHERE "bunch_id" IN (SELECT "id" FROM "bunches" WHERE "name" = 'resource_server');
+DELETE FROM "bunch_parents" WHERE "bunch_id" IN (SELECT "id" FROM "bunches" WHERE "name" = 'resource_server')
+  OR "parent_id" IN (SELECT "id" FROM "bunches" WHERE "name" = 'resource_server');
+DELETE FROM "bunches" WHERE "name" = 'resource_server';
+DELETE FROM "keys" WHERE "name" = 'introspect_token';
+`,
+	},
+}
 
 var dropDatabase = `
+DROP TABLE IF EXISTS "schema_migrations";
 DROP TABLE IF EXISTS "user_bunches";
+DROP TABLE IF EXISTS "bunch_parents";
 DROP TABLE IF EXISTS "bunch_keys";
 DROP TABLE IF EXISTS "keys";
 DROP TABLE IF EXISTS "bunches";
 DROP TABLE IF EXISTS "users";
 DROP TABLE IF EXISTS "token_histories";
+DROP TABLE IF EXISTS "signing_keys";
+DROP TABLE IF EXISTS "login_attempts";
+DROP TABLE IF EXISTS "user_totps";
+DROP TABLE IF EXISTS "recovery_codes";
+DROP TABLE IF EXISTS "user_tokens";
+DROP TABLE IF EXISTS "storage_locks";
 `
 
-// default password: "password"
+// default password: "password", the rows provisioned by migrations are inserted again with the ids the seed
+// refers to
 var seedingData = `
+DELETE FROM "bunch_keys" WHERE "bunch_id" IN (SELECT "id" FROM "bunches" WHERE "name" = 'resource_server');
+DELETE FROM "bunches" WHERE "name" = 'resource_server';
+DELETE FROM "keys" WHERE "name" = 'introspect_token';
 INSERT INTO "keys" (id, "name", "desc") VALUES (1, 'add_key', 'Add a key');
 INSERT INTO "keys" (id, "name", "desc") VALUES (2, 'modify_key', 'modify a key');
 INSERT INTO "keys" (id, "name", "desc") VALUES (3, 'get_key', 'get a key');
@@ -122,13 +402,10 @@ INSERT INTO "keys" (id, "name", "desc") VALUES (7, 'get_bunch', 'Get bunch');
 INSERT INTO "keys" (id, "name", "desc") VALUES (8, 'query_bunch', 'Query bunches');
 INSERT INTO "keys" (id, "name", "desc") VALUES (9, 'add_user', 'add_user');
 INSERT INTO "keys" (id, "name", "desc") VALUES (10 ,'modify_user', 'modify_user');
+INSERT INTO "keys" (id, "name", "desc") VALUES (11, 'introspect_token', 'Introspect a token');
 INSERT INTO bunches (id, "name", "desc", "active") VALUES (1, 'admin_role', 'Admin role', 1);
 INSERT INTO bunches (id, "name", "desc", "active") VALUES (2, 'staff_role', 'Staff role', 1);
-INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (1, 1, 1);
-INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (2, 1, 2);
-INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (3, 1, 3);
-INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (4, 1, 4);
-INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (5, 1, 5);
+INSERT INTO bunches (id, "name", "desc", "active") VALUES (3, 'resource_server', 'Resource server', 1);
 INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (6, 1, 6);
 INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (7, 1, 7);
 INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (8, 1, 8);
@@ -139,6 +416,8 @@ INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (12, 2, 2);
 INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (13, 2, 3);
 INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (14, 2, 4);
 INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (15, 2, 5);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (16, 3, 11);
+INSERT INTO bunch_parents (id, bunch_id, parent_id) VALUES (1, 1, 2);
 INSERT INTO "users" (id, full_name, username, hash, salt, email) VALUES (1, 'full_name', 'admin', '$2a$10$AdPyZkgVv70bXz9JvZLpH.CCQEzb8MbK8vMHIVQyCKtWestIyK46K', '123', 'admin@test.com');
 INSERT INTO "users" (id, full_name, username, hash, salt, email) VALUES (2, 'full_name', 'staff', '$2a$10$AdPyZkgVv70bXz9JvZLpH.CCQEzb8MbK8vMHIVQyCKtWestIyK46K', '123', 'staff@test.com');
 INSERT INTO user_bunches (id, user_id, bunch_id) VALUES (1, 1, 1);
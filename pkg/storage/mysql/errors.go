@@ -0,0 +1,115 @@
+package mysql
+
+import (
+	"regexp"
+	"strings"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// mysql server error numbers
+const (
+	errDupEntry        = 1062
+	errRowIsReferenced = 1451
+	errNoReferencedRow = 1452
+)
+
+type constraint struct {
+	entity string
+	field  string
+}
+
+// uniqueIndexes maps unique index names in db_schema.go to the violated field, primary keys which are set by
+// the caller are named after their table
+var uniqueIndexes = map[string]constraint{
+	"keys_key_uniq":           {"key", "name"},
+	"bunch_name_uniq":         {"bunch", "name"},
+	"users_username_uniq":     {"user", "username"},
+	"users_email_uniq":        {"user", "email"},
+	"bunch_key_uniq":          {"bunch_key", "key_id"},
+	"user_bunch_uniq":         {"user_bunch", "bunch_id"},
+	"bunch_parent_uniq":       {"bunch_parent", "parent_id"},
+	"uid_uniq":                {"token_history", "uid"},
+	"token_histories.PRIMARY": {"token_history", "uid"},
+	"signing_keys.PRIMARY":    {"signing_key", "kid"},
+	"user_totps.PRIMARY":      {"totp", "user_id"},
+}
+
+// legacyForeignKeys maps the foreign key names in db_schema.go which do not follow the
+// "<field>_on_<entity>" convention
+var legacyForeignKeys = map[string]constraint{
+	"role_id_on_bunch_key": {"bunch_key", "bunch_id"},
+}
+
+// identifierRegexp matches the quoted names in server messages, such as 'users.users_email_uniq' or
+// `key_id_on_bunch_key`
+var identifierRegexp = regexp.MustCompile("['`]([A-Za-z0-9_.]+)['`]")
+
+// mapError converts driver errors into storage errors, other errors are returned untouched. The violated
+// constraint is found by its name in the server message, its entity and field are left empty when the
+// server does not name it.
+func mapError(err error) error {
+	myErr, ok := err.(*driver.MySQLError)
+	if !ok {
+		return err
+	}
+
+	switch myErr.Number {
+	case errDupEntry:
+		// the index is named after the duplicated value, which may look like an index name too
+		names := identifiers(myErr.Message)
+		for i := len(names) - 1; i >= 0; i-- {
+			if c, ok := uniqueIndex(names[i]); ok {
+				return &storage.DuplicateError{Entity: c.entity, Field: c.field, Err: err}
+			}
+		}
+		return &storage.DuplicateError{Err: err}
+
+	case errRowIsReferenced, errNoReferencedRow:
+		for _, name := range identifiers(myErr.Message) {
+			if c, ok := foreignKey(name); ok {
+				return &storage.ForeignKeyError{Entity: c.entity, Field: c.field, Err: err}
+			}
+		}
+		return &storage.ForeignKeyError{Err: err}
+	}
+
+	return err
+}
+
+// identifiers returns the quoted names of a server message in order
+func identifiers(msg string) []string {
+	matches := identifierRegexp.FindAllStringSubmatch(msg, -1)
+
+	names := make([]string, 0, len(matches))
+	for _, m := range matches {
+		names = append(names, m[1])
+	}
+
+	return names
+}
+
+// uniqueIndex finds a unique index by name, mysql 8 prefixes the name by its table and mysql 5.7 does not
+func uniqueIndex(name string) (constraint, bool) {
+	if c, ok := uniqueIndexes[name]; ok {
+		return c, true
+	}
+
+	c, ok := uniqueIndexes[name[strings.LastIndex(name, ".")+1:]]
+	return c, ok
+}
+
+// foreignKey finds the entity and field of a foreign key named "<field>_on_<entity>"
+func foreignKey(name string) (constraint, bool) {
+	if c, ok := legacyForeignKeys[name]; ok {
+		return c, true
+	}
+
+	i := strings.LastIndex(name, "_on_")
+	if i <= 0 || i+len("_on_") == len(name) || strings.Contains(name, ".") {
+		return constraint{}, false
+	}
+
+	return constraint{name[i+len("_on_"):], name[:i]}, true
+}
@@ -0,0 +1,64 @@
+package mysql
+
+import (
+	"errors"
+	"testing"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+func TestMapError(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_map_duplicated_entries", func(t *testing.T) {
+		for msg, want := range map[string]storage.DuplicateError{
+			// mysql 8 prefixes index names by their table
+			"Duplicate entry 'bob' for key 'users.users_username_uniq'":         {Entity: "user", Field: "username"},
+			"Duplicate entry 'bob@test.com' for key 'users_email_uniq'":         {Entity: "user", Field: "email"},
+			"Duplicate entry 'keys_key_uniq' for key 'bunches.bunch_name_uniq'": {Entity: "bunch", Field: "name"},
+			"Duplicate entry '1-2' for key 'bunch_parents.bunch_parent_uniq'":   {Entity: "bunch_parent", Field: "parent_id"},
+			"Duplicate entry 'a1b2' for key 'token_histories.PRIMARY'":          {Entity: "token_history", Field: "uid"},
+			"Duplicate entry 'a1b2' for key 'PRIMARY'":                          {},
+		} {
+			err := mapError(&driver.MySQLError{Number: errDupEntry, Message: msg})
+			require.True(t, errors.Is(err, storage.ErrDuplicate), msg)
+
+			var dup *storage.DuplicateError
+			require.True(t, errors.As(err, &dup), msg)
+			require.Equal(t, want.Entity, dup.Entity, msg)
+			require.Equal(t, want.Field, dup.Field, msg)
+		}
+	})
+
+	t.Run("success_map_foreign_keys", func(t *testing.T) {
+		for msg, want := range map[string]storage.ForeignKeyError{
+			"Cannot add or update a child row: a foreign key constraint fails (`auth`.`bunch_keys`, " +
+				"CONSTRAINT `key_id_on_bunch_key` FOREIGN KEY (`key_id`) REFERENCES `keys` (`id`) " +
+				"ON DELETE CASCADE ON UPDATE CASCADE)": {Entity: "bunch_key", Field: "key_id"},
+			"Cannot add or update a child row: a foreign key constraint fails (`auth`.`bunch_keys`, " +
+				"CONSTRAINT `role_id_on_bunch_key` FOREIGN KEY (`bunch_id`) REFERENCES `bunches` (`id`) " +
+				"ON DELETE CASCADE ON UPDATE CASCADE)": {Entity: "bunch_key", Field: "bunch_id"},
+			"Cannot add or update a child row: a foreign key constraint fails (`auth`.`bunch_parents`, " +
+				"CONSTRAINT `parent_id_on_bunch_parent` FOREIGN KEY (`parent_id`) REFERENCES `bunches` (`id`) " +
+				"ON DELETE CASCADE ON UPDATE CASCADE)": {Entity: "bunch_parent", Field: "parent_id"},
+			"Cannot add or update a child row: a foreign key constraint fails": {},
+		} {
+			err := mapError(&driver.MySQLError{Number: errNoReferencedRow, Message: msg})
+			require.True(t, errors.Is(err, storage.ErrForeignKey), msg)
+
+			var fk *storage.ForeignKeyError
+			require.True(t, errors.As(err, &fk), msg)
+			require.Equal(t, want.Entity, fk.Entity, msg)
+			require.Equal(t, want.Field, fk.Field, msg)
+		}
+	})
+
+	t.Run("success_keep_other_errors", func(t *testing.T) {
+		other := &driver.MySQLError{Number: errNoSuchTable, Message: "Table 'auth.missing' doesn't exist"}
+		require.Equal(t, other, mapError(other))
+
+		require.Equal(t, storage.ErrNotFound, mapError(storage.ErrNotFound))
+	})
+}
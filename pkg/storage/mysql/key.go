@@ -1,8 +1,8 @@
 package mysql
 
 import (
+	"context"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -10,29 +10,32 @@ import (
 	"github.com/vespaiach/auth_service/pkg/storage"
 )
 
+var _ storage.KeyStorer = (*KeyMysqlStorer)(nil)
+
 // KeyMysqlStorer implements key's storages in mysql db
 type KeyMysqlStorer struct {
-	db *sqlx.DB
+	db executor
 }
 
 // KeyMysqlStorer creates a new instance of KeyMysqlStorer
-func NewKeyMysqlStorer(db *sqlx.DB) *KeyMysqlStorer {
+func NewKeyMysqlStorer(db executor) *KeyMysqlStorer {
 	return &KeyMysqlStorer{
 		db,
 	}
 }
 
-func (st *KeyMysqlStorer) Insert(k storage.CreateKey) (int64, error) {
+func (st *KeyMysqlStorer) Insert(ctx context.Context, k storage.CreateKey) (int64, error) {
 	sql := "INSERT INTO `keys` (`name`, `desc`, updated_at) VALUES (?, ?, ?);"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return 0, err
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(k.Name, k.Desc, time.Now())
+	res, err := stmt.ExecContext(ctx, k.Name, k.Desc, time.Now())
 	if err != nil {
-		return 0, err
+		return 0, mapError(err)
 	}
 
 	lastID, err := res.LastInsertId()
@@ -43,7 +46,7 @@ func (st *KeyMysqlStorer) Insert(k storage.CreateKey) (int64, error) {
 	return lastID, nil
 }
 
-func (st *KeyMysqlStorer) Update(k storage.UpdateKey) error {
+func (st *KeyMysqlStorer) Update(ctx context.Context, k storage.UpdateKey) error {
 	var (
 		sql      string = "UPDATE `keys` SET %s WHERE id = :id;"
 		fields   string
@@ -58,6 +61,7 @@ func (st *KeyMysqlStorer) Update(k storage.UpdateKey) error {
 	}
 	if len(k.Desc) > 0 {
 		fields += prefix + " `desc` = :desc "
+		prefix = ","
 		updating["desc"] = k.Desc
 	}
 
@@ -66,53 +70,54 @@ func (st *KeyMysqlStorer) Update(k storage.UpdateKey) error {
 		updating["updated_at"] = time.Now()
 		updating["id"] = k.ID
 
-		_, err := st.db.NamedExec(fmt.Sprintf(sql, fields), updating)
+		_, err := st.db.NamedExecContext(ctx, fmt.Sprintf(sql, fields), updating)
 		if err != nil {
-			return err
+			return mapError(err)
 		}
 	}
 
 	return nil
 }
 
-func (st *KeyMysqlStorer) Delete(id int64) error {
+func (st *KeyMysqlStorer) Delete(ctx context.Context, id int64) error {
 	sqlbunchkey := "DELETE FROM `bunch_keys` WHERE key_id=:id"
 	sqlkey := "DELETE FROM `keys` WHERE id=:id"
 	deleting := map[string]interface{}{"id": id}
 
-	tx, err := st.db.Beginx()
-	if err != nil {
-		return err
-	}
-
-	_, err = tx.NamedExec(sqlbunchkey, deleting)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+	return inTx(ctx, st.db, func(tx executor) error {
+		_, err := tx.NamedExecContext(ctx, sqlbunchkey, deleting)
+		if err != nil {
+			return err
+		}
 
-	_, err = tx.NamedExec(sqlkey, deleting)
-	if err != nil {
-		tx.Rollback()
-		return err
-	}
+		res, err := tx.NamedExecContext(ctx, sqlkey, deleting)
+		if err != nil {
+			return mapError(err)
+		}
 
-	tx.Commit()
+		affected, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if affected == 0 {
+			return storage.ErrNotFound
+		}
 
-	return nil
+		return nil
+	})
 }
 
-func (st *KeyMysqlStorer) Get(id int64) (*storage.Key, error) {
+func (st *KeyMysqlStorer) Get(ctx context.Context, id int64) (*storage.Key, error) {
 	sql := "SELECT id, `name`, `desc`, updated_at FROM `keys` WHERE id = ? LIMIT 1;"
 
-	rows, err := st.db.Queryx(sql, id)
+	rows, err := st.db.QueryxContext(ctx, sql, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	key := new(storage.Key)
@@ -123,17 +128,17 @@ func (st *KeyMysqlStorer) Get(id int64) (*storage.Key, error) {
 	return key, nil
 }
 
-func (st *KeyMysqlStorer) GetByName(name string) (*storage.Key, error) {
+func (st *KeyMysqlStorer) GetByName(ctx context.Context, name string) (*storage.Key, error) {
 	sql := "SELECT id, `name`, `desc`, updated_at FROM `keys` WHERE `name` = ? LIMIT 1;"
 
-	rows, err := st.db.Queryx(sql, name)
+	rows, err := st.db.QueryxContext(ctx, sql, name)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	key := new(storage.Key)
@@ -144,25 +149,22 @@ func (st *KeyMysqlStorer) GetByName(name string) (*storage.Key, error) {
 	return key, nil
 }
 
-func (st *KeyMysqlStorer) Query(queries storage.QueryKey, sorts storage.SortKey) ([]*storage.Key, int64, error) {
+func (st *KeyMysqlStorer) Query(ctx context.Context, queries storage.QueryKey, sorts storage.SortKey) ([]*storage.Key, int64, error) {
 	var (
 		sql      string = "SELECT id, `name`, `desc`, updated_at FROM `keys` %s ORDER BY %s LIMIT :offset, :limit;"
 		sqlcount string = "SELECT count(id) FROM `keys` %s;"
 
-		orderPrefix   string
-		order         string
-		wherePrefix   = "WHERE "
-		where         string
-		wg            sync.WaitGroup
-		queryErr      error
-		countTotalErr error
-		results       []*storage.Key
-		total         int64
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.Key
+		total       int64
 	)
 
 	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
 	if queries.Limit == 0 {
-		filter["queries"] = share.DefaultLimit
+		filter["limit"] = share.DefaultLimit
 	}
 
 	if len(queries.Name) > 0 {
@@ -211,13 +213,10 @@ func (st *KeyMysqlStorer) Query(queries storage.QueryKey, sorts storage.SortKey)
 	sql = fmt.Sprintf(sql, where, order)
 	sqlcount = fmt.Sprintf(sqlcount, where)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		rows, err := st.db.NamedQuery(sql, filter)
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
 		if err != nil {
-			queryErr = err
-			return
+			return err
 		}
 		defer rows.Close()
 
@@ -226,45 +225,17 @@ func (st *KeyMysqlStorer) Query(queries storage.QueryKey, sorts storage.SortKey)
 			key := new(storage.Key)
 			err := rows.Scan(&key.ID, &key.Name, &key.Desc, &key.UpdatedAt)
 			if err != nil {
-				queryErr = err
-				return
+				return err
 			}
 			results = append(results, key)
 		}
 
-		if rows.Err() != nil {
-			queryErr = rows.Err()
-			return
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		rows, err := st.db.NamedQuery(sqlcount, filter)
-		if err != nil {
-			countTotalErr = err
-			return
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			err := rows.Scan(&total)
-			if err != nil {
-				countTotalErr = err
-				return
-			}
-		}
-	}()
-
-	wg.Wait()
-
-	if queryErr != nil {
-		return nil, 0, queryErr
-	}
-	if countTotalErr != nil {
-		return nil, 0, countTotalErr
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return results, total, nil
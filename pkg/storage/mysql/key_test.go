@@ -1,6 +1,8 @@
 package mysql
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -17,7 +19,7 @@ func TestKeyMysqlStorer_Insert(t *testing.T) {
 		key := test.mig.createUniqueString("key")
 		desc := test.mig.createUniqueString("desc")
 
-		id, err := test.kst.Insert(storage.CreateKey{Name: key, Desc: desc})
+		id, err := test.kst.Insert(context.Background(), storage.CreateKey{Name: key, Desc: desc})
 		require.Nil(t, err)
 		require.NotZero(t, id)
 	})
@@ -34,9 +36,14 @@ func TestKeyMysqlStorer_Insert(t *testing.T) {
 			fields["desc"] = desc1
 		})
 
-		dupID, errDup := test.kst.Insert(storage.CreateKey{Name: key, Desc: desc2})
-		require.NotNil(t, errDup)
+		dupID, errDup := test.kst.Insert(context.Background(), storage.CreateKey{Name: key, Desc: desc2})
+		require.True(t, errors.Is(errDup, storage.ErrDuplicate))
 		require.Zero(t, dupID)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(errDup, &dup))
+		require.Equal(t, "key", dup.Entity)
+		require.Equal(t, "name", dup.Field)
 	})
 }
 
@@ -50,7 +57,7 @@ func TestKeyMysqlStorer_Update(t *testing.T) {
 		key := test.mig.createUniqueString("key")
 		desc := test.mig.createUniqueString("desc")
 
-		err := test.kst.Update(storage.UpdateKey{
+		err := test.kst.Update(context.Background(), storage.UpdateKey{
 			ID:   id,
 			Name: key,
 			Desc: desc,
@@ -67,9 +74,16 @@ func TestKeyMysqlStorer_Delete(t *testing.T) {
 
 		id := test.mig.createSeedingServiceKey(nil)
 
-		err := test.kst.Delete(id)
+		err := test.kst.Delete(context.Background(), id)
 		require.Nil(t, err)
 	})
+
+	t.Run("fail_delete_a_missing_key", func(t *testing.T) {
+		t.Parallel()
+
+		err := test.kst.Delete(context.Background(), -1)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
 }
 
 func TestKeyMysqlStorer_Get(t *testing.T) {
@@ -86,13 +100,21 @@ func TestKeyMysqlStorer_Get(t *testing.T) {
 			fields["desc"] = desc
 		})
 
-		found, err := test.kst.Get(id)
+		found, err := test.kst.Get(context.Background(), id)
 		require.Nil(t, err)
 		require.NotNil(t, found)
 		require.Equal(t, found.ID, id)
 		require.Equal(t, found.Name, key)
 		require.Equal(t, found.Desc, desc)
 	})
+
+	t.Run("fail_get_a_missing_key", func(t *testing.T) {
+		t.Parallel()
+
+		found, err := test.kst.Get(context.Background(), -1)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+		require.Nil(t, found)
+	})
 }
 
 func TestKeyMysqlStorer_GetByName(t *testing.T) {
@@ -109,7 +131,7 @@ func TestKeyMysqlStorer_GetByName(t *testing.T) {
 			fields["desc"] = desc
 		})
 
-		found, err := test.kst.GetByName(key)
+		found, err := test.kst.GetByName(context.Background(), key)
 		require.Nil(t, err)
 		require.NotNil(t, found)
 		require.Equal(t, found.ID, id)
@@ -138,7 +160,7 @@ func TestKeyMysqlStorer_Query(t *testing.T) {
 		test.mig.createSeedingServiceKey(func(fields map[string]interface{}) { fields["name"] = name5 })
 		test.mig.createSeedingServiceKey(func(fields map[string]interface{}) { fields["name"] = name6 })
 
-		rows, total, err := test.kst.Query(storage.QueryKey{
+		rows, total, err := test.kst.Query(context.Background(), storage.QueryKey{
 			Limit:  2,
 			Offset: 2,
 			Name:   prefix,
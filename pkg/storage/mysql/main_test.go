@@ -15,8 +15,17 @@ type testApp struct {
 	kst  *KeyMysqlStorer
 	bst  *BunchMysqlStorer
 	bkst *BunchKeyMysqlStorer
+	bpst *BunchParentMysqlStorer
 	ust  *UserMysqlStorage
 	ubst *UserBunchMysqlStorage
+	thst *TokenHistoryMysqlStorer
+	skst *SigningKeyMysqlStorer
+	last *LoginAttemptMysqlStorer
+	tost *TOTPMysqlStorer
+	rcst *RecoveryCodeMysqlStorer
+	utst *UserTokenMysqlStorer
+	prst *PermissionMysqlResolver
+	repo *Repository
 }
 
 var test *testApp
@@ -33,8 +42,17 @@ func TestMain(m *testing.M) {
 		kst:  NewKeyMysqlStorer(db),
 		bst:  NewBunchMysqlStorer(db),
 		bkst: NewBunchKeyMysqlStorer(db),
+		bpst: NewBunchParentMysqlStorer(db),
 		ust:  NewUserMysqlStorage(db),
 		ubst: NewUserBunchMysqlStorage(db),
+		thst: NewTokenHistoryMysqlStorer(db),
+		skst: NewSigningKeyMysqlStorer(db),
+		last: NewLoginAttemptMysqlStorer(db),
+		tost: NewTOTPMysqlStorer(db),
+		rcst: NewRecoveryCodeMysqlStorer(db),
+		utst: NewUserTokenMysqlStorer(db),
+		prst: NewPermissionMysqlResolver(db),
+		repo: NewRepository(db),
 	}
 
 	test.mig.Drop()
@@ -0,0 +1,262 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	_ storage.TOTPStorer         = (*TOTPMysqlStorer)(nil)
+	_ storage.RecoveryCodeStorer = (*RecoveryCodeMysqlStorer)(nil)
+)
+
+// TOTPMysqlStorer implements db's storage for totp secrets
+type TOTPMysqlStorer struct {
+	db executor
+}
+
+// NewTOTPMysqlStorer creates new instance of TOTPMysqlStorer
+func NewTOTPMysqlStorer(db executor) *TOTPMysqlStorer {
+	return &TOTPMysqlStorer{
+		db,
+	}
+}
+
+func (st *TOTPMysqlStorer) Insert(ctx context.Context, t storage.CreateTOTP) error {
+	sql := "INSERT INTO user_totps (user_id, secret, created_at) VALUES (?, ?, ?);"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, t.UserID, t.Secret, time.Now())
+	if err != nil {
+		return mapError(err)
+	}
+
+	return nil
+}
+
+func (st *TOTPMysqlStorer) Get(ctx context.Context, userID int64) (*storage.TOTP, error) {
+	var (
+		t           = new(storage.TOTP)
+		confirmedAt sql.NullTime
+	)
+
+	err := st.db.QueryRowxContext(ctx, "SELECT user_id, secret, last_step, created_at, confirmed_at FROM user_totps "+
+		"WHERE user_id = ? LIMIT 1;", userID).Scan(&t.UserID, &t.Secret, &t.LastStep, &t.CreatedAt, &confirmedAt)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.ConfirmedAt = confirmedAt.Time
+
+	return t, nil
+}
+
+// Confirm confirms the unconfirmed secret of a user with the step of its first code
+func (st *TOTPMysqlStorer) Confirm(ctx context.Context, userID int64, step int64) (bool, error) {
+	return st.update(ctx, "UPDATE user_totps SET confirmed_at = ?, last_step = ? "+
+		"WHERE user_id = ? AND confirmed_at IS NULL;", time.Now(), step, userID)
+}
+
+// Use records the step of an accepted code when it follows the last one
+func (st *TOTPMysqlStorer) Use(ctx context.Context, userID int64, step int64) (bool, error) {
+	return st.update(ctx, "UPDATE user_totps SET last_step = ? "+
+		"WHERE user_id = ? AND confirmed_at IS NOT NULL AND last_step < ?;", step, userID, step)
+}
+
+func (st *TOTPMysqlStorer) Delete(ctx context.Context, userID int64) error {
+	updated, err := st.update(ctx, "DELETE FROM user_totps WHERE user_id = ?;", userID)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+// update runs sql and tells whether it changed a row
+func (st *TOTPMysqlStorer) update(ctx context.Context, sql string, args ...interface{}) (bool, error) {
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, args...)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// RecoveryCodeMysqlStorer implements db's storage for recovery codes
+type RecoveryCodeMysqlStorer struct {
+	db executor
+}
+
+// NewRecoveryCodeMysqlStorer creates new instance of RecoveryCodeMysqlStorer
+func NewRecoveryCodeMysqlStorer(db executor) *RecoveryCodeMysqlStorer {
+	return &RecoveryCodeMysqlStorer{
+		db,
+	}
+}
+
+func (st *RecoveryCodeMysqlStorer) Insert(ctx context.Context, c storage.CreateRecoveryCode) (int64, error) {
+	sql := "INSERT INTO recovery_codes (user_id, hash, created_at) VALUES (?, ?, ?);"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, c.UserID, c.Hash, time.Now())
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (st *RecoveryCodeMysqlStorer) Query(ctx context.Context, queries storage.QueryRecoveryCode, sorts storage.SortRecoveryCode) ([]*storage.RecoveryCode, int64, error) {
+	var (
+		sql         = "SELECT id, user_id, hash, created_at, used_at FROM recovery_codes %s ORDER BY %s LIMIT :offset, :limit;"
+		sqlcount    = "SELECT count(id) FROM recovery_codes %s;"
+		direction   = share.Ascendant
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.RecoveryCode
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.UserID > 0 {
+		filter["user_id"] = queries.UserID
+		where += wherePrefix + "user_id = :user_id"
+		wherePrefix = " AND "
+	}
+
+	if queries.Used.IsSet {
+		if queries.Used.Bool {
+			where += wherePrefix + "used_at IS NOT NULL"
+		} else {
+			where += wherePrefix + "used_at IS NULL"
+		}
+		wherePrefix = " AND "
+	}
+
+	if sorts.CreatedAt != share.BiDirection {
+		direction = sorts.CreatedAt
+	}
+	order := fmt.Sprintf("created_at %[1]s, id %[1]s", getOrderDirection(direction))
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.RecoveryCode, 0, queries.Limit)
+		for rows.Next() {
+			c, err := scanRecoveryCode(rows)
+			if err != nil {
+				return err
+			}
+			results = append(results, c)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+func scanRecoveryCode(rows *sqlx.Rows) (*storage.RecoveryCode, error) {
+	var (
+		c      = new(storage.RecoveryCode)
+		usedAt sql.NullTime
+	)
+
+	if err := rows.Scan(&c.ID, &c.UserID, &c.Hash, &c.CreatedAt, &usedAt); err != nil {
+		return nil, err
+	}
+
+	c.UsedAt = usedAt.Time
+
+	return c, nil
+}
+
+// Use marks the unused code of a user matching hash as used
+func (st *RecoveryCodeMysqlStorer) Use(ctx context.Context, userID int64, hash string) (bool, error) {
+	sql := "UPDATE recovery_codes SET used_at = ? WHERE user_id = ? AND hash = ? AND used_at IS NULL;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, time.Now(), userID, hash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// DeleteUser deletes the codes of a user and returns the number of deleted rows
+func (st *RecoveryCodeMysqlStorer) DeleteUser(ctx context.Context, userID int64) (int64, error) {
+	sql := "DELETE FROM recovery_codes WHERE user_id = ?;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,184 @@
+package mysql
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// seedPermissions creates a user holding key1 and key2 through an active bunch, key3 is only in an
+// inactive bunch of the user and key4 is not assigned to the user at all
+func seedPermissions(userActive bool) (userID int64, keys []string) {
+	keys = []string{
+		test.mig.createUniqueString("key"),
+		test.mig.createUniqueString("key"),
+		test.mig.createUniqueString("key"),
+		test.mig.createUniqueString("key"),
+	}
+
+	keyIDs := make([]int64, len(keys))
+	for i, name := range keys {
+		name := name
+		keyIDs[i] = test.mig.createSeedingServiceKey(func(fields map[string]interface{}) { fields["name"] = name })
+	}
+
+	userID = test.mig.createSeedingUser(func(fields map[string]interface{}) { fields["active"] = userActive })
+	activeID := test.mig.createSeedingBunch(nil)
+	inactiveID := test.mig.createSeedingBunch(func(fields map[string]interface{}) { fields["active"] = false })
+	otherID := test.mig.createSeedingBunch(nil)
+
+	test.mig.createSeedingUserBunch(userID, activeID)
+	test.mig.createSeedingUserBunch(userID, inactiveID)
+
+	test.mig.createSeedingBunchKey(activeID, keyIDs[0])
+	test.mig.createSeedingBunchKey(activeID, keyIDs[1])
+	test.mig.createSeedingBunchKey(inactiveID, keyIDs[1])
+	test.mig.createSeedingBunchKey(inactiveID, keyIDs[2])
+	test.mig.createSeedingBunchKey(otherID, keyIDs[3])
+
+	return userID, keys
+}
+
+func TestPermissionMysqlResolver_Keys(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_resolve_keys_of_active_bunches", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(true)
+
+		found, err := test.prst.Keys(context.Background(), userID)
+		require.Nil(t, err)
+		require.Len(t, found, 2)
+		require.ElementsMatch(t, keys[:2], []string{found[0].Name, found[1].Name})
+	})
+
+	t.Run("success_resolve_no_keys_of_inactive_user", func(t *testing.T) {
+		t.Parallel()
+
+		userID, _ := seedPermissions(false)
+
+		found, err := test.prst.Keys(context.Background(), userID)
+		require.Nil(t, err)
+		require.Len(t, found, 0)
+	})
+}
+
+func TestPermissionMysqlResolver_Has(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_check_keys", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(true)
+		ctx := context.Background()
+
+		ok, err := test.prst.HasKey(ctx, userID, keys[0])
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		ok, err = test.prst.HasKey(ctx, userID, keys[2])
+		require.Nil(t, err)
+		require.False(t, ok)
+
+		ok, err = test.prst.HasAll(ctx, userID, keys[0], keys[1], keys[0])
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		ok, err = test.prst.HasAll(ctx, userID, keys[0], keys[3])
+		require.Nil(t, err)
+		require.False(t, ok)
+
+		ok, err = test.prst.HasAny(ctx, userID, keys[2], keys[3], keys[1])
+		require.Nil(t, err)
+		require.True(t, ok)
+
+		ok, err = test.prst.HasAny(ctx, userID, keys[2], keys[3])
+		require.Nil(t, err)
+		require.False(t, ok)
+	})
+
+	t.Run("success_check_keys_of_inactive_user", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(false)
+
+		ok, err := test.prst.HasAny(context.Background(), userID, keys...)
+		require.Nil(t, err)
+		require.False(t, ok)
+	})
+}
+
+func TestPermissionMysqlResolver_Explain(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_explain_a_granted_key", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(true)
+
+		exp, err := test.prst.Explain(context.Background(), userID, keys[1])
+		require.Nil(t, err)
+		require.True(t, exp.Granted)
+		require.Len(t, exp.Grants, 1)
+		require.Len(t, exp.Denials, 0)
+		require.Equal(t, userID, exp.Grants[0].UserBunch.User.ID)
+		require.Empty(t, exp.User.Hash)
+		require.Empty(t, exp.Grants[0].UserBunch.User.Hash)
+		require.Equal(t, exp.Key.ID, exp.Grants[0].BunchKey.BunchKey.KeyID)
+		require.True(t, exp.Grants[0].UserBunch.Bunch.Active.Bool)
+	})
+
+	t.Run("success_explain_a_key_of_inactive_bunch", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(true)
+
+		exp, err := test.prst.Explain(context.Background(), userID, keys[2])
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Len(t, exp.Grants, 0)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.BunchInactive, exp.Denials[0].Reason)
+		require.NotNil(t, exp.Denials[0].Bunch)
+	})
+
+	t.Run("success_explain_a_key_out_of_bunches", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(true)
+
+		exp, err := test.prst.Explain(context.Background(), userID, keys[3])
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.KeyNotInBunches, exp.Denials[0].Reason)
+	})
+
+	t.Run("success_explain_a_key_of_inactive_user", func(t *testing.T) {
+		t.Parallel()
+
+		userID, keys := seedPermissions(false)
+
+		exp, err := test.prst.Explain(context.Background(), userID, keys[0])
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Len(t, exp.Grants, 1)
+		require.Equal(t, storage.UserInactive, exp.Denials[0].Reason)
+	})
+
+	t.Run("success_explain_a_missing_key", func(t *testing.T) {
+		t.Parallel()
+
+		userID, _ := seedPermissions(true)
+
+		exp, err := test.prst.Explain(context.Background(), userID, test.mig.createUniqueString("missing"))
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Nil(t, exp.Key)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.KeyMissing, exp.Denials[0].Reason)
+	})
+}
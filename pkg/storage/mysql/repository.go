@@ -1,10 +1,36 @@
 package mysql
 
 import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	driver "github.com/go-sql-driver/mysql"
 	_ "github.com/jinzhu/gorm/dialects/mysql"
 	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// mysql server error numbers which abort a transaction but are worth retrying
+const (
+	errLockWaitTimeout = 1205
+	errLockDeadlock    = 1213
 )
 
+// DefaultTxAttempts is the number of times WithTx runs a transaction aborted by a deadlock
+const DefaultTxAttempts = 3
+
+// executor is satisfied by both *sqlx.DB and *sqlx.Tx, so storers work in and out of transactions
+type executor interface {
+	sqlx.ExtContext
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+var _ storage.Repository = (*Repository)(nil)
+
 // Init database connection
 func InitDb(dsn string) (*sqlx.DB, error) {
 	db, err := sqlx.Open("mysql", dsn)
@@ -14,3 +40,147 @@ func InitDb(dsn string) (*sqlx.DB, error) {
 
 	return db, nil
 }
+
+// stores implements storage.Stores on top of an executor
+type stores struct {
+	ex executor
+}
+
+func (s *stores) Keys() storage.KeyStorer {
+	return NewKeyMysqlStorer(s.ex)
+}
+
+func (s *stores) Bunches() storage.BunchStorer {
+	return NewBunchMysqlStorer(s.ex)
+}
+
+func (s *stores) BunchKeys() storage.BunchKeyStorer {
+	return NewBunchKeyMysqlStorer(s.ex)
+}
+
+func (s *stores) BunchParents() storage.BunchParentStorer {
+	return NewBunchParentMysqlStorer(s.ex)
+}
+
+func (s *stores) Users() storage.UserStorer {
+	return NewUserMysqlStorage(s.ex)
+}
+
+func (s *stores) UserBunches() storage.UserBunchStorer {
+	return NewUserBunchMysqlStorage(s.ex)
+}
+
+func (s *stores) TokenHistories() storage.TokenHistoryStorer {
+	return NewTokenHistoryMysqlStorer(s.ex)
+}
+
+func (s *stores) SigningKeys() storage.SigningKeyStorer {
+	return NewSigningKeyMysqlStorer(s.ex)
+}
+
+func (s *stores) LoginAttempts() storage.LoginAttemptStorer {
+	return NewLoginAttemptMysqlStorer(s.ex)
+}
+
+func (s *stores) TOTPs() storage.TOTPStorer {
+	return NewTOTPMysqlStorer(s.ex)
+}
+
+func (s *stores) RecoveryCodes() storage.RecoveryCodeStorer {
+	return NewRecoveryCodeMysqlStorer(s.ex)
+}
+
+func (s *stores) UserTokens() storage.UserTokenStorer {
+	return NewUserTokenMysqlStorer(s.ex)
+}
+
+func (s *stores) Permissions() storage.PermissionResolver {
+	return NewPermissionMysqlResolver(s.ex)
+}
+
+// Repository implements storage.Repository in mysql db
+type Repository struct {
+	*stores
+	db       *sqlx.DB
+	Attempts int
+}
+
+// NewRepository creates new instance of Repository
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		stores:   &stores{db},
+		db:       db,
+		Attempts: DefaultTxAttempts,
+	}
+}
+
+// WithTx runs fn in a transaction and retries it on deadlocks and lock wait timeouts
+func (r *Repository) WithTx(ctx context.Context, fn func(tx storage.Stores) error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = r.runTx(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt >= r.Attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+func (r *Repository) runTx(ctx context.Context, fn func(tx storage.Stores) error) (err error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(&stores{tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isRetryable tells whether err aborted the transaction because of lock contention
+func isRetryable(err error) bool {
+	var myErr *driver.MySQLError
+	if !errors.As(err, &myErr) {
+		return false
+	}
+
+	return myErr.Number == errLockDeadlock || myErr.Number == errLockWaitTimeout
+}
+
+// inTx runs fn in the executor's transaction or, on a connection pool, in a new transaction
+func inTx(ctx context.Context, ex executor, fn func(tx executor) error) error {
+	db, ok := ex.(*sqlx.DB)
+	if !ok {
+		return fn(ex)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
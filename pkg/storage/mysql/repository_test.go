@@ -0,0 +1,130 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	driver "github.com/go-sql-driver/mysql"
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+func TestRepository_WithTx(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_commit_a_user_with_bunches", func(t *testing.T) {
+		t.Parallel()
+
+		username := test.mig.createUniqueString("username")
+		bunchID1 := test.mig.createSeedingBunch(nil)
+		bunchID2 := test.mig.createSeedingBunch(nil)
+
+		err := test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+			userID, err := tx.Users().Insert(context.Background(), storage.CreateUser{
+				FullName: "full name",
+				Username: username,
+				Email:    test.mig.createUniqueString("email"),
+				Hash:     "hash",
+				Salt:     "salt",
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, bunchID := range []int64{bunchID1, bunchID2} {
+				_, err = tx.UserBunches().Insert(context.Background(), storage.CreateUserBunch{UserID: userID, BunchID: bunchID})
+				if err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		require.Nil(t, err)
+
+		rows, total, err := test.ubst.Query(context.Background(), storage.QueryUserBunch{Username: username}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Len(t, rows, 2)
+	})
+
+	t.Run("success_rollback_on_error", func(t *testing.T) {
+		t.Parallel()
+
+		username := test.mig.createUniqueString("username")
+
+		err := test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+			userID, err := tx.Users().Insert(context.Background(), storage.CreateUser{
+				FullName: "full name",
+				Username: username,
+				Email:    test.mig.createUniqueString("email"),
+				Hash:     "hash",
+				Salt:     "salt",
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.UserBunches().Insert(context.Background(), storage.CreateUserBunch{UserID: userID, BunchID: 1 << 40})
+			return err
+		})
+		require.NotNil(t, err)
+
+		_, err = test.ust.GetByName(context.Background(), username)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_rollback_on_panic", func(t *testing.T) {
+		t.Parallel()
+
+		name := test.mig.createUniqueString("bunch")
+
+		require.Panics(t, func() {
+			test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+				_, err := tx.Bunches().Insert(context.Background(), storage.CreateBunch{Name: name, Desc: "desc"})
+				require.Nil(t, err)
+				panic("boom")
+			})
+		})
+
+		_, err := test.bst.GetByName(context.Background(), name)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_retry_on_deadlock", func(t *testing.T) {
+		t.Parallel()
+
+		name := test.mig.createUniqueString("bunch")
+		calls := 0
+
+		err := test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+			calls++
+			if calls == 1 {
+				return &driver.MySQLError{Number: errLockDeadlock, Message: "Deadlock found when trying to get lock"}
+			}
+
+			_, err := tx.Bunches().Insert(context.Background(), storage.CreateBunch{Name: name, Desc: "desc"})
+			return err
+		})
+		require.Nil(t, err)
+		require.Equal(t, 2, calls)
+
+		bunch, err := test.bst.GetByName(context.Background(), name)
+		require.Nil(t, err)
+		require.NotNil(t, bunch)
+	})
+
+	t.Run("fail_after_all_attempts", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+
+		err := test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+			calls++
+			return &driver.MySQLError{Number: errLockWaitTimeout, Message: "Lock wait timeout exceeded"}
+		})
+		require.NotNil(t, err)
+		require.Equal(t, DefaultTxAttempts, calls)
+	})
+}
@@ -0,0 +1,302 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.TokenHistoryStorer = (*TokenHistoryMysqlStorer)(nil)
+
+// TokenHistoryMysqlStorer implements db's storage for token histories
+type TokenHistoryMysqlStorer struct {
+	db executor
+}
+
+// NewTokenHistoryMysqlStorer creates new instance of TokenHistoryMysqlStorer
+func NewTokenHistoryMysqlStorer(db executor) *TokenHistoryMysqlStorer {
+	return &TokenHistoryMysqlStorer{
+		db,
+	}
+}
+
+const tokenHistoryColumns = "uid, user_id, access_token, refresh_token, remote_addr, x_forwarded_for, x_real_ip, " +
+	"user_agent, family_id, created_at, expired_at, refresh_expired_at, family_expired_at, rotated_at, revoked_at"
+
+func scanTokenHistory(rows *sqlx.Rows) (*storage.TokenHistory, error) {
+	var (
+		t                                                       = new(storage.TokenHistory)
+		refreshExpiredAt, familyExpiredAt, rotatedAt, revokedAt sql.NullTime
+	)
+
+	err := rows.Scan(&t.UID, &t.UserID, &t.AccessToken, &t.RefreshToken, &t.RemoteAddr, &t.XForwardedFor,
+		&t.XRealIP, &t.UserAgent, &t.FamilyID, &t.CreatedAt, &t.ExpiredAt, &refreshExpiredAt, &familyExpiredAt,
+		&rotatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.RefreshExpiredAt = refreshExpiredAt.Time
+	t.FamilyExpiredAt = familyExpiredAt.Time
+	t.RotatedAt = rotatedAt.Time
+	t.RevokedAt = revokedAt.Time
+
+	return t, nil
+}
+
+func (st *TokenHistoryMysqlStorer) Insert(ctx context.Context, t storage.CreateTokenHistory) (string, error) {
+	sql := "INSERT INTO token_histories (uid, user_id, access_token, refresh_token, remote_addr, x_forwarded_for, " +
+		"x_real_ip, user_agent, family_id, created_at, expired_at, refresh_expired_at, family_expired_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?);"
+
+	uid := t.UID
+	if len(uid) == 0 {
+		uid = share.NewUID()
+	}
+
+	familyID := t.FamilyID
+	if len(familyID) == 0 {
+		familyID = uid
+	}
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return "", err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, uid, t.UserID, t.AccessToken, t.RefreshToken, t.RemoteAddr, t.XForwardedFor, t.XRealIP,
+		t.UserAgent, familyID, time.Now(), t.ExpiredAt, nullTime(t.RefreshExpiredAt), nullTime(t.FamilyExpiredAt))
+	if err != nil {
+		return "", mapError(err)
+	}
+
+	return uid, nil
+}
+
+func (st *TokenHistoryMysqlStorer) Get(ctx context.Context, uid string) (*storage.TokenHistory, error) {
+	sql := "SELECT " + tokenHistoryColumns + " FROM token_histories WHERE uid = ? LIMIT 1;"
+
+	rows, err := st.db.QueryxContext(ctx, sql, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, storage.ErrNotFound
+	}
+
+	return scanTokenHistory(rows)
+}
+
+func (st *TokenHistoryMysqlStorer) Query(ctx context.Context, queries storage.QueryTokenHistory, sorts storage.SortTokenHistory) ([]*storage.TokenHistory, int64, error) {
+	var (
+		sql         = "SELECT " + tokenHistoryColumns + " FROM token_histories %s ORDER BY %s LIMIT :offset, :limit;"
+		sqlcount    = "SELECT count(uid) FROM token_histories %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.TokenHistory
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.UserID > 0 {
+		filter["user_id"] = queries.UserID
+		where += wherePrefix + "user_id = :user_id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.FamilyID) > 0 {
+		filter["family_id"] = queries.FamilyID
+		where += wherePrefix + "family_id = :family_id"
+		wherePrefix = " AND "
+	}
+
+	if queries.Active.IsSet {
+		filter["now"] = time.Now()
+		if queries.Active.Bool {
+			where += wherePrefix + "(revoked_at IS NULL AND expired_at > :now)"
+		} else {
+			where += wherePrefix + "(revoked_at IS NOT NULL OR expired_at <= :now)"
+		}
+		wherePrefix = " AND "
+	}
+
+	if queries.Live.IsSet {
+		filter["now"] = time.Now()
+		live := "revoked_at IS NULL AND (expired_at > :now OR (rotated_at IS NULL AND refresh_expired_at IS NOT NULL AND refresh_expired_at > :now))"
+		if queries.Live.Bool {
+			where += wherePrefix + "(" + live + ")"
+		} else {
+			where += wherePrefix + "NOT (" + live + ")"
+		}
+		wherePrefix = " AND "
+	}
+
+	if !queries.From.IsZero() {
+		filter["from"] = queries.From
+		where += wherePrefix + "created_at > :from"
+		wherePrefix = " AND "
+	}
+
+	if !queries.To.IsZero() {
+		filter["to"] = queries.To
+		where += wherePrefix + "created_at <= :to"
+		wherePrefix = " AND "
+	}
+
+	if sorts.CreatedAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("created_at %s", getOrderDirection(sorts.CreatedAt))
+		orderPrefix = " , "
+	}
+
+	if sorts.ExpiredAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("expired_at %s", getOrderDirection(sorts.ExpiredAt))
+		orderPrefix = " , "
+	}
+
+	if len(order) == 0 {
+		order = "created_at DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.TokenHistory, 0, queries.Limit)
+		for rows.Next() {
+			t, err := scanTokenHistory(rows)
+			if err != nil {
+				return err
+			}
+			results = append(results, t)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Revoke marks a token as revoked, revoking an already revoked token keeps its original revoked time
+func (st *TokenHistoryMysqlStorer) Revoke(ctx context.Context, uid string) error {
+	sql := "UPDATE token_histories SET revoked_at = ? WHERE uid = ? AND revoked_at IS NULL;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	_, err = stmt.ExecContext(ctx, time.Now(), uid)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// Rotate marks the refresh token of a token as exchanged, it returns false when it already was or the token
+// does not exist
+func (st *TokenHistoryMysqlStorer) Rotate(ctx context.Context, uid string) (bool, error) {
+	sql := "UPDATE token_histories SET rotated_at = ? WHERE uid = ? AND rotated_at IS NULL;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, time.Now(), uid)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// RevokeFamily revokes the tokens of a family which are not revoked yet and returns how many were revoked
+func (st *TokenHistoryMysqlStorer) RevokeFamily(ctx context.Context, familyID string) (int64, error) {
+	sql := "UPDATE token_histories SET revoked_at = ? WHERE family_id = ? AND revoked_at IS NULL;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, time.Now(), familyID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// RevokeUser revokes the tokens of a user which are not revoked yet and returns how many were revoked
+func (st *TokenHistoryMysqlStorer) RevokeUser(ctx context.Context, userID int64) (int64, error) {
+	return revokeUserTokens(ctx, st.db, userID)
+}
+
+// Purge deletes tokens whose access and refresh tokens expired at or before the given time and returns the
+// number of deleted rows
+func (st *TokenHistoryMysqlStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sql := "DELETE FROM token_histories WHERE expired_at <= ? AND (refresh_expired_at IS NULL OR refresh_expired_at <= ?);"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, before, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// revokeUserTokens revokes the tokens of a user which are not revoked yet and returns how many were revoked
+func revokeUserTokens(ctx context.Context, ex executor, userID int64) (int64, error) {
+	res, err := ex.ExecContext(ctx, "UPDATE token_histories SET revoked_at = ? WHERE user_id = ? AND revoked_at IS NULL;",
+		time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
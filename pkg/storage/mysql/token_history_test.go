@@ -0,0 +1,141 @@
+package mysql
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+func TestTokenHistoryMysqlStorer_Insert(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_add_a_token_history", func(t *testing.T) {
+		t.Parallel()
+
+		uid, err := test.thst.Insert(context.Background(), storage.CreateTokenHistory{
+			UserID:      1,
+			AccessToken: test.mig.createUniqueString("access_token"),
+			RemoteAddr:  "127.0.0.1",
+			UserAgent:   "Mozilla/5.0",
+			ExpiredAt:   time.Now().Add(time.Hour),
+		})
+		require.Nil(t, err)
+		require.Len(t, uid, 36)
+	})
+
+	t.Run("fail_add_a_duplicated_uid", func(t *testing.T) {
+		t.Parallel()
+
+		uid := test.mig.createSeedingTokenHistory(nil)
+
+		dupUID, err := test.thst.Insert(context.Background(), storage.CreateTokenHistory{
+			UID:         uid,
+			UserID:      1,
+			AccessToken: test.mig.createUniqueString("access_token"),
+			ExpiredAt:   time.Now().Add(time.Hour),
+		})
+		require.NotNil(t, err)
+		require.Empty(t, dupUID)
+	})
+}
+
+func TestTokenHistoryMysqlStorer_Get(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_get_a_token_history_by_uid", func(t *testing.T) {
+		t.Parallel()
+
+		token := test.mig.createUniqueString("access_token")
+		uid := test.mig.createSeedingTokenHistory(func(fields map[string]interface{}) {
+			fields["access_token"] = token
+		})
+
+		found, err := test.thst.Get(context.Background(), uid)
+		require.Nil(t, err)
+		require.NotNil(t, found)
+		require.Equal(t, uid, found.UID)
+		require.Equal(t, token, found.AccessToken)
+		require.True(t, found.RevokedAt.IsZero())
+	})
+}
+
+func TestTokenHistoryMysqlStorer_Revoke(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_revoke_a_token", func(t *testing.T) {
+		t.Parallel()
+
+		uid := test.mig.createSeedingTokenHistory(nil)
+
+		err := test.thst.Revoke(context.Background(), uid)
+		require.Nil(t, err)
+
+		found, err := test.thst.Get(context.Background(), uid)
+		require.Nil(t, err)
+		require.False(t, found.RevokedAt.IsZero())
+	})
+}
+
+func TestTokenHistoryMysqlStorer_Purge(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_purge_expired_tokens", func(t *testing.T) {
+		t.Parallel()
+
+		expired := test.mig.createSeedingTokenHistory(func(fields map[string]interface{}) {
+			fields["created_at"] = time.Now().Add(-72 * time.Hour)
+			fields["expired_at"] = time.Now().Add(-48 * time.Hour)
+		})
+		live := test.mig.createSeedingTokenHistory(nil)
+
+		deleted, err := test.thst.Purge(context.Background(), time.Now().Add(-24*time.Hour))
+		require.Nil(t, err)
+		require.NotZero(t, deleted)
+
+		found, err := test.thst.Get(context.Background(), expired)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+		require.Nil(t, found)
+
+		found, err = test.thst.Get(context.Background(), live)
+		require.Nil(t, err)
+		require.NotNil(t, found)
+	})
+}
+
+func TestTokenHistoryMysqlStorer_Query(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_query_token_histories", func(t *testing.T) {
+		t.Parallel()
+
+		userID := test.mig.createSeedingUser(nil)
+		seed := func(fields map[string]interface{}) { fields["user_id"] = userID }
+
+		test.mig.createSeedingTokenHistory(seed)
+		test.mig.createSeedingTokenHistory(seed)
+		test.mig.createSeedingTokenHistory(seed)
+		test.mig.createSeedingTokenHistory(seed)
+		test.mig.createSeedingTokenHistory(func(fields map[string]interface{}) {
+			fields["user_id"] = userID
+			fields["expired_at"] = time.Now().Add(-time.Hour)
+		})
+
+		rows, total, err := test.thst.Query(context.Background(), storage.QueryTokenHistory{
+			Limit:  3,
+			Offset: 0,
+			UserID: userID,
+			Active: share.Boolean{IsSet: true, Bool: true},
+		}, storage.SortTokenHistory{
+			CreatedAt: share.Descendant,
+		})
+		require.Nil(t, err)
+		require.NotNil(t, rows)
+		require.Equal(t, int64(4), total)
+		require.Len(t, rows, 3)
+	})
+}
@@ -1,8 +1,9 @@
 package mysql
 
 import (
+	"context"
+	"database/sql"
 	"fmt"
-	"sync"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -10,42 +11,48 @@ import (
 	"github.com/vespaiach/auth_service/pkg/storage"
 )
 
+var (
+	_ storage.UserStorer      = (*UserMysqlStorage)(nil)
+	_ storage.UserBunchStorer = (*UserBunchMysqlStorage)(nil)
+)
+
 // UserMysqlStorage implements db's storage for user
 type UserMysqlStorage struct {
-	db *sqlx.DB
+	db executor
 }
 
 // UserBunchMysqlStorage implements db's storage for user
 type UserBunchMysqlStorage struct {
-	db *sqlx.DB
+	db executor
 }
 
 // NewUserMysqlStorage create new instance of UserMysqlStorage
-func NewUserMysqlStorage(db *sqlx.DB) *UserMysqlStorage {
+func NewUserMysqlStorage(db executor) *UserMysqlStorage {
 	return &UserMysqlStorage{
 		db,
 	}
 }
 
 // UserBunchMysqlStorage create new instance of UserBunchMysqlStorage
-func NewUserBunchMysqlStorage(db *sqlx.DB) *UserBunchMysqlStorage {
+func NewUserBunchMysqlStorage(db executor) *UserBunchMysqlStorage {
 	return &UserBunchMysqlStorage{
 		db,
 	}
 }
 
-func (st *UserMysqlStorage) Insert(u storage.CreateUser) (int64, error) {
+func (st *UserMysqlStorage) Insert(ctx context.Context, u storage.CreateUser) (int64, error) {
 	sql := "INSERT INTO users(full_name, `username`, `email`, `hash`, `salt`, updated_at) " +
 		"VALUES(?, ?, ?, ?, ?, ?);"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return 0, err
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(u.FullName, u.Username, u.Email, u.Hash, u.Salt, time.Now())
+	res, err := stmt.ExecContext(ctx, u.FullName, u.Username, u.Email, u.Hash, u.Salt, time.Now())
 	if err != nil {
-		return 0, err
+		return 0, mapError(err)
 	}
 
 	lastID, err := res.LastInsertId()
@@ -56,7 +63,7 @@ func (st *UserMysqlStorage) Insert(u storage.CreateUser) (int64, error) {
 	return lastID, nil
 }
 
-func (st *UserMysqlStorage) Update(u storage.UpdateUser) error {
+func (st *UserMysqlStorage) Update(ctx context.Context, u storage.UpdateUser) error {
 	var (
 		sql       = "UPDATE `users` SET %s	WHERE id = :id;"
 		condition string
@@ -79,7 +86,9 @@ func (st *UserMysqlStorage) Update(u storage.UpdateUser) error {
 
 	if len(u.Email) > 0 {
 		updating["email"] = u.Email
-		condition += prefix + "`email` = :email"
+		// a new email is unverified, the verified email is set again without losing its verification
+		condition += prefix + "email_verified_at = CASE WHEN `email` = :email THEN email_verified_at ELSE NULL END, " +
+			"pending_email = '', `email` = :email"
 		prefix = ", "
 	}
 
@@ -106,31 +115,111 @@ func (st *UserMysqlStorage) Update(u storage.UpdateUser) error {
 		updating["updated_at"] = time.Now()
 		condition += prefix + "`updated_at` = :updated_at"
 
-		_, err := st.db.NamedExec(fmt.Sprintf(sql, condition), updating)
+		deactivated := u.Active.IsSet && !u.Active.Bool
+		if !deactivated && len(u.Hash) == 0 && len(u.Email) == 0 {
+			_, err := st.db.NamedExecContext(ctx, fmt.Sprintf(sql, condition), updating)
+			if err != nil {
+				return mapError(err)
+			}
+
+			return nil
+		}
+
+		// a deactivated user loses its outstanding tokens, a new password or email voids the outstanding reset or
+		// verification tokens
+		return inTx(ctx, st.db, func(tx executor) error {
+			if _, err := tx.NamedExecContext(ctx, fmt.Sprintf(sql, condition), updating); err != nil {
+				return mapError(err)
+			}
+
+			if deactivated {
+				if _, err := revokeUserTokens(ctx, tx, u.ID); err != nil {
+					return err
+				}
+			}
+
+			if len(u.Hash) > 0 {
+				if _, err := invalidateUserTokens(ctx, tx, u.ID, storage.PurposePasswordReset); err != nil {
+					return err
+				}
+			}
+
+			if len(u.Email) > 0 {
+				if _, err := invalidateUserTokens(ctx, tx, u.ID, storage.PurposeEmailVerification); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return nil
+}
+
+// SetPendingEmail sets the pending email of a user, an empty one clears it
+func (st *UserMysqlStorage) SetPendingEmail(ctx context.Context, id int64, email string) error {
+	sql := "UPDATE `users` SET pending_email = :pending_email, updated_at = :updated_at WHERE id = :id;"
+
+	// a pending email voids the verification tokens mailed for the previous one
+	return inTx(ctx, st.db, func(tx executor) error {
+		_, err := tx.NamedExecContext(ctx, sql, map[string]interface{}{"id": id, "pending_email": email,
+			"updated_at": time.Now()})
 		if err != nil {
 			return err
 		}
+
+		_, err = invalidateUserTokens(ctx, tx, id, storage.PurposeEmailVerification)
+		return err
+	})
+}
+
+// VerifyEmail marks email verified when it is the email or the pending email of a user, a pending email
+// replaces the email
+func (st *UserMysqlStorage) VerifyEmail(ctx context.Context, id int64, email string) (bool, error) {
+	if len(email) == 0 {
+		return false, nil
 	}
 
-	return nil
+	// the verification time is set before the email so mysql, which assigns from left to right, compares the
+	// email it had
+	sql := "UPDATE `users` SET " +
+		"email_verified_at = CASE WHEN `email` = :email THEN COALESCE(email_verified_at, :now) ELSE :now END, " +
+		"pending_email = CASE WHEN pending_email = :email THEN '' ELSE pending_email END, " +
+		"`email` = :email, updated_at = :now " +
+		"WHERE id = :id AND (`email` = :email OR pending_email = :email);"
+
+	res, err := st.db.NamedExecContext(ctx, sql, map[string]interface{}{"id": id, "email": email, "now": time.Now()})
+	if err != nil {
+		return false, mapError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
 }
 
-func (st *UserMysqlStorage) Get(id int64) (*storage.User, error) {
-	sql := "SELECT id, full_name, `username`, `email`, `hash`, `salt`, `active`, updated_at FROM `users` " +
+func (st *UserMysqlStorage) Get(ctx context.Context, id int64) (*storage.User, error) {
+	sql := "SELECT id, full_name, `username`, `email`, `hash`, `salt`, `active`, updated_at, " +
+		"email_verified_at, pending_email FROM `users` " +
 		"WHERE `id` = ? LIMIT 1;"
 
-	rows, err := st.db.Queryx(sql, id)
+	rows, err := st.db.QueryxContext(ctx, sql, id)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	u := &storage.User{Active: share.Boolean{IsSet: true}}
-	err = rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt)
+	err = rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
+		scanTime{&u.EmailVerifiedAt}, &u.PendingEmail)
 	if err != nil {
 		return nil, err
 	}
@@ -138,22 +227,36 @@ func (st *UserMysqlStorage) Get(id int64) (*storage.User, error) {
 	return u, nil
 }
 
-func (st *UserMysqlStorage) GetByName(username string) (*storage.User, error) {
-	sql := "SELECT id, full_name, `username`, `email`, `hash`, `salt`, `active`, updated_at FROM `users` " +
+// Lock locks the row of a user until the transaction ends, it only serializes callers running in
+// Repository.WithTx
+func (st *UserMysqlStorage) Lock(ctx context.Context, id int64) error {
+	var locked int64
+	err := st.db.QueryRowxContext(ctx, "SELECT id FROM `users` WHERE `id` = ? FOR UPDATE;", id).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	}
+
+	return err
+}
+
+func (st *UserMysqlStorage) GetByName(ctx context.Context, username string) (*storage.User, error) {
+	sql := "SELECT id, full_name, `username`, `email`, `hash`, `salt`, `active`, updated_at, " +
+		"email_verified_at, pending_email FROM `users` " +
 		"WHERE `username` = ? LIMIT 1;"
 
-	rows, err := st.db.Queryx(sql, username)
+	rows, err := st.db.QueryxContext(ctx, sql, username)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	u := &storage.User{Active: share.Boolean{IsSet: true}}
-	err = rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt)
+	err = rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
+		scanTime{&u.EmailVerifiedAt}, &u.PendingEmail)
 	if err != nil {
 		return nil, err
 	}
@@ -161,22 +264,24 @@ func (st *UserMysqlStorage) GetByName(username string) (*storage.User, error) {
 	return u, nil
 }
 
-func (st *UserMysqlStorage) GetByEmail(email string) (*storage.User, error) {
-	sql := "SELECT id, full_name, `username`, `email`, `hash`, `salt`, `active`, updated_at FROM `users` " +
+func (st *UserMysqlStorage) GetByEmail(ctx context.Context, email string) (*storage.User, error) {
+	sql := "SELECT id, full_name, `username`, `email`, `hash`, `salt`, `active`, updated_at, " +
+		"email_verified_at, pending_email FROM `users` " +
 		"WHERE `email` = ? LIMIT 1;"
 
-	rows, err := st.db.Queryx(sql, email)
+	rows, err := st.db.QueryxContext(ctx, sql, email)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
 	if !rows.Next() {
-		return nil, nil
+		return nil, storage.ErrNotFound
 	}
 
 	u := &storage.User{Active: share.Boolean{IsSet: true}}
-	err = rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt)
+	err = rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
+		scanTime{&u.EmailVerifiedAt}, &u.PendingEmail)
 	if err != nil {
 		return nil, err
 	}
@@ -184,24 +289,22 @@ func (st *UserMysqlStorage) GetByEmail(email string) (*storage.User, error) {
 	return u, nil
 }
 
-func (st *UserMysqlStorage) Query(queries storage.QueryUser, sorts storage.SortUser) ([]*storage.User, int64, error) {
+func (st *UserMysqlStorage) Query(ctx context.Context, queries storage.QueryUser, sorts storage.SortUser) ([]*storage.User, int64, error) {
 	var (
-		sql           = "SELECT id, full_name, `username`, `email`, `hash`, `salt`, active, updated_at FROM `users` %s ORDER BY %s LIMIT :offset, :limit;"
-		sqlcount      = "SELECT count(id) FROM `users` %s;"
-		orderPrefix   string
-		order         string
-		wherePrefix   = "WHERE "
-		where         string
-		wg            sync.WaitGroup
-		queryErr      error
-		countTotalErr error
-		results       []*storage.User
-		total         int64
+		sql = "SELECT id, full_name, `username`, `email`, `hash`, `salt`, active, updated_at, email_verified_at, " +
+			"pending_email FROM `users` %s ORDER BY %s LIMIT :offset, :limit;"
+		sqlcount    = "SELECT count(id) FROM `users` %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.User
+		total       int64
 	)
 
 	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
 	if queries.Limit == 0 {
-		filter["queries"] = share.DefaultLimit
+		filter["limit"] = share.DefaultLimit
 	}
 
 	if len(queries.FullName) > 0 {
@@ -217,7 +320,7 @@ func (st *UserMysqlStorage) Query(queries storage.QueryUser, sorts storage.SortU
 	}
 
 	if len(queries.Email) > 0 {
-		filter["desc"] = "%" + queries.Email + "%"
+		filter["email"] = "%" + queries.Email + "%"
 		where += wherePrefix + "`email` LIKE :email"
 		wherePrefix = " AND "
 	}
@@ -228,6 +331,15 @@ func (st *UserMysqlStorage) Query(queries storage.QueryUser, sorts storage.SortU
 		wherePrefix = " AND "
 	}
 
+	if queries.Verified.IsSet {
+		if queries.Verified.Bool {
+			where += wherePrefix + "email_verified_at IS NOT NULL"
+		} else {
+			where += wherePrefix + "email_verified_at IS NULL"
+		}
+		wherePrefix = " AND "
+	}
+
 	if !queries.From.IsZero() {
 		filter["from"] = queries.From
 		where += wherePrefix + "updated_at > :from"
@@ -272,76 +384,47 @@ func (st *UserMysqlStorage) Query(queries storage.QueryUser, sorts storage.SortU
 	sql = fmt.Sprintf(sql, where, order)
 	sqlcount = fmt.Sprintf(sqlcount, where)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		rows, err := st.db.NamedQuery(sql, filter)
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
 		if err != nil {
-			queryErr = err
-			return
+			return err
 		}
 		defer rows.Close()
 
 		results = make([]*storage.User, 0, queries.Limit)
 		for rows.Next() {
 			u := &storage.User{Active: share.Boolean{IsSet: true}}
-			err := rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt)
+			err := rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
+				scanTime{&u.EmailVerifiedAt}, &u.PendingEmail)
 			if err != nil {
-				queryErr = err
-				return
+				return err
 			}
 			results = append(results, u)
 		}
 
-		if rows.Err() != nil {
-			queryErr = rows.Err()
-			return
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		rows, err := st.db.NamedQuery(sqlcount, filter)
-		if err != nil {
-			countTotalErr = err
-			return
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			err := rows.Scan(&total)
-			if err != nil {
-				countTotalErr = err
-				return
-			}
-		}
-	}()
-
-	wg.Wait()
-
-	if queryErr != nil {
-		return nil, 0, queryErr
-	}
-	if countTotalErr != nil {
-		return nil, 0, countTotalErr
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
 	}
 
 	return results, total, nil
 }
 
-func (st *UserBunchMysqlStorage) Insert(u storage.CreateUserBunch) (int64, error) {
+func (st *UserBunchMysqlStorage) Insert(ctx context.Context, u storage.CreateUserBunch) (int64, error) {
 	sql := "INSERT INTO user_bunches (user_id, bunch_id, updated_at) VALUES(?, ?, ?);"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return 0, err
 	}
+	defer stmt.Close()
 
-	res, err := stmt.Exec(u.UserID, u.BunchID, time.Now())
+	res, err := stmt.ExecContext(ctx, u.UserID, u.BunchID, time.Now())
 	if err != nil {
-		return 0, err
+		return 0, mapError(err)
 	}
 
 	lastID, err := res.LastInsertId()
@@ -352,26 +435,48 @@ func (st *UserBunchMysqlStorage) Insert(u storage.CreateUserBunch) (int64, error
 	return lastID, nil
 }
 
-func (st *UserBunchMysqlStorage) Delete(id int64) error {
+func (st *UserBunchMysqlStorage) Delete(ctx context.Context, id int64) error {
 	sql := "DELETE FROM `user_bunches` WHERE id=?"
 
-	stmt, err := st.db.Prepare(sql)
+	stmt, err := st.db.PrepareContext(ctx, sql)
 	if err != nil {
 		return err
 	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, id)
+	if err != nil {
+		return mapError(err)
+	}
 
-	_, err = stmt.Exec(id)
+	affected, err := res.RowsAffected()
 	if err != nil {
 		return err
 	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
 
 	return nil
 }
 
-func (st *UserBunchMysqlStorage) Query(queries storage.QueryUserBunch, sorts storage.SortUserBunch) ([]*storage.AggregateUserBunch, int64, error) {
+func (st *UserBunchMysqlStorage) Get(ctx context.Context, id int64) (*storage.AggregateUserBunch, error) {
+	rows, _, err := st.Query(ctx, storage.QueryUserBunch{ID: id, Limit: 1}, storage.SortUserBunch{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *UserBunchMysqlStorage) Query(ctx context.Context, queries storage.QueryUserBunch, sorts storage.SortUserBunch) ([]*storage.AggregateUserBunch, int64, error) {
 	var (
 		sql = "SELECT `users`.id, `users`.full_name, `users`.`username`, `users`.`email`, `users`.`hash`, " +
-			"`users`.`salt`, `users`.`active`, `users`.updated_at, bunches.`id`, bunches.`name`, bunches.`desc`, " +
+			"`users`.`salt`, `users`.`active`, `users`.updated_at, `users`.email_verified_at, `users`.pending_email, " +
+			"bunches.`id`, bunches.`name`, bunches.`desc`, " +
 			"bunches.`active`, bunches.updated_at, user_bunches.`id`, user_bunches.user_id, user_bunches.bunch_id, " +
 			"user_bunches.updated_at FROM `users` " +
 			"INNER JOIN user_bunches ON `users`.id = user_bunches.user_id " +
@@ -380,20 +485,29 @@ func (st *UserBunchMysqlStorage) Query(queries storage.QueryUserBunch, sorts sto
 		sqlcount = "SELECT count(user_bunches.`id`) FROM `users` " +
 			"INNER JOIN user_bunches ON `users`.id = user_bunches.user_id " +
 			"INNER JOIN bunches ON user_bunches.bunch_id = bunches.`id` %s;"
-		orderPrefix   string
-		order         string
-		wherePrefix   = "WHERE "
-		where         string
-		wg            sync.WaitGroup
-		queryErr      error
-		countTotalErr error
-		results       []*storage.AggregateUserBunch
-		total         int64
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.AggregateUserBunch
+		total       int64
 	)
 
 	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
 	if queries.Limit == 0 {
-		filter["queries"] = share.DefaultLimit
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.ID > 0 {
+		filter["id"] = queries.ID
+		where += wherePrefix + "user_bunches.`id` = :id"
+		wherePrefix = " AND "
+	}
+
+	if queries.UserID > 0 {
+		filter["user_id"] = queries.UserID
+		where += wherePrefix + "user_bunches.user_id = :user_id"
+		wherePrefix = " AND "
 	}
 
 	if len(queries.Username) > 0 {
@@ -415,8 +529,8 @@ func (st *UserBunchMysqlStorage) Query(queries storage.QueryUserBunch, sorts sto
 	}
 
 	if queries.BunchActive.IsSet {
-		filter["user_active"] = queries.BunchActive.Bool
-		where += wherePrefix + "bunches.`active` = :user_active"
+		filter["bunch_active"] = queries.BunchActive.Bool
+		where += wherePrefix + "bunches.`active` = :bunch_active"
 		wherePrefix = " AND "
 	}
 
@@ -437,13 +551,10 @@ func (st *UserBunchMysqlStorage) Query(queries storage.QueryUserBunch, sorts sto
 	sql = fmt.Sprintf(sql, where, order)
 	sqlcount = fmt.Sprintf(sqlcount, where)
 
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		rows, err := st.db.NamedQuery(sql, filter)
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
 		if err != nil {
-			queryErr = err
-			return
+			return err
 		}
 		defer rows.Close()
 
@@ -454,11 +565,11 @@ func (st *UserBunchMysqlStorage) Query(queries storage.QueryUserBunch, sorts sto
 			ub := &storage.UserBunch{}
 
 			err := rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
-				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &u.UpdatedAt,
+				scanTime{&u.EmailVerifiedAt}, &u.PendingEmail,
+				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
 				&ub.ID, &ub.UserID, &ub.BunchID, &ub.UpdatedAt)
 			if err != nil {
-				queryErr = err
-				return
+				return err
 			}
 			results = append(results, &storage.AggregateUserBunch{
 				User:      u,
@@ -467,40 +578,29 @@ func (st *UserBunchMysqlStorage) Query(queries storage.QueryUserBunch, sorts sto
 			})
 		}
 
-		if rows.Err() != nil {
-			queryErr = rows.Err()
-			return
-		}
-	}()
-
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-
-		rows, err := st.db.NamedQuery(sqlcount, filter)
-		if err != nil {
-			countTotalErr = err
-			return
-		}
-		defer rows.Close()
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
 
-		if rows.Next() {
-			err := rows.Scan(&total)
-			if err != nil {
-				countTotalErr = err
-				return
-			}
-		}
-	}()
+	return results, total, nil
+}
 
-	wg.Wait()
+// scanTime scans a nullable timestamp into t, NULL scans as the zero time
+type scanTime struct {
+	t *time.Time
+}
 
-	if queryErr != nil {
-		return nil, 0, queryErr
-	}
-	if countTotalErr != nil {
-		return nil, 0, countTotalErr
+func (s scanTime) Scan(value interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
 	}
 
-	return results, total, nil
+	*s.t = nt.Time
+
+	return nil
 }
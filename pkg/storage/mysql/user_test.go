@@ -1,6 +1,8 @@
 package mysql
 
 import (
+	"context"
+	"errors"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -17,7 +19,7 @@ func TestUserMysqlStorage_Insert(t *testing.T) {
 		name := test.mig.createUniqueString("username")
 		email := test.mig.createUniqueString("email")
 
-		id, err := test.ust.Insert(storage.CreateUser{
+		id, err := test.ust.Insert(context.Background(), storage.CreateUser{
 			FullName: "full name",
 			Username: name,
 			Email:    email,
@@ -37,14 +39,17 @@ func TestUserMysqlStorage_Insert(t *testing.T) {
 			fields["username"] = name
 		})
 
-		id, err := test.ust.Insert(storage.CreateUser{
+		id, err := test.ust.Insert(context.Background(), storage.CreateUser{
 			FullName: "full name",
 			Username: name,
 			Email:    email,
 			Hash:     "hash",
 			Salt:     "salt",
 		})
-		require.NotNil(t, err)
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "username", dup.Field)
 		require.Zero(t, id)
 	})
 
@@ -57,14 +62,17 @@ func TestUserMysqlStorage_Insert(t *testing.T) {
 			fields["email"] = email
 		})
 
-		id, err := test.ust.Insert(storage.CreateUser{
+		id, err := test.ust.Insert(context.Background(), storage.CreateUser{
 			FullName: "full name",
 			Username: name,
 			Email:    email,
 			Hash:     "hash",
 			Salt:     "salt",
 		})
-		require.NotNil(t, err)
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "email", dup.Field)
 		require.Zero(t, id)
 	})
 }
@@ -79,7 +87,7 @@ func TestUserMysqlStorage_Update(t *testing.T) {
 		newname := test.mig.createUniqueString("username")
 		newemail := test.mig.createUniqueString("email")
 
-		err := test.ust.Update(storage.UpdateUser{
+		err := test.ust.Update(context.Background(), storage.UpdateUser{
 			ID:       id,
 			FullName: "full name",
 			Username: newname,
@@ -106,7 +114,7 @@ func TestUserMysqlStorage_Get(t *testing.T) {
 
 		id := test.mig.createSeedingUser(nil)
 
-		user, err := test.ust.Get(id)
+		user, err := test.ust.Get(context.Background(), id)
 		require.Nil(t, err)
 		require.NotNil(t, user)
 	})
@@ -123,7 +131,7 @@ func TestUserMysqlStorage_GetByName(t *testing.T) {
 			fields["username"] = name
 		})
 
-		user, err := test.ust.GetByName(name)
+		user, err := test.ust.GetByName(context.Background(), name)
 		require.Nil(t, err)
 		require.NotNil(t, user)
 		require.Equal(t, id, user.ID)
@@ -141,7 +149,7 @@ func TestUserMysqlStorage_GetByEmail(t *testing.T) {
 			fields["email"] = email
 		})
 
-		user, err := test.ust.GetByEmail(email)
+		user, err := test.ust.GetByEmail(context.Background(), email)
 		require.Nil(t, err)
 		require.NotNil(t, user)
 		require.Equal(t, id, user.ID)
@@ -169,7 +177,7 @@ func TestUserMysqlStorage_Query(t *testing.T) {
 			field["active"] = false
 		})
 
-		users, total, err := test.ust.Query(storage.QueryUser{
+		users, total, err := test.ust.Query(context.Background(), storage.QueryUser{
 			Limit:    2,
 			Offset:   2,
 			Username: "user1ame",
@@ -195,7 +203,7 @@ func TestUserBunchMysqlStorage_Insert(t *testing.T) {
 		userID := test.mig.createSeedingUser(nil)
 		buncheID := test.mig.createSeedingBunch(nil)
 
-		id, err := test.ubst.Insert(storage.CreateUserBunch{
+		id, err := test.ubst.Insert(context.Background(), storage.CreateUserBunch{
 			UserID:  userID,
 			BunchID: buncheID,
 		})
@@ -206,7 +214,7 @@ func TestUserBunchMysqlStorage_Insert(t *testing.T) {
 	t.Run("fail_add_a_user_bunch", func(t *testing.T) {
 		t.Parallel()
 
-		id, err := test.ubst.Insert(storage.CreateUserBunch{
+		id, err := test.ubst.Insert(context.Background(), storage.CreateUserBunch{
 			UserID:  -1,
 			BunchID: -10,
 		})
@@ -224,14 +232,14 @@ func TestUserBunchMysqlStorage_Delete(t *testing.T) {
 		userID := test.mig.createSeedingUser(nil)
 		buncheID := test.mig.createSeedingBunch(nil)
 
-		id, err := test.ubst.Insert(storage.CreateUserBunch{
+		id, err := test.ubst.Insert(context.Background(), storage.CreateUserBunch{
 			UserID:  userID,
 			BunchID: buncheID,
 		})
 		require.Nil(t, err)
 		require.NotZero(t, id)
 
-		err = test.ubst.Delete(id)
+		err = test.ubst.Delete(context.Background(), id)
 		require.Nil(t, err)
 	})
 }
@@ -256,25 +264,25 @@ func TestUserBunchMysqlStorage_Query(t *testing.T) {
 			fields["active"] = false
 		})
 
-		_, err := test.ubst.Insert(storage.CreateUserBunch{
+		_, err := test.ubst.Insert(context.Background(), storage.CreateUserBunch{
 			UserID:  userID,
 			BunchID: bunchID1,
 		})
 		require.Nil(t, err)
 
-		_, err = test.ubst.Insert(storage.CreateUserBunch{
+		_, err = test.ubst.Insert(context.Background(), storage.CreateUserBunch{
 			UserID:  userID,
 			BunchID: bunchID2,
 		})
 		require.Nil(t, err)
 
-		_, err = test.ubst.Insert(storage.CreateUserBunch{
+		_, err = test.ubst.Insert(context.Background(), storage.CreateUserBunch{
 			UserID:  userID,
 			BunchID: bunchID3,
 		})
 		require.Nil(t, err)
 
-		rows, total, err := test.ubst.Query(storage.QueryUserBunch{
+		rows, total, err := test.ubst.Query(context.Background(), storage.QueryUserBunch{
 			Limit:       2,
 			Offset:      0,
 			Username:    name1,
@@ -0,0 +1,118 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.UserTokenStorer = (*UserTokenMysqlStorer)(nil)
+
+// UserTokenMysqlStorer implements db's storage for user tokens
+type UserTokenMysqlStorer struct {
+	db executor
+}
+
+// NewUserTokenMysqlStorer creates new instance of UserTokenMysqlStorer
+func NewUserTokenMysqlStorer(db executor) *UserTokenMysqlStorer {
+	return &UserTokenMysqlStorer{
+		db,
+	}
+}
+
+func (st *UserTokenMysqlStorer) Insert(ctx context.Context, t storage.CreateUserToken) (int64, error) {
+	sql := "INSERT INTO user_tokens (user_id, purpose, hash, created_at, expired_at) VALUES (?, ?, ?, ?, ?);"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, t.UserID, t.Purpose, t.Hash, time.Now(), t.ExpiredAt)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (st *UserTokenMysqlStorer) GetByHash(ctx context.Context, hash string) (*storage.UserToken, error) {
+	var (
+		t      = new(storage.UserToken)
+		usedAt sql.NullTime
+	)
+
+	err := st.db.QueryRowxContext(ctx, "SELECT id, user_id, purpose, hash, created_at, expired_at, used_at "+
+		"FROM user_tokens WHERE hash = ? LIMIT 1;", hash).
+		Scan(&t.ID, &t.UserID, &t.Purpose, &t.Hash, &t.CreatedAt, &t.ExpiredAt, &usedAt)
+	if err == sql.ErrNoRows {
+		return nil, storage.ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	t.UsedAt = usedAt.Time
+
+	return t, nil
+}
+
+// Use marks an unused token as used
+func (st *UserTokenMysqlStorer) Use(ctx context.Context, id int64) (bool, error) {
+	sql := "UPDATE user_tokens SET used_at = ? WHERE id = ? AND used_at IS NULL;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return false, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, time.Now(), id)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// Invalidate marks the unused tokens of a user for a purpose as used and returns how many were
+func (st *UserTokenMysqlStorer) Invalidate(ctx context.Context, userID int64, purpose string) (int64, error) {
+	return invalidateUserTokens(ctx, st.db, userID, purpose)
+}
+
+// Purge deletes tokens which expired at or before the given time and returns the number of deleted rows
+func (st *UserTokenMysqlStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sql := "DELETE FROM user_tokens WHERE expired_at <= ?;"
+
+	stmt, err := st.db.PrepareContext(ctx, sql)
+	if err != nil {
+		return 0, err
+	}
+	defer stmt.Close()
+
+	res, err := stmt.ExecContext(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// invalidateUserTokens marks the unused tokens of a user for a purpose as used and returns how many were
+func invalidateUserTokens(ctx context.Context, ex executor, userID int64, purpose string) (int64, error) {
+	res, err := ex.ExecContext(ctx, "UPDATE user_tokens SET used_at = ? WHERE user_id = ? AND purpose = ? AND used_at IS NULL;",
+		time.Now(), userID, purpose)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,117 @@
+package storage
+
+import "context"
+
+//DenyReason tells why a user does not hold a key
+type DenyReason int
+
+//Define deny reasons
+const (
+	UserInactive DenyReason = iota + 1
+	BunchInactive
+	KeyNotInBunches
+	KeyMissing
+)
+
+func (r DenyReason) String() string {
+	switch r {
+	case UserInactive:
+		return "user is inactive"
+	case BunchInactive:
+		return "bunch is inactive"
+	case KeyNotInBunches:
+		return "key is not in any of the user's bunches"
+	case KeyMissing:
+		return "key does not exist"
+	default:
+		return "unknown"
+	}
+}
+
+//PermissionGrant is a path granting a key: the user's membership of a bunch and the bunch's key, whose
+//Source is set when the bunch inherits the key. Inactive is the first inactive bunch the key is inherited
+//through, the source included, a path through one grants nothing.
+type PermissionGrant struct {
+	UserBunch *AggregateUserBunch
+	BunchKey  *AggregateBunchKey
+	Inactive  *Bunch
+}
+
+//PermissionDenial model, Bunch is set when the reason is BunchInactive, it is the user's bunch or the ancestor
+//the key is inherited through
+type PermissionDenial struct {
+	Reason DenyReason
+	Bunch  *Bunch
+}
+
+//PermissionExplanation model, Grants lists every path through active bunches only and Denials is only
+//filled when the key is not granted. Key is nil when no key has the asked name, User never holds credentials.
+type PermissionExplanation struct {
+	User    *User
+	Key     *Key
+	Granted bool
+	Grants  []*PermissionGrant
+	Denials []*PermissionDenial
+}
+
+//PermissionResolver answers which keys a user holds. A user holds a key when the user is active and
+//belongs to an active bunch which contains the key or inherits it from one of its ancestors. A bunch only
+//inherits through active ancestors, an inactive one passes on neither its keys nor those it inherits.
+type PermissionResolver interface {
+	Keys(ctx context.Context, userID int64) ([]*Key, error)
+	HasKey(ctx context.Context, userID int64, keyName string) (bool, error)
+	HasAll(ctx context.Context, userID int64, keyNames ...string) (bool, error)
+	HasAny(ctx context.Context, userID int64, keyNames ...string) (bool, error)
+	Explain(ctx context.Context, userID int64, keyName string) (*PermissionExplanation, error)
+}
+
+//ExplainPaths builds an explanation from the paths between a user and a key, or a missing key when key is nil.
+//A path is a user's bunch containing or inheriting the key, whatever the status of the bunches on the way is,
+//its user is set to the explanation's one. It is shared by storage backends.
+func ExplainPaths(user *User, key *Key, paths []*PermissionGrant) *PermissionExplanation {
+	u := *user
+	u.Hash, u.Salt = "", ""
+
+	exp := &PermissionExplanation{
+		User:    &u,
+		Key:     key,
+		Grants:  make([]*PermissionGrant, 0, len(paths)),
+		Denials: make([]*PermissionDenial, 0),
+	}
+
+	if key == nil {
+		exp.Denials = append(exp.Denials, &PermissionDenial{Reason: KeyMissing})
+		return exp
+	}
+
+	for _, p := range paths {
+		p.UserBunch.User = &u
+		if p.UserBunch.Bunch.Active.Bool && p.Inactive == nil {
+			exp.Grants = append(exp.Grants, p)
+		}
+	}
+
+	exp.Granted = u.Active.Bool && len(exp.Grants) > 0
+	if exp.Granted {
+		return exp
+	}
+
+	if !u.Active.Bool {
+		exp.Denials = append(exp.Denials, &PermissionDenial{Reason: UserInactive})
+	}
+
+	for _, p := range paths {
+		switch {
+		case !p.UserBunch.Bunch.Active.Bool:
+			exp.Denials = append(exp.Denials, &PermissionDenial{Reason: BunchInactive, Bunch: p.UserBunch.Bunch})
+		case p.Inactive != nil:
+			exp.Denials = append(exp.Denials, &PermissionDenial{Reason: BunchInactive, Bunch: p.Inactive})
+		}
+	}
+
+	if len(paths) == 0 {
+		exp.Denials = append(exp.Denials, &PermissionDenial{Reason: KeyNotInBunches})
+	}
+
+	return exp
+}
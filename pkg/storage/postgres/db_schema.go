@@ -0,0 +1,384 @@
+package postgres
+
+// migrations are the versioned changes of the schema, applied in order of Version. Released migrations must
+// never be edited: their checksums are recorded in schema_migrations, add a new migration instead.
+// The first ones create the tables as they were before versioning and only when missing, so databases created
+// before versioning are adopted as is. Later changes, even to those tables, are new migrations.
+var migrations = []*Migration{
+	{
+		Version: 1,
+		Name:    "create_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS keys (
+  id BIGSERIAL NOT NULL,
+  name VARCHAR(32) NOT NULL,
+  "desc" VARCHAR(64) NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT keys_pkey PRIMARY KEY (id),
+  CONSTRAINT keys_key_uniq UNIQUE (name)
+);
+`,
+		Down: `
+DROP TABLE IF EXISTS keys;
+`,
+	},
+	{
+		Version: 2,
+		Name:    "create_bunches",
+		Up: `
+CREATE TABLE IF NOT EXISTS bunches (
+  id BIGSERIAL NOT NULL,
+  name VARCHAR(32) NOT NULL,
+  "desc" VARCHAR(64) NOT NULL,
+  active BOOLEAN NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT bunches_pkey PRIMARY KEY (id),
+  CONSTRAINT bunch_name_uniq UNIQUE (name)
+);
+CREATE INDEX IF NOT EXISTS bunch_active_idx ON bunches (active);
+`,
+		Down: `
+DROP TABLE IF EXISTS bunches;
+`,
+	},
+	{
+		Version: 3,
+		Name:    "create_users",
+		Up: `
+CREATE TABLE IF NOT EXISTS users (
+  id BIGSERIAL NOT NULL,
+  full_name VARCHAR(64) NOT NULL,
+  username VARCHAR(32) NOT NULL,
+  email VARCHAR(64) NOT NULL,
+  hash VARCHAR(128) NOT NULL,
+  salt VARCHAR(32) NOT NULL,
+  active BOOLEAN NOT NULL DEFAULT TRUE,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT users_pkey PRIMARY KEY (id),
+  CONSTRAINT users_username_uniq UNIQUE (username),
+  CONSTRAINT users_email_uniq UNIQUE (email)
+);
+CREATE INDEX IF NOT EXISTS users_active_idx ON users (active);
+`,
+		Down: `
+DROP TABLE IF EXISTS users;
+`,
+	},
+	{
+		Version: 4,
+		Name:    "create_token_histories",
+		Up: `
+CREATE TABLE IF NOT EXISTS token_histories (
+  uid VARCHAR(36) NOT NULL,
+  user_id BIGINT NOT NULL,
+  access_token VARCHAR(1024) NOT NULL,
+  refresh_token VARCHAR(1024) NOT NULL DEFAULT '',
+  remote_addr VARCHAR(512) NOT NULL DEFAULT '',
+  x_forwarded_for VARCHAR(512) NOT NULL DEFAULT '',
+  x_real_ip VARCHAR(512) NOT NULL DEFAULT '',
+  user_agent VARCHAR(512) NOT NULL DEFAULT '',
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMPTZ NOT NULL,
+  revoked_at TIMESTAMPTZ NULL DEFAULT NULL,
+  CONSTRAINT uid_uniq PRIMARY KEY (uid)
+);
+CREATE INDEX IF NOT EXISTS token_history_user_id_idx ON token_histories (user_id);
+CREATE INDEX IF NOT EXISTS token_history_expired_at_idx ON token_histories (expired_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS token_histories;
+`,
+	},
+	{
+		Version: 5,
+		Name:    "create_bunch_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS bunch_keys (
+  id BIGSERIAL NOT NULL,
+  bunch_id BIGINT NOT NULL,
+  key_id BIGINT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT bunch_keys_pkey PRIMARY KEY (id),
+  CONSTRAINT bunch_key_uniq UNIQUE (bunch_id, key_id),
+  CONSTRAINT key_id_on_bunch_key
+    FOREIGN KEY (key_id)
+    REFERENCES keys (id)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE,
+  CONSTRAINT role_id_on_bunch_key
+    FOREIGN KEY (bunch_id)
+    REFERENCES bunches (id)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS bunch_key_key_id_idx ON bunch_keys (key_id);
+CREATE INDEX IF NOT EXISTS bunch_key_bunch_id_idx ON bunch_keys (bunch_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS bunch_keys;
+`,
+	},
+	{
+		Version: 6,
+		Name:    "create_user_bunches",
+		Up: `
+CREATE TABLE IF NOT EXISTS user_bunches (
+  id BIGSERIAL NOT NULL,
+  user_id BIGINT NOT NULL,
+  bunch_id BIGINT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT user_bunches_pkey PRIMARY KEY (id),
+  CONSTRAINT user_bunch_uniq UNIQUE (user_id, bunch_id),
+  CONSTRAINT user_id_on_user_bunch
+    FOREIGN KEY (user_id)
+    REFERENCES users (id)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE,
+  CONSTRAINT bunch_id_on_user_bunch
+    FOREIGN KEY (bunch_id)
+    REFERENCES bunches (id)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS user_bunch_user_id_idx ON user_bunches (user_id);
+CREATE INDEX IF NOT EXISTS user_bunch_bunch_id_idx ON user_bunches (bunch_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS user_bunches;
+`,
+	},
+	{
+		Version: 7,
+		Name:    "add_token_families",
+		Up: `
+ALTER TABLE token_histories
+  ADD COLUMN family_id VARCHAR(36) NOT NULL DEFAULT '',
+  ADD COLUMN refresh_expired_at TIMESTAMPTZ NULL DEFAULT NULL,
+  ADD COLUMN family_expired_at TIMESTAMPTZ NULL DEFAULT NULL,
+  ADD COLUMN rotated_at TIMESTAMPTZ NULL DEFAULT NULL;
+UPDATE token_histories SET family_id = uid WHERE family_id = '';
+CREATE INDEX token_history_family_id_idx ON token_histories (family_id);
+`,
+		Down: `
+DROP INDEX token_history_family_id_idx;
+ALTER TABLE token_histories
+  DROP COLUMN rotated_at,
+  DROP COLUMN family_expired_at,
+  DROP COLUMN refresh_expired_at,
+  DROP COLUMN family_id;
+`,
+	},
+	{
+		Version: 8,
+		Name:    "create_signing_keys",
+		Up: `
+CREATE TABLE IF NOT EXISTS signing_keys (
+  kid VARCHAR(36) NOT NULL,
+  algorithm VARCHAR(16) NOT NULL,
+  public_key BYTEA NOT NULL,
+  private_key BYTEA NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  activated_at TIMESTAMPTZ NOT NULL,
+  expired_at TIMESTAMPTZ NULL DEFAULT NULL,
+  CONSTRAINT kid_uniq PRIMARY KEY (kid)
+);
+CREATE INDEX IF NOT EXISTS signing_key_expired_at_idx ON signing_keys (expired_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS signing_keys;
+`,
+	},
+	{
+		Version: 9,
+		Name:    "create_login_attempts",
+		Up: `
+CREATE TABLE IF NOT EXISTS login_attempts (
+  id BIGSERIAL NOT NULL,
+  user_id BIGINT NOT NULL DEFAULT 0,
+  username VARCHAR(64) NOT NULL DEFAULT '',
+  ip VARCHAR(64) NOT NULL DEFAULT '',
+  outcome VARCHAR(16) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT login_attempts_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS login_attempt_user_id_idx ON login_attempts (user_id, created_at);
+CREATE INDEX IF NOT EXISTS login_attempt_username_idx ON login_attempts (username, created_at);
+CREATE INDEX IF NOT EXISTS login_attempt_ip_idx ON login_attempts (ip, created_at);
+CREATE INDEX IF NOT EXISTS login_attempt_created_at_idx ON login_attempts (created_at);
+`,
+		Down: `
+DROP TABLE IF EXISTS login_attempts;
+`,
+	},
+	{
+		Version: 10,
+		Name:    "create_mfa",
+		Up: `
+CREATE TABLE IF NOT EXISTS user_totps (
+  user_id BIGINT NOT NULL,
+  secret VARCHAR(64) NOT NULL,
+  last_step BIGINT NOT NULL DEFAULT 0,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  confirmed_at TIMESTAMPTZ NULL DEFAULT NULL,
+  CONSTRAINT user_totps_pkey PRIMARY KEY (user_id)
+);
+CREATE TABLE IF NOT EXISTS recovery_codes (
+  id BIGSERIAL NOT NULL,
+  user_id BIGINT NOT NULL,
+  hash VARCHAR(64) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  used_at TIMESTAMPTZ NULL DEFAULT NULL,
+  CONSTRAINT recovery_codes_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS recovery_code_user_id_idx ON recovery_codes (user_id, hash);
+`,
+		Down: `
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_totps;
+`,
+	},
+	{
+		Version: 11,
+		Name:    "create_user_tokens",
+		Up: `
+CREATE TABLE IF NOT EXISTS user_tokens (
+  id BIGSERIAL NOT NULL,
+  user_id BIGINT NOT NULL,
+  purpose VARCHAR(32) NOT NULL,
+  hash VARCHAR(64) NOT NULL,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expired_at TIMESTAMPTZ NOT NULL,
+  used_at TIMESTAMPTZ NULL DEFAULT NULL,
+  CONSTRAINT user_tokens_pkey PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS user_token_hash_idx ON user_tokens (hash);
+CREATE INDEX IF NOT EXISTS user_token_user_id_idx ON user_tokens (user_id, purpose);
+`,
+		Down: `
+DROP TABLE IF EXISTS user_tokens;
+`,
+	},
+	{
+		Version: 12,
+		Name:    "add_email_verification",
+		Up: `
+ALTER TABLE users
+  ADD COLUMN email_verified_at TIMESTAMPTZ NULL DEFAULT NULL,
+  ADD COLUMN pending_email VARCHAR(64) NOT NULL DEFAULT '';
+`,
+		Down: `
+ALTER TABLE users
+  DROP COLUMN pending_email,
+  DROP COLUMN email_verified_at;
+`,
+	},
+	{
+		Version: 13,
+		Name:    "create_bunch_parents",
+		Up: `
+CREATE TABLE IF NOT EXISTS bunch_parents (
+  id BIGSERIAL NOT NULL,
+  bunch_id BIGINT NOT NULL,
+  parent_id BIGINT NOT NULL,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  CONSTRAINT bunch_parents_pkey PRIMARY KEY (id),
+  CONSTRAINT bunch_parent_uniq UNIQUE (bunch_id, parent_id),
+  CONSTRAINT bunch_id_on_bunch_parent
+    FOREIGN KEY (bunch_id)
+    REFERENCES bunches (id)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE,
+  CONSTRAINT parent_id_on_bunch_parent
+    FOREIGN KEY (parent_id)
+    REFERENCES bunches (id)
+    ON DELETE CASCADE
+    ON UPDATE CASCADE
+);
+CREATE INDEX IF NOT EXISTS bunch_parent_parent_id_idx ON bunch_parents (parent_id);
+`,
+		Down: `
+DROP TABLE IF EXISTS bunch_parents;
+`,
+	},
+	{
+		Version: 14,
+		Name:    "provision_resource_server",
+		Up: `
+INSERT INTO keys (name, "desc") VALUES ('introspect_token', 'Introspect a token') ON CONFLICT (name) DO NOTHING;
+INSERT INTO bunches (name, "desc", active) VALUES ('resource_server', 'Resource server', TRUE) ON CONFLICT (name) DO NOTHING;
+INSERT INTO bunch_keys (bunch_id, key_id)
+  SELECT b.id, k.id FROM bunches b, keys k
+  WHERE b.name = 'resource_server' AND k.name = 'introspect_token'
+  ON CONFLICT (bunch_id, key_id) DO NOTHING;
+`,
+		Down: `
+DELETE FROM bunch_keys WHERE bunch_id IN (SELECT id FROM bunches WHERE name = 'resource_server');
+DELETE FROM user_bunches WHERE bunch_id IN (SELECT id FROM bunches WHERE name = 'resource_server');
+DELETE FROM bunch_parents WHERE bunch_id IN (SELECT id FROM bunches WHERE name = 'resource_server')
+  OR parent_id IN (SELECT id FROM bunches WHERE name = 'resource_server');
+DELETE FROM bunches WHERE name = 'resource_server';
+DELETE FROM keys WHERE name = 'introspect_token';
+`,
+	},
+}
+
+var dropDatabase = `
+DROP TABLE IF EXISTS schema_migrations;
+DROP TABLE IF EXISTS user_bunches;
+DROP TABLE IF EXISTS bunch_parents;
+DROP TABLE IF EXISTS bunch_keys;
+DROP TABLE IF EXISTS keys;
+DROP TABLE IF EXISTS bunches;
+DROP TABLE IF EXISTS users;
+DROP TABLE IF EXISTS token_histories;
+DROP TABLE IF EXISTS signing_keys;
+DROP TABLE IF EXISTS login_attempts;
+DROP TABLE IF EXISTS user_totps;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS user_tokens;
+`
+
+// default password: "password", the rows provisioned by migrations are inserted again with the ids the seed
+// refers to
+var seedingData = `
+DELETE FROM bunch_keys WHERE bunch_id IN (SELECT id FROM bunches WHERE name = 'resource_server');
+DELETE FROM bunches WHERE name = 'resource_server';
+DELETE FROM keys WHERE name = 'introspect_token';
+INSERT INTO keys (id, name, "desc") VALUES (1, 'add_key', 'Add a key');
+INSERT INTO keys (id, name, "desc") VALUES (2, 'modify_key', 'modify a key');
+INSERT INTO keys (id, name, "desc") VALUES (3, 'get_key', 'get a key');
+INSERT INTO keys (id, name, "desc") VALUES (4, 'query_key', 'list key');
+INSERT INTO keys (id, name, "desc") VALUES (5 ,'add_bunch', 'Add bunch');
+INSERT INTO keys (id, name, "desc") VALUES (6, 'modify_bunch', 'Modify bunch');
+INSERT INTO keys (id, name, "desc") VALUES (7, 'get_bunch', 'Get bunch');
+INSERT INTO keys (id, name, "desc") VALUES (8, 'query_bunch', 'Query bunches');
+INSERT INTO keys (id, name, "desc") VALUES (9, 'add_user', 'add_user');
+INSERT INTO keys (id, name, "desc") VALUES (10 ,'modify_user', 'modify_user');
+INSERT INTO keys (id, name, "desc") VALUES (11, 'introspect_token', 'Introspect a token');
+INSERT INTO bunches (id, name, "desc", active) VALUES (1, 'admin_role', 'Admin role', TRUE);
+INSERT INTO bunches (id, name, "desc", active) VALUES (2, 'staff_role', 'Staff role', TRUE);
+INSERT INTO bunches (id, name, "desc", active) VALUES (3, 'resource_server', 'Resource server', TRUE);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (6, 1, 6);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (7, 1, 7);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (8, 1, 8);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (9, 1, 9);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (10, 1, 10);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (11, 2, 1);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (12, 2, 2);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (13, 2, 3);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (14, 2, 4);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (15, 2, 5);
+INSERT INTO bunch_keys (id, bunch_id, key_id) VALUES (16, 3, 11);
+INSERT INTO bunch_parents (id, bunch_id, parent_id) VALUES (1, 1, 2);
+INSERT INTO users (id, full_name, username, hash, salt, email) VALUES (1, 'full_name', 'admin', '$2a$10$AdPyZkgVv70bXz9JvZLpH.CCQEzb8MbK8vMHIVQyCKtWestIyK46K', '123', 'admin@test.com');
+INSERT INTO users (id, full_name, username, hash, salt, email) VALUES (2, 'full_name', 'staff', '$2a$10$AdPyZkgVv70bXz9JvZLpH.CCQEzb8MbK8vMHIVQyCKtWestIyK46K', '123', 'staff@test.com');
+INSERT INTO user_bunches (id, user_id, bunch_id) VALUES (1, 1, 1);
+INSERT INTO user_bunches (id, user_id, bunch_id) VALUES (2, 1, 2);
+INSERT INTO user_bunches (id, user_id, bunch_id) VALUES (3, 2, 2);
+SELECT setval('keys_id_seq', (SELECT MAX(id) FROM keys));
+SELECT setval('bunches_id_seq', (SELECT MAX(id) FROM bunches));
+SELECT setval('bunch_keys_id_seq', (SELECT MAX(id) FROM bunch_keys));
+SELECT setval('users_id_seq', (SELECT MAX(id) FROM users));
+SELECT setval('user_bunches_id_seq', (SELECT MAX(id) FROM user_bunches));
+SELECT setval('bunch_parents_id_seq', (SELECT MAX(id) FROM bunch_parents));
+`
@@ -0,0 +1,68 @@
+package postgres
+
+import (
+	"github.com/lib/pq"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// postgres error codes
+const (
+	errUniqueViolation     = "23505"
+	errForeignKeyViolation = "23503"
+	errDeadlockDetected    = "40P01"
+	errSerializationFailed = "40001"
+	errLockNotAvailable    = "55P03"
+)
+
+type constraint struct {
+	entity string
+	field  string
+}
+
+// uniqueIndexes maps unique constraint names in db_schema.go to the violated field
+var uniqueIndexes = map[string]constraint{
+	"keys_key_uniq":       {"key", "name"},
+	"bunch_name_uniq":     {"bunch", "name"},
+	"users_username_uniq": {"user", "username"},
+	"users_email_uniq":    {"user", "email"},
+	"bunch_key_uniq":      {"bunch_key", "key_id"},
+	"user_bunch_uniq":     {"user_bunch", "bunch_id"},
+	"bunch_parent_uniq":   {"bunch_parent", "parent_id"},
+	"uid_uniq":            {"token_history", "uid"},
+}
+
+// foreignKeys maps foreign key names in db_schema.go to the violated field
+var foreignKeys = map[string]constraint{
+	"key_id_on_bunch_key":       {"bunch_key", "key_id"},
+	"role_id_on_bunch_key":      {"bunch_key", "bunch_id"},
+	"user_id_on_user_bunch":     {"user_bunch", "user_id"},
+	"bunch_id_on_user_bunch":    {"user_bunch", "bunch_id"},
+	"bunch_id_on_bunch_parent":  {"bunch_parent", "bunch_id"},
+	"parent_id_on_bunch_parent": {"bunch_parent", "parent_id"},
+}
+
+// mapError converts driver errors into storage errors, other errors are returned untouched
+func mapError(err error) error {
+	pqErr, ok := err.(*pq.Error)
+	if !ok {
+		return err
+	}
+
+	switch pqErr.Code {
+	case errUniqueViolation:
+		c, ok := uniqueIndexes[pqErr.Constraint]
+		if !ok {
+			c = constraint{pqErr.Table, pqErr.Column}
+		}
+		return &storage.DuplicateError{Entity: c.entity, Field: c.field, Err: err}
+
+	case errForeignKeyViolation:
+		c, ok := foreignKeys[pqErr.Constraint]
+		if !ok {
+			c = constraint{pqErr.Table, pqErr.Column}
+		}
+		return &storage.ForeignKeyError{Entity: c.entity, Field: c.field, Err: err}
+	}
+
+	return err
+}
@@ -0,0 +1,316 @@
+package postgres
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// postgres error code of a missing table
+const errUndefinedTable = "42P01"
+
+// lockRetryInterval is how often a migrator tries to take the migration lock held by another one
+const lockRetryInterval = 100 * time.Millisecond
+
+// DefaultLockTimeout is how long a migrator waits for another one to release the migration lock
+const DefaultLockTimeout = 30 * time.Second
+
+// DefaultMigrationTable is the table recording applied migrations
+const DefaultMigrationTable = "schema_migrations"
+
+var (
+	// ErrChecksumMismatch tells that an applied migration has been edited since it ran
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+	// ErrUnknownMigration tells that the database holds a migration which this build does not know
+	ErrUnknownMigration = errors.New("unknown migration")
+
+	// ErrLockTimeout tells that another migrator held the migration lock for too long
+	ErrLockTimeout = errors.New("timeout acquiring migration lock")
+)
+
+// Migration is a numbered, reversible change of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the scripts of a migration, it changes whenever one of them is edited
+func (mg *Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(mg.Up + "\x00" + mg.Down))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus tells whether a migration is applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Checksum  string
+	Applied   bool
+	AppliedAt time.Time
+
+	// Modified is set when the applied checksum differs from the migration's scripts
+	Modified bool
+
+	// Unknown is set when the migration is applied but missing from this build
+	Unknown bool
+}
+
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies the next n pending migrations, or all of them when n <= 0, and returns the applied migrations.
+// In dry run mode nothing is executed and the migrations which would be applied are returned.
+func (m *Migrator) Up(ctx context.Context, n int) ([]*Migration, error) {
+	var plan []*Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; !ok {
+				plan = append(plan, mg)
+			}
+		}
+		if n > 0 && n < len(plan) {
+			plan = plan[:n]
+		}
+
+		if m.DryRun {
+			return nil
+		}
+
+		for i, mg := range plan {
+			if err := m.apply(ctx, conn, mg, true); err != nil {
+				plan = plan[:i]
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return plan, err
+}
+
+// Down reverts the last n applied migrations, or all of them when n <= 0, and returns the reverted migrations.
+// In dry run mode nothing is executed and the migrations which would be reverted are returned.
+func (m *Migrator) Down(ctx context.Context, n int) ([]*Migration, error) {
+	var plan []*Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				plan = append(plan, m.migrations[i])
+			}
+		}
+		if n > 0 && n < len(plan) {
+			plan = plan[:n]
+		}
+
+		if m.DryRun {
+			return nil
+		}
+
+		for i, mg := range plan {
+			if err := m.apply(ctx, conn, mg, false); err != nil {
+				plan = plan[:i]
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return plan, err
+}
+
+// Status lists every known migration followed by the applied migrations missing from this build
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		st := &MigrationStatus{Version: mg.Version, Name: mg.Name, Checksum: mg.Checksum()}
+		if a, ok := applied[mg.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = a.appliedAt
+			st.Modified = a.checksum != st.Checksum
+			delete(applied, mg.Version)
+		}
+		results = append(results, st)
+	}
+
+	unknown := make([]*MigrationStatus, 0, len(applied))
+	for _, a := range applied {
+		unknown = append(unknown, &MigrationStatus{Version: a.version, Name: a.name, Checksum: a.checksum,
+			Applied: true, AppliedAt: a.appliedAt, Unknown: true})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+
+	return append(results, unknown...), nil
+}
+
+// locked runs fn on a single connection holding the migration lock, so concurrent migrators run one by one.
+// Advisory locks belong to the session, that is why everything runs on the same connection.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	lock := "migrate." + m.table
+	deadline := time.Now().Add(m.LockTimeout)
+
+	for {
+		var got bool
+		err = conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(hashtext($1));", lock).Scan(&got)
+		if err != nil {
+			return err
+		}
+		if got {
+			break
+		}
+		if time.Now().After(deadline) {
+			return ErrLockTimeout
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(lockRetryInterval):
+		}
+	}
+
+	defer func() {
+		// the lock is released anyway when the connection is closed
+		conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(hashtext($1));", lock)
+	}()
+
+	if !m.DryRun {
+		_, err = conn.ExecContext(ctx, fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s ("+
+			"version BIGINT NOT NULL, "+
+			"name VARCHAR(128) NOT NULL, "+
+			"checksum CHAR(64) NOT NULL, "+
+			"applied_at TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP, "+
+			"PRIMARY KEY (version));", pq.QuoteIdentifier(m.table)))
+		if err != nil {
+			return err
+		}
+	}
+
+	return fn(conn)
+}
+
+// verify refuses to migrate a database holding edited or unknown migrations
+func (m *Migrator) verify(ctx context.Context, conn *sql.Conn) (map[int]*appliedMigration, error) {
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]*Migration, len(m.migrations))
+	for _, mg := range m.migrations {
+		known[mg.Version] = mg
+	}
+
+	for _, a := range applied {
+		mg, ok := known[a.version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrUnknownMigration, a.version, a.name)
+		}
+		if mg.Checksum() != a.checksum {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrChecksumMismatch, a.version, a.name)
+		}
+	}
+
+	return applied, nil
+}
+
+// applied reads the applied migrations, a missing migration table means none is applied
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]*appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf("SELECT version, name, checksum, applied_at FROM %s;",
+		pq.QuoteIdentifier(m.table)))
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == errUndefinedTable {
+			return map[int]*appliedMigration{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[int]*appliedMigration)
+	for rows.Next() {
+		a := new(appliedMigration)
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		results[a.version] = a
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// apply runs one direction of a migration and records it in a single transaction, postgres rolls back
+// DDL statements too, so a failed migration leaves no trace
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg *Migration, up bool) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	table := pq.QuoteIdentifier(m.table)
+	script, record, args := mg.Down, fmt.Sprintf("DELETE FROM %s WHERE version = $1;", table),
+		[]interface{}{mg.Version}
+	if up {
+		script, record, args = mg.Up, fmt.Sprintf("INSERT INTO %s (version, name, checksum, applied_at) "+
+			"VALUES ($1, $2, $3, $4);", table), []interface{}{mg.Version, mg.Name, mg.Checksum(), time.Now()}
+	}
+
+	if _, err := tx.ExecContext(ctx, script); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d (%s): %w", mg.Version, mg.Name, err)
+	}
+
+	if _, err := tx.ExecContext(ctx, record, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,193 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+
+	"github.com/lib/pq"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.PermissionResolver = (*PermissionPostgresResolver)(nil)
+
+// userBunchAncestors is the CTE of the ancestors of a user's bunches, the user id is its argument
+var userBunchAncestors = bunchAncestors("SELECT bunch_id AS id FROM user_bunches WHERE user_id = $1")
+
+// userKeysJoin joins an active user to the keys of the user's active bunches and of their active ancestors,
+// it follows userBunchAncestors
+const userKeysJoin = "FROM users " +
+	"INNER JOIN user_bunches ON user_bunches.user_id = users.id " +
+	"INNER JOIN bunches ON bunches.id = user_bunches.bunch_id AND bunches.active " +
+	"INNER JOIN bunch_ancestors ON bunch_ancestors.bunch_id = bunches.id AND bunch_ancestors.inactive_id = 0 " +
+	"INNER JOIN bunch_keys ON bunch_keys.bunch_id = bunch_ancestors.ancestor_id " +
+	"INNER JOIN keys ON keys.id = bunch_keys.key_id " +
+	"WHERE users.id = $1 AND users.active"
+
+// PermissionPostgresResolver resolves user's keys in postgres db
+type PermissionPostgresResolver struct {
+	db executor
+}
+
+// NewPermissionPostgresResolver creates new instance of PermissionPostgresResolver
+func NewPermissionPostgresResolver(db executor) *PermissionPostgresResolver {
+	return &PermissionPostgresResolver{
+		db,
+	}
+}
+
+// Keys returns the deduplicated keys held by a user ordered by name
+func (rs *PermissionPostgresResolver) Keys(ctx context.Context, userID int64) ([]*storage.Key, error) {
+	sql := userBunchAncestors + `SELECT DISTINCT keys.id, keys.name, keys."desc", keys.updated_at ` + userKeysJoin +
+		" ORDER BY keys.name ASC;"
+
+	rows, err := rs.db.QueryxContext(ctx, sql, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make([]*storage.Key, 0)
+	for rows.Next() {
+		key := new(storage.Key)
+		if err := rows.Scan(&key.ID, &key.Name, &key.Desc, &key.UpdatedAt); err != nil {
+			return nil, err
+		}
+		results = append(results, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// HasKey tells whether a user holds a key
+func (rs *PermissionPostgresResolver) HasKey(ctx context.Context, userID int64, keyName string) (bool, error) {
+	return rs.HasAll(ctx, userID, keyName)
+}
+
+// HasAll tells whether a user holds every given key, it is true when no key is given
+func (rs *PermissionPostgresResolver) HasAll(ctx context.Context, userID int64, keyNames ...string) (bool, error) {
+	names := uniqueStrings(keyNames)
+	if len(names) == 0 {
+		return true, nil
+	}
+
+	count, err := rs.countKeys(ctx, userID, names)
+	if err != nil {
+		return false, err
+	}
+
+	return count == int64(len(names)), nil
+}
+
+// HasAny tells whether a user holds at least one of the given keys, it is false when no key is given
+func (rs *PermissionPostgresResolver) HasAny(ctx context.Context, userID int64, keyNames ...string) (bool, error) {
+	names := uniqueStrings(keyNames)
+	if len(names) == 0 {
+		return false, nil
+	}
+
+	count, err := rs.countKeys(ctx, userID, names)
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// countKeys counts how many of the given key names a user holds
+func (rs *PermissionPostgresResolver) countKeys(ctx context.Context, userID int64, names []string) (int64, error) {
+	sql := userBunchAncestors + "SELECT count(DISTINCT keys.id) " + userKeysJoin + " AND keys.name = ANY($2);"
+
+	var count int64
+	if err := rs.db.QueryRowxContext(ctx, sql, userID, pq.Array(names)).Scan(&count); err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// Explain tells every path granting a key to a user or, when the key is not granted, the reasons
+func (rs *PermissionPostgresResolver) Explain(ctx context.Context, userID int64, keyName string) (*storage.PermissionExplanation, error) {
+	sql := userBunchAncestors + "SELECT " +
+		`bunches.id, bunches.name, bunches."desc", bunches.active, bunches.updated_at, ` +
+		"user_bunches.id, user_bunches.user_id, user_bunches.bunch_id, user_bunches.updated_at, " +
+		"bunch_keys.id, bunch_keys.bunch_id, bunch_keys.key_id, bunch_keys.updated_at, " +
+		`sources.id, sources.name, sources."desc", sources.active, sources.updated_at, ` +
+		"bunch_ancestors.inactive_id " +
+		"FROM user_bunches " +
+		"INNER JOIN bunches ON bunches.id = user_bunches.bunch_id " +
+		"INNER JOIN bunch_ancestors ON bunch_ancestors.bunch_id = bunches.id " +
+		"INNER JOIN bunch_keys ON bunch_keys.bunch_id = bunch_ancestors.ancestor_id " +
+		"INNER JOIN bunches AS sources ON sources.id = bunch_ancestors.ancestor_id " +
+		"WHERE user_bunches.user_id = $1 AND bunch_keys.key_id = $2 " +
+		"ORDER BY bunches.name ASC, sources.name ASC;"
+
+	user, err := NewUserPostgresStorage(rs.db).Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewKeyPostgresStorer(rs.db).GetByName(ctx, keyName)
+	if errors.Is(err, storage.ErrNotFound) {
+		return storage.ExplainPaths(user, nil, nil), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := rs.db.QueryxContext(ctx, sql, userID, key.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	paths := make([]*storage.PermissionGrant, 0)
+	for rows.Next() {
+		b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+		ub := new(storage.UserBunch)
+		bk := new(storage.BunchKey)
+		source := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+		var inactiveID int64
+
+		err := rows.Scan(&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
+			&ub.ID, &ub.UserID, &ub.BunchID, &ub.UpdatedAt,
+			&bk.ID, &bk.BunchID, &bk.KeyID, &bk.UpdatedAt,
+			&source.ID, &source.Name, &source.Desc, &source.Active.Bool, &source.UpdatedAt, &inactiveID)
+		if err != nil {
+			return nil, err
+		}
+
+		grant := &storage.PermissionGrant{
+			UserBunch: &storage.AggregateUserBunch{Bunch: b, UserBunch: ub},
+			BunchKey:  &storage.AggregateBunchKey{BunchKey: bk, Key: key, Bunch: b},
+		}
+		if source.ID != b.ID {
+			grant.BunchKey.Source = source
+		}
+		if inactiveID > 0 {
+			grant.Inactive = &storage.Bunch{ID: inactiveID}
+		}
+		paths = append(paths, grant)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	// the inactive bunches on the way are few, they are read once the rows are
+	for _, p := range paths {
+		if p.Inactive == nil {
+			continue
+		}
+		if p.Inactive, err = NewBunchPostgresStorer(rs.db).Get(ctx, p.Inactive.ID); err != nil {
+			return nil, err
+		}
+	}
+
+	return storage.ExplainPaths(user, key, paths), nil
+}
@@ -0,0 +1,269 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.TokenHistoryStorer = (*TokenHistoryPostgresStorer)(nil)
+
+// TokenHistoryPostgresStorer implements db's storage for token histories
+type TokenHistoryPostgresStorer struct {
+	db executor
+}
+
+// NewTokenHistoryPostgresStorer creates new instance of TokenHistoryPostgresStorer
+func NewTokenHistoryPostgresStorer(db executor) *TokenHistoryPostgresStorer {
+	return &TokenHistoryPostgresStorer{
+		db,
+	}
+}
+
+const tokenHistoryColumns = "uid, user_id, access_token, refresh_token, remote_addr, x_forwarded_for, x_real_ip, " +
+	"user_agent, family_id, created_at, expired_at, refresh_expired_at, family_expired_at, rotated_at, revoked_at"
+
+func scanTokenHistory(rows *sqlx.Rows) (*storage.TokenHistory, error) {
+	var (
+		t                                                       = new(storage.TokenHistory)
+		refreshExpiredAt, familyExpiredAt, rotatedAt, revokedAt sql.NullTime
+	)
+
+	err := rows.Scan(&t.UID, &t.UserID, &t.AccessToken, &t.RefreshToken, &t.RemoteAddr, &t.XForwardedFor,
+		&t.XRealIP, &t.UserAgent, &t.FamilyID, &t.CreatedAt, &t.ExpiredAt, &refreshExpiredAt, &familyExpiredAt,
+		&rotatedAt, &revokedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	t.RefreshExpiredAt = refreshExpiredAt.Time
+	t.FamilyExpiredAt = familyExpiredAt.Time
+	t.RotatedAt = rotatedAt.Time
+	t.RevokedAt = revokedAt.Time
+
+	return t, nil
+}
+
+func (st *TokenHistoryPostgresStorer) Insert(ctx context.Context, t storage.CreateTokenHistory) (string, error) {
+	sql := "INSERT INTO token_histories (uid, user_id, access_token, refresh_token, remote_addr, x_forwarded_for, " +
+		"x_real_ip, user_agent, family_id, created_at, expired_at, refresh_expired_at, family_expired_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13);"
+
+	uid := t.UID
+	if len(uid) == 0 {
+		uid = share.NewUID()
+	}
+
+	familyID := t.FamilyID
+	if len(familyID) == 0 {
+		familyID = uid
+	}
+
+	_, err := st.db.ExecContext(ctx, sql, uid, t.UserID, t.AccessToken, t.RefreshToken, t.RemoteAddr,
+		t.XForwardedFor, t.XRealIP, t.UserAgent, familyID, time.Now(), t.ExpiredAt, nullTime(t.RefreshExpiredAt),
+		nullTime(t.FamilyExpiredAt))
+	if err != nil {
+		return "", mapError(err)
+	}
+
+	return uid, nil
+}
+
+func (st *TokenHistoryPostgresStorer) Get(ctx context.Context, uid string) (*storage.TokenHistory, error) {
+	sql := "SELECT " + tokenHistoryColumns + " FROM token_histories WHERE uid = $1 LIMIT 1;"
+
+	rows, err := st.db.QueryxContext(ctx, sql, uid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, storage.ErrNotFound
+	}
+
+	return scanTokenHistory(rows)
+}
+
+func (st *TokenHistoryPostgresStorer) Query(ctx context.Context, queries storage.QueryTokenHistory, sorts storage.SortTokenHistory) ([]*storage.TokenHistory, int64, error) {
+	var (
+		sql         = "SELECT " + tokenHistoryColumns + " FROM token_histories %s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount    = "SELECT count(uid) FROM token_histories %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.TokenHistory
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.UserID > 0 {
+		filter["user_id"] = queries.UserID
+		where += wherePrefix + "user_id = :user_id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.FamilyID) > 0 {
+		filter["family_id"] = queries.FamilyID
+		where += wherePrefix + "family_id = :family_id"
+		wherePrefix = " AND "
+	}
+
+	if queries.Active.IsSet {
+		filter["now"] = time.Now()
+		if queries.Active.Bool {
+			where += wherePrefix + "(revoked_at IS NULL AND expired_at > :now)"
+		} else {
+			where += wherePrefix + "(revoked_at IS NOT NULL OR expired_at <= :now)"
+		}
+		wherePrefix = " AND "
+	}
+
+	if queries.Live.IsSet {
+		filter["now"] = time.Now()
+		live := "revoked_at IS NULL AND (expired_at > :now OR (rotated_at IS NULL AND refresh_expired_at IS NOT NULL AND refresh_expired_at > :now))"
+		if queries.Live.Bool {
+			where += wherePrefix + "(" + live + ")"
+		} else {
+			where += wherePrefix + "NOT (" + live + ")"
+		}
+		wherePrefix = " AND "
+	}
+
+	if !queries.From.IsZero() {
+		filter["from"] = queries.From
+		where += wherePrefix + "created_at > :from"
+		wherePrefix = " AND "
+	}
+
+	if !queries.To.IsZero() {
+		filter["to"] = queries.To
+		where += wherePrefix + "created_at <= :to"
+		wherePrefix = " AND "
+	}
+
+	if sorts.CreatedAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("created_at %s", getOrderDirection(sorts.CreatedAt))
+		orderPrefix = ", "
+	}
+
+	if sorts.ExpiredAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("expired_at %s", getOrderDirection(sorts.ExpiredAt))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		order = "created_at DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.TokenHistory, 0, queries.Limit)
+		for rows.Next() {
+			t, err := scanTokenHistory(rows)
+			if err != nil {
+				return err
+			}
+			results = append(results, t)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Revoke marks a token as revoked, revoking an already revoked token keeps its original revoked time
+func (st *TokenHistoryPostgresStorer) Revoke(ctx context.Context, uid string) error {
+	sql := "UPDATE token_histories SET revoked_at = $1 WHERE uid = $2 AND revoked_at IS NULL;"
+
+	_, err := st.db.ExecContext(ctx, sql, time.Now(), uid)
+
+	return err
+}
+
+// Rotate marks the refresh token of a token as exchanged, it returns false when it already was or the token
+// does not exist
+func (st *TokenHistoryPostgresStorer) Rotate(ctx context.Context, uid string) (bool, error) {
+	res, err := st.db.ExecContext(ctx, "UPDATE token_histories SET rotated_at = $1 WHERE uid = $2 AND rotated_at IS NULL;",
+		time.Now(), uid)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// RevokeFamily revokes the tokens of a family which are not revoked yet and returns how many were revoked
+func (st *TokenHistoryPostgresStorer) RevokeFamily(ctx context.Context, familyID string) (int64, error) {
+	res, err := st.db.ExecContext(ctx,
+		"UPDATE token_histories SET revoked_at = $1 WHERE family_id = $2 AND revoked_at IS NULL;", time.Now(), familyID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// RevokeUser revokes the tokens of a user which are not revoked yet and returns how many were revoked
+func (st *TokenHistoryPostgresStorer) RevokeUser(ctx context.Context, userID int64) (int64, error) {
+	return revokeUserTokens(ctx, st.db, userID)
+}
+
+// Purge deletes tokens whose access and refresh tokens expired at or before the given time and returns the
+// number of deleted rows
+func (st *TokenHistoryPostgresStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	sql := "DELETE FROM token_histories WHERE expired_at <= $1 AND " +
+		"(refresh_expired_at IS NULL OR refresh_expired_at <= $2);"
+
+	res, err := st.db.ExecContext(ctx, sql, before, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// revokeUserTokens revokes the tokens of a user which are not revoked yet and returns how many were revoked
+func revokeUserTokens(ctx context.Context, ex executor, userID int64) (int64, error) {
+	res, err := ex.ExecContext(ctx, "UPDATE token_histories SET revoked_at = $1 WHERE user_id = $2 AND revoked_at IS NULL;",
+		time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
+
+// nullTime stores a zero time as NULL
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
@@ -0,0 +1,540 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	_ storage.UserStorer      = (*UserPostgresStorage)(nil)
+	_ storage.UserBunchStorer = (*UserBunchPostgresStorage)(nil)
+)
+
+// UserPostgresStorage implements db's storage for user
+type UserPostgresStorage struct {
+	db executor
+}
+
+// UserBunchPostgresStorage implements db's storage for user-bunch
+type UserBunchPostgresStorage struct {
+	db executor
+}
+
+// NewUserPostgresStorage create new instance of UserPostgresStorage
+func NewUserPostgresStorage(db executor) *UserPostgresStorage {
+	return &UserPostgresStorage{
+		db,
+	}
+}
+
+// NewUserBunchPostgresStorage create new instance of UserBunchPostgresStorage
+func NewUserBunchPostgresStorage(db executor) *UserBunchPostgresStorage {
+	return &UserBunchPostgresStorage{
+		db,
+	}
+}
+
+const userColumns = "users.id, users.full_name, users.username, users.email, users.hash, users.salt, " +
+	"users.active, users.updated_at, users.email_verified_at, users.pending_email"
+
+func scanUser(rows *sqlx.Rows) (*storage.User, error) {
+	u := &storage.User{Active: share.Boolean{IsSet: true}}
+
+	err := rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
+		scanTime{&u.EmailVerifiedAt}, &u.PendingEmail)
+	if err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+func (st *UserPostgresStorage) Insert(ctx context.Context, u storage.CreateUser) (int64, error) {
+	sql := "INSERT INTO users (full_name, username, email, hash, salt, updated_at) " +
+		"VALUES ($1, $2, $3, $4, $5, $6) RETURNING id;"
+
+	var id int64
+	err := st.db.QueryRowxContext(ctx, sql, u.FullName, u.Username, u.Email, u.Hash, u.Salt, time.Now()).Scan(&id)
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return id, nil
+}
+
+func (st *UserPostgresStorage) Update(ctx context.Context, u storage.UpdateUser) error {
+	var (
+		sql       = "UPDATE users SET %s WHERE id = :id;"
+		condition string
+		prefix    string
+	)
+
+	updating := make(map[string]interface{})
+
+	if len(u.FullName) > 0 {
+		updating["full_name"] = u.FullName
+		condition += prefix + "full_name = :full_name"
+		prefix = ", "
+	}
+
+	if len(u.Username) > 0 {
+		updating["username"] = u.Username
+		condition += prefix + "username = :username"
+		prefix = ", "
+	}
+
+	if len(u.Email) > 0 {
+		updating["email"] = u.Email
+		// a new email is unverified, the verified email is set again without losing its verification
+		condition += prefix + "email_verified_at = CASE WHEN email = :email THEN email_verified_at ELSE NULL END, " +
+			"pending_email = '', email = :email"
+		prefix = ", "
+	}
+
+	if len(u.Hash) > 0 {
+		updating["hash"] = u.Hash
+		condition += prefix + "hash = :hash"
+		prefix = ", "
+	}
+
+	if len(u.Salt) > 0 {
+		updating["salt"] = u.Salt
+		condition += prefix + "salt = :salt"
+		prefix = ", "
+	}
+
+	if u.Active.IsSet {
+		updating["active"] = u.Active.Bool
+		condition += prefix + "active = :active"
+		prefix = ", "
+	}
+
+	if len(updating) > 0 {
+		updating["id"] = u.ID
+		updating["updated_at"] = time.Now()
+		condition += prefix + "updated_at = :updated_at"
+
+		deactivated := u.Active.IsSet && !u.Active.Bool
+		if !deactivated && len(u.Hash) == 0 && len(u.Email) == 0 {
+			_, err := st.db.NamedExecContext(ctx, fmt.Sprintf(sql, condition), updating)
+			if err != nil {
+				return mapError(err)
+			}
+
+			return nil
+		}
+
+		// a deactivated user loses its outstanding tokens, a new password or email voids the outstanding reset or
+		// verification tokens
+		return inTx(ctx, st.db, func(tx executor) error {
+			if _, err := tx.NamedExecContext(ctx, fmt.Sprintf(sql, condition), updating); err != nil {
+				return mapError(err)
+			}
+
+			if deactivated {
+				if _, err := revokeUserTokens(ctx, tx, u.ID); err != nil {
+					return err
+				}
+			}
+
+			if len(u.Hash) > 0 {
+				if _, err := invalidateUserTokens(ctx, tx, u.ID, storage.PurposePasswordReset); err != nil {
+					return err
+				}
+			}
+
+			if len(u.Email) > 0 {
+				if _, err := invalidateUserTokens(ctx, tx, u.ID, storage.PurposeEmailVerification); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+	}
+
+	return nil
+}
+
+// SetPendingEmail sets the pending email of a user, an empty one clears it
+func (st *UserPostgresStorage) SetPendingEmail(ctx context.Context, id int64, email string) error {
+	sql := "UPDATE users SET pending_email = :pending_email, updated_at = :updated_at WHERE id = :id;"
+
+	// a pending email voids the verification tokens mailed for the previous one
+	return inTx(ctx, st.db, func(tx executor) error {
+		_, err := tx.NamedExecContext(ctx, sql, map[string]interface{}{"id": id, "pending_email": email,
+			"updated_at": time.Now()})
+		if err != nil {
+			return err
+		}
+
+		_, err = invalidateUserTokens(ctx, tx, id, storage.PurposeEmailVerification)
+		return err
+	})
+}
+
+// VerifyEmail marks email verified when it is the email or the pending email of a user, a pending email
+// replaces the email
+func (st *UserPostgresStorage) VerifyEmail(ctx context.Context, id int64, email string) (bool, error) {
+	if len(email) == 0 {
+		return false, nil
+	}
+
+	// the verification time is set before the email so mysql, which assigns from left to right, compares the
+	// email it had
+	sql := "UPDATE users SET " +
+		"email_verified_at = CASE WHEN email = :email THEN COALESCE(email_verified_at, :now) ELSE :now END, " +
+		"pending_email = CASE WHEN pending_email = :email THEN '' ELSE pending_email END, " +
+		"email = :email, updated_at = :now " +
+		"WHERE id = :id AND (email = :email OR pending_email = :email);"
+
+	res, err := st.db.NamedExecContext(ctx, sql, map[string]interface{}{"id": id, "email": email, "now": time.Now()})
+	if err != nil {
+		return false, mapError(err)
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+func (st *UserPostgresStorage) Get(ctx context.Context, id int64) (*storage.User, error) {
+	return st.getBy(ctx, "id", id)
+}
+
+// Lock locks the row of a user until the transaction ends, it only serializes callers running in
+// Repository.WithTx
+func (st *UserPostgresStorage) Lock(ctx context.Context, id int64) error {
+	var locked int64
+	err := st.db.QueryRowxContext(ctx, "SELECT id FROM users WHERE id = $1 FOR UPDATE;", id).Scan(&locked)
+	if err == sql.ErrNoRows {
+		return storage.ErrNotFound
+	}
+
+	return err
+}
+
+func (st *UserPostgresStorage) GetByName(ctx context.Context, username string) (*storage.User, error) {
+	return st.getBy(ctx, "username", username)
+}
+
+func (st *UserPostgresStorage) GetByEmail(ctx context.Context, email string) (*storage.User, error) {
+	return st.getBy(ctx, "email", email)
+}
+
+func (st *UserPostgresStorage) getBy(ctx context.Context, column string, value interface{}) (*storage.User, error) {
+	sql := fmt.Sprintf("SELECT %s FROM users WHERE %s = $1 LIMIT 1;", userColumns, column)
+
+	rows, err := st.db.QueryxContext(ctx, sql, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, storage.ErrNotFound
+	}
+
+	return scanUser(rows)
+}
+
+func (st *UserPostgresStorage) Query(ctx context.Context, queries storage.QueryUser, sorts storage.SortUser) ([]*storage.User, int64, error) {
+	var (
+		sql         = "SELECT " + userColumns + " FROM users %s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount    = "SELECT count(id) FROM users %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.User
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if len(queries.FullName) > 0 {
+		filter["full_name"] = "%" + queries.FullName + "%"
+		where += wherePrefix + "full_name ILIKE :full_name"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Username) > 0 {
+		filter["username"] = "%" + queries.Username + "%"
+		where += wherePrefix + "username ILIKE :username"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Email) > 0 {
+		filter["email"] = "%" + queries.Email + "%"
+		where += wherePrefix + "email ILIKE :email"
+		wherePrefix = " AND "
+	}
+
+	if queries.Active.IsSet {
+		filter["active"] = queries.Active.Bool
+		where += wherePrefix + "active = :active"
+		wherePrefix = " AND "
+	}
+
+	if queries.Verified.IsSet {
+		if queries.Verified.Bool {
+			where += wherePrefix + "email_verified_at IS NOT NULL"
+		} else {
+			where += wherePrefix + "email_verified_at IS NULL"
+		}
+		wherePrefix = " AND "
+	}
+
+	if !queries.From.IsZero() {
+		filter["from"] = queries.From
+		where += wherePrefix + "updated_at > :from"
+		wherePrefix = " AND "
+	}
+
+	if !queries.To.IsZero() {
+		filter["to"] = queries.To
+		where += wherePrefix + "updated_at <= :to"
+		wherePrefix = " AND "
+	}
+
+	if sorts.Username != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("username %s", getOrderDirection(sorts.Username))
+		orderPrefix = ", "
+	}
+
+	if sorts.FullName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("full_name %s", getOrderDirection(sorts.FullName))
+		orderPrefix = ", "
+	}
+
+	if sorts.Email != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("email %s", getOrderDirection(sorts.Email))
+		orderPrefix = ", "
+	}
+
+	if sorts.UpdatedAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("updated_at %s", getOrderDirection(sorts.UpdatedAt))
+		orderPrefix = ", "
+	}
+
+	if sorts.Active != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("active %s", getOrderDirection(sorts.Active))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		order = "id DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.User, 0, queries.Limit)
+		for rows.Next() {
+			u, err := scanUser(rows)
+			if err != nil {
+				return err
+			}
+			results = append(results, u)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+func (st *UserBunchPostgresStorage) Insert(ctx context.Context, u storage.CreateUserBunch) (int64, error) {
+	sql := "INSERT INTO user_bunches (user_id, bunch_id, updated_at) VALUES ($1, $2, $3) RETURNING id;"
+
+	var id int64
+	if err := st.db.QueryRowxContext(ctx, sql, u.UserID, u.BunchID, time.Now()).Scan(&id); err != nil {
+		return 0, mapError(err)
+	}
+
+	return id, nil
+}
+
+func (st *UserBunchPostgresStorage) Delete(ctx context.Context, id int64) error {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM user_bunches WHERE id = $1;", id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (st *UserBunchPostgresStorage) Get(ctx context.Context, id int64) (*storage.AggregateUserBunch, error) {
+	rows, _, err := st.Query(ctx, storage.QueryUserBunch{ID: id, Limit: 1}, storage.SortUserBunch{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *UserBunchPostgresStorage) Query(ctx context.Context, queries storage.QueryUserBunch, sorts storage.SortUserBunch) ([]*storage.AggregateUserBunch, int64, error) {
+	var (
+		sql = "SELECT " + userColumns + `, bunches.id, bunches.name, bunches."desc", bunches.active, ` +
+			"bunches.updated_at, user_bunches.id, user_bunches.user_id, user_bunches.bunch_id, " +
+			"user_bunches.updated_at FROM users " +
+			"INNER JOIN user_bunches ON users.id = user_bunches.user_id " +
+			"INNER JOIN bunches ON user_bunches.bunch_id = bunches.id " +
+			"%s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount = "SELECT count(user_bunches.id) FROM users " +
+			"INNER JOIN user_bunches ON users.id = user_bunches.user_id " +
+			"INNER JOIN bunches ON user_bunches.bunch_id = bunches.id %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.AggregateUserBunch
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.ID > 0 {
+		filter["id"] = queries.ID
+		where += wherePrefix + "user_bunches.id = :id"
+		wherePrefix = " AND "
+	}
+
+	if queries.UserID > 0 {
+		filter["user_id"] = queries.UserID
+		where += wherePrefix + "user_bunches.user_id = :user_id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Username) > 0 {
+		filter["username"] = "%" + queries.Username + "%"
+		where += wherePrefix + "users.username ILIKE :username"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.BunchName) > 0 {
+		filter["name"] = "%" + queries.BunchName + "%"
+		where += wherePrefix + "bunches.name ILIKE :name"
+		wherePrefix = " AND "
+	}
+
+	if queries.UserActive.IsSet {
+		filter["user_active"] = queries.UserActive.Bool
+		where += wherePrefix + "users.active = :user_active"
+		wherePrefix = " AND "
+	}
+
+	if queries.BunchActive.IsSet {
+		filter["bunch_active"] = queries.BunchActive.Bool
+		where += wherePrefix + "bunches.active = :bunch_active"
+		wherePrefix = " AND "
+	}
+
+	if sorts.Username != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("users.username %s", getOrderDirection(sorts.Username))
+		orderPrefix = ", "
+	}
+
+	if sorts.BunchName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("bunches.name %s", getOrderDirection(sorts.BunchName))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		order = "user_bunches.id DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.AggregateUserBunch, 0, queries.Limit)
+		for rows.Next() {
+			u := &storage.User{Active: share.Boolean{IsSet: true}}
+			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+			ub := new(storage.UserBunch)
+
+			err := rows.Scan(&u.ID, &u.FullName, &u.Username, &u.Email, &u.Hash, &u.Salt, &u.Active.Bool, &u.UpdatedAt,
+				scanTime{&u.EmailVerifiedAt}, &u.PendingEmail,
+				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
+				&ub.ID, &ub.UserID, &ub.BunchID, &ub.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			results = append(results, &storage.AggregateUserBunch{
+				User:      u,
+				Bunch:     b,
+				UserBunch: ub,
+			})
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// scanTime scans a nullable timestamp into t, NULL scans as the zero time
+type scanTime struct {
+	t *time.Time
+}
+
+func (s scanTime) Scan(value interface{}) error {
+	var nt sql.NullTime
+	if err := nt.Scan(value); err != nil {
+		return err
+	}
+
+	*s.t = nt.Time
+
+	return nil
+}
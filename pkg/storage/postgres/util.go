@@ -0,0 +1,94 @@
+package postgres
+
+import (
+	"context"
+	"sync"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+func getOrderDirection(i share.Direction) string {
+	switch i {
+	case share.Ascendant:
+		return "ASC"
+	case share.Descendant:
+		return "DESC"
+	default:
+		return ""
+	}
+}
+
+// queryAndCount runs the page query and the total count query. They run concurrently on a
+// connection pool, the first failure cancels the other one. Inside a transaction they run one
+// after another because a transaction holds a single connection.
+func queryAndCount(ctx context.Context, ex executor, query func(context.Context) error, count func(context.Context) error) error {
+	if _, ok := ex.(*sqlx.Tx); ok {
+		if err := query(ctx); err != nil {
+			return err
+		}
+		return count(ctx)
+	}
+
+	var (
+		wg       sync.WaitGroup
+		queryErr error
+		countErr error
+	)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if queryErr = query(ctx); queryErr != nil {
+			cancel()
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if countErr = count(ctx); countErr != nil {
+			cancel()
+		}
+	}()
+	wg.Wait()
+
+	if queryErr != nil {
+		return queryErr
+	}
+	return countErr
+}
+
+// countTotal scans the result of a named count query into total
+func countTotal(ctx context.Context, ex executor, sqlcount string, filter map[string]interface{}, total *int64) error {
+	rows, err := sqlx.NamedQueryContext(ctx, ex, sqlcount, filter)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		if err := rows.Scan(total); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// uniqueStrings removes duplicated and empty strings keeping the original order
+func uniqueStrings(values []string) []string {
+	seen := make(map[string]bool, len(values))
+	results := make([]string, 0, len(values))
+
+	for _, v := range values {
+		if len(v) == 0 || seen[v] {
+			continue
+		}
+		seen[v] = true
+		results = append(results, v)
+	}
+
+	return results
+}
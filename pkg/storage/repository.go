@@ -0,0 +1,30 @@
+package storage
+
+import "context"
+
+//Stores gives access to storers sharing the same connection pool or transaction
+type Stores interface {
+	Keys() KeyStorer
+	Bunches() BunchStorer
+	BunchKeys() BunchKeyStorer
+	BunchParents() BunchParentStorer
+	Users() UserStorer
+	UserBunches() UserBunchStorer
+	TokenHistories() TokenHistoryStorer
+	SigningKeys() SigningKeyStorer
+	LoginAttempts() LoginAttemptStorer
+	TOTPs() TOTPStorer
+	RecoveryCodes() RecoveryCodeStorer
+	UserTokens() UserTokenStorer
+	Permissions() PermissionResolver
+}
+
+//Repository is the entry point of a storage backend
+type Repository interface {
+	Stores
+
+	//WithTx runs fn in a transaction which is committed when fn returns nil and rolled back when
+	//fn returns an error or panics. fn may run more than once when the transaction is aborted by
+	//a deadlock or a lock wait timeout, so it should have no side effects outside of tx.
+	WithTx(ctx context.Context, fn func(tx Stores) error) error
+}
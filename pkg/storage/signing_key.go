@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+//SigningKey model. PrivateKey is encrypted by the caller, so the table never holds a usable private key, and
+//PublicKey is DER encoded. A key signs tokens from ActivatedAt until a newer key is activated and verifies
+//them until ExpiredAt, which is zero while the key has no successor.
+type SigningKey struct {
+	KID         string
+	Algorithm   string
+	PublicKey   []byte
+	PrivateKey  []byte
+	CreatedAt   time.Time
+	ActivatedAt time.Time
+	ExpiredAt   time.Time
+}
+
+//CreateSigningKey model, a new kid will be generated when KID is empty
+type CreateSigningKey struct {
+	KID         string
+	Algorithm   string
+	PublicKey   []byte
+	PrivateKey  []byte
+	ActivatedAt time.Time
+}
+
+//QuerySigningKey model, ExpiredAfter filters the keys which have not expired at that time
+type QuerySigningKey struct {
+	Limit        int64
+	Offset       int64
+	Algorithm    string
+	ExpiredAfter time.Time
+}
+
+//SortSigningKey model
+type SortSigningKey struct {
+	ActivatedAt share.Direction
+}
+
+//SigningKeyStorer defines fundamental functions to interact with storage repository. Expire sets the
+//expiry of a key which has none yet, so a key never outlives its first successor. Purge deletes the keys
+//which expired at or before the given time.
+type SigningKeyStorer interface {
+	Insert(ctx context.Context, k CreateSigningKey) (string, error)
+	Get(ctx context.Context, kid string) (*SigningKey, error)
+	Query(ctx context.Context, queries QuerySigningKey, sorts SortSigningKey) ([]*SigningKey, int64, error)
+	Expire(ctx context.Context, kid string, at time.Time) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
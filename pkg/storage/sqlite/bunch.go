@@ -0,0 +1,598 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var (
+	_ storage.BunchStorer       = (*BunchSqliteStorer)(nil)
+	_ storage.BunchKeyStorer    = (*BunchKeySqliteStorer)(nil)
+	_ storage.BunchParentStorer = (*BunchParentSqliteStorer)(nil)
+)
+
+// bunchAncestors returns a CTE pairing the bunches selected by seed, a query of their ids named id, with
+// themselves and with each bunch they inherit from, directly or not. inactive_id is the first inactive bunch on
+// the way to the ancestor, the ancestor included but not the bunch itself, or 0 when there is none. UNION drops
+// the rows found again, so it ends even on a cycle.
+func bunchAncestors(seed string) string {
+	return "WITH RECURSIVE bunch_ancestors (bunch_id, ancestor_id, inactive_id) AS (" +
+		"SELECT seeds.id, seeds.id, CAST(0 AS INTEGER) FROM (" + seed + ") AS seeds " +
+		"UNION " +
+		"SELECT bunch_ancestors.bunch_id, bunch_parents.parent_id, " +
+		"CASE WHEN bunch_ancestors.inactive_id = 0 AND NOT parents.active THEN parents.id " +
+		"ELSE bunch_ancestors.inactive_id END FROM bunch_ancestors " +
+		"INNER JOIN bunch_parents ON bunch_parents.bunch_id = bunch_ancestors.ancestor_id " +
+		"INNER JOIN bunches AS parents ON parents.id = bunch_parents.parent_id) "
+}
+
+// BunchSqliteStorer implements db's storage for bunch
+type BunchSqliteStorer struct {
+	db executor
+}
+
+// BunchKeySqliteStorer implements db's storage for bunch-key
+type BunchKeySqliteStorer struct {
+	db executor
+}
+
+// BunchParentSqliteStorer implements db's storage for bunch-parent
+type BunchParentSqliteStorer struct {
+	db executor
+}
+
+// NewBunchSqliteStorer create new instance of BunchSqliteStorer
+func NewBunchSqliteStorer(db executor) *BunchSqliteStorer {
+	return &BunchSqliteStorer{
+		db,
+	}
+}
+
+// NewBunchKeySqliteStorer create new instance of BunchKeySqliteStorer
+func NewBunchKeySqliteStorer(db executor) *BunchKeySqliteStorer {
+	return &BunchKeySqliteStorer{
+		db,
+	}
+}
+
+// NewBunchParentSqliteStorer create new instance of BunchParentSqliteStorer
+func NewBunchParentSqliteStorer(db executor) *BunchParentSqliteStorer {
+	return &BunchParentSqliteStorer{
+		db,
+	}
+}
+
+func (st *BunchSqliteStorer) Insert(ctx context.Context, u storage.CreateBunch) (int64, error) {
+	sql := `INSERT INTO bunches (name, "desc", active, updated_at) VALUES (?, ?, ?, ?);`
+
+	res, err := st.db.ExecContext(ctx, sql, u.Name, u.Desc, true, time.Now())
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (st *BunchSqliteStorer) Update(ctx context.Context, u storage.UpdateBunch) error {
+	var (
+		sql      = "UPDATE bunches SET %s WHERE id = :id;"
+		fields   string
+		prefix   string
+		updating = make(map[string]interface{})
+	)
+
+	if len(u.Name) > 0 {
+		fields += prefix + "name = :name"
+		prefix = ", "
+		updating["name"] = u.Name
+	}
+
+	if len(u.Desc) > 0 {
+		fields += prefix + `"desc" = :desc`
+		prefix = ", "
+		updating["desc"] = u.Desc
+	}
+
+	if u.Active.IsSet {
+		fields += prefix + "active = :active"
+		prefix = ", "
+		updating["active"] = u.Active.Bool
+	}
+
+	if len(updating) > 0 {
+		fields += prefix + "updated_at = :updated_at"
+		updating["updated_at"] = time.Now()
+		updating["id"] = u.ID
+
+		_, err := st.db.NamedExecContext(ctx, fmt.Sprintf(sql, fields), updating)
+		if err != nil {
+			return mapError(err)
+		}
+	}
+
+	return nil
+}
+
+func (st *BunchSqliteStorer) Get(ctx context.Context, id int64) (*storage.Bunch, error) {
+	return st.getBy(ctx, "id", id)
+}
+
+func (st *BunchSqliteStorer) GetByName(ctx context.Context, name string) (*storage.Bunch, error) {
+	return st.getBy(ctx, "name", name)
+}
+
+func (st *BunchSqliteStorer) getBy(ctx context.Context, column string, value interface{}) (*storage.Bunch, error) {
+	sql := fmt.Sprintf(`SELECT id, name, "desc", active, updated_at FROM bunches WHERE %s = ? LIMIT 1;`, column)
+
+	rows, err := st.db.QueryxContext(ctx, sql, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, storage.ErrNotFound
+	}
+
+	b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+	if err := rows.Scan(&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func (st *BunchSqliteStorer) Query(ctx context.Context, queries storage.QueryBunch, sorts storage.SortBunch) ([]*storage.Bunch, int64, error) {
+	var (
+		sql         = `SELECT id, name, "desc", active, updated_at FROM bunches %s ORDER BY %s LIMIT :limit OFFSET :offset;`
+		sqlcount    = "SELECT count(id) FROM bunches %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.Bunch
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if len(queries.Name) > 0 {
+		filter["name"] = "%" + queries.Name + "%"
+		where += wherePrefix + "name LIKE :name"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Desc) > 0 {
+		filter["desc"] = "%" + queries.Desc + "%"
+		where += wherePrefix + `"desc" LIKE :desc`
+		wherePrefix = " AND "
+	}
+
+	if queries.Active.IsSet {
+		filter["active"] = queries.Active.Bool
+		where += wherePrefix + "active = :active"
+		wherePrefix = " AND "
+	}
+
+	if !queries.From.IsZero() {
+		filter["from"] = queries.From
+		where += wherePrefix + "updated_at > :from"
+		wherePrefix = " AND "
+	}
+
+	if !queries.To.IsZero() {
+		filter["to"] = queries.To
+		where += wherePrefix + "updated_at <= :to"
+		wherePrefix = " AND "
+	}
+
+	if sorts.Name != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("name %s", getOrderDirection(sorts.Name))
+		orderPrefix = ", "
+	}
+
+	if sorts.Desc != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf(`"desc" %s`, getOrderDirection(sorts.Desc))
+		orderPrefix = ", "
+	}
+
+	if sorts.UpdatedAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("updated_at %s", getOrderDirection(sorts.UpdatedAt))
+		orderPrefix = ", "
+	}
+
+	if sorts.Active != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("active %s", getOrderDirection(sorts.Active))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		order = "id DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.Bunch, 0, queries.Limit)
+		for rows.Next() {
+			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+			if err := rows.Scan(&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt); err != nil {
+				return err
+			}
+			results = append(results, b)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+func (st *BunchKeySqliteStorer) Insert(ctx context.Context, bk storage.BunchKey) (int64, error) {
+	sql := "INSERT INTO bunch_keys (bunch_id, key_id, updated_at) VALUES (?, ?, ?);"
+
+	res, err := st.db.ExecContext(ctx, sql, bk.BunchID, bk.KeyID, time.Now())
+	if err != nil {
+		return 0, withReference(ctx, st.db, mapError(err), "bunch_key",
+			reference{"bunch_id", "bunches", bk.BunchID}, reference{"key_id", "keys", bk.KeyID})
+	}
+
+	return res.LastInsertId()
+}
+
+func (st *BunchKeySqliteStorer) Delete(ctx context.Context, id int64) error {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM bunch_keys WHERE id = ?;", id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (st *BunchKeySqliteStorer) Get(ctx context.Context, id int64) (*storage.AggregateBunchKey, error) {
+	rows, _, err := st.Query(ctx, storage.QueryBunchKey{ID: id, Limit: 1}, storage.SortBunchKey{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *BunchKeySqliteStorer) Query(ctx context.Context, queries storage.QueryBunchKey, sorts storage.SortBunchKey) ([]*storage.AggregateBunchKey, int64, error) {
+	var (
+		sql = `%sSELECT keys.id, keys.name, keys."desc", keys.updated_at, ` +
+			`bunches.id, bunches.name, bunches."desc", bunches.active, bunches.updated_at, ` +
+			"bunch_keys.id, bunch_keys.bunch_id, bunch_keys.key_id, bunch_keys.updated_at, " +
+			`sources.id, sources.name, sources."desc", sources.active, sources.updated_at ` +
+			"%s %s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount    = "%sSELECT count(bunch_keys.id) %s %s;"
+		cte         string
+		seed        string
+		seedPrefix  = " WHERE "
+		from        string
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.AggregateBunchKey
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.Inherited {
+		// sources are the ancestors holding the keys, the bunch itself for its own keys. An inactive ancestor
+		// passes on none of the keys it holds or inherits.
+		from = "FROM bunch_ancestors " +
+			"INNER JOIN bunch_keys ON bunch_keys.bunch_id = bunch_ancestors.ancestor_id " +
+			"INNER JOIN keys ON keys.id = bunch_keys.key_id " +
+			"INNER JOIN bunches ON bunches.id = bunch_ancestors.bunch_id " +
+			"INNER JOIN bunches AS sources ON sources.id = bunch_ancestors.ancestor_id"
+		where += wherePrefix + "bunch_ancestors.inactive_id = 0"
+		wherePrefix = " AND "
+	} else {
+		from = "FROM bunch_keys " +
+			"INNER JOIN keys ON keys.id = bunch_keys.key_id " +
+			"INNER JOIN bunches ON bunches.id = bunch_keys.bunch_id " +
+			"INNER JOIN bunches AS sources ON sources.id = bunch_keys.bunch_id"
+	}
+
+	if queries.ID > 0 {
+		filter["id"] = queries.ID
+		where += wherePrefix + "bunch_keys.id = :id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.BunchName) > 0 {
+		filter["bunch_name"] = queries.BunchName
+		where += wherePrefix + "bunches.name = :bunch_name"
+		seed += seedPrefix + "name = :bunch_name"
+		wherePrefix = " AND "
+		seedPrefix = " AND "
+	}
+
+	if len(queries.KeyName) > 0 {
+		filter["key_name"] = queries.KeyName
+		where += wherePrefix + "keys.name = :key_name"
+		wherePrefix = " AND "
+	}
+
+	if queries.BunchActive.IsSet {
+		filter["active"] = queries.BunchActive.Bool
+		where += wherePrefix + "bunches.active = :active"
+		seed += seedPrefix + "active = :active"
+		wherePrefix = " AND "
+		seedPrefix = " AND "
+	}
+
+	if queries.Inherited {
+		// only the ancestors of the bunches filtered are looked for
+		cte = bunchAncestors("SELECT id FROM bunches" + seed)
+	}
+
+	if sorts.BunchName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("bunches.name %s", getOrderDirection(sorts.BunchName))
+		orderPrefix = ", "
+	}
+
+	if sorts.KeyName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("keys.name %s", getOrderDirection(sorts.KeyName))
+		orderPrefix = ", "
+	}
+
+	if sorts.BunchActive != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("bunches.active %s", getOrderDirection(sorts.BunchActive))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		// an inherited key is listed once per bunch inheriting it
+		order = "bunch_keys.id DESC, bunches.id DESC"
+	}
+
+	sql = fmt.Sprintf(sql, cte, from, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, cte, from, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.AggregateBunchKey, 0, queries.Limit)
+		for rows.Next() {
+			k := new(storage.Key)
+			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+			bk := new(storage.BunchKey)
+			source := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+
+			err := rows.Scan(&k.ID, &k.Name, &k.Desc, &k.UpdatedAt,
+				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
+				&bk.ID, &bk.BunchID, &bk.KeyID, &bk.UpdatedAt,
+				&source.ID, &source.Name, &source.Desc, &source.Active.Bool, &source.UpdatedAt)
+			if err != nil {
+				return err
+			}
+
+			row := &storage.AggregateBunchKey{
+				BunchKey: bk,
+				Key:      k,
+				Bunch:    b,
+			}
+			if source.ID != b.ID {
+				row.Source = source
+			}
+			results = append(results, row)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Insert makes a bunch inherit the keys of a parent unless the parent inherits from the bunch already. The
+// transactions hold the write lock of the database from their start, so concurrent inserts cannot both pass
+// the check.
+func (st *BunchParentSqliteStorer) Insert(ctx context.Context, bp storage.CreateBunchParent) (int64, error) {
+	sql := "INSERT INTO bunch_parents (bunch_id, parent_id, updated_at) VALUES (?, ?, ?);"
+
+	if bp.BunchID == bp.ParentID {
+		return 0, storage.ErrBunchCycle
+	}
+
+	var lastID int64
+	err := inTx(ctx, st.db, func(tx executor) error {
+		var count int64
+		err := tx.QueryRowxContext(ctx, bunchAncestors("SELECT id FROM bunches WHERE id = ?")+
+			"SELECT count(*) FROM bunch_ancestors WHERE ancestor_id = ?;",
+			bp.ParentID, bp.BunchID).Scan(&count)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			return storage.ErrBunchCycle
+		}
+
+		res, err := tx.ExecContext(ctx, sql, bp.BunchID, bp.ParentID, time.Now())
+		if err != nil {
+			return withReference(ctx, tx, mapError(err), "bunch_parent",
+				reference{"bunch_id", "bunches", bp.BunchID}, reference{"parent_id", "bunches", bp.ParentID})
+		}
+
+		lastID, err = res.LastInsertId()
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return lastID, nil
+}
+
+func (st *BunchParentSqliteStorer) Delete(ctx context.Context, id int64) error {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM bunch_parents WHERE id = ?;", id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (st *BunchParentSqliteStorer) Get(ctx context.Context, id int64) (*storage.AggregateBunchParent, error) {
+	rows, _, err := st.Query(ctx, storage.QueryBunchParent{ID: id, Limit: 1}, storage.SortBunchParent{})
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, storage.ErrNotFound
+	}
+
+	return rows[0], nil
+}
+
+func (st *BunchParentSqliteStorer) Query(ctx context.Context, queries storage.QueryBunchParent, sorts storage.SortBunchParent) ([]*storage.AggregateBunchParent, int64, error) {
+	var (
+		sql = "SELECT bunch_parents.id, bunch_parents.bunch_id, bunch_parents.parent_id, bunch_parents.updated_at, " +
+			`bunches.id, bunches.name, bunches."desc", bunches.active, bunches.updated_at, ` +
+			`parents.id, parents.name, parents."desc", parents.active, parents.updated_at ` +
+			"FROM bunch_parents " +
+			"INNER JOIN bunches ON bunches.id = bunch_parents.bunch_id " +
+			"INNER JOIN bunches AS parents ON parents.id = bunch_parents.parent_id " +
+			"%s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount = "SELECT count(bunch_parents.id) " +
+			"FROM bunch_parents " +
+			"INNER JOIN bunches ON bunches.id = bunch_parents.bunch_id " +
+			"INNER JOIN bunches AS parents ON parents.id = bunch_parents.parent_id %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.AggregateBunchParent
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.ID > 0 {
+		filter["id"] = queries.ID
+		where += wherePrefix + "bunch_parents.id = :id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.BunchName) > 0 {
+		filter["bunch_name"] = queries.BunchName
+		where += wherePrefix + "bunches.name = :bunch_name"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.ParentName) > 0 {
+		filter["parent_name"] = queries.ParentName
+		where += wherePrefix + "parents.name = :parent_name"
+		wherePrefix = " AND "
+	}
+
+	if sorts.BunchName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("bunches.name %s", getOrderDirection(sorts.BunchName))
+		orderPrefix = ", "
+	}
+
+	if sorts.ParentName != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("parents.name %s", getOrderDirection(sorts.ParentName))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		order = "bunch_parents.id DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.AggregateBunchParent, 0, queries.Limit)
+		for rows.Next() {
+			bp := new(storage.BunchParent)
+			b := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+			parent := &storage.Bunch{Active: share.Boolean{IsSet: true}}
+
+			err := rows.Scan(&bp.ID, &bp.BunchID, &bp.ParentID, &bp.UpdatedAt,
+				&b.ID, &b.Name, &b.Desc, &b.Active.Bool, &b.UpdatedAt,
+				&parent.ID, &parent.Name, &parent.Desc, &parent.Active.Bool, &parent.UpdatedAt)
+			if err != nil {
+				return err
+			}
+			results = append(results, &storage.AggregateBunchParent{
+				BunchParent: bp,
+				Bunch:       b,
+				Parent:      parent,
+			})
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
@@ -0,0 +1,123 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type uniqInt struct {
+	order int
+	mux   sync.Mutex
+}
+
+var inc *uniqInt = &uniqInt{order: 1}
+
+func (unq *uniqInt) New() int {
+	unq.mux.Lock()
+	defer unq.mux.Unlock()
+	unq.order++
+	return unq.order
+}
+
+// createUniqueString is to create unique string for testing
+func (m *Migrator) createUniqueString(prefix string) string {
+	return fmt.Sprintf("%s%s", prefix, strconv.Itoa(inc.New()))
+}
+
+// Script migration script
+type Script struct {
+	Name string
+	Text string
+}
+
+// Migrator struct
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []*Migration
+	table      string
+	drop       []*Script
+	seed       []*Script
+
+	// DryRun makes Up and Down report what they would do without touching the database
+	DryRun bool
+
+	// LockTimeout is how long Up and Down wait for a concurrent migrator
+	LockTimeout time.Duration
+}
+
+// NewMigrator return struct instance
+func NewMigrator(db *sqlx.DB) *Migrator {
+	return newMigrator(db, DefaultMigrationTable, migrations)
+}
+
+func newMigrator(db *sqlx.DB, table string, migrations []*Migration) *Migrator {
+	var dropScripts = []*Script{
+		&Script{Name: "drop_database", Text: dropDatabase},
+	}
+
+	var seedScripts = []*Script{
+		&Script{Name: "seed_database", Text: seedingData},
+	}
+
+	sorted := make([]*Migration, len(migrations))
+	copy(sorted, migrations)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Version < sorted[j].Version })
+
+	return &Migrator{
+		db:          db,
+		migrations:  sorted,
+		table:       table,
+		drop:        dropScripts,
+		seed:        seedScripts,
+		LockTimeout: DefaultLockTimeout,
+	}
+}
+
+// Init database by applying every pending migration
+func (m *Migrator) Init() {
+	if _, err := m.Up(context.Background(), 0); err != nil {
+		panic(err)
+	}
+}
+
+// Drop database
+func (m *Migrator) Drop() {
+	tx := m.db.MustBegin()
+
+	for i := len(m.drop) - 1; i >= 0; i-- {
+		tx.MustExec(m.drop[i].Text)
+	}
+
+	tx.Commit()
+}
+
+// Seed database
+func (m *Migrator) Seed() {
+	tx := m.db.MustBegin()
+
+	for i := len(m.seed) - 1; i >= 0; i-- {
+		tx.MustExec(m.seed[i].Text)
+	}
+
+	tx.Commit()
+}
+
+func (m *Migrator) getUserByID(id int64) (username string, email string, hash string, active bool) {
+	rows, err := m.db.Queryx("SELECT username, email, hash, active FROM users WHERE id = ?", id)
+	if err != nil {
+		return
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		rows.Scan(&username, &email, &hash, &active)
+	}
+
+	return
+}
@@ -0,0 +1,87 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+type constraint struct {
+	entity string
+	field  string
+}
+
+// uniqueColumns maps the columns sqlite reports for a unique constraint in db_schema.go to the violated field
+var uniqueColumns = map[string]constraint{
+	"keys.name":                              {"key", "name"},
+	"bunches.name":                           {"bunch", "name"},
+	"users.username":                         {"user", "username"},
+	"users.email":                            {"user", "email"},
+	"bunch_keys.bunch_id, bunch_keys.key_id": {"bunch_key", "key_id"},
+	"user_bunches.user_id, user_bunches.bunch_id":     {"user_bunch", "bunch_id"},
+	"bunch_parents.bunch_id, bunch_parents.parent_id": {"bunch_parent", "parent_id"},
+	"token_histories.uid":                             {"token_history", "uid"},
+}
+
+const uniqueFailedPrefix = "UNIQUE constraint failed: "
+
+// mapError converts driver errors into storage errors, other errors are returned untouched
+func mapError(err error) error {
+	liteErr, ok := err.(sqlite3.Error)
+	if !ok {
+		return err
+	}
+
+	switch liteErr.ExtendedCode {
+	case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+		c := constraint{"record", "key"}
+		msg := liteErr.Error()
+		if i := strings.Index(msg, uniqueFailedPrefix); i >= 0 {
+			columns := msg[i+len(uniqueFailedPrefix):]
+			if found, ok := uniqueColumns[columns]; ok {
+				c = found
+			} else if strings.HasSuffix(columns, ".id") {
+				c.field = "id"
+			}
+		}
+		return &storage.DuplicateError{Entity: c.entity, Field: c.field, Err: err}
+
+	case sqlite3.ErrConstraintForeignKey:
+		return &storage.ForeignKeyError{Entity: "record", Field: "reference", Err: err}
+	}
+
+	return err
+}
+
+// reference is a row which a foreign key column points to
+type reference struct {
+	field string
+	table string
+	id    int64
+}
+
+// withReference fills in a foreign key error the first reference which does not exist, sqlite only
+// reports that a foreign key failed but not which one
+func withReference(ctx context.Context, db executor, err error, entity string, refs ...reference) error {
+	var fkErr *storage.ForeignKeyError
+	if !errors.As(err, &fkErr) {
+		return err
+	}
+
+	fkErr.Entity = entity
+	for _, ref := range refs {
+		var count int64
+		if db.QueryRowxContext(ctx, "SELECT count(id) FROM "+ref.table+" WHERE id = ?;", ref.id).Scan(&count) != nil {
+			break
+		}
+		if count == 0 {
+			fkErr.Field = ref.field
+			break
+		}
+	}
+
+	return fkErr
+}
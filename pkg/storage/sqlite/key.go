@@ -0,0 +1,207 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.KeyStorer = (*KeySqliteStorer)(nil)
+
+// KeySqliteStorer implements key's storages in sqlite db
+type KeySqliteStorer struct {
+	db executor
+}
+
+// NewKeySqliteStorer creates a new instance of KeySqliteStorer
+func NewKeySqliteStorer(db executor) *KeySqliteStorer {
+	return &KeySqliteStorer{
+		db,
+	}
+}
+
+func (st *KeySqliteStorer) Insert(ctx context.Context, k storage.CreateKey) (int64, error) {
+	sql := `INSERT INTO keys (name, "desc", updated_at) VALUES (?, ?, ?);`
+
+	res, err := st.db.ExecContext(ctx, sql, k.Name, k.Desc, time.Now())
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (st *KeySqliteStorer) Update(ctx context.Context, k storage.UpdateKey) error {
+	var (
+		sql      = "UPDATE keys SET %s WHERE id = :id;"
+		fields   string
+		prefix   string
+		updating = make(map[string]interface{})
+	)
+
+	if len(k.Name) > 0 {
+		fields += prefix + "name = :name"
+		prefix = ", "
+		updating["name"] = k.Name
+	}
+
+	if len(k.Desc) > 0 {
+		fields += prefix + `"desc" = :desc`
+		prefix = ", "
+		updating["desc"] = k.Desc
+	}
+
+	if len(updating) > 0 {
+		fields += prefix + "updated_at = :updated_at"
+		updating["updated_at"] = time.Now()
+		updating["id"] = k.ID
+
+		_, err := st.db.NamedExecContext(ctx, fmt.Sprintf(sql, fields), updating)
+		if err != nil {
+			return mapError(err)
+		}
+	}
+
+	return nil
+}
+
+// Delete removes a key, its bunch_keys rows are removed by the foreign key cascade
+func (st *KeySqliteStorer) Delete(ctx context.Context, id int64) error {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM keys WHERE id = ?;", id)
+	if err != nil {
+		return mapError(err)
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return storage.ErrNotFound
+	}
+
+	return nil
+}
+
+func (st *KeySqliteStorer) Get(ctx context.Context, id int64) (*storage.Key, error) {
+	return st.getBy(ctx, "id", id)
+}
+
+func (st *KeySqliteStorer) GetByName(ctx context.Context, name string) (*storage.Key, error) {
+	return st.getBy(ctx, "name", name)
+}
+
+func (st *KeySqliteStorer) getBy(ctx context.Context, column string, value interface{}) (*storage.Key, error) {
+	sql := fmt.Sprintf(`SELECT id, name, "desc", updated_at FROM keys WHERE %s = ? LIMIT 1;`, column)
+
+	rows, err := st.db.QueryxContext(ctx, sql, value)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, storage.ErrNotFound
+	}
+
+	key := new(storage.Key)
+	if err := rows.Scan(&key.ID, &key.Name, &key.Desc, &key.UpdatedAt); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+func (st *KeySqliteStorer) Query(ctx context.Context, queries storage.QueryKey, sorts storage.SortKey) ([]*storage.Key, int64, error) {
+	var (
+		sql         = `SELECT id, name, "desc", updated_at FROM keys %s ORDER BY %s LIMIT :limit OFFSET :offset;`
+		sqlcount    = "SELECT count(id) FROM keys %s;"
+		orderPrefix string
+		order       string
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.Key
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if len(queries.Name) > 0 {
+		filter["name"] = "%" + queries.Name + "%"
+		where += wherePrefix + "name LIKE :name"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Desc) > 0 {
+		filter["desc"] = "%" + queries.Desc + "%"
+		where += wherePrefix + `"desc" LIKE :desc`
+		wherePrefix = " AND "
+	}
+
+	if !queries.From.IsZero() {
+		filter["from"] = queries.From
+		where += wherePrefix + "updated_at > :from"
+		wherePrefix = " AND "
+	}
+
+	if !queries.To.IsZero() {
+		filter["to"] = queries.To
+		where += wherePrefix + "updated_at <= :to"
+		wherePrefix = " AND "
+	}
+
+	if sorts.Name != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("name %s", getOrderDirection(sorts.Name))
+		orderPrefix = ", "
+	}
+
+	if sorts.Desc != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf(`"desc" %s`, getOrderDirection(sorts.Desc))
+		orderPrefix = ", "
+	}
+
+	if sorts.UpdatedAt != share.BiDirection {
+		order += orderPrefix + fmt.Sprintf("updated_at %s", getOrderDirection(sorts.UpdatedAt))
+		orderPrefix = ", "
+	}
+
+	if len(order) == 0 {
+		order = "id DESC"
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.Key, 0, queries.Limit)
+		for rows.Next() {
+			key := new(storage.Key)
+			if err := rows.Scan(&key.ID, &key.Name, &key.Desc, &key.UpdatedAt); err != nil {
+				return err
+			}
+			results = append(results, key)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
@@ -0,0 +1,135 @@
+package sqlite
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.LoginAttemptStorer = (*LoginAttemptSqliteStorer)(nil)
+
+// LoginAttemptSqliteStorer implements db's storage for login attempts
+type LoginAttemptSqliteStorer struct {
+	db executor
+}
+
+// NewLoginAttemptSqliteStorer creates new instance of LoginAttemptSqliteStorer
+func NewLoginAttemptSqliteStorer(db executor) *LoginAttemptSqliteStorer {
+	return &LoginAttemptSqliteStorer{
+		db,
+	}
+}
+
+const loginAttemptColumns = "id, user_id, username, ip, outcome, created_at"
+
+func (st *LoginAttemptSqliteStorer) Insert(ctx context.Context, a storage.CreateLoginAttempt) (int64, error) {
+	sql := "INSERT INTO login_attempts (user_id, username, ip, outcome, created_at) VALUES (?, ?, ?, ?, ?);"
+
+	res, err := st.db.ExecContext(ctx, sql, a.UserID, a.Username, a.IP, a.Outcome, time.Now())
+	if err != nil {
+		return 0, mapError(err)
+	}
+
+	return res.LastInsertId()
+}
+
+func (st *LoginAttemptSqliteStorer) Query(ctx context.Context, queries storage.QueryLoginAttempt, sorts storage.SortLoginAttempt) ([]*storage.LoginAttempt, int64, error) {
+	var (
+		sql         = "SELECT " + loginAttemptColumns + " FROM login_attempts %s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount    = "SELECT count(id) FROM login_attempts %s;"
+		direction   = share.Descendant
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.LoginAttempt
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if queries.UserID > 0 {
+		filter["user_id"] = queries.UserID
+		where += wherePrefix + "user_id = :user_id"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Username) > 0 {
+		filter["username"] = queries.Username
+		where += wherePrefix + "username = :username"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.IP) > 0 {
+		filter["ip"] = queries.IP
+		where += wherePrefix + "ip = :ip"
+		wherePrefix = " AND "
+	}
+
+	if len(queries.Outcome) > 0 {
+		filter["outcome"] = queries.Outcome
+		where += wherePrefix + "outcome = :outcome"
+		wherePrefix = " AND "
+	}
+
+	if !queries.From.IsZero() {
+		filter["from"] = queries.From
+		where += wherePrefix + "created_at > :from"
+		wherePrefix = " AND "
+	}
+
+	if !queries.To.IsZero() {
+		filter["to"] = queries.To
+		where += wherePrefix + "created_at <= :to"
+		wherePrefix = " AND "
+	}
+
+	if sorts.CreatedAt != share.BiDirection {
+		direction = sorts.CreatedAt
+	}
+	order := fmt.Sprintf("created_at %[1]s, id %[1]s", getOrderDirection(direction))
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.LoginAttempt, 0, queries.Limit)
+		for rows.Next() {
+			a := new(storage.LoginAttempt)
+			if err := rows.Scan(&a.ID, &a.UserID, &a.Username, &a.IP, &a.Outcome, &a.CreatedAt); err != nil {
+				return err
+			}
+			results = append(results, a)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Purge deletes attempts made at or before the given time and returns the number of deleted rows
+func (st *LoginAttemptSqliteStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM login_attempts WHERE created_at <= ?;", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
@@ -0,0 +1,69 @@
+package sqlite
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testApp struct {
+	mig  *Migrator
+	kst  *KeySqliteStorer
+	bst  *BunchSqliteStorer
+	bkst *BunchKeySqliteStorer
+	bpst *BunchParentSqliteStorer
+	ust  *UserSqliteStorage
+	ubst *UserBunchSqliteStorage
+	thst *TokenHistorySqliteStorer
+	skst *SigningKeySqliteStorer
+	last *LoginAttemptSqliteStorer
+	tost *TOTPSqliteStorer
+	rcst *RecoveryCodeSqliteStorer
+	utst *UserTokenSqliteStorer
+	prst *PermissionSqliteResolver
+	repo *Repository
+}
+
+var test *testApp
+
+// TestMain setup testing env for sqlite repository in a temporary database file
+func TestMain(m *testing.M) {
+	dir, err := ioutil.TempDir("", "auth_service")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := InitDb(filepath.Join(dir, "auth.db"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	test = &testApp{
+		mig:  NewMigrator(db),
+		kst:  NewKeySqliteStorer(db),
+		bst:  NewBunchSqliteStorer(db),
+		bkst: NewBunchKeySqliteStorer(db),
+		bpst: NewBunchParentSqliteStorer(db),
+		ust:  NewUserSqliteStorage(db),
+		ubst: NewUserBunchSqliteStorage(db),
+		thst: NewTokenHistorySqliteStorer(db),
+		skst: NewSigningKeySqliteStorer(db),
+		last: NewLoginAttemptSqliteStorer(db),
+		tost: NewTOTPSqliteStorer(db),
+		rcst: NewRecoveryCodeSqliteStorer(db),
+		utst: NewUserTokenSqliteStorer(db),
+		prst: NewPermissionSqliteResolver(db),
+		repo: NewRepository(db),
+	}
+
+	test.mig.Drop()
+	test.mig.Init()
+
+	code := m.Run()
+
+	db.Close()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
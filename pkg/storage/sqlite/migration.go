@@ -0,0 +1,338 @@
+package sqlite
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-sqlite3"
+)
+
+// noSuchTablePrefix starts the sqlite message of a missing table
+const noSuchTablePrefix = "no such table"
+
+// DefaultLockTimeout is how long a migrator waits for another one to release the migration lock
+const DefaultLockTimeout = 30 * time.Second
+
+// DefaultMigrationTable is the table recording applied migrations
+const DefaultMigrationTable = "schema_migrations"
+
+var (
+	// ErrChecksumMismatch tells that an applied migration has been edited since it ran
+	ErrChecksumMismatch = errors.New("migration checksum mismatch")
+
+	// ErrUnknownMigration tells that the database holds a migration which this build does not know
+	ErrUnknownMigration = errors.New("unknown migration")
+
+	// ErrLockTimeout tells that another migrator held the migration lock for too long
+	ErrLockTimeout = errors.New("timeout acquiring migration lock")
+)
+
+// Migration is a numbered, reversible change of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Checksum identifies the scripts of a migration, it changes whenever one of them is edited
+func (mg *Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(mg.Up + "\x00" + mg.Down))
+	return hex.EncodeToString(sum[:])
+}
+
+// MigrationStatus tells whether a migration is applied to the database
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	Checksum  string
+	Applied   bool
+	AppliedAt time.Time
+
+	// Modified is set when the applied checksum differs from the migration's scripts
+	Modified bool
+
+	// Unknown is set when the migration is applied but missing from this build
+	Unknown bool
+}
+
+type appliedMigration struct {
+	version   int
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+// Up applies the next n pending migrations, or all of them when n <= 0, and returns the applied migrations.
+// In dry run mode nothing is executed and the migrations which would be applied are returned.
+func (m *Migrator) Up(ctx context.Context, n int) ([]*Migration, error) {
+	var plan []*Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mg := range m.migrations {
+			if _, ok := applied[mg.Version]; !ok {
+				plan = append(plan, mg)
+			}
+		}
+		if n > 0 && n < len(plan) {
+			plan = plan[:n]
+		}
+
+		if m.DryRun {
+			return nil
+		}
+
+		for i, mg := range plan {
+			if err := m.apply(ctx, conn, mg, true); err != nil {
+				plan = plan[:i]
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return plan, err
+}
+
+// Down reverts the last n applied migrations, or all of them when n <= 0, and returns the reverted migrations.
+// In dry run mode nothing is executed and the migrations which would be reverted are returned.
+func (m *Migrator) Down(ctx context.Context, n int) ([]*Migration, error) {
+	var plan []*Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		applied, err := m.verify(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			if _, ok := applied[m.migrations[i].Version]; ok {
+				plan = append(plan, m.migrations[i])
+			}
+		}
+		if n > 0 && n < len(plan) {
+			plan = plan[:n]
+		}
+
+		if m.DryRun {
+			return nil
+		}
+
+		for i, mg := range plan {
+			if err := m.apply(ctx, conn, mg, false); err != nil {
+				plan = plan[:i]
+				return err
+			}
+		}
+
+		return nil
+	})
+
+	return plan, err
+}
+
+// Status lists every known migration followed by the applied migrations missing from this build
+func (m *Migrator) Status(ctx context.Context) ([]*MigrationStatus, error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*MigrationStatus, 0, len(m.migrations))
+	for _, mg := range m.migrations {
+		st := &MigrationStatus{Version: mg.Version, Name: mg.Name, Checksum: mg.Checksum()}
+		if a, ok := applied[mg.Version]; ok {
+			st.Applied = true
+			st.AppliedAt = a.appliedAt
+			st.Modified = a.checksum != st.Checksum
+			delete(applied, mg.Version)
+		}
+		results = append(results, st)
+	}
+
+	unknown := make([]*MigrationStatus, 0, len(applied))
+	for _, a := range applied {
+		unknown = append(unknown, &MigrationStatus{Version: a.version, Name: a.name, Checksum: a.checksum,
+			Applied: true, AppliedAt: a.appliedAt, Unknown: true})
+	}
+	sort.Slice(unknown, func(i, j int) bool { return unknown[i].Version < unknown[j].Version })
+
+	return append(results, unknown...), nil
+}
+
+// locked runs fn on a single connection inside a write transaction, so concurrent migrators run one by one.
+// Every migration runs in a savepoint of that transaction, so the ones applied before a failure are committed.
+// Foreign keys are turned off meanwhile, so tables can be rebuilt without cascading deletes, and checked
+// before each migration is released.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) (err error) {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var busyTimeout int64
+	if err := conn.QueryRowContext(ctx, "PRAGMA busy_timeout;").Scan(&busyTimeout); err != nil {
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, fmt.Sprintf("PRAGMA foreign_keys = OFF; PRAGMA busy_timeout = %d;",
+		m.LockTimeout/time.Millisecond))
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		conn.ExecContext(context.Background(), fmt.Sprintf("PRAGMA foreign_keys = ON; PRAGMA busy_timeout = %d;",
+			busyTimeout))
+	}()
+
+	if _, err := conn.ExecContext(ctx, "BEGIN IMMEDIATE;"); err != nil {
+		if liteErr, ok := err.(sqlite3.Error); ok && liteErr.Code == sqlite3.ErrBusy {
+			return ErrLockTimeout
+		}
+		return err
+	}
+
+	if !m.DryRun {
+		_, err = conn.ExecContext(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS "%s" (`+
+			"version BIGINT NOT NULL PRIMARY KEY, "+
+			"name VARCHAR(128) NOT NULL, "+
+			"checksum CHAR(64) NOT NULL, "+
+			"applied_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP);", m.table))
+		if err != nil {
+			conn.ExecContext(context.Background(), "ROLLBACK;")
+			return err
+		}
+	}
+
+	err = fn(conn)
+
+	end := "COMMIT;"
+	if m.DryRun {
+		end = "ROLLBACK;"
+	}
+	if _, endErr := conn.ExecContext(context.Background(), end); endErr != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK;")
+		if err == nil {
+			err = endErr
+		}
+	}
+
+	return err
+}
+
+// verify refuses to migrate a database holding edited or unknown migrations
+func (m *Migrator) verify(ctx context.Context, conn *sql.Conn) (map[int]*appliedMigration, error) {
+	applied, err := m.applied(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	known := make(map[int]*Migration, len(m.migrations))
+	for _, mg := range m.migrations {
+		known[mg.Version] = mg
+	}
+
+	for _, a := range applied {
+		mg, ok := known[a.version]
+		if !ok {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrUnknownMigration, a.version, a.name)
+		}
+		if mg.Checksum() != a.checksum {
+			return nil, fmt.Errorf("%w: version %d (%s)", ErrChecksumMismatch, a.version, a.name)
+		}
+	}
+
+	return applied, nil
+}
+
+// applied reads the applied migrations, a missing migration table means none is applied
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int]*appliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, fmt.Sprintf(`SELECT version, name, checksum, applied_at FROM "%s";`, m.table))
+	if err != nil {
+		if strings.HasPrefix(err.Error(), noSuchTablePrefix) {
+			return map[int]*appliedMigration{}, nil
+		}
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := make(map[int]*appliedMigration)
+	for rows.Next() {
+		a := new(appliedMigration)
+		if err := rows.Scan(&a.version, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		results[a.version] = a
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// apply runs one direction of a migration and records it in a savepoint, sqlite rolls back DDL statements too,
+// so a failed migration leaves no trace
+func (m *Migrator) apply(ctx context.Context, conn *sql.Conn, mg *Migration, up bool) error {
+	if _, err := conn.ExecContext(ctx, "SAVEPOINT migration;"); err != nil {
+		return err
+	}
+
+	script, record, args := mg.Down, fmt.Sprintf(`DELETE FROM "%s" WHERE version = ?;`, m.table),
+		[]interface{}{mg.Version}
+	if up {
+		script, record, args = mg.Up, fmt.Sprintf(`INSERT INTO "%s" (version, name, checksum, applied_at) `+
+			"VALUES (?, ?, ?, ?);", m.table), []interface{}{mg.Version, mg.Name, mg.Checksum(), time.Now()}
+	}
+
+	err := func() error {
+		if _, err := conn.ExecContext(ctx, script); err != nil {
+			return fmt.Errorf("migration %d (%s): %w", mg.Version, mg.Name, err)
+		}
+
+		if _, err := conn.ExecContext(ctx, record, args...); err != nil {
+			return err
+		}
+
+		rows, err := conn.QueryContext(ctx, "PRAGMA foreign_key_check;")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		if rows.Next() {
+			return fmt.Errorf("migration %d (%s): foreign key constraint failed", mg.Version, mg.Name)
+		}
+
+		return rows.Err()
+	}()
+	if err != nil {
+		conn.ExecContext(context.Background(), "ROLLBACK TO migration; RELEASE migration;")
+		return err
+	}
+
+	_, err = conn.ExecContext(ctx, "RELEASE migration;")
+	return err
+}
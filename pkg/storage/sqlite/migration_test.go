@@ -0,0 +1,250 @@
+package sqlite
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// newTestMigrator creates a migrator of three migrations which only touches its own tables
+func newTestMigrator() (*Migrator, []*Migration) {
+	// tables outlive the test run, so names must be unique across runs too
+	prefix := test.mig.createUniqueString(fmt.Sprintf("mig_%x_", time.Now().UnixNano()))
+
+	migs := []*Migration{
+		{Version: 1, Name: "create_a", Up: fmt.Sprintf(`CREATE TABLE "%s_a" ("id" INT NOT NULL, PRIMARY KEY ("id"));`, prefix),
+			Down: fmt.Sprintf(`DROP TABLE "%s_a";`, prefix)},
+		{Version: 2, Name: "create_b", Up: fmt.Sprintf(`CREATE TABLE "%s_b" ("id" INT NOT NULL, PRIMARY KEY ("id"));`, prefix),
+			Down: fmt.Sprintf(`DROP TABLE "%s_b";`, prefix)},
+		{Version: 3, Name: "create_c", Up: fmt.Sprintf(`CREATE TABLE "%s_c" ("id" INT NOT NULL, PRIMARY KEY ("id"));`, prefix),
+			Down: fmt.Sprintf(`DROP TABLE "%s_c";`, prefix)},
+	}
+
+	return newMigrator(test.mig.db, prefix+"_schema_migrations", migs), migs
+}
+
+func appliedVersions(t *testing.T, m *Migrator) []int {
+	statuses, err := m.Status(context.Background())
+	require.Nil(t, err)
+
+	versions := make([]int, 0)
+	for _, st := range statuses {
+		if st.Applied {
+			versions = append(versions, st.Version)
+		}
+	}
+
+	return versions
+}
+
+func migrationVersions(migs []*Migration) []int {
+	versions := make([]int, 0, len(migs))
+	for _, mg := range migs {
+		versions = append(versions, mg.Version)
+	}
+
+	return versions
+}
+
+func TestMigrator_Migrations(t *testing.T) {
+	t.Parallel()
+
+	require.NotEmpty(t, migrations)
+	for i, mg := range migrations {
+		require.Equal(t, i+1, mg.Version)
+		require.NotEmpty(t, mg.Name)
+		require.NotEmpty(t, mg.Up)
+		require.NotEmpty(t, mg.Down)
+	}
+
+	statuses, err := test.mig.Status(context.Background())
+	require.Nil(t, err)
+	require.Len(t, statuses, len(migrations))
+	for _, st := range statuses {
+		require.True(t, st.Applied)
+		require.False(t, st.Modified)
+	}
+}
+
+func TestMigrator_Up(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_up_step_by_step", func(t *testing.T) {
+		m, _ := newTestMigrator()
+
+		applied, err := m.Up(ctx, 1)
+		require.Nil(t, err)
+		require.Equal(t, []int{1}, migrationVersions(applied))
+		require.Equal(t, []int{1}, appliedVersions(t, m))
+
+		applied, err = m.Up(ctx, 0)
+		require.Nil(t, err)
+		require.Equal(t, []int{2, 3}, migrationVersions(applied))
+		require.Equal(t, []int{1, 2, 3}, appliedVersions(t, m))
+
+		applied, err = m.Up(ctx, 0)
+		require.Nil(t, err)
+		require.Empty(t, applied)
+	})
+
+	t.Run("success_up_dry_run", func(t *testing.T) {
+		m, _ := newTestMigrator()
+		m.DryRun = true
+
+		applied, err := m.Up(ctx, 2)
+		require.Nil(t, err)
+		require.Equal(t, []int{1, 2}, migrationVersions(applied))
+		require.Empty(t, appliedVersions(t, m))
+	})
+
+	t.Run("fail_up_with_edited_migration", func(t *testing.T) {
+		m, migs := newTestMigrator()
+
+		_, err := m.Up(ctx, 1)
+		require.Nil(t, err)
+
+		migs[0].Down += "\n"
+
+		_, err = m.Up(ctx, 0)
+		require.True(t, errors.Is(err, ErrChecksumMismatch))
+		require.Equal(t, []int{1}, appliedVersions(t, m))
+
+		statuses, err := m.Status(ctx)
+		require.Nil(t, err)
+		require.True(t, statuses[0].Modified)
+	})
+
+	t.Run("fail_up_with_unknown_migration", func(t *testing.T) {
+		m, migs := newTestMigrator()
+
+		_, err := m.Up(ctx, 0)
+		require.Nil(t, err)
+
+		older := newMigrator(m.db, m.table, migs[:2])
+
+		_, err = older.Up(ctx, 0)
+		require.True(t, errors.Is(err, ErrUnknownMigration))
+
+		statuses, err := older.Status(ctx)
+		require.Nil(t, err)
+		require.Len(t, statuses, 3)
+		require.True(t, statuses[2].Unknown)
+	})
+
+	t.Run("fail_up_with_broken_script", func(t *testing.T) {
+		m, migs := newTestMigrator()
+		migs[1].Up = "CREATE TABLE;"
+
+		applied, err := m.Up(ctx, 0)
+		require.NotNil(t, err)
+		require.Equal(t, []int{1}, migrationVersions(applied))
+		require.Equal(t, []int{1}, appliedVersions(t, m))
+	})
+}
+
+func TestMigrator_Down(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_down_step_by_step", func(t *testing.T) {
+		m, _ := newTestMigrator()
+
+		_, err := m.Up(ctx, 0)
+		require.Nil(t, err)
+
+		reverted, err := m.Down(ctx, 2)
+		require.Nil(t, err)
+		require.Equal(t, []int{3, 2}, migrationVersions(reverted))
+		require.Equal(t, []int{1}, appliedVersions(t, m))
+
+		reverted, err = m.Down(ctx, 0)
+		require.Nil(t, err)
+		require.Equal(t, []int{1}, migrationVersions(reverted))
+		require.Empty(t, appliedVersions(t, m))
+
+		applied, err := m.Up(ctx, 0)
+		require.Nil(t, err)
+		require.Len(t, applied, 3)
+	})
+
+	t.Run("success_down_dry_run", func(t *testing.T) {
+		m, _ := newTestMigrator()
+
+		_, err := m.Up(ctx, 0)
+		require.Nil(t, err)
+
+		m.DryRun = true
+		reverted, err := m.Down(ctx, 1)
+		require.Nil(t, err)
+		require.Equal(t, []int{3}, migrationVersions(reverted))
+		require.Equal(t, []int{1, 2, 3}, appliedVersions(t, m))
+	})
+}
+
+func TestMigrator_Status(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_status_without_migration_table", func(t *testing.T) {
+		m, _ := newTestMigrator()
+
+		statuses, err := m.Status(context.Background())
+		require.Nil(t, err)
+		require.Len(t, statuses, 3)
+		for _, st := range statuses {
+			require.False(t, st.Applied)
+			require.NotEmpty(t, st.Checksum)
+		}
+	})
+}
+
+func TestMigrator_Rebuild(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_down_and_up_keeping_rows", func(t *testing.T) {
+		dir, err := ioutil.TempDir("", "auth_service")
+		require.Nil(t, err)
+		defer os.RemoveAll(dir)
+
+		db, err := InitDb(filepath.Join(dir, "auth.db"))
+		require.Nil(t, err)
+		defer db.Close()
+
+		m := NewMigrator(db)
+		m.Init()
+		m.Seed()
+
+		// reverting the added columns rebuilds users and token_histories, rows referring to them must stay
+		reverted, err := m.Down(ctx, len(migrations)-6)
+		require.Nil(t, err)
+		require.Equal(t, []int{14, 13, 12, 11, 10, 9, 8, 7}, migrationVersions(reverted))
+
+		var count int
+		require.Nil(t, db.Get(&count, "SELECT count(*) FROM user_bunches;"))
+		require.Equal(t, 3, count)
+
+		_, err = m.Up(ctx, 0)
+		require.Nil(t, err)
+		require.Equal(t, migrationVersions(migrations), appliedVersions(t, m))
+
+		username, _, _, active := m.getUserByID(1)
+		require.Equal(t, "admin", username)
+		require.True(t, active)
+
+		_, err = NewUserBunchSqliteStorage(db).Insert(ctx, storage.CreateUserBunch{UserID: 1 << 40, BunchID: 1})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+
+		_, err = m.Down(ctx, 0)
+		require.Nil(t, err)
+		require.Empty(t, appliedVersions(t, m))
+	})
+}
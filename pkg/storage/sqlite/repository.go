@@ -0,0 +1,182 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/mattn/go-sqlite3"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// DefaultTxAttempts is the number of times WithTx runs a transaction aborted by a locked database
+const DefaultTxAttempts = 3
+
+// executor is satisfied by both *sqlx.DB and *sqlx.Tx, so storers work in and out of transactions
+type executor interface {
+	sqlx.ExtContext
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+	NamedExecContext(ctx context.Context, query string, arg interface{}) (sql.Result, error)
+}
+
+var _ storage.Repository = (*Repository)(nil)
+
+// connParams enables foreign keys, which sqlite turns off by default, and lets writers wait for each other
+const connParams = "_foreign_keys=1&_busy_timeout=5000&_journal_mode=WAL&_txlock=immediate"
+
+// Init database connection to a sqlite file
+func InitDb(file string) (*sqlx.DB, error) {
+	db, err := sqlx.Open("sqlite3", "file:"+file+"?"+connParams)
+	if err != nil {
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// stores implements storage.Stores on top of an executor
+type stores struct {
+	ex executor
+}
+
+func (s *stores) Keys() storage.KeyStorer {
+	return NewKeySqliteStorer(s.ex)
+}
+
+func (s *stores) Bunches() storage.BunchStorer {
+	return NewBunchSqliteStorer(s.ex)
+}
+
+func (s *stores) BunchKeys() storage.BunchKeyStorer {
+	return NewBunchKeySqliteStorer(s.ex)
+}
+
+func (s *stores) BunchParents() storage.BunchParentStorer {
+	return NewBunchParentSqliteStorer(s.ex)
+}
+
+func (s *stores) Users() storage.UserStorer {
+	return NewUserSqliteStorage(s.ex)
+}
+
+func (s *stores) UserBunches() storage.UserBunchStorer {
+	return NewUserBunchSqliteStorage(s.ex)
+}
+
+func (s *stores) TokenHistories() storage.TokenHistoryStorer {
+	return NewTokenHistorySqliteStorer(s.ex)
+}
+
+func (s *stores) SigningKeys() storage.SigningKeyStorer {
+	return NewSigningKeySqliteStorer(s.ex)
+}
+
+func (s *stores) LoginAttempts() storage.LoginAttemptStorer {
+	return NewLoginAttemptSqliteStorer(s.ex)
+}
+
+func (s *stores) TOTPs() storage.TOTPStorer {
+	return NewTOTPSqliteStorer(s.ex)
+}
+
+func (s *stores) RecoveryCodes() storage.RecoveryCodeStorer {
+	return NewRecoveryCodeSqliteStorer(s.ex)
+}
+
+func (s *stores) UserTokens() storage.UserTokenStorer {
+	return NewUserTokenSqliteStorer(s.ex)
+}
+
+func (s *stores) Permissions() storage.PermissionResolver {
+	return NewPermissionSqliteResolver(s.ex)
+}
+
+// Repository implements storage.Repository in sqlite db
+type Repository struct {
+	*stores
+	db       *sqlx.DB
+	Attempts int
+}
+
+// NewRepository creates new instance of Repository
+func NewRepository(db *sqlx.DB) *Repository {
+	return &Repository{
+		stores:   &stores{db},
+		db:       db,
+		Attempts: DefaultTxAttempts,
+	}
+}
+
+// WithTx runs fn in a transaction and retries it while the database is busy or locked
+func (r *Repository) WithTx(ctx context.Context, fn func(tx storage.Stores) error) error {
+	var err error
+
+	for attempt := 1; ; attempt++ {
+		err = r.runTx(ctx, fn)
+		if err == nil || !isRetryable(err) || attempt >= r.Attempts {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Duration(attempt*attempt) * 10 * time.Millisecond):
+		}
+	}
+}
+
+func (r *Repository) runTx(ctx context.Context, fn func(tx storage.Stores) error) (err error) {
+	tx, err := r.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+	}()
+
+	if err = fn(&stores{tx}); err != nil {
+		if rbErr := tx.Rollback(); rbErr != nil && !errors.Is(rbErr, sql.ErrTxDone) {
+			return fmt.Errorf("%w (rollback: %v)", err, rbErr)
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// isRetryable tells whether err aborted the transaction because the database was locked
+func isRetryable(err error) bool {
+	var liteErr sqlite3.Error
+	if !errors.As(err, &liteErr) {
+		return false
+	}
+
+	return liteErr.Code == sqlite3.ErrBusy || liteErr.Code == sqlite3.ErrLocked
+}
+
+// inTx runs fn in the executor's transaction or, on a connection pool, in a new transaction
+func inTx(ctx context.Context, ex executor, fn func(tx executor) error) error {
+	db, ok := ex.(*sqlx.DB)
+	if !ok {
+		return fn(ex)
+	}
+
+	tx, err := db.BeginTxx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,50 @@
+package sqlite
+
+import (
+	"context"
+	"testing"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+func TestRepository_WithTx(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_retry_on_busy_database", func(t *testing.T) {
+		t.Parallel()
+
+		name := test.mig.createUniqueString("bunch")
+		calls := 0
+
+		err := test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+			calls++
+			if calls == 1 {
+				return sqlite3.Error{Code: sqlite3.ErrBusy}
+			}
+
+			_, err := tx.Bunches().Insert(context.Background(), storage.CreateBunch{Name: name, Desc: "desc"})
+			return err
+		})
+		require.Nil(t, err)
+		require.Equal(t, 2, calls)
+
+		bunch, err := test.bst.GetByName(context.Background(), name)
+		require.Nil(t, err)
+		require.NotNil(t, bunch)
+	})
+
+	t.Run("fail_after_all_attempts", func(t *testing.T) {
+		t.Parallel()
+
+		calls := 0
+
+		err := test.repo.WithTx(context.Background(), func(tx storage.Stores) error {
+			calls++
+			return sqlite3.Error{Code: sqlite3.ErrLocked}
+		})
+		require.NotNil(t, err)
+		require.Equal(t, DefaultTxAttempts, calls)
+	})
+}
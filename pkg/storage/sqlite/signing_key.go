@@ -0,0 +1,158 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var _ storage.SigningKeyStorer = (*SigningKeySqliteStorer)(nil)
+
+// SigningKeySqliteStorer implements db's storage for signing keys
+type SigningKeySqliteStorer struct {
+	db executor
+}
+
+// NewSigningKeySqliteStorer creates new instance of SigningKeySqliteStorer
+func NewSigningKeySqliteStorer(db executor) *SigningKeySqliteStorer {
+	return &SigningKeySqliteStorer{
+		db,
+	}
+}
+
+const signingKeyColumns = "kid, algorithm, public_key, private_key, created_at, activated_at, expired_at"
+
+func scanSigningKey(rows *sqlx.Rows) (*storage.SigningKey, error) {
+	var (
+		k         = new(storage.SigningKey)
+		expiredAt sql.NullTime
+	)
+
+	err := rows.Scan(&k.KID, &k.Algorithm, &k.PublicKey, &k.PrivateKey, &k.CreatedAt, &k.ActivatedAt, &expiredAt)
+	if err != nil {
+		return nil, err
+	}
+
+	k.ExpiredAt = expiredAt.Time
+
+	return k, nil
+}
+
+func (st *SigningKeySqliteStorer) Insert(ctx context.Context, k storage.CreateSigningKey) (string, error) {
+	sql := "INSERT INTO signing_keys (kid, algorithm, public_key, private_key, created_at, activated_at) " +
+		"VALUES (?, ?, ?, ?, ?, ?);"
+
+	kid := k.KID
+	if len(kid) == 0 {
+		kid = share.NewUID()
+	}
+
+	_, err := st.db.ExecContext(ctx, sql, kid, k.Algorithm, k.PublicKey, k.PrivateKey, time.Now(), k.ActivatedAt)
+	if err != nil {
+		return "", mapError(err)
+	}
+
+	return kid, nil
+}
+
+func (st *SigningKeySqliteStorer) Get(ctx context.Context, kid string) (*storage.SigningKey, error) {
+	sql := "SELECT " + signingKeyColumns + " FROM signing_keys WHERE kid = ? LIMIT 1;"
+
+	rows, err := st.db.QueryxContext(ctx, sql, kid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	if !rows.Next() {
+		return nil, storage.ErrNotFound
+	}
+
+	return scanSigningKey(rows)
+}
+
+func (st *SigningKeySqliteStorer) Query(ctx context.Context, queries storage.QuerySigningKey, sorts storage.SortSigningKey) ([]*storage.SigningKey, int64, error) {
+	var (
+		sql         = "SELECT " + signingKeyColumns + " FROM signing_keys %s ORDER BY %s LIMIT :limit OFFSET :offset;"
+		sqlcount    = "SELECT count(kid) FROM signing_keys %s;"
+		order       = "activated_at DESC"
+		wherePrefix = "WHERE "
+		where       string
+		results     []*storage.SigningKey
+		total       int64
+	)
+
+	filter := map[string]interface{}{"limit": queries.Limit, "offset": queries.Offset}
+	if queries.Limit == 0 {
+		filter["limit"] = share.DefaultLimit
+	}
+
+	if len(queries.Algorithm) > 0 {
+		filter["algorithm"] = queries.Algorithm
+		where += wherePrefix + "algorithm = :algorithm"
+		wherePrefix = " AND "
+	}
+
+	if !queries.ExpiredAfter.IsZero() {
+		filter["expired_after"] = queries.ExpiredAfter
+		where += wherePrefix + "(expired_at IS NULL OR expired_at > :expired_after)"
+		wherePrefix = " AND "
+	}
+
+	if sorts.ActivatedAt != share.BiDirection {
+		order = fmt.Sprintf("activated_at %s", getOrderDirection(sorts.ActivatedAt))
+	}
+
+	sql = fmt.Sprintf(sql, where, order)
+	sqlcount = fmt.Sprintf(sqlcount, where)
+
+	err := queryAndCount(ctx, st.db, func(ctx context.Context) error {
+		rows, err := sqlx.NamedQueryContext(ctx, st.db, sql, filter)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		results = make([]*storage.SigningKey, 0, queries.Limit)
+		for rows.Next() {
+			k, err := scanSigningKey(rows)
+			if err != nil {
+				return err
+			}
+			results = append(results, k)
+		}
+
+		return rows.Err()
+	}, func(ctx context.Context) error {
+		return countTotal(ctx, st.db, sqlcount, filter, &total)
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return results, total, nil
+}
+
+// Expire sets the expiry of a key which has none yet
+func (st *SigningKeySqliteStorer) Expire(ctx context.Context, kid string, at time.Time) error {
+	sql := "UPDATE signing_keys SET expired_at = ? WHERE kid = ? AND expired_at IS NULL;"
+
+	_, err := st.db.ExecContext(ctx, sql, at, kid)
+
+	return err
+}
+
+// Purge deletes keys which expired at or before the given time and returns the number of deleted rows
+func (st *SigningKeySqliteStorer) Purge(ctx context.Context, before time.Time) (int64, error) {
+	res, err := st.db.ExecContext(ctx, "DELETE FROM signing_keys WHERE expired_at <= ?;", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return res.RowsAffected()
+}
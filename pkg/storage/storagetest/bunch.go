@@ -0,0 +1,179 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunBunchStorer tests a storage.BunchStorer
+func RunBunchStorer(t *testing.T, f Factories) {
+	f.needs(t, "Bunches")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_get_an_active_bunch", func(t *testing.T) {
+		scope := names.scope()
+
+		id, err := f.Bunches().Insert(ctx, storage.CreateBunch{Name: scope + "_bunch", Desc: scope + "_desc"})
+		require.Nil(t, err)
+		require.NotZero(t, id)
+
+		bunch, err := f.Bunches().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, id, bunch.ID)
+		require.Equal(t, scope+"_bunch", bunch.Name)
+		require.Equal(t, scope+"_desc", bunch.Desc)
+		require.Equal(t, share.Boolean{IsSet: true, Bool: true}, bunch.Active)
+		require.False(t, bunch.UpdatedAt.IsZero())
+
+		bunch, err = f.Bunches().GetByName(ctx, scope+"_bunch")
+		require.Nil(t, err)
+		require.Equal(t, id, bunch.ID)
+	})
+
+	t.Run("fail_get_a_missing_bunch", func(t *testing.T) {
+		_, err := f.Bunches().Get(ctx, 1<<40)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+
+		_, err = f.Bunches().GetByName(ctx, names.scope())
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("fail_insert_a_duplicated_bunch", func(t *testing.T) {
+		scope := names.scope()
+		insertBunch(t, f, scope+"_bunch")
+
+		id, err := f.Bunches().Insert(ctx, storage.CreateBunch{Name: scope + "_bunch", Desc: "desc"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		require.Zero(t, id)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "bunch", dup.Entity)
+		require.Equal(t, "name", dup.Field)
+	})
+
+	t.Run("success_update_a_bunch", func(t *testing.T) {
+		scope := names.scope()
+		id := insertBunch(t, f, scope+"_bunch")
+
+		err := f.Bunches().Update(ctx, storage.UpdateBunch{
+			ID:     id,
+			Name:   scope + "_new",
+			Desc:   "new desc",
+			Active: share.Boolean{IsSet: true, Bool: false},
+		})
+		require.Nil(t, err)
+
+		bunch, err := f.Bunches().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_new", bunch.Name)
+		require.Equal(t, "new desc", bunch.Desc)
+		require.Equal(t, share.Boolean{IsSet: true, Bool: false}, bunch.Active)
+
+		err = f.Bunches().Update(ctx, storage.UpdateBunch{ID: id, Desc: "newer desc"})
+		require.Nil(t, err)
+
+		bunch, err = f.Bunches().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, "newer desc", bunch.Desc)
+		require.False(t, bunch.Active.Bool)
+
+		err = f.Bunches().Update(ctx, storage.UpdateBunch{ID: id, Active: share.Boolean{IsSet: true, Bool: true}})
+		require.Nil(t, err)
+
+		bunch, err = f.Bunches().Get(ctx, id)
+		require.Nil(t, err)
+		require.True(t, bunch.Active.Bool)
+	})
+
+	t.Run("fail_update_a_bunch_to_a_duplicated_name", func(t *testing.T) {
+		scope := names.scope()
+		insertBunch(t, f, scope+"_a")
+		id := insertBunch(t, f, scope+"_b")
+
+		err := f.Bunches().Update(ctx, storage.UpdateBunch{ID: id, Name: scope + "_a"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+	})
+
+	t.Run("success_query_bunches", func(t *testing.T) {
+		scope := names.scope()
+		idC := insertBunch(t, f, scope+"_c")
+		idA := insertBunch(t, f, scope+"_a")
+		idB := insertBunch(t, f, scope+"_b")
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: idA, Active: share.Boolean{IsSet: true}}))
+
+		rows, total, err := f.Bunches().Query(ctx, storage.QueryBunch{Name: scope}, storage.SortBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idB, idA, idC}, bunchIDs(rows))
+
+		rows, total, err = f.Bunches().Query(ctx, storage.QueryBunch{Name: scope}, storage.SortBunch{Name: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idA, idB, idC}, bunchIDs(rows))
+
+		rows, total, err = f.Bunches().Query(ctx, storage.QueryBunch{Name: scope, Limit: 1, Offset: 1},
+			storage.SortBunch{Name: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idB}, bunchIDs(rows))
+
+		rows, total, err = f.Bunches().Query(ctx, storage.QueryBunch{Name: scope}, storage.SortBunch{Active: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, idA, rows[0].ID)
+
+		rows, total, err = f.Bunches().Query(ctx, storage.QueryBunch{Desc: scope + "_C"}, storage.SortBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idC}, bunchIDs(rows))
+	})
+
+	t.Run("success_query_bunches_by_active", func(t *testing.T) {
+		scope := names.scope()
+		idA := insertBunch(t, f, scope+"_a")
+		idB := insertBunch(t, f, scope+"_b")
+		idC := insertBunch(t, f, scope+"_c")
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: idB, Active: share.Boolean{IsSet: true}}))
+
+		sorts := storage.SortBunch{Name: share.Ascendant}
+
+		rows, total, err := f.Bunches().Query(ctx, storage.QueryBunch{Name: scope}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idA, idB, idC}, bunchIDs(rows))
+
+		rows, total, err = f.Bunches().Query(ctx, storage.QueryBunch{Name: scope, Active: share.Boolean{IsSet: true, Bool: true}}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idA, idC}, bunchIDs(rows))
+
+		rows, total, err = f.Bunches().Query(ctx, storage.QueryBunch{Name: scope, Active: share.Boolean{IsSet: true}}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idB}, bunchIDs(rows))
+	})
+}
+
+func insertBunch(t *testing.T, f Factories, name string) int64 {
+	t.Helper()
+
+	id, err := f.Bunches().Insert(context.Background(), storage.CreateBunch{Name: name, Desc: name + " desc"})
+	require.Nil(t, err)
+
+	return id
+}
+
+func bunchIDs(rows []*storage.Bunch) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	return ids
+}
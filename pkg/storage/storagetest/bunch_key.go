@@ -0,0 +1,249 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunBunchKeyStorer tests a storage.BunchKeyStorer
+func RunBunchKeyStorer(t *testing.T, f Factories) {
+	f.needs(t, "Keys", "Bunches", "BunchKeys")
+	ctx := context.Background()
+
+	t.Run("success_insert_a_bunch_key", func(t *testing.T) {
+		scope := names.scope()
+		bunchID := insertBunch(t, f, scope+"_bunch")
+		keyID := insertKey(t, f, scope+"_key")
+
+		id, err := f.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: bunchID, KeyID: keyID})
+		require.Nil(t, err)
+		require.NotZero(t, id)
+
+		rows, total, err := f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_bunch"}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, id, rows[0].BunchKey.ID)
+		require.Equal(t, bunchID, rows[0].BunchKey.BunchID)
+		require.Equal(t, keyID, rows[0].BunchKey.KeyID)
+		require.Equal(t, scope+"_bunch", rows[0].Bunch.Name)
+		require.Equal(t, share.Boolean{IsSet: true, Bool: true}, rows[0].Bunch.Active)
+		require.Equal(t, scope+"_key", rows[0].Key.Name)
+		require.False(t, rows[0].BunchKey.UpdatedAt.IsZero())
+	})
+
+	t.Run("fail_insert_a_duplicated_bunch_key", func(t *testing.T) {
+		scope := names.scope()
+		bunchID := insertBunch(t, f, scope+"_bunch")
+		keyID := insertKey(t, f, scope+"_key")
+		insertBunchKey(t, f, bunchID, keyID)
+
+		id, err := f.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: bunchID, KeyID: keyID})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		require.Zero(t, id)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "bunch_key", dup.Entity)
+		require.Equal(t, "key_id", dup.Field)
+	})
+
+	t.Run("fail_insert_a_bunch_key_of_a_missing_key", func(t *testing.T) {
+		bunchID := insertBunch(t, f, names.scope()+"_bunch")
+
+		id, err := f.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: bunchID, KeyID: 1 << 40})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+		require.Zero(t, id)
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "bunch_key", fk.Entity)
+		require.Equal(t, "key_id", fk.Field)
+	})
+
+	t.Run("fail_insert_a_bunch_key_of_a_missing_bunch", func(t *testing.T) {
+		keyID := insertKey(t, f, names.scope()+"_key")
+
+		_, err := f.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: 1 << 40, KeyID: keyID})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "bunch_key", fk.Entity)
+		require.Equal(t, "bunch_id", fk.Field)
+	})
+
+	t.Run("success_get_a_bunch_key", func(t *testing.T) {
+		scope := names.scope()
+		bunchID, keyID := insertBunch(t, f, scope+"_bunch"), insertKey(t, f, scope+"_key")
+		insertBunchKey(t, f, bunchID, insertKey(t, f, scope+"_other"))
+		id := insertBunchKey(t, f, bunchID, keyID)
+
+		bk, err := f.BunchKeys().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, id, bk.BunchKey.ID)
+		require.Equal(t, bunchID, bk.BunchKey.BunchID)
+		require.Equal(t, keyID, bk.BunchKey.KeyID)
+		require.Equal(t, scope+"_bunch", bk.Bunch.Name)
+		require.Equal(t, scope+"_key", bk.Key.Name)
+		require.Nil(t, bk.Source)
+		require.False(t, bk.BunchKey.UpdatedAt.IsZero())
+
+		require.Nil(t, f.BunchKeys().Delete(ctx, id))
+		_, err = f.BunchKeys().Get(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_delete_a_bunch_key", func(t *testing.T) {
+		scope := names.scope()
+		id := insertBunchKey(t, f, insertBunch(t, f, scope+"_bunch"), insertKey(t, f, scope+"_key"))
+
+		require.Nil(t, f.BunchKeys().Delete(ctx, id))
+
+		_, total, err := f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_bunch"}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+
+		err = f.BunchKeys().Delete(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_query_bunch_keys", func(t *testing.T) {
+		scope := names.scope()
+		bunchA := insertBunch(t, f, scope+"_a")
+		bunchB := insertBunch(t, f, scope+"_b")
+		keyX := insertKey(t, f, scope+"_x")
+		keyY := insertKey(t, f, scope+"_y")
+		keyZ := insertKey(t, f, scope+"_z")
+		idAZ := insertBunchKey(t, f, bunchA, keyZ)
+		idAX := insertBunchKey(t, f, bunchA, keyX)
+		idBY := insertBunchKey(t, f, bunchB, keyY)
+		idBX := insertBunchKey(t, f, bunchB, keyX)
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: bunchB, Active: share.Boolean{IsSet: true}}))
+
+		rows, total, err := f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_a"}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAX, idAZ}, bunchKeyIDs(rows))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_a"},
+			storage.SortBunchKey{KeyName: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAX, idAZ}, bunchKeyIDs(rows))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{KeyName: scope + "_x"},
+			storage.SortBunchKey{BunchName: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idBX, idAX}, bunchKeyIDs(rows))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{KeyName: scope + "_x", Limit: 1, Offset: 1},
+			storage.SortBunchKey{BunchName: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAX}, bunchKeyIDs(rows))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_b", KeyName: scope + "_y"},
+			storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idBY}, bunchKeyIDs(rows))
+		require.False(t, rows[0].Bunch.Active.Bool)
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{
+			KeyName:     scope + "_x",
+			BunchActive: share.Boolean{IsSet: true, Bool: true},
+		}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idAX}, bunchKeyIDs(rows))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{
+			KeyName:     scope + "_x",
+			BunchActive: share.Boolean{IsSet: true},
+		}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idBX}, bunchKeyIDs(rows))
+	})
+
+	t.Run("success_query_inherited_bunch_keys", func(t *testing.T) {
+		f.needs(t, "BunchParents")
+
+		scope := names.scope()
+		grandparent := insertBunch(t, f, scope+"_grandparent")
+		parent := insertBunch(t, f, scope+"_parent")
+		child := insertBunch(t, f, scope+"_child")
+		idGZ := insertBunchKey(t, f, grandparent, insertKey(t, f, scope+"_z"))
+		idPX := insertBunchKey(t, f, parent, insertKey(t, f, scope+"_x"))
+		idCY := insertBunchKey(t, f, child, insertKey(t, f, scope+"_y"))
+		insertBunchParent(t, f, parent, grandparent)
+		insertBunchParent(t, f, child, parent)
+
+		rows, total, err := f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_child"}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idCY}, bunchKeyIDs(rows))
+		require.Nil(t, rows[0].Source)
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_child", Inherited: true},
+			storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idCY, idPX, idGZ}, bunchKeyIDs(rows))
+		require.Nil(t, rows[0].Source)
+		require.Equal(t, scope+"_child", rows[1].Bunch.Name)
+		require.Equal(t, scope+"_x", rows[1].Key.Name)
+		require.Equal(t, parent, rows[1].BunchKey.BunchID)
+		require.Equal(t, scope+"_parent", rows[1].Source.Name)
+		require.Equal(t, scope+"_grandparent", rows[2].Source.Name)
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{KeyName: scope + "_x", Inherited: true},
+			storage.SortBunchKey{BunchName: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idPX, idPX}, bunchKeyIDs(rows))
+		require.Equal(t, scope+"_child", rows[0].Bunch.Name)
+		require.Equal(t, scope+"_parent", rows[0].Source.Name)
+		require.Equal(t, scope+"_parent", rows[1].Bunch.Name)
+		require.Nil(t, rows[1].Source)
+
+		//an inactive parent passes on neither its keys nor those it inherits, it still lists its own
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: parent, Active: share.Boolean{IsSet: true}}))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_child", Inherited: true},
+			storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idCY}, bunchKeyIDs(rows))
+
+		rows, total, err = f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_parent", Inherited: true},
+			storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idPX, idGZ}, bunchKeyIDs(rows))
+	})
+}
+
+func insertBunchKey(t *testing.T, f Factories, bunchID int64, keyID int64) int64 {
+	t.Helper()
+
+	id, err := f.BunchKeys().Insert(context.Background(), storage.BunchKey{BunchID: bunchID, KeyID: keyID})
+	require.Nil(t, err)
+
+	return id
+}
+
+func bunchKeyIDs(rows []*storage.AggregateBunchKey) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.BunchKey.ID)
+	}
+
+	return ids
+}
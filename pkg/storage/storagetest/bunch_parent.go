@@ -0,0 +1,232 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunBunchParentStorer tests a storage.BunchParentStorer
+func RunBunchParentStorer(t *testing.T, f Factories) {
+	f.needs(t, "Bunches", "BunchParents")
+	ctx := context.Background()
+
+	t.Run("success_insert_a_bunch_parent", func(t *testing.T) {
+		scope := names.scope()
+		bunchID := insertBunch(t, f, scope+"_bunch")
+		parentID := insertBunch(t, f, scope+"_parent")
+
+		id, err := f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchID, ParentID: parentID})
+		require.Nil(t, err)
+		require.NotZero(t, id)
+
+		rows, total, err := f.BunchParents().Query(ctx, storage.QueryBunchParent{BunchName: scope + "_bunch"},
+			storage.SortBunchParent{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, id, rows[0].BunchParent.ID)
+		require.Equal(t, bunchID, rows[0].BunchParent.BunchID)
+		require.Equal(t, parentID, rows[0].BunchParent.ParentID)
+		require.Equal(t, scope+"_bunch", rows[0].Bunch.Name)
+		require.Equal(t, scope+"_parent", rows[0].Parent.Name)
+		require.Equal(t, share.Boolean{IsSet: true, Bool: true}, rows[0].Parent.Active)
+		require.False(t, rows[0].BunchParent.UpdatedAt.IsZero())
+	})
+
+	t.Run("fail_insert_a_duplicated_bunch_parent", func(t *testing.T) {
+		scope := names.scope()
+		bunchID := insertBunch(t, f, scope+"_bunch")
+		parentID := insertBunch(t, f, scope+"_parent")
+		insertBunchParent(t, f, bunchID, parentID)
+
+		id, err := f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchID, ParentID: parentID})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		require.Zero(t, id)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "bunch_parent", dup.Entity)
+		require.Equal(t, "parent_id", dup.Field)
+	})
+
+	t.Run("fail_insert_a_bunch_parent_of_a_missing_parent", func(t *testing.T) {
+		bunchID := insertBunch(t, f, names.scope()+"_bunch")
+
+		id, err := f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchID, ParentID: 1 << 40})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+		require.Zero(t, id)
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "bunch_parent", fk.Entity)
+		require.Equal(t, "parent_id", fk.Field)
+	})
+
+	t.Run("fail_insert_a_bunch_parent_of_a_missing_bunch", func(t *testing.T) {
+		parentID := insertBunch(t, f, names.scope()+"_parent")
+
+		_, err := f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: 1 << 40, ParentID: parentID})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "bunch_parent", fk.Entity)
+		require.Equal(t, "bunch_id", fk.Field)
+	})
+
+	t.Run("fail_insert_a_bunch_parent_making_a_cycle", func(t *testing.T) {
+		scope := names.scope()
+		bunchA := insertBunch(t, f, scope+"_a")
+		bunchB := insertBunch(t, f, scope+"_b")
+		bunchC := insertBunch(t, f, scope+"_c")
+		insertBunchParent(t, f, bunchA, bunchB)
+		insertBunchParent(t, f, bunchB, bunchC)
+
+		id, err := f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchA, ParentID: bunchA})
+		require.True(t, errors.Is(err, storage.ErrBunchCycle))
+		require.Zero(t, id)
+
+		_, err = f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchB, ParentID: bunchA})
+		require.True(t, errors.Is(err, storage.ErrBunchCycle))
+
+		_, err = f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchC, ParentID: bunchA})
+		require.True(t, errors.Is(err, storage.ErrBunchCycle))
+
+		_, err = f.BunchParents().Insert(ctx, storage.CreateBunchParent{BunchID: bunchA, ParentID: bunchC})
+		require.Nil(t, err)
+	})
+
+	t.Run("fail_insert_concurrent_bunch_parents_making_a_cycle", func(t *testing.T) {
+		// A inherits from B and C from D, B inheriting from C and D from A at once would close A, B, C, D
+		for round := 0; round < 5; round++ {
+			scope := names.scope()
+			bunchA := insertBunch(t, f, scope+"_a")
+			bunchB := insertBunch(t, f, scope+"_b")
+			bunchC := insertBunch(t, f, scope+"_c")
+			bunchD := insertBunch(t, f, scope+"_d")
+			insertBunchParent(t, f, bunchA, bunchB)
+			insertBunchParent(t, f, bunchC, bunchD)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, 2)
+			for _, bp := range []storage.CreateBunchParent{{BunchID: bunchB, ParentID: bunchC},
+				{BunchID: bunchD, ParentID: bunchA}} {
+				wg.Add(1)
+				go func(bp storage.CreateBunchParent) {
+					defer wg.Done()
+					_, err := f.BunchParents().Insert(ctx, bp)
+					errs <- err
+				}(bp)
+			}
+			wg.Wait()
+			close(errs)
+
+			var inserted, cycles int
+			for err := range errs {
+				switch {
+				case err == nil:
+					inserted++
+				case errors.Is(err, storage.ErrBunchCycle):
+					cycles++
+				default:
+					require.Nil(t, err)
+				}
+			}
+			require.Equal(t, 1, inserted)
+			require.Equal(t, 1, cycles)
+		}
+	})
+
+	t.Run("success_get_a_bunch_parent", func(t *testing.T) {
+		scope := names.scope()
+		bunchID, parentID := insertBunch(t, f, scope+"_bunch"), insertBunch(t, f, scope+"_parent")
+		insertBunchParent(t, f, bunchID, insertBunch(t, f, scope+"_other"))
+		id := insertBunchParent(t, f, bunchID, parentID)
+
+		bp, err := f.BunchParents().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, id, bp.BunchParent.ID)
+		require.Equal(t, bunchID, bp.BunchParent.BunchID)
+		require.Equal(t, parentID, bp.BunchParent.ParentID)
+		require.Equal(t, scope+"_bunch", bp.Bunch.Name)
+		require.Equal(t, scope+"_parent", bp.Parent.Name)
+		require.False(t, bp.BunchParent.UpdatedAt.IsZero())
+
+		require.Nil(t, f.BunchParents().Delete(ctx, id))
+		_, err = f.BunchParents().Get(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_delete_a_bunch_parent", func(t *testing.T) {
+		scope := names.scope()
+		id := insertBunchParent(t, f, insertBunch(t, f, scope+"_bunch"), insertBunch(t, f, scope+"_parent"))
+
+		require.Nil(t, f.BunchParents().Delete(ctx, id))
+
+		_, total, err := f.BunchParents().Query(ctx, storage.QueryBunchParent{BunchName: scope + "_bunch"},
+			storage.SortBunchParent{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+
+		err = f.BunchParents().Delete(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_query_bunch_parents", func(t *testing.T) {
+		scope := names.scope()
+		bunchA := insertBunch(t, f, scope+"_a")
+		bunchB := insertBunch(t, f, scope+"_b")
+		bunchX := insertBunch(t, f, scope+"_x")
+		bunchY := insertBunch(t, f, scope+"_y")
+		idAY := insertBunchParent(t, f, bunchA, bunchY)
+		idAX := insertBunchParent(t, f, bunchA, bunchX)
+		idBX := insertBunchParent(t, f, bunchB, bunchX)
+
+		rows, total, err := f.BunchParents().Query(ctx, storage.QueryBunchParent{BunchName: scope + "_a"},
+			storage.SortBunchParent{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAX, idAY}, bunchParentIDs(rows))
+
+		rows, total, err = f.BunchParents().Query(ctx, storage.QueryBunchParent{BunchName: scope + "_a"},
+			storage.SortBunchParent{ParentName: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAY, idAX}, bunchParentIDs(rows))
+
+		rows, total, err = f.BunchParents().Query(ctx, storage.QueryBunchParent{ParentName: scope + "_x"},
+			storage.SortBunchParent{BunchName: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAX, idBX}, bunchParentIDs(rows))
+
+		rows, total, err = f.BunchParents().Query(ctx, storage.QueryBunchParent{ParentName: scope + "_x", Limit: 1, Offset: 1},
+			storage.SortBunchParent{BunchName: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idBX}, bunchParentIDs(rows))
+	})
+}
+
+func insertBunchParent(t *testing.T, f Factories, bunchID int64, parentID int64) int64 {
+	t.Helper()
+
+	id, err := f.BunchParents().Insert(context.Background(), storage.CreateBunchParent{BunchID: bunchID, ParentID: parentID})
+	require.Nil(t, err)
+
+	return id
+}
+
+func bunchParentIDs(rows []*storage.AggregateBunchParent) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.BunchParent.ID)
+	}
+
+	return ids
+}
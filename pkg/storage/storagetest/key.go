@@ -0,0 +1,200 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunKeyStorer tests a storage.KeyStorer
+func RunKeyStorer(t *testing.T, f Factories) {
+	f.needs(t, "Keys")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_get_a_key", func(t *testing.T) {
+		scope := names.scope()
+
+		id, err := f.Keys().Insert(ctx, storage.CreateKey{Name: scope + "_key", Desc: scope + "_desc"})
+		require.Nil(t, err)
+		require.NotZero(t, id)
+
+		key, err := f.Keys().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, id, key.ID)
+		require.Equal(t, scope+"_key", key.Name)
+		require.Equal(t, scope+"_desc", key.Desc)
+		require.False(t, key.UpdatedAt.IsZero())
+
+		key, err = f.Keys().GetByName(ctx, scope+"_key")
+		require.Nil(t, err)
+		require.Equal(t, id, key.ID)
+	})
+
+	t.Run("fail_get_a_missing_key", func(t *testing.T) {
+		_, err := f.Keys().Get(ctx, 1<<40)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+
+		_, err = f.Keys().GetByName(ctx, names.scope())
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("fail_insert_a_duplicated_key", func(t *testing.T) {
+		scope := names.scope()
+		insertKey(t, f, scope+"_key")
+
+		id, err := f.Keys().Insert(ctx, storage.CreateKey{Name: scope + "_key", Desc: "desc"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		require.Zero(t, id)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "key", dup.Entity)
+		require.Equal(t, "name", dup.Field)
+	})
+
+	t.Run("success_update_a_key", func(t *testing.T) {
+		scope := names.scope()
+		id := insertKey(t, f, scope+"_key")
+
+		err := f.Keys().Update(ctx, storage.UpdateKey{ID: id, Name: scope + "_new", Desc: "new desc"})
+		require.Nil(t, err)
+
+		key, err := f.Keys().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_new", key.Name)
+		require.Equal(t, "new desc", key.Desc)
+
+		err = f.Keys().Update(ctx, storage.UpdateKey{ID: id})
+		require.Nil(t, err)
+
+		err = f.Keys().Update(ctx, storage.UpdateKey{ID: id, Desc: "newer desc"})
+		require.Nil(t, err)
+
+		key, err = f.Keys().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_new", key.Name)
+		require.Equal(t, "newer desc", key.Desc)
+	})
+
+	t.Run("fail_update_a_key_to_a_duplicated_name", func(t *testing.T) {
+		scope := names.scope()
+		insertKey(t, f, scope+"_a")
+		id := insertKey(t, f, scope+"_b")
+
+		err := f.Keys().Update(ctx, storage.UpdateKey{ID: id, Name: scope + "_a"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+
+		key, err := f.Keys().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_b", key.Name)
+	})
+
+	t.Run("success_delete_a_key", func(t *testing.T) {
+		id := insertKey(t, f, names.scope()+"_key")
+
+		require.Nil(t, f.Keys().Delete(ctx, id))
+
+		_, err := f.Keys().Get(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+
+		err = f.Keys().Delete(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_delete_a_key_cascades_to_bunches", func(t *testing.T) {
+		f.needs(t, "Bunches", "BunchKeys")
+
+		scope := names.scope()
+		keyID := insertKey(t, f, scope+"_key")
+		otherKeyID := insertKey(t, f, scope+"_other")
+		bunchID := insertBunch(t, f, scope+"_bunch")
+		insertBunchKey(t, f, bunchID, keyID)
+		insertBunchKey(t, f, bunchID, otherKeyID)
+
+		require.Nil(t, f.Keys().Delete(ctx, keyID))
+
+		rows, total, err := f.BunchKeys().Query(ctx, storage.QueryBunchKey{BunchName: scope + "_bunch"}, storage.SortBunchKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Len(t, rows, 1)
+		require.Equal(t, otherKeyID, rows[0].Key.ID)
+	})
+
+	t.Run("success_query_keys", func(t *testing.T) {
+		scope := names.scope()
+		idC := insertKey(t, f, scope+"_c")
+		idA := insertKey(t, f, scope+"_a")
+		idB := insertKey(t, f, scope+"_b")
+		require.Nil(t, f.Keys().Update(ctx, storage.UpdateKey{ID: idB, Desc: scope + " special"}))
+
+		rows, total, err := f.Keys().Query(ctx, storage.QueryKey{Name: scope}, storage.SortKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idB, idA, idC}, keyIDs(rows))
+
+		rows, total, err = f.Keys().Query(ctx, storage.QueryKey{Name: scope}, storage.SortKey{Name: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idA, idB, idC}, keyIDs(rows))
+
+		rows, total, err = f.Keys().Query(ctx, storage.QueryKey{Name: scope, Limit: 2, Offset: 1}, storage.SortKey{Name: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idB, idA}, keyIDs(rows))
+
+		rows, total, err = f.Keys().Query(ctx, storage.QueryKey{Desc: scope + " SPECIAL"}, storage.SortKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idB}, keyIDs(rows))
+
+		rows, total, err = f.Keys().Query(ctx, storage.QueryKey{Name: scope, From: time.Now().Add(time.Hour)}, storage.SortKey{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+		require.Empty(t, rows)
+
+		rows, total, err = f.Keys().Query(ctx, storage.QueryKey{Name: scope, To: time.Now().Add(-time.Hour)}, storage.SortKey{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+		require.Empty(t, rows)
+	})
+
+	t.Run("success_query_keys_with_default_limit", func(t *testing.T) {
+		scope := names.scope()
+		for i := 0; i < share.DefaultLimit+2; i++ {
+			insertKey(t, f, scope+"_"+string(rune('a'+i)))
+		}
+
+		rows, total, err := f.Keys().Query(ctx, storage.QueryKey{Name: scope}, storage.SortKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(share.DefaultLimit+2), total)
+		require.Len(t, rows, share.DefaultLimit)
+
+		rows, total, err = f.Keys().Query(ctx, storage.QueryKey{Name: scope, Offset: share.DefaultLimit}, storage.SortKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(share.DefaultLimit+2), total)
+		require.Len(t, rows, 2)
+	})
+}
+
+func insertKey(t *testing.T, f Factories, name string) int64 {
+	t.Helper()
+
+	id, err := f.Keys().Insert(context.Background(), storage.CreateKey{Name: name, Desc: name + " desc"})
+	require.Nil(t, err)
+
+	return id
+}
+
+func keyIDs(rows []*storage.Key) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,120 @@
+package storagetest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunLoginAttemptStorer tests a storage.LoginAttemptStorer
+func RunLoginAttemptStorer(t *testing.T, f Factories) {
+	f.needs(t, "LoginAttempts")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_query_login_attempts", func(t *testing.T) {
+		userID := uniqueUserID()
+		username := names.scope()
+		before := time.Now().Add(-time.Second)
+
+		failed := insertLoginAttempt(t, f, userID, username, "192.0.2.1", storage.LoginFailed)
+		succeeded := insertLoginAttempt(t, f, userID, username, "192.0.2.2", storage.LoginSucceeded)
+		unknown := insertLoginAttempt(t, f, 0, username+"@test.com", "192.0.2.1", storage.LoginFailed)
+
+		rows, total, err := f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: userID},
+			storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{succeeded, failed}, loginAttemptIDs(rows))
+
+		a := rows[0]
+		require.Equal(t, userID, a.UserID)
+		require.Equal(t, username, a.Username)
+		require.Equal(t, "192.0.2.2", a.IP)
+		require.Equal(t, storage.LoginSucceeded, a.Outcome)
+		require.True(t, a.CreatedAt.After(before))
+
+		rows, total, err = f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{Username: username + "@test.com"},
+			storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{unknown}, loginAttemptIDs(rows))
+		require.Zero(t, rows[0].UserID)
+
+		rows, total, err = f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: userID,
+			Outcome: storage.LoginFailed}, storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{failed}, loginAttemptIDs(rows))
+
+		rows, total, err = f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: userID, From: time.Now().Add(time.Hour)},
+			storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+		require.Empty(t, rows)
+	})
+
+	t.Run("success_keep_the_order_of_login_attempts", func(t *testing.T) {
+		ip := names.scope()
+
+		ids := make([]int64, 0, 4)
+		for i := 0; i < 4; i++ {
+			ids = append(ids, insertLoginAttempt(t, f, 0, "nobody", ip, storage.LoginFailed))
+		}
+
+		rows, total, err := f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{IP: ip, Limit: 2, Offset: 1},
+			storage.SortLoginAttempt{CreatedAt: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(4), total)
+		require.Equal(t, ids[1:3], loginAttemptIDs(rows))
+
+		rows, _, err = f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{IP: ip, Limit: 2},
+			storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Equal(t, []int64{ids[3], ids[2]}, loginAttemptIDs(rows))
+	})
+
+	t.Run("success_purge_old_login_attempts", func(t *testing.T) {
+		userID := uniqueUserID()
+		insertLoginAttempt(t, f, userID, "alice", "", storage.LoginFailed)
+
+		purged, err := f.LoginAttempts().Purge(ctx, time.Now().Add(-time.Hour))
+		require.Nil(t, err)
+		require.Zero(t, purged)
+
+		purged, err = f.LoginAttempts().Purge(ctx, time.Now().Add(time.Hour))
+		require.Nil(t, err)
+		require.True(t, purged >= 1)
+
+		_, total, err := f.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: userID},
+			storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+	})
+}
+
+func insertLoginAttempt(t *testing.T, f Factories, userID int64, username string, ip string, outcome string) int64 {
+	t.Helper()
+
+	id, err := f.LoginAttempts().Insert(context.Background(), storage.CreateLoginAttempt{
+		UserID:   userID,
+		Username: username,
+		IP:       ip,
+		Outcome:  outcome,
+	})
+	require.Nil(t, err)
+
+	return id
+}
+
+func loginAttemptIDs(rows []*storage.LoginAttempt) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,183 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunTOTPStorer tests a storage.TOTPStorer
+func RunTOTPStorer(t *testing.T, f Factories) {
+	f.needs(t, "TOTPs")
+	ctx := context.Background()
+
+	t.Run("success_enroll_and_confirm_a_secret", func(t *testing.T) {
+		userID := uniqueUserID()
+
+		require.Nil(t, f.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: "JBSWY3DPEHPK3PXP"}))
+
+		totp, err := f.TOTPs().Get(ctx, userID)
+		require.Nil(t, err)
+		require.Equal(t, userID, totp.UserID)
+		require.Equal(t, "JBSWY3DPEHPK3PXP", totp.Secret)
+		require.Zero(t, totp.LastStep)
+		require.False(t, totp.CreatedAt.IsZero())
+		require.True(t, totp.ConfirmedAt.IsZero())
+
+		used, err := f.TOTPs().Use(ctx, userID, 100)
+		require.Nil(t, err)
+		require.False(t, used)
+
+		confirmed, err := f.TOTPs().Confirm(ctx, userID, 100)
+		require.Nil(t, err)
+		require.True(t, confirmed)
+
+		confirmed, err = f.TOTPs().Confirm(ctx, userID, 101)
+		require.Nil(t, err)
+		require.False(t, confirmed)
+
+		totp, err = f.TOTPs().Get(ctx, userID)
+		require.Nil(t, err)
+		require.Equal(t, int64(100), totp.LastStep)
+		require.False(t, totp.ConfirmedAt.IsZero())
+	})
+
+	t.Run("success_use_each_step_once", func(t *testing.T) {
+		userID := uniqueUserID()
+
+		require.Nil(t, f.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: "JBSWY3DPEHPK3PXP"}))
+		_, err := f.TOTPs().Confirm(ctx, userID, 100)
+		require.Nil(t, err)
+
+		for _, step := range []int64{99, 100} {
+			used, err := f.TOTPs().Use(ctx, userID, step)
+			require.Nil(t, err)
+			require.False(t, used, "step %d", step)
+		}
+
+		used, err := f.TOTPs().Use(ctx, userID, 102)
+		require.Nil(t, err)
+		require.True(t, used)
+
+		used, err = f.TOTPs().Use(ctx, userID, 101)
+		require.Nil(t, err)
+		require.False(t, used)
+
+		totp, err := f.TOTPs().Get(ctx, userID)
+		require.Nil(t, err)
+		require.Equal(t, int64(102), totp.LastStep)
+	})
+
+	t.Run("success_delete_a_secret", func(t *testing.T) {
+		userID := uniqueUserID()
+
+		require.Nil(t, f.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: "JBSWY3DPEHPK3PXP"}))
+		require.Nil(t, f.TOTPs().Delete(ctx, userID))
+
+		_, err := f.TOTPs().Get(ctx, userID)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+		require.True(t, errors.Is(f.TOTPs().Delete(ctx, userID), storage.ErrNotFound))
+
+		require.Nil(t, f.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: "KRSXG5CTMVRXEZLU"}))
+	})
+
+	t.Run("fail_insert_a_second_secret", func(t *testing.T) {
+		userID := uniqueUserID()
+
+		require.Nil(t, f.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: "JBSWY3DPEHPK3PXP"}))
+
+		err := f.TOTPs().Insert(ctx, storage.CreateTOTP{UserID: userID, Secret: "KRSXG5CTMVRXEZLU"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate), "%v", err)
+	})
+}
+
+// RunRecoveryCodeStorer tests a storage.RecoveryCodeStorer
+func RunRecoveryCodeStorer(t *testing.T, f Factories) {
+	f.needs(t, "RecoveryCodes")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_use_recovery_codes", func(t *testing.T) {
+		userID := uniqueUserID()
+		hashes := []string{names.scope(), names.scope(), names.scope()}
+
+		ids := make([]int64, 0, len(hashes))
+		for _, hash := range hashes {
+			ids = append(ids, insertRecoveryCode(t, f, userID, hash))
+		}
+
+		used, err := f.RecoveryCodes().Use(ctx, userID, hashes[1])
+		require.Nil(t, err)
+		require.True(t, used)
+
+		used, err = f.RecoveryCodes().Use(ctx, userID, hashes[1])
+		require.Nil(t, err)
+		require.False(t, used)
+
+		used, err = f.RecoveryCodes().Use(ctx, uniqueUserID(), hashes[0])
+		require.Nil(t, err)
+		require.False(t, used)
+
+		rows, total, err := f.RecoveryCodes().Query(ctx, storage.QueryRecoveryCode{UserID: userID},
+			storage.SortRecoveryCode{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, ids, recoveryCodeIDs(rows))
+		require.Equal(t, hashes[0], rows[0].Hash)
+		require.True(t, rows[0].UsedAt.IsZero())
+		require.False(t, rows[1].UsedAt.IsZero())
+
+		rows, total, err = f.RecoveryCodes().Query(ctx, storage.QueryRecoveryCode{UserID: userID,
+			Used: share.Boolean{IsSet: true, Bool: false}}, storage.SortRecoveryCode{CreatedAt: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{ids[2], ids[0]}, recoveryCodeIDs(rows))
+
+		_, total, err = f.RecoveryCodes().Query(ctx, storage.QueryRecoveryCode{UserID: userID,
+			Used: share.Boolean{IsSet: true, Bool: true}}, storage.SortRecoveryCode{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+	})
+
+	t.Run("success_delete_the_codes_of_a_user", func(t *testing.T) {
+		userID, otherID := uniqueUserID(), uniqueUserID()
+		insertRecoveryCode(t, f, userID, names.scope())
+		insertRecoveryCode(t, f, userID, names.scope())
+		insertRecoveryCode(t, f, otherID, names.scope())
+
+		deleted, err := f.RecoveryCodes().DeleteUser(ctx, userID)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), deleted)
+
+		_, total, err := f.RecoveryCodes().Query(ctx, storage.QueryRecoveryCode{UserID: userID},
+			storage.SortRecoveryCode{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+
+		_, total, err = f.RecoveryCodes().Query(ctx, storage.QueryRecoveryCode{UserID: otherID},
+			storage.SortRecoveryCode{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+	})
+}
+
+func insertRecoveryCode(t *testing.T, f Factories, userID int64, hash string) int64 {
+	t.Helper()
+
+	id, err := f.RecoveryCodes().Insert(context.Background(), storage.CreateRecoveryCode{UserID: userID, Hash: hash})
+	require.Nil(t, err)
+
+	return id
+}
+
+func recoveryCodeIDs(rows []*storage.RecoveryCode) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	return ids
+}
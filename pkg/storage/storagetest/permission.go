@@ -0,0 +1,229 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunPermissionResolver tests a storage.PermissionResolver
+func RunPermissionResolver(t *testing.T, f Factories) {
+	f.needs(t, "Keys", "Bunches", "BunchKeys", "Users", "UserBunches", "Permissions")
+	ctx := context.Background()
+
+	//seed gives a user the keys a and b through an active bunch, the key c only through an inactive
+	//bunch, and leaves the key d out of the user's bunches
+	seed := func(t *testing.T) (scope string, userID int64) {
+		scope = names.scope()
+		userID = insertUser(t, f, scope+"_user")
+
+		keyA := insertKey(t, f, scope+"_a")
+		keyB := insertKey(t, f, scope+"_b")
+		keyC := insertKey(t, f, scope+"_c")
+		insertKey(t, f, scope+"_d")
+
+		active := insertBunch(t, f, scope+"_active")
+		insertBunchKey(t, f, active, keyA)
+		insertBunchKey(t, f, active, keyB)
+		insertUserBunch(t, f, userID, active)
+
+		inactive := insertBunch(t, f, scope+"_inactive")
+		insertBunchKey(t, f, inactive, keyB)
+		insertBunchKey(t, f, inactive, keyC)
+		insertUserBunch(t, f, userID, inactive)
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: inactive, Active: share.Boolean{IsSet: true}}))
+
+		return scope, userID
+	}
+
+	t.Run("success_list_keys", func(t *testing.T) {
+		scope, userID := seed(t)
+
+		keys, err := f.Permissions().Keys(ctx, userID)
+		require.Nil(t, err)
+		require.Len(t, keys, 2)
+		require.Equal(t, scope+"_a", keys[0].Name)
+		require.Equal(t, scope+"_b", keys[1].Name)
+	})
+
+	t.Run("success_check_keys", func(t *testing.T) {
+		scope, userID := seed(t)
+
+		has, err := f.Permissions().HasKey(ctx, userID, scope+"_a")
+		require.Nil(t, err)
+		require.True(t, has)
+
+		has, err = f.Permissions().HasKey(ctx, userID, scope+"_c")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		has, err = f.Permissions().HasAll(ctx, userID, scope+"_a", scope+"_b", scope+"_a")
+		require.Nil(t, err)
+		require.True(t, has)
+
+		has, err = f.Permissions().HasAll(ctx, userID, scope+"_a", scope+"_c")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		has, err = f.Permissions().HasAny(ctx, userID, scope+"_c", scope+"_d", scope+"_b")
+		require.Nil(t, err)
+		require.True(t, has)
+
+		has, err = f.Permissions().HasAny(ctx, userID, scope+"_c", scope+"_missing")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		has, err = f.Permissions().HasAll(ctx, userID)
+		require.Nil(t, err)
+		require.True(t, has)
+
+		has, err = f.Permissions().HasAny(ctx, userID)
+		require.Nil(t, err)
+		require.False(t, has)
+	})
+
+	t.Run("success_inactive_user_holds_no_key", func(t *testing.T) {
+		scope, userID := seed(t)
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: userID, Active: share.Boolean{IsSet: true}}))
+
+		keys, err := f.Permissions().Keys(ctx, userID)
+		require.Nil(t, err)
+		require.Empty(t, keys)
+
+		has, err := f.Permissions().HasAny(ctx, userID, scope+"_a", scope+"_b")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		exp, err := f.Permissions().Explain(ctx, userID, scope+"_a")
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.UserInactive, exp.Denials[0].Reason)
+	})
+
+	t.Run("success_explain_keys", func(t *testing.T) {
+		scope, userID := seed(t)
+
+		exp, err := f.Permissions().Explain(ctx, userID, scope+"_b")
+		require.Nil(t, err)
+		require.True(t, exp.Granted)
+		require.Len(t, exp.Grants, 1)
+		require.Equal(t, scope+"_active", exp.Grants[0].UserBunch.Bunch.Name)
+		require.Equal(t, userID, exp.Grants[0].UserBunch.User.ID)
+		require.Empty(t, exp.Grants[0].UserBunch.User.Hash)
+		require.Empty(t, exp.User.Hash)
+		require.Empty(t, exp.User.Salt)
+		require.Empty(t, exp.Denials)
+
+		exp, err = f.Permissions().Explain(ctx, userID, scope+"_c")
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.BunchInactive, exp.Denials[0].Reason)
+		require.Equal(t, scope+"_inactive", exp.Denials[0].Bunch.Name)
+
+		exp, err = f.Permissions().Explain(ctx, userID, scope+"_d")
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.KeyNotInBunches, exp.Denials[0].Reason)
+
+		exp, err = f.Permissions().Explain(ctx, userID, scope+"_missing")
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Nil(t, exp.Key)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.KeyMissing, exp.Denials[0].Reason)
+
+		_, err = f.Permissions().Explain(ctx, 1<<40, scope+"_b")
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_resolve_inherited_keys", func(t *testing.T) {
+		f.needs(t, "BunchParents")
+
+		scope, userID := seed(t)
+		active, err := f.Bunches().GetByName(ctx, scope+"_active")
+		require.Nil(t, err)
+		keyA, err := f.Keys().GetByName(ctx, scope+"_a")
+		require.Nil(t, err)
+
+		parent := insertBunch(t, f, scope+"_parent")
+		insertBunchKey(t, f, parent, keyA.ID)
+		insertBunchKey(t, f, parent, insertKey(t, f, scope+"_e"))
+		insertBunchParent(t, f, active.ID, parent)
+
+		keys, err := f.Permissions().Keys(ctx, userID)
+		require.Nil(t, err)
+		require.Len(t, keys, 3)
+		require.Equal(t, scope+"_e", keys[2].Name)
+
+		has, err := f.Permissions().HasAll(ctx, userID, scope+"_a", scope+"_e")
+		require.Nil(t, err)
+		require.True(t, has)
+
+		exp, err := f.Permissions().Explain(ctx, userID, scope+"_e")
+		require.Nil(t, err)
+		require.True(t, exp.Granted)
+		require.Len(t, exp.Grants, 1)
+		require.Equal(t, scope+"_active", exp.Grants[0].UserBunch.Bunch.Name)
+		require.Equal(t, scope+"_parent", exp.Grants[0].BunchKey.Source.Name)
+		require.Equal(t, parent, exp.Grants[0].BunchKey.BunchKey.BunchID)
+	})
+
+	t.Run("success_ignore_keys_through_inactive_ancestors", func(t *testing.T) {
+		f.needs(t, "BunchParents")
+
+		scope, userID := seed(t)
+		active, err := f.Bunches().GetByName(ctx, scope+"_active")
+		require.Nil(t, err)
+
+		//the user's bunch inherits the key e from the active grandparent through the inactive parent
+		parent := insertBunch(t, f, scope+"_parent")
+		grandparent := insertBunch(t, f, scope+"_grandparent")
+		insertBunchKey(t, f, grandparent, insertKey(t, f, scope+"_e"))
+		insertBunchParent(t, f, parent, grandparent)
+		insertBunchParent(t, f, active.ID, parent)
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: parent, Active: share.Boolean{IsSet: true}}))
+
+		keys, err := f.Permissions().Keys(ctx, userID)
+		require.Nil(t, err)
+		require.Len(t, keys, 2)
+
+		has, err := f.Permissions().HasAny(ctx, userID, scope+"_e")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		exp, err := f.Permissions().Explain(ctx, userID, scope+"_e")
+		require.Nil(t, err)
+		require.False(t, exp.Granted)
+		require.Empty(t, exp.Grants)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, storage.BunchInactive, exp.Denials[0].Reason)
+		require.Equal(t, scope+"_parent", exp.Denials[0].Bunch.Name)
+
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: parent,
+			Active: share.Boolean{IsSet: true, Bool: true}}))
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: grandparent, Active: share.Boolean{IsSet: true}}))
+
+		has, err = f.Permissions().HasKey(ctx, userID, scope+"_e")
+		require.Nil(t, err)
+		require.False(t, has)
+
+		exp, err = f.Permissions().Explain(ctx, userID, scope+"_e")
+		require.Nil(t, err)
+		require.Len(t, exp.Denials, 1)
+		require.Equal(t, scope+"_grandparent", exp.Denials[0].Bunch.Name)
+
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: grandparent,
+			Active: share.Boolean{IsSet: true, Bool: true}}))
+
+		has, err = f.Permissions().HasKey(ctx, userID, scope+"_e")
+		require.Nil(t, err)
+		require.True(t, has)
+	})
+}
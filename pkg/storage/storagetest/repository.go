@@ -0,0 +1,162 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunRepository tests the transactions of a storage.Repository
+func RunRepository(t *testing.T, f Factories) {
+	f.needs(t, "Repository")
+	ctx := context.Background()
+
+	t.Run("success_commit_a_user_with_bunches", func(t *testing.T) {
+		scope := names.scope()
+		bunchIDs := []int64{insertBunch(t, f, scope+"_a"), insertBunch(t, f, scope+"_b")}
+
+		err := f.Repository().WithTx(ctx, func(tx storage.Stores) error {
+			userID, err := tx.Users().Insert(ctx, storage.CreateUser{
+				FullName: "full name",
+				Username: scope + "_user",
+				Email:    scope + "@test.com",
+				Hash:     "hash",
+				Salt:     "salt",
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, bunchID := range bunchIDs {
+				if _, err := tx.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: bunchID}); err != nil {
+					return err
+				}
+			}
+
+			return nil
+		})
+		require.Nil(t, err)
+
+		_, total, err := f.Repository().UserBunches().Query(ctx, storage.QueryUserBunch{Username: scope},
+			storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+	})
+
+	t.Run("success_read_own_writes", func(t *testing.T) {
+		name := names.scope() + "_bunch"
+
+		err := f.Repository().WithTx(ctx, func(tx storage.Stores) error {
+			id, err := tx.Bunches().Insert(ctx, storage.CreateBunch{Name: name, Desc: "desc"})
+			if err != nil {
+				return err
+			}
+
+			bunch, err := tx.Bunches().Get(ctx, id)
+			if err != nil {
+				return err
+			}
+			require.Equal(t, name, bunch.Name)
+
+			return nil
+		})
+		require.Nil(t, err)
+	})
+
+	t.Run("success_rollback_on_error", func(t *testing.T) {
+		username := names.scope() + "_user"
+
+		err := f.Repository().WithTx(ctx, func(tx storage.Stores) error {
+			userID, err := tx.Users().Insert(ctx, storage.CreateUser{
+				FullName: "full name",
+				Username: username,
+				Email:    username + "@test.com",
+				Hash:     "hash",
+				Salt:     "salt",
+			})
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: 1 << 40})
+			return err
+		})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+
+		_, err = f.Repository().Users().GetByName(ctx, username)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_rollback_on_panic", func(t *testing.T) {
+		name := names.scope() + "_bunch"
+
+		require.Panics(t, func() {
+			f.Repository().WithTx(ctx, func(tx storage.Stores) error {
+				_, err := tx.Bunches().Insert(ctx, storage.CreateBunch{Name: name, Desc: "desc"})
+				require.Nil(t, err)
+				panic("boom")
+			})
+		})
+
+		_, err := f.Repository().Bunches().GetByName(ctx, name)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+	t.Run("success_serialize_transactions_locking_a_user", func(t *testing.T) {
+		username := names.scope() + "_user"
+		userID, err := f.Repository().Users().Insert(ctx, storage.CreateUser{
+			FullName: "full name",
+			Username: username,
+			Email:    username + "@test.com",
+			Hash:     "hash",
+			Salt:     "salt",
+		})
+		require.Nil(t, err)
+
+		// every transaction records an attempt only when it sees none, which only one may when they are serialized
+		var wg sync.WaitGroup
+		errs := make(chan error, 5)
+		for i := 0; i < cap(errs); i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errs <- f.Repository().WithTx(ctx, func(tx storage.Stores) error {
+					if err := tx.Users().Lock(ctx, userID); err != nil {
+						return err
+					}
+
+					_, total, err := tx.LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: userID},
+						storage.SortLoginAttempt{})
+					if err != nil || total > 0 {
+						return err
+					}
+
+					_, err = tx.LoginAttempts().Insert(ctx, storage.CreateLoginAttempt{UserID: userID,
+						Username: username, Outcome: storage.LoginFailed})
+					return err
+				})
+			}()
+		}
+		wg.Wait()
+		close(errs)
+
+		for err := range errs {
+			require.Nil(t, err)
+		}
+
+		_, total, err := f.Repository().LoginAttempts().Query(ctx, storage.QueryLoginAttempt{UserID: userID},
+			storage.SortLoginAttempt{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+	})
+
+	t.Run("fail_lock_a_missing_user", func(t *testing.T) {
+		err := f.Repository().WithTx(ctx, func(tx storage.Stores) error {
+			return tx.Users().Lock(ctx, 1<<40)
+		})
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+}
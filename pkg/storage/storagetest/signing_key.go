@@ -0,0 +1,136 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunSigningKeyStorer tests a storage.SigningKeyStorer
+func RunSigningKeyStorer(t *testing.T, f Factories) {
+	f.needs(t, "SigningKeys")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_get_a_signing_key", func(t *testing.T) {
+		activatedAt := time.Now().Add(time.Hour).Truncate(time.Second)
+		public := []byte{0x30, 0x00, 0xff, 0x01}
+		private := []byte{0x00, 0x9c, 0xfe, 0x00, 0x42}
+
+		kid, err := f.SigningKeys().Insert(ctx, storage.CreateSigningKey{
+			Algorithm:   "EdDSA",
+			PublicKey:   public,
+			PrivateKey:  private,
+			ActivatedAt: activatedAt,
+		})
+		require.Nil(t, err)
+		require.Len(t, kid, 36)
+
+		k, err := f.SigningKeys().Get(ctx, kid)
+		require.Nil(t, err)
+		require.Equal(t, kid, k.KID)
+		require.Equal(t, "EdDSA", k.Algorithm)
+		require.Equal(t, public, k.PublicKey)
+		require.Equal(t, private, k.PrivateKey)
+		require.True(t, activatedAt.Equal(k.ActivatedAt))
+		require.False(t, k.CreatedAt.IsZero())
+		require.True(t, k.ExpiredAt.IsZero())
+	})
+
+	t.Run("fail_insert_a_duplicated_kid", func(t *testing.T) {
+		kid := insertSigningKey(t, f, names.scope(), time.Now())
+
+		_, err := f.SigningKeys().Insert(ctx, storage.CreateSigningKey{KID: kid, Algorithm: "EdDSA",
+			PublicKey: []byte{1}, PrivateKey: []byte{1}, ActivatedAt: time.Now()})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+	})
+
+	t.Run("fail_get_a_missing_signing_key", func(t *testing.T) {
+		_, err := f.SigningKeys().Get(ctx, share.NewUID())
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_expire_a_signing_key_once", func(t *testing.T) {
+		kid := insertSigningKey(t, f, names.scope(), time.Now())
+		expiredAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		require.Nil(t, f.SigningKeys().Expire(ctx, kid, expiredAt))
+		require.Nil(t, f.SigningKeys().Expire(ctx, kid, expiredAt.Add(time.Hour)))
+
+		k, err := f.SigningKeys().Get(ctx, kid)
+		require.Nil(t, err)
+		require.True(t, expiredAt.Equal(k.ExpiredAt))
+
+		require.Nil(t, f.SigningKeys().Expire(ctx, share.NewUID(), expiredAt))
+	})
+
+	t.Run("success_query_unexpired_signing_keys", func(t *testing.T) {
+		algorithm := names.scope()
+		now := time.Now().Truncate(time.Second)
+
+		older := insertSigningKey(t, f, algorithm, now.Add(-2*time.Hour))
+		expired := insertSigningKey(t, f, algorithm, now.Add(-time.Hour))
+		newer := insertSigningKey(t, f, algorithm, now)
+		require.Nil(t, f.SigningKeys().Expire(ctx, older, now.Add(time.Minute)))
+		require.Nil(t, f.SigningKeys().Expire(ctx, expired, now.Add(-time.Minute)))
+
+		rows, total, err := f.SigningKeys().Query(ctx, storage.QuerySigningKey{Algorithm: algorithm, ExpiredAfter: now},
+			storage.SortSigningKey{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []string{newer, older}, signingKeyIDs(rows))
+
+		rows, total, err = f.SigningKeys().Query(ctx, storage.QuerySigningKey{Algorithm: algorithm, Limit: 2},
+			storage.SortSigningKey{ActivatedAt: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []string{older, expired}, signingKeyIDs(rows))
+	})
+
+	t.Run("success_purge_expired_signing_keys", func(t *testing.T) {
+		algorithm := names.scope()
+		now := time.Now().Truncate(time.Second)
+
+		expired := insertSigningKey(t, f, algorithm, now.Add(-2*time.Hour))
+		later := insertSigningKey(t, f, algorithm, now.Add(-time.Hour))
+		current := insertSigningKey(t, f, algorithm, now)
+		require.Nil(t, f.SigningKeys().Expire(ctx, expired, now.Add(-time.Minute)))
+		require.Nil(t, f.SigningKeys().Expire(ctx, later, now.Add(time.Hour)))
+
+		purged, err := f.SigningKeys().Purge(ctx, now)
+		require.Nil(t, err)
+		require.True(t, purged >= 1)
+
+		rows, _, err := f.SigningKeys().Query(ctx, storage.QuerySigningKey{Algorithm: algorithm},
+			storage.SortSigningKey{})
+		require.Nil(t, err)
+		require.Equal(t, []string{current, later}, signingKeyIDs(rows))
+	})
+}
+
+func insertSigningKey(t *testing.T, f Factories, algorithm string, activatedAt time.Time) string {
+	t.Helper()
+
+	kid, err := f.SigningKeys().Insert(context.Background(), storage.CreateSigningKey{
+		Algorithm:   algorithm,
+		PublicKey:   []byte("public"),
+		PrivateKey:  []byte("private"),
+		ActivatedAt: activatedAt,
+	})
+	require.Nil(t, err)
+
+	return kid
+}
+
+func signingKeyIDs(rows []*storage.SigningKey) []string {
+	kids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		kids = append(kids, row.KID)
+	}
+
+	return kids
+}
@@ -0,0 +1,128 @@
+// Package storagetest is a behavioural test suite for storage backends. A backend proves it behaves
+// like the others by running the suite from one of its tests:
+//
+//	func TestConformance(t *testing.T) {
+//		storagetest.Run(t, storagetest.Factories{
+//			Keys:    func() storage.KeyStorer { return NewKeyMysqlStorer(db) },
+//			Bunches: func() storage.BunchStorer { return NewBunchMysqlStorer(db) },
+//			...
+//		})
+//	}
+//
+// Storers returned by the factories must share the same database. The database may hold other rows,
+// the suite only looks at the rows it creates, but it must not be written concurrently by other tests
+// while the suite runs, so the suite's test should not call t.Parallel.
+package storagetest
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// Factories creates the storers under test, suites whose storers are missing are skipped
+type Factories struct {
+	Keys           func() storage.KeyStorer
+	Bunches        func() storage.BunchStorer
+	BunchKeys      func() storage.BunchKeyStorer
+	BunchParents   func() storage.BunchParentStorer
+	Users          func() storage.UserStorer
+	UserBunches    func() storage.UserBunchStorer
+	TokenHistories func() storage.TokenHistoryStorer
+	SigningKeys    func() storage.SigningKeyStorer
+	LoginAttempts  func() storage.LoginAttemptStorer
+	TOTPs          func() storage.TOTPStorer
+	RecoveryCodes  func() storage.RecoveryCodeStorer
+	UserTokens     func() storage.UserTokenStorer
+	Permissions    func() storage.PermissionResolver
+	Repository     func() storage.Repository
+}
+
+// Run runs every suite
+func Run(t *testing.T, f Factories) {
+	t.Run("KeyStorer", func(t *testing.T) { RunKeyStorer(t, f) })
+	t.Run("BunchStorer", func(t *testing.T) { RunBunchStorer(t, f) })
+	t.Run("BunchKeyStorer", func(t *testing.T) { RunBunchKeyStorer(t, f) })
+	t.Run("BunchParentStorer", func(t *testing.T) { RunBunchParentStorer(t, f) })
+	t.Run("UserStorer", func(t *testing.T) { RunUserStorer(t, f) })
+	t.Run("UserBunchStorer", func(t *testing.T) { RunUserBunchStorer(t, f) })
+	t.Run("TokenHistoryStorer", func(t *testing.T) { RunTokenHistoryStorer(t, f) })
+	t.Run("SigningKeyStorer", func(t *testing.T) { RunSigningKeyStorer(t, f) })
+	t.Run("LoginAttemptStorer", func(t *testing.T) { RunLoginAttemptStorer(t, f) })
+	t.Run("TOTPStorer", func(t *testing.T) { RunTOTPStorer(t, f) })
+	t.Run("RecoveryCodeStorer", func(t *testing.T) { RunRecoveryCodeStorer(t, f) })
+	t.Run("UserTokenStorer", func(t *testing.T) { RunUserTokenStorer(t, f) })
+	t.Run("PermissionResolver", func(t *testing.T) { RunPermissionResolver(t, f) })
+	t.Run("Repository", func(t *testing.T) { RunRepository(t, f) })
+}
+
+// needs skips the test when one of the needed factories is missing
+func (f Factories) needs(t *testing.T, names ...string) {
+	t.Helper()
+
+	available := map[string]bool{
+		"Keys":           f.Keys != nil,
+		"Bunches":        f.Bunches != nil,
+		"BunchKeys":      f.BunchKeys != nil,
+		"BunchParents":   f.BunchParents != nil,
+		"Users":          f.Users != nil,
+		"UserBunches":    f.UserBunches != nil,
+		"TokenHistories": f.TokenHistories != nil,
+		"SigningKeys":    f.SigningKeys != nil,
+		"LoginAttempts":  f.LoginAttempts != nil,
+		"TOTPs":          f.TOTPs != nil,
+		"RecoveryCodes":  f.RecoveryCodes != nil,
+		"UserTokens":     f.UserTokens != nil,
+		"Permissions":    f.Permissions != nil,
+		"Repository":     f.Repository != nil,
+	}
+
+	for _, name := range names {
+		if !available[name] {
+			t.Skipf("storagetest: no %s factory", name)
+		}
+	}
+}
+
+// unique creates names which are not used by any other run of the suite, so the suite can filter its
+// own rows out of a database shared with other tests
+type unique struct {
+	prefix string
+	order  int
+	mux    sync.Mutex
+}
+
+func newUnique() *unique {
+	b := make([]byte, 3)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+
+	return &unique{prefix: fmt.Sprintf("%x", b)}
+}
+
+// number returns a new number which is far from any auto increment id
+func (u *unique) number() int64 {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.order++
+
+	var prefix int64
+	fmt.Sscanf(u.prefix, "%x", &prefix)
+
+	return 1<<40 + prefix<<16 + int64(u.order)
+}
+
+// scope returns a new prefix shared by the rows of one test, it matches none of the other scopes
+func (u *unique) scope() string {
+	u.mux.Lock()
+	defer u.mux.Unlock()
+	u.order++
+
+	return fmt.Sprintf("s%s%04d", u.prefix, u.order)
+}
+
+var names = newUnique()
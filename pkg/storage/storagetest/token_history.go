@@ -0,0 +1,327 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunTokenHistoryStorer tests a storage.TokenHistoryStorer
+func RunTokenHistoryStorer(t *testing.T, f Factories) {
+	f.needs(t, "TokenHistories")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_get_a_token", func(t *testing.T) {
+		userID := uniqueUserID()
+		expiredAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		uid, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{
+			UserID:        userID,
+			AccessToken:   "access",
+			RefreshToken:  "refresh",
+			RemoteAddr:    "127.0.0.1",
+			XForwardedFor: "10.0.0.1",
+			XRealIP:       "10.0.0.2",
+			UserAgent:     "agent",
+			ExpiredAt:     expiredAt,
+		})
+		require.Nil(t, err)
+		require.Len(t, uid, 36)
+
+		th, err := f.TokenHistories().Get(ctx, uid)
+		require.Nil(t, err)
+		require.Equal(t, uid, th.UID)
+		require.Equal(t, userID, th.UserID)
+		require.Equal(t, "access", th.AccessToken)
+		require.Equal(t, "refresh", th.RefreshToken)
+		require.Equal(t, "127.0.0.1", th.RemoteAddr)
+		require.Equal(t, "10.0.0.1", th.XForwardedFor)
+		require.Equal(t, "10.0.0.2", th.XRealIP)
+		require.Equal(t, "agent", th.UserAgent)
+		require.True(t, expiredAt.Equal(th.ExpiredAt))
+		require.False(t, th.CreatedAt.IsZero())
+		require.True(t, th.RevokedAt.IsZero())
+	})
+
+	t.Run("success_insert_a_token_with_uid", func(t *testing.T) {
+		uid := share.NewUID()
+
+		inserted, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UID: uid, UserID: uniqueUserID(),
+			AccessToken: "access", ExpiredAt: time.Now().Add(time.Hour)})
+		require.Nil(t, err)
+		require.Equal(t, uid, inserted)
+
+		_, err = f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UID: uid, UserID: uniqueUserID(),
+			AccessToken: "access", ExpiredAt: time.Now().Add(time.Hour)})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "token_history", dup.Entity)
+		require.Equal(t, "uid", dup.Field)
+	})
+
+	t.Run("fail_get_a_missing_token", func(t *testing.T) {
+		_, err := f.TokenHistories().Get(ctx, share.NewUID())
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_revoke_a_token_once", func(t *testing.T) {
+		uid := insertToken(t, f, uniqueUserID(), time.Now().Add(time.Hour))
+
+		require.Nil(t, f.TokenHistories().Revoke(ctx, uid))
+
+		th, err := f.TokenHistories().Get(ctx, uid)
+		require.Nil(t, err)
+		require.False(t, th.RevokedAt.IsZero())
+
+		time.Sleep(1100 * time.Millisecond)
+		require.Nil(t, f.TokenHistories().Revoke(ctx, uid))
+
+		again, err := f.TokenHistories().Get(ctx, uid)
+		require.Nil(t, err)
+		require.True(t, th.RevokedAt.Equal(again.RevokedAt))
+
+		require.Nil(t, f.TokenHistories().Revoke(ctx, share.NewUID()))
+	})
+
+	t.Run("success_query_tokens", func(t *testing.T) {
+		userID := uniqueUserID()
+		now := time.Now()
+		active := insertToken(t, f, userID, now.Add(2*time.Hour))
+		expired := insertToken(t, f, userID, now.Add(-time.Hour))
+		revoked := insertToken(t, f, userID, now.Add(time.Hour))
+		require.Nil(t, f.TokenHistories().Revoke(ctx, revoked))
+		insertToken(t, f, uniqueUserID(), now.Add(time.Hour))
+
+		sorts := storage.SortTokenHistory{ExpiredAt: share.Ascendant}
+
+		rows, total, err := f.TokenHistories().Query(ctx, storage.QueryTokenHistory{UserID: userID}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []string{expired, revoked, active}, tokenUIDs(rows))
+
+		rows, total, err = f.TokenHistories().Query(ctx, storage.QueryTokenHistory{UserID: userID, Limit: 1, Offset: 1},
+			storage.SortTokenHistory{ExpiredAt: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []string{revoked}, tokenUIDs(rows))
+
+		rows, total, err = f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			Active: share.Boolean{IsSet: true, Bool: true},
+		}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []string{active}, tokenUIDs(rows))
+
+		rows, total, err = f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			Active: share.Boolean{IsSet: true},
+		}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []string{expired, revoked}, tokenUIDs(rows))
+
+		rows, total, err = f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			From:   now.Add(time.Hour),
+		}, sorts)
+		require.Nil(t, err)
+		require.Zero(t, total)
+		require.Empty(t, rows)
+	})
+
+	t.Run("success_insert_a_token_family", func(t *testing.T) {
+		userID := uniqueUserID()
+		now := time.Now().Truncate(time.Second)
+
+		root, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UserID: userID, AccessToken: "access",
+			RefreshToken: "refresh", ExpiredAt: now.Add(time.Minute), RefreshExpiredAt: now.Add(time.Hour),
+			FamilyExpiredAt: now.Add(24 * time.Hour)})
+		require.Nil(t, err)
+
+		th, err := f.TokenHistories().Get(ctx, root)
+		require.Nil(t, err)
+		require.Equal(t, root, th.FamilyID)
+		require.True(t, now.Add(time.Hour).Equal(th.RefreshExpiredAt))
+		require.True(t, now.Add(24*time.Hour).Equal(th.FamilyExpiredAt))
+		require.True(t, th.RotatedAt.IsZero())
+
+		child, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UserID: userID, AccessToken: "access",
+			FamilyID: root, ExpiredAt: now.Add(time.Minute)})
+		require.Nil(t, err)
+		insertToken(t, f, userID, now.Add(time.Minute))
+
+		rows, total, err := f.TokenHistories().Query(ctx, storage.QueryTokenHistory{FamilyID: root},
+			storage.SortTokenHistory{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.ElementsMatch(t, []string{root, child}, tokenUIDs(rows))
+
+		th, err = f.TokenHistories().Get(ctx, child)
+		require.Nil(t, err)
+		require.True(t, th.RefreshExpiredAt.IsZero())
+		require.True(t, th.FamilyExpiredAt.IsZero())
+	})
+
+	t.Run("success_rotate_a_token_once", func(t *testing.T) {
+		uid := insertToken(t, f, uniqueUserID(), time.Now().Add(time.Hour))
+
+		rotated, err := f.TokenHistories().Rotate(ctx, uid)
+		require.Nil(t, err)
+		require.True(t, rotated)
+
+		th, err := f.TokenHistories().Get(ctx, uid)
+		require.Nil(t, err)
+		require.False(t, th.RotatedAt.IsZero())
+
+		rotated, err = f.TokenHistories().Rotate(ctx, uid)
+		require.Nil(t, err)
+		require.False(t, rotated)
+
+		rotated, err = f.TokenHistories().Rotate(ctx, share.NewUID())
+		require.Nil(t, err)
+		require.False(t, rotated)
+	})
+
+	t.Run("success_revoke_a_token_family", func(t *testing.T) {
+		userID := uniqueUserID()
+		expiredAt := time.Now().Add(time.Hour)
+
+		root := insertToken(t, f, userID, expiredAt)
+		child, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UserID: userID, AccessToken: "access",
+			FamilyID: root, ExpiredAt: expiredAt})
+		require.Nil(t, err)
+		require.Nil(t, f.TokenHistories().Revoke(ctx, child))
+		other := insertToken(t, f, userID, expiredAt)
+
+		revoked, err := f.TokenHistories().RevokeFamily(ctx, root)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), revoked)
+
+		rows, total, err := f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			Active: share.Boolean{IsSet: true, Bool: true},
+		}, storage.SortTokenHistory{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []string{other}, tokenUIDs(rows))
+	})
+
+	t.Run("success_query_live_tokens", func(t *testing.T) {
+		userID := uniqueUserID()
+		now := time.Now()
+
+		access := insertToken(t, f, userID, now.Add(time.Hour))
+		refreshable, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UserID: userID,
+			AccessToken: "access", ExpiredAt: now.Add(-time.Hour), RefreshExpiredAt: now.Add(time.Hour)})
+		require.Nil(t, err)
+		rotated, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UserID: userID,
+			AccessToken: "access", ExpiredAt: now.Add(-time.Hour), RefreshExpiredAt: now.Add(time.Hour)})
+		require.Nil(t, err)
+		_, err = f.TokenHistories().Rotate(ctx, rotated)
+		require.Nil(t, err)
+		revoked := insertToken(t, f, userID, now.Add(time.Hour))
+		require.Nil(t, f.TokenHistories().Revoke(ctx, revoked))
+		expired := insertToken(t, f, userID, now.Add(-time.Hour))
+
+		sorts := storage.SortTokenHistory{}
+
+		rows, total, err := f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			Live:   share.Boolean{IsSet: true, Bool: true},
+		}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.ElementsMatch(t, []string{access, refreshable}, tokenUIDs(rows))
+
+		rows, total, err = f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			Live:   share.Boolean{IsSet: true},
+		}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.ElementsMatch(t, []string{rotated, revoked, expired}, tokenUIDs(rows))
+	})
+
+	t.Run("success_revoke_the_tokens_of_a_user", func(t *testing.T) {
+		userID := uniqueUserID()
+		expiredAt := time.Now().Add(time.Hour)
+
+		insertToken(t, f, userID, expiredAt)
+		revoked := insertToken(t, f, userID, expiredAt)
+		require.Nil(t, f.TokenHistories().Revoke(ctx, revoked))
+		insertToken(t, f, userID, expiredAt)
+		other := insertToken(t, f, uniqueUserID(), expiredAt)
+
+		n, err := f.TokenHistories().RevokeUser(ctx, userID)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), n)
+
+		_, total, err := f.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: userID,
+			Active: share.Boolean{IsSet: true, Bool: true},
+		}, storage.SortTokenHistory{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+
+		th, err := f.TokenHistories().Get(ctx, other)
+		require.Nil(t, err)
+		require.True(t, th.RevokedAt.IsZero())
+	})
+
+	t.Run("success_purge_expired_tokens", func(t *testing.T) {
+		userID := uniqueUserID()
+		base := time.Date(2001, 1, 1, 0, 0, 0, 0, time.Local)
+		insertToken(t, f, userID, base)
+		insertToken(t, f, userID, base.Add(24*time.Hour))
+		kept := insertToken(t, f, userID, base.Add(30*24*time.Hour))
+
+		refreshable, err := f.TokenHistories().Insert(ctx, storage.CreateTokenHistory{UserID: userID,
+			AccessToken: "access", ExpiredAt: base, RefreshExpiredAt: base.Add(7 * 24 * time.Hour)})
+		require.Nil(t, err)
+
+		purged, err := f.TokenHistories().Purge(ctx, base.Add(24*time.Hour))
+		require.Nil(t, err)
+		require.Equal(t, int64(2), purged)
+
+		rows, total, err := f.TokenHistories().Query(ctx, storage.QueryTokenHistory{UserID: userID},
+			storage.SortTokenHistory{ExpiredAt: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []string{kept, refreshable}, tokenUIDs(rows))
+	})
+}
+
+// uniqueUserID returns a user id which no other token belongs to, token histories have no foreign key on users
+func uniqueUserID() int64 {
+	return names.number()
+}
+
+func insertToken(t *testing.T, f Factories, userID int64, expiredAt time.Time) string {
+	t.Helper()
+
+	uid, err := f.TokenHistories().Insert(context.Background(), storage.CreateTokenHistory{
+		UserID:      userID,
+		AccessToken: "access",
+		ExpiredAt:   expiredAt,
+	})
+	require.Nil(t, err)
+
+	return uid
+}
+
+func tokenUIDs(rows []*storage.TokenHistory) []string {
+	uids := make([]string, 0, len(rows))
+	for _, row := range rows {
+		uids = append(uids, row.UID)
+	}
+
+	return uids
+}
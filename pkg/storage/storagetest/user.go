@@ -0,0 +1,371 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunUserStorer tests a storage.UserStorer
+func RunUserStorer(t *testing.T, f Factories) {
+	f.needs(t, "Users")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_get_an_active_user", func(t *testing.T) {
+		scope := names.scope()
+
+		id, err := f.Users().Insert(ctx, storage.CreateUser{
+			FullName: scope + " full name",
+			Username: scope + "_user",
+			Email:    scope + "@test.com",
+			Hash:     "hash",
+			Salt:     "salt",
+		})
+		require.Nil(t, err)
+		require.NotZero(t, id)
+
+		user, err := f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+		require.Equal(t, scope+" full name", user.FullName)
+		require.Equal(t, scope+"_user", user.Username)
+		require.Equal(t, scope+"@test.com", user.Email)
+		require.Equal(t, "hash", user.Hash)
+		require.Equal(t, "salt", user.Salt)
+		require.Equal(t, share.Boolean{IsSet: true, Bool: true}, user.Active)
+		require.False(t, user.UpdatedAt.IsZero())
+
+		user, err = f.Users().GetByName(ctx, scope+"_user")
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+
+		user, err = f.Users().GetByEmail(ctx, scope+"@test.com")
+		require.Nil(t, err)
+		require.Equal(t, id, user.ID)
+	})
+
+	t.Run("fail_get_a_missing_user", func(t *testing.T) {
+		scope := names.scope()
+
+		_, err := f.Users().Get(ctx, 1<<40)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+
+		_, err = f.Users().GetByName(ctx, scope)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+
+		_, err = f.Users().GetByEmail(ctx, scope+"@test.com")
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("fail_insert_a_duplicated_username", func(t *testing.T) {
+		scope := names.scope()
+		insertUser(t, f, scope+"_user")
+
+		id, err := f.Users().Insert(ctx, storage.CreateUser{Username: scope + "_user", Email: scope + "_other@test.com"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		require.Zero(t, id)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "user", dup.Entity)
+		require.Equal(t, "username", dup.Field)
+	})
+
+	t.Run("fail_insert_a_duplicated_email", func(t *testing.T) {
+		scope := names.scope()
+		insertUser(t, f, scope+"_user")
+
+		_, err := f.Users().Insert(ctx, storage.CreateUser{Username: scope + "_other", Email: scope + "_user@test.com"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "user", dup.Entity)
+		require.Equal(t, "email", dup.Field)
+	})
+
+	t.Run("success_update_a_user", func(t *testing.T) {
+		scope := names.scope()
+		id := insertUser(t, f, scope+"_user")
+
+		err := f.Users().Update(ctx, storage.UpdateUser{
+			ID:       id,
+			FullName: "new full name",
+			Username: scope + "_new",
+			Email:    scope + "_new@test.com",
+			Hash:     "new hash",
+			Salt:     "new salt",
+			Active:   share.Boolean{IsSet: true, Bool: false},
+		})
+		require.Nil(t, err)
+
+		user, err := f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, "new full name", user.FullName)
+		require.Equal(t, scope+"_new", user.Username)
+		require.Equal(t, scope+"_new@test.com", user.Email)
+		require.Equal(t, "new hash", user.Hash)
+		require.Equal(t, "new salt", user.Salt)
+		require.Equal(t, share.Boolean{IsSet: true, Bool: false}, user.Active)
+
+		err = f.Users().Update(ctx, storage.UpdateUser{ID: id, Hash: "newer hash"})
+		require.Nil(t, err)
+
+		user, err = f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, "newer hash", user.Hash)
+		require.Equal(t, scope+"_new", user.Username)
+		require.False(t, user.Active.Bool)
+	})
+
+	t.Run("success_deactivate_a_user_revoking_its_tokens", func(t *testing.T) {
+		f.needs(t, "TokenHistories")
+		id := insertUser(t, f, names.scope()+"_user")
+
+		uids := []string{
+			insertToken(t, f, id, time.Now().Add(time.Hour)),
+			insertToken(t, f, id, time.Now().Add(time.Hour)),
+		}
+
+		require.Nil(t, f.TokenHistories().Revoke(ctx, uids[0]))
+		before, err := f.TokenHistories().Get(ctx, uids[0])
+		require.Nil(t, err)
+		time.Sleep(1100 * time.Millisecond)
+
+		// updates leaving the user active keep its tokens
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: id, Active: share.Boolean{IsSet: true, Bool: true}}))
+		th, err := f.TokenHistories().Get(ctx, uids[1])
+		require.Nil(t, err)
+		require.True(t, th.RevokedAt.IsZero())
+
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: id, Active: share.Boolean{IsSet: true}}))
+		th, err = f.TokenHistories().Get(ctx, uids[1])
+		require.Nil(t, err)
+		require.False(t, th.RevokedAt.IsZero())
+
+		// tokens revoked before keep their revocation time
+		th, err = f.TokenHistories().Get(ctx, uids[0])
+		require.Nil(t, err)
+		require.True(t, before.RevokedAt.Equal(th.RevokedAt))
+	})
+
+	t.Run("success_change_a_password_invalidating_reset_tokens", func(t *testing.T) {
+		f.needs(t, "UserTokens")
+		id := insertUser(t, f, names.scope()+"_user")
+		hash := names.scope()
+		insertUserToken(t, f, id, hash, time.Now().Add(time.Hour))
+
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: id, FullName: "Renamed"}))
+		ut, err := f.UserTokens().GetByHash(ctx, hash)
+		require.Nil(t, err)
+		require.True(t, ut.UsedAt.IsZero())
+
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: id, Hash: "new_hash", Salt: "new_salt"}))
+		ut, err = f.UserTokens().GetByHash(ctx, hash)
+		require.Nil(t, err)
+		require.False(t, ut.UsedAt.IsZero())
+	})
+
+	t.Run("fail_update_a_user_to_a_duplicated_email", func(t *testing.T) {
+		scope := names.scope()
+		insertUser(t, f, scope+"_a")
+		id := insertUser(t, f, scope+"_b")
+
+		err := f.Users().Update(ctx, storage.UpdateUser{ID: id, Email: scope + "_a@test.com"})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+
+		user, err := f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_b@test.com", user.Email)
+	})
+
+	t.Run("success_query_users", func(t *testing.T) {
+		scope := names.scope()
+		idC := insertUser(t, f, scope+"_c")
+		idA := insertUser(t, f, scope+"_a")
+		idB := insertUser(t, f, scope+"_b")
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: idC, FullName: scope + " Special"}))
+
+		rows, total, err := f.Users().Query(ctx, storage.QueryUser{Username: scope}, storage.SortUser{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idB, idA, idC}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{Username: scope}, storage.SortUser{Username: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idA, idB, idC}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{Email: scope, Limit: 2},
+			storage.SortUser{Email: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idC, idB}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{FullName: scope + " special"}, storage.SortUser{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idC}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{Email: scope + "_a@"}, storage.SortUser{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idA}, userIDs(rows))
+	})
+
+	t.Run("success_query_users_by_active", func(t *testing.T) {
+		scope := names.scope()
+		idA := insertUser(t, f, scope+"_a")
+		idB := insertUser(t, f, scope+"_b")
+		idC := insertUser(t, f, scope+"_c")
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: idA, Active: share.Boolean{IsSet: true}}))
+
+		sorts := storage.SortUser{Username: share.Ascendant}
+
+		rows, total, err := f.Users().Query(ctx, storage.QueryUser{Username: scope}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idA, idB, idC}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{Username: scope, Active: share.Boolean{IsSet: true, Bool: true}}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idB, idC}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{Username: scope, Active: share.Boolean{IsSet: true}}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idA}, userIDs(rows))
+
+		rows, total, err = f.Users().Query(ctx, storage.QueryUser{Username: scope}, storage.SortUser{Active: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, idA, rows[2].ID)
+	})
+	t.Run("success_verify_an_email", func(t *testing.T) {
+		scope := names.scope()
+		idA := insertUser(t, f, scope+"_a")
+		idB := insertUser(t, f, scope+"_b")
+
+		user, err := f.Users().Get(ctx, idA)
+		require.Nil(t, err)
+		require.True(t, user.EmailVerifiedAt.IsZero())
+		require.Empty(t, user.PendingEmail)
+
+		verified, err := f.Users().VerifyEmail(ctx, idA, scope+"_b@test.com")
+		require.Nil(t, err)
+		require.False(t, verified)
+
+		verified, err = f.Users().VerifyEmail(ctx, idA, scope+"_a@test.com")
+		require.Nil(t, err)
+		require.True(t, verified)
+
+		user, err = f.Users().Get(ctx, idA)
+		require.Nil(t, err)
+		require.False(t, user.EmailVerifiedAt.IsZero())
+
+		sorts := storage.SortUser{Username: share.Ascendant}
+		rows, _, err := f.Users().Query(ctx, storage.QueryUser{Username: scope, Verified: share.Boolean{IsSet: true, Bool: true}}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, []int64{idA}, userIDs(rows))
+
+		rows, _, err = f.Users().Query(ctx, storage.QueryUser{Username: scope, Verified: share.Boolean{IsSet: true}}, sorts)
+		require.Nil(t, err)
+		require.Equal(t, []int64{idB}, userIDs(rows))
+
+		// setting the same email keeps its verification, a new one loses it
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: idA, Email: scope + "_a@test.com"}))
+		user, err = f.Users().Get(ctx, idA)
+		require.Nil(t, err)
+		require.False(t, user.EmailVerifiedAt.IsZero())
+
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: idA, Email: scope + "_c@test.com"}))
+		user, err = f.Users().Get(ctx, idA)
+		require.Nil(t, err)
+		require.True(t, user.EmailVerifiedAt.IsZero())
+	})
+
+	t.Run("success_verify_a_pending_email", func(t *testing.T) {
+		f.needs(t, "UserTokens")
+		scope := names.scope()
+		id := insertUser(t, f, scope+"_a")
+		hash := names.scope()
+		insertUserToken(t, f, id, hash, time.Now().Add(time.Hour))
+		tokenID, err := f.UserTokens().Insert(ctx, storage.CreateUserToken{UserID: id,
+			Purpose: storage.PurposeEmailVerification, Hash: names.scope(), ExpiredAt: time.Now().Add(time.Hour)})
+		require.Nil(t, err)
+
+		require.Nil(t, f.Users().SetPendingEmail(ctx, id, scope+"_new@test.com"))
+
+		user, err := f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_a@test.com", user.Email)
+		require.Equal(t, scope+"_new@test.com", user.PendingEmail)
+
+		// the verification tokens of the previous email are void, the reset tokens are kept
+		used, err := f.UserTokens().Use(ctx, tokenID)
+		require.Nil(t, err)
+		require.False(t, used)
+		ut, err := f.UserTokens().GetByHash(ctx, hash)
+		require.Nil(t, err)
+		require.True(t, ut.UsedAt.IsZero())
+
+		verified, err := f.Users().VerifyEmail(ctx, id, scope+"_new@test.com")
+		require.Nil(t, err)
+		require.True(t, verified)
+
+		user, err = f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_new@test.com", user.Email)
+		require.Empty(t, user.PendingEmail)
+		require.False(t, user.EmailVerifiedAt.IsZero())
+
+		_, err = f.Users().GetByEmail(ctx, scope+"_a@test.com")
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("fail_verify_a_pending_email_taken_since", func(t *testing.T) {
+		scope := names.scope()
+		id := insertUser(t, f, scope+"_a")
+
+		require.Nil(t, f.Users().SetPendingEmail(ctx, id, scope+"_b@test.com"))
+		insertUser(t, f, scope+"_b")
+
+		_, err := f.Users().VerifyEmail(ctx, id, scope+"_b@test.com")
+		require.True(t, errors.Is(err, storage.ErrDuplicate), "%v", err)
+
+		user, err := f.Users().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, scope+"_a@test.com", user.Email)
+	})
+}
+
+// insertUser inserts an active user whose email is the username at test.com
+func insertUser(t *testing.T, f Factories, username string) int64 {
+	t.Helper()
+
+	id, err := f.Users().Insert(context.Background(), storage.CreateUser{
+		FullName: username + " full name",
+		Username: username,
+		Email:    username + "@test.com",
+		Hash:     "hash",
+		Salt:     "salt",
+	})
+	require.Nil(t, err)
+
+	return id
+}
+
+func userIDs(rows []*storage.User) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.ID)
+	}
+
+	return ids
+}
@@ -0,0 +1,218 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunUserBunchStorer tests a storage.UserBunchStorer
+func RunUserBunchStorer(t *testing.T, f Factories) {
+	f.needs(t, "Users", "Bunches", "UserBunches")
+	ctx := context.Background()
+
+	t.Run("success_insert_a_user_bunch", func(t *testing.T) {
+		scope := names.scope()
+		userID := insertUser(t, f, scope+"_user")
+		bunchID := insertBunch(t, f, scope+"_bunch")
+
+		bunch, err := f.Bunches().Get(ctx, bunchID)
+		require.Nil(t, err)
+
+		id, err := f.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: bunchID})
+		require.Nil(t, err)
+		require.NotZero(t, id)
+
+		rows, total, err := f.UserBunches().Query(ctx, storage.QueryUserBunch{Username: scope}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, id, rows[0].UserBunch.ID)
+		require.Equal(t, userID, rows[0].UserBunch.UserID)
+		require.Equal(t, bunchID, rows[0].UserBunch.BunchID)
+		require.Equal(t, scope+"_user", rows[0].User.Username)
+		require.Equal(t, scope+"_bunch", rows[0].Bunch.Name)
+		require.True(t, bunch.UpdatedAt.Equal(rows[0].Bunch.UpdatedAt))
+		require.False(t, rows[0].UserBunch.UpdatedAt.IsZero())
+	})
+
+	t.Run("fail_insert_a_duplicated_user_bunch", func(t *testing.T) {
+		scope := names.scope()
+		userID := insertUser(t, f, scope+"_user")
+		bunchID := insertBunch(t, f, scope+"_bunch")
+		insertUserBunch(t, f, userID, bunchID)
+
+		id, err := f.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: bunchID})
+		require.True(t, errors.Is(err, storage.ErrDuplicate))
+		require.Zero(t, id)
+
+		var dup *storage.DuplicateError
+		require.True(t, errors.As(err, &dup))
+		require.Equal(t, "user_bunch", dup.Entity)
+		require.Equal(t, "bunch_id", dup.Field)
+	})
+
+	t.Run("fail_insert_a_user_bunch_of_a_missing_bunch", func(t *testing.T) {
+		userID := insertUser(t, f, names.scope()+"_user")
+
+		id, err := f.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: 1 << 40})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+		require.Zero(t, id)
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "user_bunch", fk.Entity)
+		require.Equal(t, "bunch_id", fk.Field)
+	})
+
+	t.Run("fail_insert_a_user_bunch_of_a_missing_user", func(t *testing.T) {
+		bunchID := insertBunch(t, f, names.scope()+"_bunch")
+
+		_, err := f.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: 1 << 40, BunchID: bunchID})
+		require.True(t, errors.Is(err, storage.ErrForeignKey))
+
+		var fk *storage.ForeignKeyError
+		require.True(t, errors.As(err, &fk))
+		require.Equal(t, "user_bunch", fk.Entity)
+		require.Equal(t, "user_id", fk.Field)
+	})
+
+	t.Run("success_get_a_user_bunch", func(t *testing.T) {
+		scope := names.scope()
+		userID, bunchID := insertUser(t, f, scope+"_user"), insertBunch(t, f, scope+"_bunch")
+		insertUserBunch(t, f, userID, insertBunch(t, f, scope+"_other"))
+		id := insertUserBunch(t, f, userID, bunchID)
+
+		ub, err := f.UserBunches().Get(ctx, id)
+		require.Nil(t, err)
+		require.Equal(t, id, ub.UserBunch.ID)
+		require.Equal(t, userID, ub.UserBunch.UserID)
+		require.Equal(t, bunchID, ub.UserBunch.BunchID)
+		require.Equal(t, scope+"_user", ub.User.Username)
+		require.Equal(t, scope+"_bunch", ub.Bunch.Name)
+		require.False(t, ub.UserBunch.UpdatedAt.IsZero())
+
+		require.Nil(t, f.UserBunches().Delete(ctx, id))
+		_, err = f.UserBunches().Get(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_query_the_bunches_of_a_user", func(t *testing.T) {
+		scope := names.scope()
+		userID := insertUser(t, f, scope+"_ann")
+		// the username of the other user contains the first one
+		otherID := insertUser(t, f, scope+"_anne")
+		bunchIDs := []int64{insertBunch(t, f, scope+"_a"), insertBunch(t, f, scope+"_b")}
+		insertUserBunch(t, f, userID, bunchIDs[0])
+		insertUserBunch(t, f, otherID, bunchIDs[0])
+		insertUserBunch(t, f, otherID, bunchIDs[1])
+
+		rows, total, err := f.UserBunches().Query(ctx, storage.QueryUserBunch{UserID: userID}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, userID, rows[0].User.ID)
+		require.Equal(t, bunchIDs[0], rows[0].Bunch.ID)
+
+		_, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{UserID: otherID}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+	})
+
+	t.Run("success_delete_a_user_bunch", func(t *testing.T) {
+		scope := names.scope()
+		id := insertUserBunch(t, f, insertUser(t, f, scope+"_user"), insertBunch(t, f, scope+"_bunch"))
+
+		require.Nil(t, f.UserBunches().Delete(ctx, id))
+
+		_, total, err := f.UserBunches().Query(ctx, storage.QueryUserBunch{Username: scope}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Zero(t, total)
+
+		err = f.UserBunches().Delete(ctx, id)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_query_user_bunches", func(t *testing.T) {
+		scope := names.scope()
+		userA := insertUser(t, f, scope+"_a")
+		userB := insertUser(t, f, scope+"_b")
+		bunchX := insertBunch(t, f, scope+"_x")
+		bunchY := insertBunch(t, f, scope+"_y")
+		idAY := insertUserBunch(t, f, userA, bunchY)
+		idAX := insertUserBunch(t, f, userA, bunchX)
+		idBX := insertUserBunch(t, f, userB, bunchX)
+		require.Nil(t, f.Users().Update(ctx, storage.UpdateUser{ID: userB, Active: share.Boolean{IsSet: true}}))
+		require.Nil(t, f.Bunches().Update(ctx, storage.UpdateBunch{ID: bunchY, Active: share.Boolean{IsSet: true}}))
+
+		rows, total, err := f.UserBunches().Query(ctx, storage.QueryUserBunch{Username: scope}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idBX, idAX, idAY}, userBunchIDs(rows))
+
+		rows, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{Username: scope + "_a"},
+			storage.SortUserBunch{BunchName: share.Ascendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idAX, idAY}, userBunchIDs(rows))
+
+		rows, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{BunchName: scope + "_x"},
+			storage.SortUserBunch{Username: share.Descendant})
+		require.Nil(t, err)
+		require.Equal(t, int64(2), total)
+		require.Equal(t, []int64{idBX, idAX}, userBunchIDs(rows))
+
+		rows, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{Username: scope, Limit: 1, Offset: 2},
+			storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(3), total)
+		require.Equal(t, []int64{idAY}, userBunchIDs(rows))
+
+		rows, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{
+			Username:   scope,
+			UserActive: share.Boolean{IsSet: true},
+		}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idBX}, userBunchIDs(rows))
+		require.False(t, rows[0].User.Active.Bool)
+
+		rows, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{
+			Username:    scope,
+			BunchActive: share.Boolean{IsSet: true},
+		}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idAY}, userBunchIDs(rows))
+		require.False(t, rows[0].Bunch.Active.Bool)
+
+		rows, total, err = f.UserBunches().Query(ctx, storage.QueryUserBunch{
+			Username:    scope,
+			UserActive:  share.Boolean{IsSet: true, Bool: true},
+			BunchActive: share.Boolean{IsSet: true, Bool: true},
+		}, storage.SortUserBunch{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, []int64{idAX}, userBunchIDs(rows))
+	})
+}
+
+func insertUserBunch(t *testing.T, f Factories, userID int64, bunchID int64) int64 {
+	t.Helper()
+
+	id, err := f.UserBunches().Insert(context.Background(), storage.CreateUserBunch{UserID: userID, BunchID: bunchID})
+	require.Nil(t, err)
+
+	return id
+}
+
+func userBunchIDs(rows []*storage.AggregateUserBunch) []int64 {
+	ids := make([]int64, 0, len(rows))
+	for _, row := range rows {
+		ids = append(ids, row.UserBunch.ID)
+	}
+
+	return ids
+}
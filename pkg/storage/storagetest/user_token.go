@@ -0,0 +1,104 @@
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// RunUserTokenStorer tests a storage.UserTokenStorer
+func RunUserTokenStorer(t *testing.T, f Factories) {
+	f.needs(t, "UserTokens")
+	ctx := context.Background()
+
+	t.Run("success_insert_and_use_a_token", func(t *testing.T) {
+		userID := uniqueUserID()
+		hash := names.scope()
+		expiredAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+		id := insertUserToken(t, f, userID, hash, expiredAt)
+
+		ut, err := f.UserTokens().GetByHash(ctx, hash)
+		require.Nil(t, err)
+		require.Equal(t, id, ut.ID)
+		require.Equal(t, userID, ut.UserID)
+		require.Equal(t, storage.PurposePasswordReset, ut.Purpose)
+		require.True(t, expiredAt.Equal(ut.ExpiredAt))
+		require.False(t, ut.CreatedAt.IsZero())
+		require.True(t, ut.UsedAt.IsZero())
+
+		used, err := f.UserTokens().Use(ctx, id)
+		require.Nil(t, err)
+		require.True(t, used)
+
+		used, err = f.UserTokens().Use(ctx, id)
+		require.Nil(t, err)
+		require.False(t, used)
+
+		ut, err = f.UserTokens().GetByHash(ctx, hash)
+		require.Nil(t, err)
+		require.False(t, ut.UsedAt.IsZero())
+
+		_, err = f.UserTokens().GetByHash(ctx, names.scope())
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_invalidate_the_tokens_of_a_user", func(t *testing.T) {
+		userID := uniqueUserID()
+		hashes := []string{names.scope(), names.scope(), names.scope()}
+		expiredAt := time.Now().Add(time.Hour)
+
+		used := insertUserToken(t, f, userID, hashes[0], expiredAt)
+		_, err := f.UserTokens().Use(ctx, used)
+		require.Nil(t, err)
+		insertUserToken(t, f, userID, hashes[1], expiredAt)
+		other := insertUserToken(t, f, uniqueUserID(), hashes[2], expiredAt)
+
+		n, err := f.UserTokens().Invalidate(ctx, userID, "email_verification")
+		require.Nil(t, err)
+		require.Zero(t, n)
+
+		n, err = f.UserTokens().Invalidate(ctx, userID, storage.PurposePasswordReset)
+		require.Nil(t, err)
+		require.Equal(t, int64(1), n)
+
+		ut, err := f.UserTokens().GetByHash(ctx, hashes[1])
+		require.Nil(t, err)
+		require.False(t, ut.UsedAt.IsZero())
+
+		ok, err := f.UserTokens().Use(ctx, other)
+		require.Nil(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("success_purge_expired_tokens", func(t *testing.T) {
+		userID := uniqueUserID()
+		expired, live := names.scope(), names.scope()
+
+		insertUserToken(t, f, userID, expired, time.Now().Add(-2*time.Hour))
+		insertUserToken(t, f, userID, live, time.Now().Add(time.Hour))
+
+		n, err := f.UserTokens().Purge(ctx, time.Now().Add(-time.Hour))
+		require.Nil(t, err)
+		require.True(t, n >= 1)
+
+		_, err = f.UserTokens().GetByHash(ctx, expired)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+		_, err = f.UserTokens().GetByHash(ctx, live)
+		require.Nil(t, err)
+	})
+}
+
+func insertUserToken(t *testing.T, f Factories, userID int64, hash string, expiredAt time.Time) int64 {
+	t.Helper()
+
+	id, err := f.UserTokens().Insert(context.Background(), storage.CreateUserToken{UserID: userID,
+		Purpose: storage.PurposePasswordReset, Hash: hash, ExpiredAt: expiredAt})
+	require.Nil(t, err)
+
+	return id
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+)
+
+//TokenHistory model. ExpiredAt is the expiry of the access token and RefreshExpiredAt the one of the
+//refresh token, which is zero when the token has none. Tokens refreshed from one login share a FamilyID,
+//the uid of the family's first token, and the FamilyExpiredAt no refresh can go beyond. RotatedAt is set
+//once the refresh token was exchanged for a new token.
+type TokenHistory struct {
+	UID              string
+	UserID           int64
+	AccessToken      string
+	RefreshToken     string
+	RemoteAddr       string
+	XForwardedFor    string
+	XRealIP          string
+	UserAgent        string
+	FamilyID         string
+	CreatedAt        time.Time
+	ExpiredAt        time.Time
+	RefreshExpiredAt time.Time
+	FamilyExpiredAt  time.Time
+	RotatedAt        time.Time
+	RevokedAt        time.Time
+}
+
+//CreateTokenHistory model, a new uid will be generated when UID is empty and the token starts its own
+//family when FamilyID is empty
+type CreateTokenHistory struct {
+	UID              string
+	UserID           int64
+	AccessToken      string
+	RefreshToken     string
+	RemoteAddr       string
+	XForwardedFor    string
+	XRealIP          string
+	UserAgent        string
+	FamilyID         string
+	ExpiredAt        time.Time
+	RefreshExpiredAt time.Time
+	FamilyExpiredAt  time.Time
+}
+
+//QueryTokenHistory model, Active filters tokens which are neither revoked nor expired. Live filters the tokens
+//a signed in session still holds: not revoked, with an unexpired access token or an unexchanged and unexpired
+//refresh token.
+type QueryTokenHistory struct {
+	Limit    int64
+	Offset   int64
+	UserID   int64
+	FamilyID string
+	Active   share.Boolean
+	Live     share.Boolean
+	From     time.Time
+	To       time.Time
+}
+
+//SortTokenHistory model
+type SortTokenHistory struct {
+	CreatedAt share.Direction
+	ExpiredAt share.Direction
+}
+
+//TokenHistoryStorer defines fundamental functions to interact with storage repository. Rotate marks the
+//refresh token of a token as exchanged and tells whether this call did it, so only one of concurrent
+//refreshes wins. RevokeFamily revokes every token of a family and RevokeUser every token of a user, both
+//return how many were revoked. Purge deletes the tokens whose access and refresh tokens both expired at or
+//before the given time.
+type TokenHistoryStorer interface {
+	Insert(ctx context.Context, t CreateTokenHistory) (string, error)
+	Get(ctx context.Context, uid string) (*TokenHistory, error)
+	Query(ctx context.Context, queries QueryTokenHistory, sorts SortTokenHistory) ([]*TokenHistory, int64, error)
+	Revoke(ctx context.Context, uid string) error
+	Rotate(ctx context.Context, uid string) (bool, error)
+	RevokeFamily(ctx context.Context, familyID string) (int64, error)
+	RevokeUser(ctx context.Context, userID int64) (int64, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
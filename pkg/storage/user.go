@@ -1,21 +1,25 @@
 package storage
 
 import (
+	"context"
 	"time"
 
 	"github.com/vespaiach/auth_service/pkg/share"
 )
 
-//User model
+//User model. EmailVerifiedAt is zero until Email is verified, PendingEmail is a new address waiting for its
+//verification to replace Email.
 type User struct {
-	ID        int64
-	FullName  string
-	Username  string
-	Email     string
-	Hash      string
-	Salt      string
-	Active    share.Boolean
-	UpdatedAt time.Time
+	ID              int64
+	FullName        string
+	Username        string
+	Email           string
+	Hash            string
+	Salt            string
+	Active          share.Boolean
+	UpdatedAt       time.Time
+	EmailVerifiedAt time.Time
+	PendingEmail    string
 }
 
 //CreateUser model
@@ -46,6 +50,7 @@ type QueryUser struct {
 	Username string
 	Email    string
 	Active   share.Boolean
+	Verified share.Boolean
 	From     time.Time
 	To       time.Time
 }
@@ -80,10 +85,12 @@ type CreateUserBunch struct {
 	BunchID int64
 }
 
-//QueryUserBunch model
+//QueryUserBunch model, Username and BunchName match substrings while UserID matches the bunches of one user
 type QueryUserBunch struct {
 	Limit       int64
 	Offset      int64
+	ID          int64
+	UserID      int64
 	Username    string
 	BunchName   string
 	UserActive  share.Boolean
@@ -96,19 +103,29 @@ type SortUserBunch struct {
 	BunchName share.Direction
 }
 
-//UserStorer defines fundamental functions to interact with storage repository
+//UserStorer defines fundamental functions to interact with storage repository. Update revokes the tokens of a
+//user it deactivates and invalidates the password reset tokens of a user whose hash it changes. An Email set by
+//Update replaces the pending one and is unverified unless it is the verified Email already.
+//SetPendingEmail sets the pending email of a user, an empty one clears it. Both invalidate the email verification
+//tokens of the user. VerifyEmail marks email verified when it is the Email or the PendingEmail of a user,
+//which it replaces Email with, and tells whether it was either. Lock locks a user until the transaction of
+//Repository.WithTx it runs in ends, so the callers locking the same user run one after the other.
 type UserStorer interface {
-	Insert(u CreateUser) (*User, error)
-	Update(u UpdateUser) (*User, error)
-	Get(id int64) (*User, error)
-	GetByName(username string) (*User, error)
-	GetByEmail(email string) (*User, error)
-	Query(queries QueryUser, sorts SortUser) ([]*User, int64, error)
+	Insert(ctx context.Context, u CreateUser) (int64, error)
+	Update(ctx context.Context, u UpdateUser) error
+	SetPendingEmail(ctx context.Context, id int64, email string) error
+	VerifyEmail(ctx context.Context, id int64, email string) (bool, error)
+	Lock(ctx context.Context, id int64) error
+	Get(ctx context.Context, id int64) (*User, error)
+	GetByName(ctx context.Context, username string) (*User, error)
+	GetByEmail(ctx context.Context, email string) (*User, error)
+	Query(ctx context.Context, queries QueryUser, sorts SortUser) ([]*User, int64, error)
 }
 
 //UserBunchStorer defines fundamental functions to interact with storage repository
 type UserBunchStorer interface {
-	Insert(ub CreateUserBunch) (*UserBunch, error)
-	Delete(id int64) error
-	Query(queries QueryUserBunch, sorts SortUserBunch) ([]*AggregateUserBunch, int64, error)
+	Insert(ctx context.Context, ub CreateUserBunch) (int64, error)
+	Delete(ctx context.Context, id int64) error
+	Get(ctx context.Context, id int64) (*AggregateUserBunch, error)
+	Query(ctx context.Context, queries QueryUserBunch, sorts SortUserBunch) ([]*AggregateUserBunch, int64, error)
 }
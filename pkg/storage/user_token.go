@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"context"
+	"time"
+)
+
+//purposes of user tokens
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	PurposeMFAEnrollment     = "mfa_enrollment"
+)
+
+//UserToken model, a single-use token mailed to a user for a purpose. Hash is the digest of the token, which is
+//never stored. A token is valid until ExpiredAt unless UsedAt is set.
+type UserToken struct {
+	ID        int64
+	UserID    int64
+	Purpose   string
+	Hash      string
+	CreatedAt time.Time
+	ExpiredAt time.Time
+	UsedAt    time.Time
+}
+
+//CreateUserToken model
+type CreateUserToken struct {
+	UserID    int64
+	Purpose   string
+	Hash      string
+	ExpiredAt time.Time
+}
+
+//UserTokenStorer defines fundamental functions to interact with storage repository. Use marks an unused token
+//as used and tells whether it was unused. Invalidate marks the unused tokens of a user for a purpose as used
+//and returns how many were. Purge deletes the tokens which expired at or before the given time.
+type UserTokenStorer interface {
+	Insert(ctx context.Context, t CreateUserToken) (int64, error)
+	GetByHash(ctx context.Context, hash string) (*UserToken, error)
+	Use(ctx context.Context, id int64) (bool, error)
+	Invalidate(ctx context.Context, userID int64, purpose string) (int64, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
@@ -0,0 +1,163 @@
+package token
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// token types, named as the token_type_hint values of RFC 7009
+const (
+	TypeAccessToken  = "access_token"
+	TypeRefreshToken = "refresh_token"
+)
+
+// Introspection describes a token as told by RFC 7662, SessionID is the family of the token. Only Active is set
+// for tokens which are not active.
+type Introspection struct {
+	Active    bool
+	TokenType string
+	UID       string
+	SessionID string
+	UserID    int64
+	Username  string
+	Bunches   []string
+	Keys      []string
+	Issuer    string
+	Audience  []string
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+}
+
+// Revoke revokes an access token or a refresh token. Revoking a refresh token revokes its whole family, the
+// access tokens included. As RFC 7009 asks, unknown and invalid tokens are ignored, only storage failures
+// are returned.
+func (i *Issuer) Revoke(ctx context.Context, token string) error {
+	if isAccessToken(token) {
+		claims, err := i.parse(ctx, token)
+		if errors.Is(err, ErrInvalidToken) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		th, err := i.history(ctx, claims.ID, token, false)
+		if th == nil {
+			return err
+		}
+
+		return i.repo.TokenHistories().Revoke(ctx, th.UID)
+	}
+
+	th, err := i.history(ctx, strings.SplitN(token, ".", 2)[0], token, true)
+	if th == nil {
+		return err
+	}
+
+	_, err = i.repo.TokenHistories().RevokeFamily(ctx, th.FamilyID)
+	return err
+}
+
+// Introspect tells whether an access token or a refresh token is active and what it grants. An access token
+// is active when it verifies and is not revoked, a refresh token when it was not exchanged, revoked or
+// expired and its user is active. Only storage failures are returned.
+func (i *Issuer) Introspect(ctx context.Context, token string) (*Introspection, error) {
+	inactive := &Introspection{}
+
+	if isAccessToken(token) {
+		claims, err := i.Verify(ctx, token)
+		if errors.Is(err, ErrInvalidToken) {
+			return inactive, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		th, err := i.history(ctx, claims.ID, token, false)
+		if th == nil || !th.RevokedAt.IsZero() {
+			return inactive, err
+		}
+
+		userID, err := claims.UserID()
+		if err != nil {
+			return inactive, nil
+		}
+
+		return &Introspection{
+			Active:    true,
+			TokenType: TypeAccessToken,
+			UID:       claims.ID,
+			SessionID: th.FamilyID,
+			UserID:    userID,
+			Username:  claims.Username,
+			Bunches:   claims.Bunches,
+			Keys:      claims.Keys,
+			Issuer:    claims.Issuer,
+			Audience:  claims.Audience,
+			IssuedAt:  claims.IssuedAt.Time,
+			ExpiresAt: claims.ExpiresAt.Time,
+		}, nil
+	}
+
+	th, err := i.history(ctx, strings.SplitN(token, ".", 2)[0], token, true)
+	if th == nil || !th.RevokedAt.IsZero() || !th.RotatedAt.IsZero() || !i.now().Before(th.RefreshExpiredAt) {
+		return inactive, err
+	}
+
+	user, err := i.repo.Users().Get(ctx, th.UserID)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && !user.Active.Bool) {
+		return inactive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Introspection{
+		Active:    true,
+		TokenType: TypeRefreshToken,
+		UID:       th.UID,
+		SessionID: th.FamilyID,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Issuer:    i.config.Issuer,
+		Audience:  i.config.Audience,
+		IssuedAt:  th.CreatedAt,
+		ExpiresAt: th.RefreshExpiredAt,
+	}, nil
+}
+
+// history returns the token_histories row of uid when it records token, nil otherwise
+func (i *Issuer) history(ctx context.Context, uid string, token string, refresh bool) (*storage.TokenHistory, error) {
+	th, err := i.repo.TokenHistories().Get(ctx, uid)
+	if errors.Is(err, storage.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	digest := th.AccessToken
+	if refresh {
+		digest = th.RefreshToken
+	}
+	if !matches(token, digest) {
+		return nil, nil
+	}
+
+	return th, nil
+}
+
+// isAccessToken tells a JWT, made of three parts, from a refresh token made of two
+func isAccessToken(token string) bool {
+	return strings.Count(token, ".") == 2
+}
+
+// matches compares the digest of token with a recorded digest in constant time
+func matches(token string, digest string) bool {
+	return len(digest) > 0 && subtle.ConstantTimeCompare([]byte(Digest(token)), []byte(digest)) == 1
+}
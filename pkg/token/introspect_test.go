@@ -0,0 +1,168 @@
+package token
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+func TestIssuer_Introspect(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newIssuer := func(t *testing.T) (*Issuer, storage.Repository, *storage.User, func(time.Duration)) {
+		repo, user := newTestRepo(t)
+		signer, err := NewHS256Signer(testSecret)
+		require.Nil(t, err)
+
+		issuer := NewIssuer(repo, signer, Config{TTL: time.Minute, Audience: []string{"api"}})
+		var advance func(time.Duration)
+		issuer.now, advance = newTestClock()
+
+		return issuer, repo, user, advance
+	}
+
+	t.Run("success_introspect_active_tokens", func(t *testing.T) {
+		issuer, _, user, _ := newIssuer(t)
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		access, err := issuer.Introspect(ctx, tok.AccessToken)
+		require.Nil(t, err)
+		require.True(t, access.Active)
+		require.Equal(t, TypeAccessToken, access.TokenType)
+		require.Equal(t, tok.Claims.ID, access.UID)
+		require.Equal(t, user.ID, access.UserID)
+		require.Equal(t, "alice", access.Username)
+		require.Equal(t, []string{"read"}, access.Keys)
+		require.Equal(t, []string{"api"}, access.Audience)
+		require.True(t, tok.ExpiresAt.Equal(access.ExpiresAt))
+
+		refresh, err := issuer.Introspect(ctx, tok.RefreshToken)
+		require.Nil(t, err)
+		require.True(t, refresh.Active)
+		require.Equal(t, TypeRefreshToken, refresh.TokenType)
+		require.Equal(t, user.ID, refresh.UserID)
+		require.True(t, tok.RefreshExpiresAt.Equal(refresh.ExpiresAt))
+	})
+
+	t.Run("success_introspect_inactive_tokens", func(t *testing.T) {
+		issuer, _, user, advance := newIssuer(t)
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		for _, token := range []string{"", "garbage", "a.b.c", share.NewUID() + ".secret", tok.AccessToken + "x",
+			tok.RefreshToken + "x"} {
+			got, err := issuer.Introspect(ctx, token)
+			require.Nil(t, err)
+			require.Equal(t, &Introspection{}, got)
+		}
+
+		next, err := issuer.Refresh(ctx, tok.RefreshToken, Client{})
+		require.Nil(t, err)
+
+		// the exchanged refresh token is inactive, the access token stays active until it expires
+		got, err := issuer.Introspect(ctx, tok.RefreshToken)
+		require.Nil(t, err)
+		require.False(t, got.Active)
+		got, err = issuer.Introspect(ctx, tok.AccessToken)
+		require.Nil(t, err)
+		require.True(t, got.Active)
+
+		advance(2 * time.Minute)
+		got, err = issuer.Introspect(ctx, next.AccessToken)
+		require.Nil(t, err)
+		require.False(t, got.Active)
+		got, err = issuer.Introspect(ctx, next.RefreshToken)
+		require.Nil(t, err)
+		require.True(t, got.Active)
+	})
+
+	t.Run("success_deactivating_a_user_revokes_its_tokens", func(t *testing.T) {
+		issuer, repo, user, _ := newIssuer(t)
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		require.Nil(t, repo.Users().Update(ctx, storage.UpdateUser{ID: user.ID, Active: share.Boolean{IsSet: true}}))
+
+		for _, token := range []string{tok.AccessToken, tok.RefreshToken} {
+			got, err := issuer.Introspect(ctx, token)
+			require.Nil(t, err)
+			require.False(t, got.Active)
+		}
+	})
+}
+
+func TestIssuer_Revoke(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newIssuer := func(t *testing.T) (*Issuer, storage.Repository, *storage.User) {
+		repo, user := newTestRepo(t)
+		signer, err := NewHS256Signer(testSecret)
+		require.Nil(t, err)
+
+		return NewIssuer(repo, signer, Config{}), repo, user
+	}
+
+	t.Run("success_revoke_an_access_token", func(t *testing.T) {
+		issuer, repo, user := newIssuer(t)
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		require.Nil(t, issuer.Revoke(ctx, tok.AccessToken))
+
+		th, err := repo.TokenHistories().Get(ctx, tok.Claims.ID)
+		require.Nil(t, err)
+		require.False(t, th.RevokedAt.IsZero())
+
+		got, err := issuer.Introspect(ctx, tok.AccessToken)
+		require.Nil(t, err)
+		require.False(t, got.Active)
+
+		_, err = issuer.Refresh(ctx, tok.RefreshToken, Client{})
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("success_revoke_a_refresh_token_family", func(t *testing.T) {
+		issuer, _, user := newIssuer(t)
+
+		first, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		second, err := issuer.Refresh(ctx, first.RefreshToken, Client{})
+		require.Nil(t, err)
+
+		// the exchanged refresh token still names the family
+		require.Nil(t, issuer.Revoke(ctx, first.RefreshToken))
+
+		for _, token := range []string{first.AccessToken, second.AccessToken, second.RefreshToken} {
+			got, err := issuer.Introspect(ctx, token)
+			require.Nil(t, err)
+			require.False(t, got.Active)
+		}
+	})
+
+	t.Run("success_ignore_unknown_tokens", func(t *testing.T) {
+		issuer, repo, user := newIssuer(t)
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		for _, token := range []string{"", "garbage", "a.b.c", share.NewUID() + ".secret", tok.AccessToken + "x",
+			tok.Claims.ID + ".secret"} {
+			require.Nil(t, issuer.Revoke(ctx, token))
+		}
+
+		th, err := repo.TokenHistories().Get(ctx, tok.Claims.ID)
+		require.Nil(t, err)
+		require.True(t, th.RevokedAt.IsZero())
+	})
+}
@@ -0,0 +1,56 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// JWK is a public key as told by RFC 7517, RSA keys have n and e and Ed25519 keys crv and x (RFC 8037)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+}
+
+// JWKS is a JSON Web Key Set, the document downstream services verify access tokens with
+type JWKS struct {
+	Keys []*JWK `json:"keys"`
+}
+
+// JWKS returns the published public keys of the issuer, none when tokens are signed with a shared secret
+func (i *Issuer) JWKS(ctx context.Context) (*JWKS, error) {
+	signers, err := i.keys.Published(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &JWKS{Keys: make([]*JWK, 0, len(signers))}
+	for _, s := range signers {
+		jwk := &JWK{Use: "sig", Algorithm: s.Algorithm(), KeyID: s.kid}
+
+		switch public := s.public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+
+		set.Keys = append(set.Keys, jwk)
+	}
+
+	return set, nil
+}
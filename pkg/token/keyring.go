@@ -0,0 +1,453 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// defaults of KeyRingConfig
+const (
+	DefaultRotationPeriod  = 30 * 24 * time.Hour
+	DefaultRotationOverlap = 24 * time.Hour
+)
+
+const (
+	// encryptionKeyLength is the size of the AES-256 key encrypting the private keys at rest
+	encryptionKeyLength = 32
+
+	// keyRingRefresh is how long a KeyRing uses the keys it loaded before loading them again
+	keyRingRefresh = time.Minute
+
+	// keyRingMissRefresh is the least time between two loads caused by an unknown kid, so forged kids cannot
+	// flood the database
+	keyRingMissRefresh = 5 * time.Second
+)
+
+// ErrNoSigningKey is returned when a KeyRing has no active key yet, Maintain generates the first one
+var ErrNoSigningKey = errors.New("token: no active signing key")
+
+// KeyRingConfig configures the keys generated by a KeyRing
+type KeyRingConfig struct {
+	// Algorithm of the generated keys, RS256 or EdDSA, EdDSA when empty
+	Algorithm string
+
+	// RotationPeriod is how long a key signs tokens, DefaultRotationPeriod when zero
+	RotationPeriod time.Duration
+
+	// Overlap is how long a key is published before it signs tokens and after it stopped signing,
+	// DefaultRotationOverlap when zero. It must be longer than the access tokens live and than verifiers
+	// cache the published keys.
+	Overlap time.Duration
+}
+
+// KeyRing is a KeySet of generated keys kept in signing_keys, their private keys encrypted with AES-256-GCM.
+// Maintain rotates the keys on schedule: the successor of the current key is generated an overlap before
+// the current key's period ends, so verifiers learn it before it signs, and the current key stays published
+// an overlap after its successor took over, until the tokens it signed expired.
+type KeyRing struct {
+	repo   storage.Repository
+	aead   cipher.AEAD
+	config KeyRingConfig
+	now    func() time.Time
+
+	mu       sync.Mutex
+	keys     []*ringKey
+	signers  map[string]*Signer
+	loadedAt time.Time
+}
+
+// ringKey is a loaded key, with its decrypted signer
+type ringKey struct {
+	signer      *Signer
+	activatedAt time.Time
+	expiredAt   time.Time
+}
+
+// NewKeyRing creates new instance of KeyRing, encryptionKey is the 32 bytes AES-256 key of the private keys
+func NewKeyRing(repo storage.Repository, encryptionKey []byte, config KeyRingConfig) (*KeyRing, error) {
+	if len(encryptionKey) != encryptionKeyLength {
+		return nil, ErrWeakKey
+	}
+
+	switch strings.ToUpper(config.Algorithm) {
+	case "", strings.ToUpper(EdDSA):
+		config.Algorithm = EdDSA
+	case RS256:
+		config.Algorithm = RS256
+	default:
+		return nil, fmt.Errorf("%w: %q, a key ring publishes RS256 or EdDSA keys", ErrUnknownAlgorithm, config.Algorithm)
+	}
+
+	if config.RotationPeriod <= 0 {
+		config.RotationPeriod = DefaultRotationPeriod
+	}
+	if config.Overlap <= 0 {
+		config.Overlap = DefaultRotationOverlap
+	}
+	if config.Overlap >= config.RotationPeriod {
+		return nil, errors.New("token: the rotation overlap must be shorter than the rotation period")
+	}
+
+	block, err := aes.NewCipher(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &KeyRing{
+		repo:    repo,
+		aead:    aead,
+		config:  config,
+		now:     time.Now,
+		signers: make(map[string]*Signer),
+	}, nil
+}
+
+// Current returns the signer of the most recently activated key, it implements KeySet
+func (r *KeyRing) Current(ctx context.Context) (*Signer, error) {
+	now := r.now()
+
+	for _, force := range []bool{false, true} {
+		keys, err := r.load(ctx, now, force)
+		if err != nil {
+			return nil, err
+		}
+
+		// keys are sorted by activation, latest first
+		for _, k := range keys {
+			if !k.activatedAt.After(now) {
+				return k.signer, nil
+			}
+		}
+	}
+
+	return nil, ErrNoSigningKey
+}
+
+// Lookup returns the signer of an unexpired key, it implements KeySet
+func (r *KeyRing) Lookup(ctx context.Context, kid string) (*Signer, error) {
+	if len(kid) == 0 {
+		return nil, ErrInvalidToken
+	}
+
+	now := r.now()
+
+	for _, force := range []bool{false, true} {
+		keys, err := r.load(ctx, now, force)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, k := range keys {
+			if k.signer.kid == kid && (k.expiredAt.IsZero() || k.expiredAt.After(now)) {
+				return k.signer, nil
+			}
+		}
+	}
+
+	return nil, ErrInvalidToken
+}
+
+// Published returns the signers of the unexpired keys, those waiting for their activation included. It
+// implements KeySet.
+func (r *KeyRing) Published(ctx context.Context) ([]*Signer, error) {
+	now := r.now()
+
+	keys, err := r.load(ctx, now, false)
+	if err != nil {
+		return nil, err
+	}
+
+	signers := make([]*Signer, 0, len(keys))
+	for _, k := range keys {
+		if k.expiredAt.IsZero() || k.expiredAt.After(now) {
+			signers = append(signers, k.signer)
+		}
+	}
+
+	return signers, nil
+}
+
+// Maintain generates the first key, or the successor of the current key when its period ends within the
+// overlap, and purges the expired keys. A key of another algorithm than the configured one is replaced at
+// once. Maintain is meant to run periodically, more often than the overlap, see Run.
+func (r *KeyRing) Maintain(ctx context.Context) error {
+	now := r.now().Truncate(time.Second)
+
+	err := r.repo.WithTx(ctx, func(tx storage.Stores) error {
+		keys, err := unexpiredKeys(ctx, tx, now)
+		if err != nil {
+			return err
+		}
+
+		activatedAt := now
+		if len(keys) > 0 && keys[0].Algorithm == r.config.Algorithm {
+			latest := keys[0]
+			if now.Before(latest.ActivatedAt.Add(r.config.RotationPeriod - r.config.Overlap)) {
+				return nil
+			}
+
+			activatedAt = latest.ActivatedAt.Add(r.config.RotationPeriod)
+			if earliest := now.Add(r.config.Overlap); activatedAt.Before(earliest) {
+				activatedAt = earliest
+			}
+		}
+
+		return r.rotate(ctx, tx, keys, activatedAt)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidate()
+
+	_, err = r.repo.SigningKeys().Purge(ctx, now)
+	return err
+}
+
+// Rotate replaces the current key by a new key signing from now on, the replaced keys stay published for
+// the overlap. It is meant for keys which must stop signing before their period ends, Maintain rotates
+// keys on schedule.
+func (r *KeyRing) Rotate(ctx context.Context) error {
+	now := r.now().Truncate(time.Second)
+
+	err := r.repo.WithTx(ctx, func(tx storage.Stores) error {
+		keys, err := unexpiredKeys(ctx, tx, now)
+		if err != nil {
+			return err
+		}
+
+		return r.rotate(ctx, tx, keys, now)
+	})
+	if err != nil {
+		return err
+	}
+
+	r.invalidate()
+
+	return nil
+}
+
+// Run calls Maintain every interval until ctx is done, failures are logged and retried at the next tick
+func (r *KeyRing) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.Maintain(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("token: maintain signing keys: %v", err)
+		}
+	}
+}
+
+// rotate stores a new key activated at activatedAt, the previous keys expire an overlap after it
+func (r *KeyRing) rotate(ctx context.Context, tx storage.Stores, previous []*storage.SigningKey, activatedAt time.Time) error {
+	key, err := r.generate(activatedAt)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.SigningKeys().Insert(ctx, key); err != nil {
+		return err
+	}
+
+	for _, k := range previous {
+		if err := tx.SigningKeys().Expire(ctx, k.KID, activatedAt.Add(r.config.Overlap)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// generate creates a key of the configured algorithm and encrypts its private key
+func (r *KeyRing) generate(activatedAt time.Time) (storage.CreateSigningKey, error) {
+	var (
+		key     storage.CreateSigningKey
+		private crypto.Signer
+	)
+
+	switch r.config.Algorithm {
+	case RS256:
+		rsaKey, err := rsa.GenerateKey(rand.Reader, minRSABits)
+		if err != nil {
+			return key, err
+		}
+		private = rsaKey
+
+	default:
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return key, err
+		}
+		private = edKey
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return key, err
+	}
+
+	public, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return key, err
+	}
+
+	key = storage.CreateSigningKey{
+		KID:         share.NewUID(),
+		Algorithm:   r.config.Algorithm,
+		PublicKey:   public,
+		ActivatedAt: activatedAt,
+	}
+
+	nonce := make([]byte, r.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return key, err
+	}
+	key.PrivateKey = r.aead.Seal(nonce, nonce, der, sealedData(key.KID, key.Algorithm))
+
+	return key, nil
+}
+
+// open decrypts the private key of a stored key
+func (r *KeyRing) open(k *storage.SigningKey) (*Signer, error) {
+	size := r.aead.NonceSize()
+	if len(k.PrivateKey) < size {
+		return nil, fmt.Errorf("token: signing key %s is not encrypted", k.KID)
+	}
+
+	der, err := r.aead.Open(nil, k.PrivateKey[:size], k.PrivateKey[size:], sealedData(k.KID, k.Algorithm))
+	if err != nil {
+		return nil, fmt.Errorf("token: decrypt signing key %s: %w", k.KID, err)
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, fmt.Errorf("token: parse signing key %s: %w", k.KID, err)
+	}
+
+	var signer *Signer
+	switch private := parsed.(type) {
+	case *rsa.PrivateKey:
+		signer, err = NewRS256Signer(private)
+	case ed25519.PrivateKey:
+		signer, err = NewEdDSASigner(private)
+	default:
+		err = ErrUnknownAlgorithm
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token: signing key %s: %w", k.KID, err)
+	}
+	if signer.Algorithm() != k.Algorithm {
+		return nil, fmt.Errorf("token: signing key %s: %w", k.KID, ErrUnknownAlgorithm)
+	}
+
+	signer.kid = k.KID
+
+	return signer, nil
+}
+
+// load returns the unexpired keys, loading them again when they are older than keyRingRefresh, or when
+// force is set and they are older than keyRingMissRefresh
+func (r *KeyRing) load(ctx context.Context, now time.Time, force bool) ([]*ringKey, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	age := now.Sub(r.loadedAt)
+	if r.keys != nil && (age < keyRingMissRefresh || (!force && age < keyRingRefresh)) {
+		return r.keys, nil
+	}
+
+	rows, err := unexpiredKeys(ctx, r.repo, now)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]*ringKey, 0, len(rows))
+	signers := make(map[string]*Signer, len(rows))
+	for _, row := range rows {
+		signer, ok := r.signers[row.KID]
+		if !ok {
+			if signer, err = r.open(row); err != nil {
+				return nil, err
+			}
+		}
+
+		signers[row.KID] = signer
+		keys = append(keys, &ringKey{signer: signer, activatedAt: row.ActivatedAt, expiredAt: row.ExpiredAt})
+	}
+
+	r.keys, r.signers, r.loadedAt = keys, signers, now
+
+	return keys, nil
+}
+
+// invalidate makes the next call load the keys
+func (r *KeyRing) invalidate() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.keys = nil
+}
+
+// unexpiredKeys returns the keys which have not expired at now, latest activation first
+func unexpiredKeys(ctx context.Context, stores storage.Stores, now time.Time) ([]*storage.SigningKey, error) {
+	keys := make([]*storage.SigningKey, 0)
+
+	query := storage.QuerySigningKey{ExpiredAfter: now, Limit: share.DefaultLimit}
+	for {
+		rows, total, err := stores.SigningKeys().Query(ctx, query, storage.SortSigningKey{ActivatedAt: share.Descendant})
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, rows...)
+
+		query.Offset += int64(len(rows))
+		if len(rows) == 0 || query.Offset >= total {
+			break
+		}
+	}
+
+	// a key rotated at once shares its activation with the key it replaced, which has an expiry by then
+	sort.SliceStable(keys, func(i, j int) bool {
+		if !keys[i].ActivatedAt.Equal(keys[j].ActivatedAt) {
+			return keys[i].ActivatedAt.After(keys[j].ActivatedAt)
+		}
+
+		return keys[i].ExpiredAt.IsZero() && !keys[j].ExpiredAt.IsZero()
+	})
+
+	return keys, nil
+}
+
+// sealedData binds an encrypted private key to its kid and algorithm, so rows cannot be swapped
+func sealedData(kid string, algorithm string) []byte {
+	return []byte(algorithm + " " + kid)
+}
@@ -0,0 +1,225 @@
+package token
+
+import (
+	"bytes"
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+var testEncryptionKey = bytes.Repeat([]byte{7}, encryptionKeyLength)
+
+func TestKeyRing(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newRing := func(t *testing.T, repo storage.Repository, config KeyRingConfig) (*KeyRing, func(time.Duration)) {
+		ring, err := NewKeyRing(repo, testEncryptionKey, config)
+		require.Nil(t, err)
+
+		var advance func(time.Duration)
+		ring.now, advance = newTestClock()
+
+		return ring, advance
+	}
+
+	kidOf := func(t *testing.T, signed string) string {
+		parsed, _, err := jwt.NewParser().ParseUnverified(signed, &Claims{})
+		require.Nil(t, err)
+
+		kid, _ := parsed.Header["kid"].(string)
+		return kid
+	}
+
+	t.Run("success_rotate_keys_on_schedule", func(t *testing.T) {
+		repo, user := newTestRepo(t)
+		ring, advance := newRing(t, repo, KeyRingConfig{RotationPeriod: 10 * 24 * time.Hour, Overlap: 24 * time.Hour})
+		issuer := NewIssuer(repo, ring, Config{TTL: 72 * time.Hour})
+		issuer.now = ring.now
+
+		_, err := ring.Current(ctx)
+		require.Equal(t, ErrNoSigningKey, err)
+
+		require.Nil(t, ring.Maintain(ctx))
+		first, err := ring.Current(ctx)
+		require.Nil(t, err)
+		require.Equal(t, EdDSA, first.Algorithm())
+		require.Len(t, first.KeyID(), 36)
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		require.Equal(t, first.KeyID(), kidOf(t, tok.AccessToken))
+
+		// the current key signs until its period ends within the overlap
+		advance(8 * 24 * time.Hour)
+		require.Nil(t, ring.Maintain(ctx))
+		published, err := ring.Published(ctx)
+		require.Nil(t, err)
+		require.Len(t, published, 1)
+
+		// its successor is then published before it signs
+		advance(24*time.Hour + time.Second)
+		require.Nil(t, ring.Maintain(ctx))
+		published, err = ring.Published(ctx)
+		require.Nil(t, err)
+		require.Len(t, published, 2)
+		late, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		require.Equal(t, first.KeyID(), kidOf(t, late.AccessToken))
+
+		advance(24 * time.Hour)
+		second, err := ring.Current(ctx)
+		require.Nil(t, err)
+		require.NotEqual(t, first.KeyID(), second.KeyID())
+
+		// the retired key verifies the tokens it signed for the overlap
+		_, err = issuer.Verify(ctx, late.AccessToken)
+		require.Nil(t, err)
+
+		advance(24 * time.Hour)
+		require.Nil(t, ring.Maintain(ctx))
+		published, err = ring.Published(ctx)
+		require.Nil(t, err)
+		require.Equal(t, []*Signer{second}, published)
+		_, err = issuer.Verify(ctx, late.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+
+		_, err = repo.SigningKeys().Get(ctx, first.KeyID())
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_rotate_a_key_at_once", func(t *testing.T) {
+		repo, user := newTestRepo(t)
+		ring, _ := newRing(t, repo, KeyRingConfig{Algorithm: RS256})
+		issuer := NewIssuer(repo, ring, Config{})
+		issuer.now = ring.now
+
+		require.Nil(t, ring.Maintain(ctx))
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		require.Nil(t, ring.Rotate(ctx))
+		current, err := ring.Current(ctx)
+		require.Nil(t, err)
+		require.Equal(t, RS256, current.Algorithm())
+		require.NotEqual(t, kidOf(t, tok.AccessToken), current.KeyID())
+
+		_, err = issuer.Verify(ctx, tok.AccessToken)
+		require.Nil(t, err)
+	})
+
+	t.Run("success_share_keys_between_rings", func(t *testing.T) {
+		repo, user := newTestRepo(t)
+		ring, _ := newRing(t, repo, KeyRingConfig{})
+		other, _ := newRing(t, repo, KeyRingConfig{})
+
+		require.Nil(t, ring.Maintain(ctx))
+		require.Nil(t, other.Maintain(ctx))
+		published, err := other.Published(ctx)
+		require.Nil(t, err)
+		require.Len(t, published, 1)
+
+		tok, err := NewIssuer(repo, ring, Config{}).Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		_, err = NewIssuer(repo, other, Config{}).Verify(ctx, tok.AccessToken)
+		require.Nil(t, err)
+	})
+
+	t.Run("success_encrypt_private_keys_at_rest", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+		ring, _ := newRing(t, repo, KeyRingConfig{})
+		require.Nil(t, ring.Maintain(ctx))
+
+		current, err := ring.Current(ctx)
+		require.Nil(t, err)
+		stored, err := repo.SigningKeys().Get(ctx, current.KeyID())
+		require.Nil(t, err)
+
+		_, err = x509.ParsePKCS8PrivateKey(stored.PrivateKey)
+		require.NotNil(t, err)
+		public, err := x509.ParsePKIXPublicKey(stored.PublicKey)
+		require.Nil(t, err)
+		require.Equal(t, current.public, public)
+
+		stolen, err := NewKeyRing(repo, bytes.Repeat([]byte{8}, encryptionKeyLength), KeyRingConfig{})
+		require.Nil(t, err)
+		_, err = stolen.Current(ctx)
+		require.NotNil(t, err)
+		require.Contains(t, err.Error(), "decrypt signing key")
+	})
+
+	t.Run("fail_create_an_unsafe_key_ring", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+
+		_, err := NewKeyRing(repo, []byte("short"), KeyRingConfig{})
+		require.Equal(t, ErrWeakKey, err)
+
+		_, err = NewKeyRing(repo, testEncryptionKey, KeyRingConfig{Algorithm: HS256})
+		require.True(t, errors.Is(err, ErrUnknownAlgorithm))
+
+		_, err = NewKeyRing(repo, testEncryptionKey, KeyRingConfig{RotationPeriod: time.Hour, Overlap: time.Hour})
+		require.NotNil(t, err)
+	})
+}
+
+func TestIssuer_JWKS(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	t.Run("success_publish_rsa_keys", func(t *testing.T) {
+		repo, user := newTestRepo(t)
+		ring, err := NewKeyRing(repo, testEncryptionKey, KeyRingConfig{Algorithm: RS256})
+		require.Nil(t, err)
+		require.Nil(t, ring.Maintain(ctx))
+
+		issuer := NewIssuer(repo, ring, Config{})
+		set, err := issuer.JWKS(ctx)
+		require.Nil(t, err)
+		require.Len(t, set.Keys, 1)
+
+		jwk := set.Keys[0]
+		require.Equal(t, "RSA", jwk.KeyType)
+		require.Equal(t, "sig", jwk.Use)
+		require.Equal(t, RS256, jwk.Algorithm)
+		require.Equal(t, "AQAB", jwk.E)
+
+		// the published key alone verifies the tokens
+		n, err := base64.RawURLEncoding.DecodeString(jwk.N)
+		require.Nil(t, err)
+		public := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: 65537}
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		parsed, err := jwt.Parse(tok.AccessToken, func(token *jwt.Token) (interface{}, error) {
+			require.Equal(t, jwk.KeyID, token.Header["kid"])
+			return public, nil
+		}, jwt.WithValidMethods([]string{RS256}))
+		require.Nil(t, err)
+		require.True(t, parsed.Valid)
+	})
+
+	t.Run("success_publish_static_keys_but_secrets", func(t *testing.T) {
+		repo, _ := newTestRepo(t)
+		signers := newTestSigners(t)
+
+		set, err := NewIssuer(repo, signers[EdDSA], Config{}).JWKS(ctx)
+		require.Nil(t, err)
+		require.Len(t, set.Keys, 1)
+		require.Equal(t, "OKP", set.Keys[0].KeyType)
+		require.Equal(t, "Ed25519", set.Keys[0].Curve)
+		require.Empty(t, set.Keys[0].KeyID)
+
+		set, err = NewIssuer(repo, signers[HS256], Config{}).JWKS(ctx)
+		require.Nil(t, err)
+		require.Empty(t, set.Keys)
+	})
+}
@@ -0,0 +1,103 @@
+package token
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// ErrTokenReused is returned when an exchanged refresh token is presented again, its family is revoked
+var ErrTokenReused = errors.New("token: refresh token reused")
+
+// refreshSecretLength is the number of random bytes of a refresh token
+const refreshSecretLength = 32
+
+// newRefreshToken returns a refresh token of the token uid, "<uid>.<secret>"
+func newRefreshToken(uid string) (string, error) {
+	secret := make([]byte, refreshSecretLength)
+	if _, err := rand.Read(secret); err != nil {
+		return "", err
+	}
+
+	return uid + "." + base64.RawURLEncoding.EncodeToString(secret), nil
+}
+
+// Refresh exchanges a refresh token for new tokens of the same family, with the bunches and keys the user holds
+// now. The refresh token cannot be used again, presenting it again revokes its family and fails with
+// ErrTokenReused. Other failures, such as an expired token or an inactive user, fail with ErrInvalidToken.
+func (i *Issuer) Refresh(ctx context.Context, refreshToken string, client Client) (*Token, error) {
+	uid := strings.SplitN(refreshToken, ".", 2)[0]
+
+	var (
+		tok    *Token
+		revoke string
+	)
+	err := i.repo.WithTx(ctx, func(tx storage.Stores) error {
+		revoke = ""
+
+		th, err := tx.TokenHistories().Get(ctx, uid)
+		if errors.Is(err, storage.ErrNotFound) {
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if !matches(refreshToken, th.RefreshToken) {
+			return ErrInvalidToken
+		}
+
+		if !th.RevokedAt.IsZero() {
+			return ErrInvalidToken
+		}
+
+		rotated, err := tx.TokenHistories().Rotate(ctx, uid)
+		if err != nil {
+			return err
+		}
+		if !rotated {
+			revoke = th.FamilyID
+			return ErrTokenReused
+		}
+
+		now := i.now().Truncate(time.Second)
+		if !now.Before(th.RefreshExpiredAt) {
+			return ErrInvalidToken
+		}
+
+		user, err := tx.Users().Get(ctx, th.UserID)
+		if errors.Is(err, storage.ErrNotFound) || (err == nil && !user.Active.Bool) {
+			revoke = th.FamilyID
+			return ErrInvalidToken
+		}
+		if err != nil {
+			return err
+		}
+
+		var history storage.CreateTokenHistory
+		tok, history, err = i.mint(ctx, tx, user, client, now, th.FamilyID, th.FamilyExpiredAt)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.TokenHistories().Insert(ctx, history)
+		return err
+	})
+
+	// the revocation must outlive the rolled back transaction
+	if len(revoke) > 0 {
+		if _, rerr := i.repo.TokenHistories().RevokeFamily(ctx, revoke); rerr != nil {
+			return nil, rerr
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
@@ -0,0 +1,149 @@
+package token
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// newTestClock returns a clock starting now and a function moving it forward
+func newTestClock() (func() time.Time, func(time.Duration)) {
+	now := time.Now()
+	return func() time.Time { return now }, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestIssuer_Refresh(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newIssuer := func(t *testing.T, config Config) (*Issuer, storage.Repository, *storage.User, func(time.Duration)) {
+		repo, user := newTestRepo(t)
+		signer, err := NewHS256Signer(testSecret)
+		require.Nil(t, err)
+
+		issuer := NewIssuer(repo, signer, config)
+		var advance func(time.Duration)
+		issuer.now, advance = newTestClock()
+
+		return issuer, repo, user, advance
+	}
+
+	t.Run("success_rotate_refresh_tokens", func(t *testing.T) {
+		issuer, repo, user, advance := newIssuer(t, Config{})
+
+		first, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		require.True(t, strings.HasPrefix(first.RefreshToken, first.Claims.ID+"."))
+
+		advance(time.Minute)
+		second, err := issuer.Refresh(ctx, first.RefreshToken, Client{UserAgent: "refresher"})
+		require.Nil(t, err)
+		require.NotEqual(t, first.RefreshToken, second.RefreshToken)
+		require.Equal(t, []string{"read"}, second.Claims.Keys)
+
+		old, err := repo.TokenHistories().Get(ctx, first.Claims.ID)
+		require.Nil(t, err)
+		require.False(t, old.RotatedAt.IsZero())
+
+		current, err := repo.TokenHistories().Get(ctx, second.Claims.ID)
+		require.Nil(t, err)
+		require.Equal(t, first.Claims.ID, current.FamilyID)
+		require.Equal(t, Digest(second.RefreshToken), current.RefreshToken)
+		require.Equal(t, "refresher", current.UserAgent)
+		require.True(t, old.FamilyExpiredAt.Equal(current.FamilyExpiredAt))
+
+		_, err = issuer.Refresh(ctx, second.RefreshToken, Client{})
+		require.Nil(t, err)
+	})
+
+	t.Run("fail_reuse_revokes_the_family", func(t *testing.T) {
+		issuer, repo, user, _ := newIssuer(t, Config{})
+
+		first, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		other, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		second, err := issuer.Refresh(ctx, first.RefreshToken, Client{})
+		require.Nil(t, err)
+
+		_, err = issuer.Refresh(ctx, first.RefreshToken, Client{})
+		require.Equal(t, ErrTokenReused, err)
+
+		_, err = issuer.Refresh(ctx, second.RefreshToken, Client{})
+		require.Equal(t, ErrInvalidToken, err)
+
+		rows, total, err := repo.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+			UserID: user.ID,
+			Active: share.Boolean{IsSet: true, Bool: true},
+		}, storage.SortTokenHistory{})
+		require.Nil(t, err)
+		require.Equal(t, int64(1), total)
+		require.Equal(t, other.Claims.ID, rows[0].UID)
+	})
+
+	t.Run("fail_refresh_after_idle_lifetime", func(t *testing.T) {
+		issuer, _, user, advance := newIssuer(t, Config{RefreshIdleTTL: time.Hour})
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		advance(time.Hour)
+		_, err = issuer.Refresh(ctx, tok.RefreshToken, Client{})
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("fail_refresh_after_absolute_lifetime", func(t *testing.T) {
+		issuer, _, user, advance := newIssuer(t, Config{RefreshIdleTTL: time.Hour, RefreshAbsoluteTTL: 90 * time.Minute})
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		require.Equal(t, time.Hour, tok.RefreshExpiresAt.Sub(tok.Claims.IssuedAt.Time))
+
+		advance(50 * time.Minute)
+		tok, err = issuer.Refresh(ctx, tok.RefreshToken, Client{})
+		require.Nil(t, err)
+		require.Equal(t, 40*time.Minute, tok.RefreshExpiresAt.Sub(tok.Claims.IssuedAt.Time))
+
+		advance(45 * time.Minute)
+		_, err = issuer.Refresh(ctx, tok.RefreshToken, Client{})
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("fail_refresh_for_an_inactive_user", func(t *testing.T) {
+		issuer, repo, user, _ := newIssuer(t, Config{})
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		require.Nil(t, repo.Users().Update(ctx, storage.UpdateUser{ID: user.ID, Active: share.Boolean{IsSet: true}}))
+
+		_, err = issuer.Refresh(ctx, tok.RefreshToken, Client{})
+		require.Equal(t, ErrInvalidToken, err)
+
+		th, err := repo.TokenHistories().Get(ctx, tok.Claims.ID)
+		require.Nil(t, err)
+		require.False(t, th.RevokedAt.IsZero())
+	})
+
+	t.Run("fail_refresh_unknown_or_forged_tokens", func(t *testing.T) {
+		issuer, repo, user, _ := newIssuer(t, Config{})
+
+		tok, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+
+		for _, forged := range []string{"", "nope", share.NewUID() + ".secret", tok.Claims.ID + ".secret", tok.Claims.ID} {
+			_, err = issuer.Refresh(ctx, forged, Client{})
+			require.Equal(t, ErrInvalidToken, err, forged)
+		}
+
+		th, err := repo.TokenHistories().Get(ctx, tok.Claims.ID)
+		require.Nil(t, err)
+		require.True(t, th.RotatedAt.IsZero())
+		require.True(t, th.RevokedAt.IsZero())
+	})
+}
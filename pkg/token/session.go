@@ -0,0 +1,157 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/useragent"
+)
+
+// Session is a login of a user, the family of tokens refreshed from it, identified by the family id. A session
+// is live while one of its tokens grants access or can still be refreshed. Its client is the one of its
+// latest token.
+type Session struct {
+	ID         string
+	UserID     int64
+	IP         string
+	UserAgent  string
+	Agent      useragent.UserAgent
+	SignedInAt time.Time
+	LastUsedAt time.Time
+	ExpiresAt  time.Time
+}
+
+// Sessions returns the live sessions of a user, the most recently used first
+func (i *Issuer) Sessions(ctx context.Context, userID int64) ([]*Session, error) {
+	rows, err := i.liveTokens(ctx, storage.QueryTokenHistory{UserID: userID})
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := make(map[string]*Session)
+	for _, th := range rows {
+		s, ok := sessions[th.FamilyID]
+		if !ok {
+			s = &Session{ID: th.FamilyID, UserID: th.UserID, SignedInAt: th.CreatedAt}
+			sessions[th.FamilyID] = s
+		}
+
+		if th.CreatedAt.Before(s.SignedInAt) {
+			s.SignedInAt = th.CreatedAt
+		}
+		if !th.CreatedAt.Before(s.LastUsedAt) {
+			s.LastUsedAt = th.CreatedAt
+			s.IP = i.clientIP(th)
+			s.UserAgent = th.UserAgent
+			s.Agent = useragent.Parse(th.UserAgent)
+		}
+		if th.ExpiredAt.After(s.ExpiresAt) {
+			s.ExpiresAt = th.ExpiredAt
+		}
+		if th.RotatedAt.IsZero() && th.RefreshExpiredAt.After(s.ExpiresAt) {
+			s.ExpiresAt = th.RefreshExpiredAt
+		}
+	}
+
+	list := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		// the login itself is no longer live once refreshed, it tells when the session started while recorded
+		root, err := i.repo.TokenHistories().Get(ctx, s.ID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		if err == nil && root.CreatedAt.Before(s.SignedInAt) {
+			s.SignedInAt = root.CreatedAt
+		}
+
+		list = append(list, s)
+	}
+
+	sort.Slice(list, func(a, b int) bool {
+		if !list[a].LastUsedAt.Equal(list[b].LastUsedAt) {
+			return list[a].LastUsedAt.After(list[b].LastUsedAt)
+		}
+		return list[a].ID < list[b].ID
+	})
+
+	return list, nil
+}
+
+// RevokeSession signs a user out of one of its live sessions, storage.ErrNotFound is returned when the user
+// has no such session
+func (i *Issuer) RevokeSession(ctx context.Context, userID int64, sessionID string) error {
+	_, total, err := i.repo.TokenHistories().Query(ctx, storage.QueryTokenHistory{
+		UserID:   userID,
+		FamilyID: sessionID,
+		Live:     share.Boolean{IsSet: true, Bool: true},
+		Limit:    1,
+	}, storage.SortTokenHistory{})
+	if err != nil {
+		return err
+	}
+	if total == 0 {
+		return storage.ErrNotFound
+	}
+
+	_, err = i.repo.TokenHistories().RevokeFamily(ctx, sessionID)
+	return err
+}
+
+// RevokeSessions signs a user out of every session but keep and returns how many tokens were revoked. Every
+// token of the user is revoked when keep is empty.
+func (i *Issuer) RevokeSessions(ctx context.Context, userID int64, keep string) (int64, error) {
+	if len(keep) == 0 {
+		return i.repo.TokenHistories().RevokeUser(ctx, userID)
+	}
+
+	sessions, err := i.Sessions(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	var revoked int64
+	for _, s := range sessions {
+		if s.ID == keep {
+			continue
+		}
+
+		n, err := i.repo.TokenHistories().RevokeFamily(ctx, s.ID)
+		if err != nil {
+			return revoked, err
+		}
+		revoked += n
+	}
+
+	return revoked, nil
+}
+
+// liveTokens returns every live token matching query
+func (i *Issuer) liveTokens(ctx context.Context, query storage.QueryTokenHistory) ([]*storage.TokenHistory, error) {
+	query.Live = share.Boolean{IsSet: true, Bool: true}
+	query.Limit = share.DefaultLimit
+
+	var tokens []*storage.TokenHistory
+	for {
+		rows, total, err := i.repo.TokenHistories().Query(ctx, query, storage.SortTokenHistory{})
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, rows...)
+
+		query.Offset += int64(len(rows))
+		if len(rows) == 0 || query.Offset >= total {
+			break
+		}
+	}
+
+	return tokens, nil
+}
+
+// clientIP returns the address of the client a token was issued to
+func (i *Issuer) clientIP(th *storage.TokenHistory) string {
+	return i.ClientIP(Client{RemoteAddr: th.RemoteAddr, XForwardedFor: th.XForwardedFor, XRealIP: th.XRealIP})
+}
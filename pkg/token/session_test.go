@@ -0,0 +1,189 @@
+package token
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/useragent"
+)
+
+const (
+	testChrome  = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36"
+	testFirefox = "Mozilla/5.0 (X11; Linux x86_64; rv:109.0) Gecko/20100101 Firefox/118.0"
+)
+
+func TestIssuer_Sessions(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	newIssuer := func(t *testing.T) (*Issuer, storage.Repository, *storage.User) {
+		repo, user := newTestRepo(t)
+		signer, err := NewHS256Signer(testSecret)
+		require.Nil(t, err)
+		proxies, err := share.ParseProxies("10.0.0.0/8")
+		require.Nil(t, err)
+
+		return NewIssuer(repo, signer, Config{TrustedProxies: proxies}), repo, user
+	}
+
+	otherUser := func(t *testing.T, repo storage.Repository) *storage.User {
+		id, err := repo.Users().Insert(ctx, storage.CreateUser{Username: "bob", Email: "bob@test.com"})
+		require.Nil(t, err)
+		other, err := repo.Users().Get(ctx, id)
+		require.Nil(t, err)
+
+		return other
+	}
+
+	t.Run("success_list_live_sessions", func(t *testing.T) {
+		issuer, repo, user := newIssuer(t)
+
+		desktop, err := issuer.Issue(ctx, user, Client{RemoteAddr: "10.0.0.1:52100", UserAgent: testChrome})
+		require.Nil(t, err)
+		laptop, err := issuer.Issue(ctx, user, Client{RemoteAddr: "10.0.0.2:52100", XRealIP: "198.51.100.7",
+			UserAgent: testFirefox})
+		require.Nil(t, err)
+		_, err = issuer.Issue(ctx, otherUser(t, repo), Client{})
+		require.Nil(t, err)
+
+		refreshed, err := issuer.Refresh(ctx, desktop.RefreshToken, Client{RemoteAddr: "10.0.0.1:52100",
+			XForwardedFor: "203.0.113.9, 10.0.0.254", UserAgent: testChrome})
+		require.Nil(t, err)
+
+		sessions, err := issuer.Sessions(ctx, user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 2)
+
+		current := sessions[0]
+		require.Equal(t, desktop.Claims.ID, current.ID)
+		require.Equal(t, user.ID, current.UserID)
+		require.Equal(t, "203.0.113.9", current.IP)
+		require.Equal(t, testChrome, current.UserAgent)
+		require.Equal(t, useragent.UserAgent{Browser: "Chrome", BrowserVersion: "118", OS: "Windows", OSVersion: "10"},
+			current.Agent)
+		require.True(t, current.SignedInAt.Before(current.LastUsedAt))
+		require.True(t, refreshed.RefreshExpiresAt.Equal(current.ExpiresAt))
+
+		require.Equal(t, laptop.Claims.ID, sessions[1].ID)
+		require.Equal(t, "198.51.100.7", sessions[1].IP)
+		require.Equal(t, "Firefox", sessions[1].Agent.Browser)
+	})
+
+	t.Run("success_revoke_a_session", func(t *testing.T) {
+		issuer, repo, user := newIssuer(t)
+
+		kept, err := issuer.Issue(ctx, user, Client{RemoteAddr: "10.0.0.1"})
+		require.Nil(t, err)
+		revoked, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		foreign, err := issuer.Issue(ctx, otherUser(t, repo), Client{})
+		require.Nil(t, err)
+
+		require.Nil(t, issuer.RevokeSession(ctx, user.ID, revoked.Claims.ID))
+
+		sessions, err := issuer.Sessions(ctx, user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, kept.Claims.ID, sessions[0].ID)
+		require.Equal(t, "10.0.0.1", sessions[0].IP)
+
+		_, err = issuer.Refresh(ctx, revoked.RefreshToken, Client{})
+		require.Equal(t, ErrInvalidToken, err)
+
+		err = issuer.RevokeSession(ctx, user.ID, revoked.Claims.ID)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+		err = issuer.RevokeSession(ctx, user.ID, foreign.Claims.ID)
+		require.True(t, errors.Is(err, storage.ErrNotFound))
+	})
+
+	t.Run("success_revoke_other_sessions", func(t *testing.T) {
+		issuer, repo, user := newIssuer(t)
+
+		current, err := issuer.Issue(ctx, user, Client{})
+		require.Nil(t, err)
+		for i := 0; i < 2; i++ {
+			_, err = issuer.Issue(ctx, user, Client{})
+			require.Nil(t, err)
+		}
+		foreign, err := issuer.Issue(ctx, otherUser(t, repo), Client{})
+		require.Nil(t, err)
+
+		revoked, err := issuer.RevokeSessions(ctx, user.ID, current.Claims.ID)
+		require.Nil(t, err)
+		require.Equal(t, int64(2), revoked)
+
+		sessions, err := issuer.Sessions(ctx, user.ID)
+		require.Nil(t, err)
+		require.Len(t, sessions, 1)
+		require.Equal(t, current.Claims.ID, sessions[0].ID)
+
+		// signing out everywhere spares the current session too
+		revoked, err = issuer.RevokeSessions(ctx, user.ID, "")
+		require.Nil(t, err)
+		require.Equal(t, int64(1), revoked)
+
+		sessions, err = issuer.Sessions(ctx, user.ID)
+		require.Nil(t, err)
+		require.Empty(t, sessions)
+
+		info, err := issuer.Introspect(ctx, current.AccessToken)
+		require.Nil(t, err)
+		require.False(t, info.Active)
+
+		info, err = issuer.Introspect(ctx, foreign.AccessToken)
+		require.Nil(t, err)
+		require.True(t, info.Active)
+		require.Equal(t, foreign.Claims.ID, info.SessionID)
+	})
+}
+
+func TestClientIP(t *testing.T) {
+	t.Parallel()
+
+	proxies, err := share.ParseProxies("10.0.0.0/8, ::1")
+	require.Nil(t, err)
+	issuer := &Issuer{config: Config{TrustedProxies: proxies}}
+
+	t.Run("success_believe_trusted_proxies_only", func(t *testing.T) {
+		tests := []struct {
+			th   storage.TokenHistory
+			want string
+		}{
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1:4000"}, "10.0.0.1"},
+			{storage.TokenHistory{RemoteAddr: "[::1]:4000"}, "::1"},
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1"}, "10.0.0.1"},
+			{storage.TokenHistory{RemoteAddr: "192.0.2.9:4000", XRealIP: "192.0.2.1",
+				XForwardedFor: "192.0.2.2"}, "192.0.2.9"},
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1:4000", XRealIP: "192.0.2.1"}, "192.0.2.1"},
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1:4000", XRealIP: "192.0.2.1",
+				XForwardedFor: "192.0.2.2, 10.0.0.3"}, "192.0.2.2"},
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1:4000",
+				XForwardedFor: "198.51.100.7, 192.0.2.2, 10.0.0.3"}, "192.0.2.2"},
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1:4000", XForwardedFor: "10.0.0.2, 10.0.0.3"}, "10.0.0.2"},
+			{storage.TokenHistory{RemoteAddr: "10.0.0.1:4000", XForwardedFor: "forged, 10.0.0.3"}, "10.0.0.3"},
+			{storage.TokenHistory{}, ""},
+		}
+
+		for _, test := range tests {
+			th := test.th
+			require.Equal(t, test.want, issuer.clientIP(&th))
+		}
+	})
+
+	t.Run("success_trust_no_proxy_by_default", func(t *testing.T) {
+		th := storage.TokenHistory{RemoteAddr: "10.0.0.1:4000", XRealIP: "192.0.2.1", XForwardedFor: "192.0.2.2"}
+		require.Equal(t, "10.0.0.1", (&Issuer{}).clientIP(&th))
+	})
+
+	t.Run("fail_parse_invalid_proxies", func(t *testing.T) {
+		_, err := share.ParseProxies("10.0.0.1, proxy.example.org")
+		require.NotNil(t, err)
+
+		_, err = share.ParseProxies("10.0.0.0/33")
+		require.NotNil(t, err)
+	})
+}
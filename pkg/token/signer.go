@@ -0,0 +1,138 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// signing algorithms, named as in the alg header of the tokens
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	// minSecretLength is the shortest HS256 secret accepted, the size of the SHA-256 output
+	minSecretLength = 32
+
+	// minRSABits is the smallest RSA modulus accepted
+	minRSABits = 2048
+)
+
+var (
+	// ErrUnknownAlgorithm is returned for signing algorithms this package does not implement
+	ErrUnknownAlgorithm = errors.New("token: unknown signing algorithm")
+
+	// ErrWeakKey is returned for signing keys too short to be safe
+	ErrWeakKey = errors.New("token: signing key is too weak")
+)
+
+// Signer signs tokens with one algorithm and key, and verifies the tokens it signed. A Signer is also the
+// KeySet of its only key, whose tokens carry no kid header.
+type Signer struct {
+	method jwt.SigningMethod
+	key    interface{}
+	public interface{}
+	kid    string
+}
+
+// NewHS256Signer creates a Signer sharing secret between signing and verification, secret has at least 32 bytes
+func NewHS256Signer(secret []byte) (*Signer, error) {
+	if len(secret) < minSecretLength {
+		return nil, ErrWeakKey
+	}
+
+	return &Signer{method: jwt.SigningMethodHS256, key: secret, public: secret}, nil
+}
+
+// NewRS256Signer creates a Signer from a RSA private key of at least 2048 bits
+func NewRS256Signer(key *rsa.PrivateKey) (*Signer, error) {
+	if key.N.BitLen() < minRSABits {
+		return nil, ErrWeakKey
+	}
+
+	return &Signer{method: jwt.SigningMethodRS256, key: key, public: &key.PublicKey}, nil
+}
+
+// NewEdDSASigner creates a Signer from an Ed25519 private key
+func NewEdDSASigner(key ed25519.PrivateKey) (*Signer, error) {
+	if len(key) != ed25519.PrivateKeySize {
+		return nil, ErrWeakKey
+	}
+
+	return &Signer{method: jwt.SigningMethodEdDSA, key: key, public: key.Public()}, nil
+}
+
+// ParseSigner creates a Signer for algorithm from key, the raw secret for HS256 and a PEM encoded private key,
+// PKCS #1 or PKCS #8, for RS256 and EdDSA
+func ParseSigner(algorithm string, key []byte) (*Signer, error) {
+	switch strings.ToUpper(algorithm) {
+	case HS256:
+		return NewHS256Signer(key)
+
+	case RS256:
+		rsaKey, err := jwt.ParseRSAPrivateKeyFromPEM(key)
+		if err != nil {
+			return nil, fmt.Errorf("token: invalid RS256 key: %w", err)
+		}
+		return NewRS256Signer(rsaKey)
+
+	case strings.ToUpper(EdDSA):
+		edKey, err := jwt.ParseEdPrivateKeyFromPEM(key)
+		if err != nil {
+			return nil, fmt.Errorf("token: invalid EdDSA key: %w", err)
+		}
+		return NewEdDSASigner(edKey.(ed25519.PrivateKey))
+	}
+
+	return nil, fmt.Errorf("%w: %q", ErrUnknownAlgorithm, algorithm)
+}
+
+// Algorithm returns the alg header of the tokens signed by s
+func (s *Signer) Algorithm() string {
+	return s.method.Alg()
+}
+
+// KeyID returns the kid header of the tokens signed by s, empty for the keys which are not stored
+func (s *Signer) KeyID() string {
+	return s.kid
+}
+
+// Current returns s, it implements KeySet
+func (s *Signer) Current(ctx context.Context) (*Signer, error) {
+	return s, nil
+}
+
+// Lookup returns s when kid is its key id, it implements KeySet
+func (s *Signer) Lookup(ctx context.Context, kid string) (*Signer, error) {
+	if kid != s.kid {
+		return nil, ErrInvalidToken
+	}
+
+	return s, nil
+}
+
+// Published returns s unless its key is a shared secret, it implements KeySet
+func (s *Signer) Published(ctx context.Context) ([]*Signer, error) {
+	if s.Algorithm() == HS256 {
+		return nil, nil
+	}
+
+	return []*Signer{s}, nil
+}
+
+func (s *Signer) sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(s.method, claims)
+	if len(s.kid) > 0 {
+		token.Header["kid"] = s.kid
+	}
+
+	return token.SignedString(s.key)
+}
@@ -0,0 +1,502 @@
+// Package token mints the signed access tokens of the service and the refresh tokens renewing them.
+//
+// Access tokens are JWTs carrying the user's id as subject, its username, the names of its active bunches and
+// the names of the keys those bunches grant. Every issued token is recorded in token_histories with its id as
+// uid. The recorded access_token and refresh_token are SHA-256 digests of the tokens, so the table never holds
+// usable credentials.
+//
+// Refresh tokens are single-use. Refreshing exchanges one for a new access token and a new refresh token of the
+// same family, the tokens descending from one login. A refresh token which is presented again after its
+// exchange was likely stolen, the whole family is then revoked. A refresh token expires when it was not used
+// for the idle lifetime, and no family is refreshed beyond the absolute lifetime after its login.
+package token
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+)
+
+// ErrInvalidToken is returned for tokens which are malformed, badly signed, expired or meant for someone else
+var ErrInvalidToken = errors.New("token: invalid token")
+
+// environment variables read by KeysFromEnv and ConfigFromEnv
+const (
+	AlgorithmEnv          = "AUTH_TOKEN_ALGORITHM"
+	KeyEnv                = "AUTH_TOKEN_KEY"
+	KeyFileEnv            = "AUTH_TOKEN_KEY_FILE"
+	TTLEnv                = "AUTH_TOKEN_TTL"
+	IssuerEnv             = "AUTH_TOKEN_ISSUER"
+	AudienceEnv           = "AUTH_TOKEN_AUDIENCE"
+	RefreshIdleTTLEnv     = "AUTH_TOKEN_REFRESH_IDLE_TTL"
+	RefreshAbsoluteTTLEnv = "AUTH_TOKEN_REFRESH_ABSOLUTE_TTL"
+	EncryptionKeyEnv      = "AUTH_TOKEN_ENCRYPTION_KEY"
+	RotationPeriodEnv     = "AUTH_TOKEN_ROTATION_PERIOD"
+	RotationOverlapEnv    = "AUTH_TOKEN_ROTATION_OVERLAP"
+	TrustedProxiesEnv     = "AUTH_TRUSTED_PROXIES"
+)
+
+// defaults of Config
+const (
+	DefaultTTL                = 15 * time.Minute
+	DefaultIssuer             = "auth_service"
+	DefaultRefreshIdleTTL     = 7 * 24 * time.Hour
+	DefaultRefreshAbsoluteTTL = 30 * 24 * time.Hour
+)
+
+// maxClientField is the size of the client columns of token_histories
+const maxClientField = 512
+
+// Config of the issued tokens
+type Config struct {
+	// TTL is how long tokens are valid, DefaultTTL when zero
+	TTL time.Duration
+
+	// Issuer is the iss claim, DefaultIssuer when empty
+	Issuer string
+
+	// Audience is the aud claim, verified tokens must name one of them
+	Audience []string
+
+	// RefreshIdleTTL is how long a refresh token stays usable, DefaultRefreshIdleTTL when zero
+	RefreshIdleTTL time.Duration
+
+	// RefreshAbsoluteTTL is how long tokens can be refreshed after the login, DefaultRefreshAbsoluteTTL when zero
+	RefreshAbsoluteTTL time.Duration
+
+	// TrustedProxies are the proxies whose forwarding headers tell the address of a client, none when empty
+	TrustedProxies share.Proxies
+}
+
+// Claims of the access tokens
+type Claims struct {
+	jwt.RegisteredClaims
+	Username string   `json:"username"`
+	Bunches  []string `json:"bunches"`
+	Keys     []string `json:"keys"`
+}
+
+// UserID returns the user id held by the subject
+func (c *Claims) UserID() (int64, error) {
+	id, err := strconv.ParseInt(c.Subject, 10, 64)
+	if err != nil {
+		return 0, ErrInvalidToken
+	}
+
+	return id, nil
+}
+
+// Client describes where a token was requested from, it is recorded with the token
+type Client struct {
+	RemoteAddr    string
+	XForwardedFor string
+	XRealIP       string
+	UserAgent     string
+}
+
+// Token is an issued access token and the refresh token to exchange for the next one
+type Token struct {
+	AccessToken      string
+	ExpiresAt        time.Time
+	RefreshToken     string
+	RefreshExpiresAt time.Time
+	Claims           *Claims
+}
+
+// KeySet holds the keys of an Issuer: the one signing new tokens and those verifying the tokens signed before
+type KeySet interface {
+	// Current returns the signer of new tokens
+	Current(ctx context.Context) (*Signer, error)
+
+	// Lookup returns the signer of the key named by the kid header of a token, ErrInvalidToken when the key
+	// is unknown or expired
+	Lookup(ctx context.Context, kid string) (*Signer, error)
+
+	// Published returns the signers whose public keys verify tokens, or will soon
+	Published(ctx context.Context) ([]*Signer, error)
+}
+
+// Issuer mints and verifies access tokens
+type Issuer struct {
+	repo   storage.Repository
+	keys   KeySet
+	config Config
+	now    func() time.Time
+}
+
+// NewIssuer creates new instance of Issuer, keys is a single *Signer or a *KeyRing
+func NewIssuer(repo storage.Repository, keys KeySet, config Config) *Issuer {
+	if config.TTL <= 0 {
+		config.TTL = DefaultTTL
+	}
+	if len(config.Issuer) == 0 {
+		config.Issuer = DefaultIssuer
+	}
+	if config.RefreshIdleTTL <= 0 {
+		config.RefreshIdleTTL = DefaultRefreshIdleTTL
+	}
+	if config.RefreshAbsoluteTTL <= 0 {
+		config.RefreshAbsoluteTTL = DefaultRefreshAbsoluteTTL
+	}
+
+	return &Issuer{
+		repo:   repo,
+		keys:   keys,
+		config: config,
+		now:    time.Now,
+	}
+}
+
+// NewIssuerFromEnv creates an Issuer whose keys and configuration are read from the environment, see
+// KeysFromEnv and ConfigFromEnv
+func NewIssuerFromEnv(repo storage.Repository) (*Issuer, error) {
+	keys, err := KeysFromEnv(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	config, err := ConfigFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewIssuer(repo, keys, config), nil
+}
+
+// KeysFromEnv creates the KeySet configured by the environment. When $AUTH_TOKEN_ENCRYPTION_KEY holds a
+// base64 encoded 32 bytes key, the keys are generated and rotated by a KeyRing: $AUTH_TOKEN_ALGORITHM names
+// their algorithm, EdDSA by default, and $AUTH_TOKEN_ROTATION_PERIOD and $AUTH_TOKEN_ROTATION_OVERLAP are
+// durations. Otherwise the single key is read from the file named by $AUTH_TOKEN_KEY_FILE, or else from
+// $AUTH_TOKEN_KEY, and $AUTH_TOKEN_ALGORITHM defaults to HS256.
+func KeysFromEnv(repo storage.Repository) (KeySet, error) {
+	algorithm := os.Getenv(AlgorithmEnv)
+
+	if encoded := os.Getenv(EncryptionKeyEnv); len(encoded) > 0 {
+		encryptionKey, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("token: invalid $%s: %w", EncryptionKeyEnv, err)
+		}
+
+		config := KeyRingConfig{Algorithm: algorithm}
+		if err := parseDurations(map[string]*time.Duration{
+			RotationPeriodEnv:  &config.RotationPeriod,
+			RotationOverlapEnv: &config.Overlap,
+		}); err != nil {
+			return nil, err
+		}
+
+		return NewKeyRing(repo, encryptionKey, config)
+	}
+
+	if len(algorithm) == 0 {
+		algorithm = HS256
+	}
+
+	key := []byte(os.Getenv(KeyEnv))
+	if file := os.Getenv(KeyFileEnv); len(file) > 0 {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("token: read key: %w", err)
+		}
+		key = data
+	}
+
+	return ParseSigner(algorithm, key)
+}
+
+// ConfigFromEnv reads the Config of the tokens from the environment. $AUTH_TOKEN_TTL,
+// $AUTH_TOKEN_REFRESH_IDLE_TTL and $AUTH_TOKEN_REFRESH_ABSOLUTE_TTL are durations, $AUTH_TOKEN_AUDIENCE a
+// comma separated list, $AUTH_TRUSTED_PROXIES a comma separated list of addresses and CIDR networks.
+func ConfigFromEnv() (Config, error) {
+	config := Config{Issuer: os.Getenv(IssuerEnv)}
+	if err := parseDurations(map[string]*time.Duration{
+		TTLEnv:                &config.TTL,
+		RefreshIdleTTLEnv:     &config.RefreshIdleTTL,
+		RefreshAbsoluteTTLEnv: &config.RefreshAbsoluteTTL,
+	}); err != nil {
+		return config, err
+	}
+	for _, aud := range strings.Split(os.Getenv(AudienceEnv), ",") {
+		if aud = strings.TrimSpace(aud); len(aud) > 0 {
+			config.Audience = append(config.Audience, aud)
+		}
+	}
+
+	proxies, err := share.ParseProxies(os.Getenv(TrustedProxiesEnv))
+	if err != nil {
+		return config, fmt.Errorf("token: invalid $%s: %w", TrustedProxiesEnv, err)
+	}
+	config.TrustedProxies = proxies
+
+	return config, nil
+}
+
+// parseDurations sets the durations held by the environment variables, unset variables are skipped
+func parseDurations(durations map[string]*time.Duration) error {
+	for env, d := range durations {
+		value := os.Getenv(env)
+		if len(value) == 0 {
+			continue
+		}
+
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("token: invalid $%s: %w", env, err)
+		}
+		*d = parsed
+	}
+
+	return nil
+}
+
+// ClientIP returns the address of the client, its forwarding headers are only believed when sent by one of the
+// trusted proxies
+func (i *Issuer) ClientIP(client Client) string {
+	return i.config.TrustedProxies.ClientIP(client.RemoteAddr, client.XForwardedFor, client.XRealIP)
+}
+
+// Issue mints an access token and a refresh token starting a new family for user, and records them. The
+// caller is expected to have verified the user's credentials, an inactive user gets a token without keys.
+func (i *Issuer) Issue(ctx context.Context, user *storage.User, client Client) (*Token, error) {
+	now := i.now().Truncate(time.Second)
+
+	tok, history, err := i.mint(ctx, i.repo, user, client, now, "", now.Add(i.config.RefreshAbsoluteTTL))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := i.repo.TokenHistories().Insert(ctx, history); err != nil {
+		return nil, err
+	}
+
+	return tok, nil
+}
+
+// mint signs the tokens of user and returns them with their history row, which joins familyID or starts a
+// family when familyID is empty
+func (i *Issuer) mint(ctx context.Context, stores storage.Stores, user *storage.User, client Client, now time.Time,
+	familyID string, familyExpiredAt time.Time) (*Token, storage.CreateTokenHistory, error) {
+	var history storage.CreateTokenHistory
+
+	bunches, err := bunchesOf(ctx, stores, user)
+	if err != nil {
+		return nil, history, err
+	}
+
+	keys, err := stores.Permissions().Keys(ctx, user.ID)
+	if err != nil {
+		return nil, history, err
+	}
+
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        share.NewUID(),
+			Subject:   strconv.FormatInt(user.ID, 10),
+			Issuer:    i.config.Issuer,
+			Audience:  i.config.Audience,
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(i.config.TTL)),
+		},
+		Username: user.Username,
+		Bunches:  bunches,
+		Keys:     make([]string, 0, len(keys)),
+	}
+	for _, k := range keys {
+		claims.Keys = append(claims.Keys, k.Name)
+	}
+
+	signer, err := i.keys.Current(ctx)
+	if err != nil {
+		return nil, history, err
+	}
+
+	signed, err := signer.sign(claims)
+	if err != nil {
+		return nil, history, err
+	}
+
+	refresh, err := newRefreshToken(claims.ID)
+	if err != nil {
+		return nil, history, err
+	}
+
+	refreshExpiresAt := now.Add(i.config.RefreshIdleTTL)
+	if refreshExpiresAt.After(familyExpiredAt) {
+		refreshExpiresAt = familyExpiredAt
+	}
+
+	history = storage.CreateTokenHistory{
+		UID:              claims.ID,
+		UserID:           user.ID,
+		AccessToken:      Digest(signed),
+		RefreshToken:     Digest(refresh),
+		RemoteAddr:       truncate(client.RemoteAddr),
+		XForwardedFor:    truncate(client.XForwardedFor),
+		XRealIP:          truncate(client.XRealIP),
+		UserAgent:        truncate(client.UserAgent),
+		FamilyID:         familyID,
+		ExpiredAt:        claims.ExpiresAt.Time,
+		RefreshExpiredAt: refreshExpiresAt,
+		FamilyExpiredAt:  familyExpiredAt,
+	}
+
+	tok := &Token{
+		AccessToken:      signed,
+		ExpiresAt:        claims.ExpiresAt.Time,
+		RefreshToken:     refresh,
+		RefreshExpiresAt: refreshExpiresAt,
+		Claims:           claims,
+	}
+
+	return tok, history, nil
+}
+
+// Verify checks the signature, the lifetime, the issuer and the audience of an access token and returns its
+// claims. It does not look at token_histories.
+func (i *Issuer) Verify(ctx context.Context, signed string) (*Claims, error) {
+	claims, err := i.parse(ctx, signed)
+	if err != nil {
+		return nil, err
+	}
+
+	now := i.now()
+	if !claims.VerifyExpiresAt(now, true) || !claims.VerifyNotBefore(now, false) ||
+		!claims.VerifyIssuer(i.config.Issuer, true) || !i.verifyAudience(claims) {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+// parse checks the signature of an access token with the key named by its kid header and returns its
+// claims, whatever their lifetime
+func (i *Issuer) parse(ctx context.Context, signed string) (*Claims, error) {
+	var (
+		claims    = new(Claims)
+		lookupErr error
+	)
+
+	parser := jwt.NewParser(jwt.WithoutClaimsValidation())
+	_, err := parser.ParseWithClaims(signed, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+
+		signer, err := i.keys.Lookup(ctx, kid)
+		if err != nil {
+			lookupErr = err
+			return nil, err
+		}
+
+		// the algorithm is the key's, never the one the token claims
+		if t.Method.Alg() != signer.Algorithm() {
+			return nil, ErrInvalidToken
+		}
+
+		return signer.public, nil
+	})
+	if lookupErr != nil && !errors.Is(lookupErr, ErrInvalidToken) {
+		return nil, lookupErr
+	}
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	return claims, nil
+}
+
+func (i *Issuer) verifyAudience(claims *Claims) bool {
+	if len(i.config.Audience) == 0 {
+		return true
+	}
+
+	for _, aud := range i.config.Audience {
+		if claims.VerifyAudience(aud, true) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Purge deletes the recorded tokens whose access and refresh tokens both expired
+func (i *Issuer) Purge(ctx context.Context) error {
+	_, err := i.repo.TokenHistories().Purge(ctx, i.now())
+	return err
+}
+
+// RunPurge calls Purge every interval until ctx is done, failures are logged and retried at the next tick
+func (i *Issuer) RunPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := i.Purge(ctx); err != nil && ctx.Err() == nil {
+			log.Printf("token: purge token histories: %v", err)
+		}
+	}
+}
+
+// bunchesOf returns the sorted names of the active bunches of user
+func bunchesOf(ctx context.Context, stores storage.Stores, user *storage.User) ([]string, error) {
+	names := make([]string, 0)
+
+	query := storage.QueryUserBunch{
+		UserID:      user.ID,
+		BunchActive: share.Boolean{IsSet: true, Bool: true},
+		Limit:       share.DefaultLimit,
+	}
+	for {
+		rows, total, err := stores.UserBunches().Query(ctx, query, storage.SortUserBunch{})
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ub := range rows {
+			names = append(names, ub.Bunch.Name)
+		}
+
+		query.Offset += int64(len(rows))
+		if len(rows) == 0 || query.Offset >= total {
+			break
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// Digest returns the hex encoded SHA-256 digest of a token, as recorded in token_histories
+func Digest(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// truncate cuts s to the size of a client column, which counts characters
+func truncate(s string) string {
+	if utf8.RuneCountInString(s) <= maxClientField {
+		return s
+	}
+
+	return string([]rune(s)[:maxClientField])
+}
@@ -0,0 +1,240 @@
+package token
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/vespaiach/auth_service/pkg/share"
+	"github.com/vespaiach/auth_service/pkg/storage"
+	"github.com/vespaiach/auth_service/pkg/storage/memory"
+)
+
+var testSecret = []byte("0123456789abcdef0123456789abcdef")
+
+// newTestRepo stores alice, member of the active bunch staff granting read and of the inactive bunch old
+// granting write, and ali whose name is a substring of alice's
+func newTestRepo(t *testing.T) (storage.Repository, *storage.User) {
+	ctx := context.Background()
+	repo := memory.NewRepository(memory.NewDB())
+
+	userID, err := repo.Users().Insert(ctx, storage.CreateUser{Username: "alice", Email: "alice@test.com"})
+	require.Nil(t, err)
+	otherID, err := repo.Users().Insert(ctx, storage.CreateUser{Username: "ali", Email: "ali@test.com"})
+	require.Nil(t, err)
+
+	readID, err := repo.Keys().Insert(ctx, storage.CreateKey{Name: "read"})
+	require.Nil(t, err)
+	writeID, err := repo.Keys().Insert(ctx, storage.CreateKey{Name: "write"})
+	require.Nil(t, err)
+
+	staffID, err := repo.Bunches().Insert(ctx, storage.CreateBunch{Name: "staff"})
+	require.Nil(t, err)
+	oldID, err := repo.Bunches().Insert(ctx, storage.CreateBunch{Name: "old"})
+	require.Nil(t, err)
+	adminID, err := repo.Bunches().Insert(ctx, storage.CreateBunch{Name: "admin"})
+	require.Nil(t, err)
+	require.Nil(t, repo.Bunches().Update(ctx, storage.UpdateBunch{ID: oldID, Active: share.Boolean{IsSet: true}}))
+
+	_, err = repo.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: staffID, KeyID: readID})
+	require.Nil(t, err)
+	_, err = repo.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: oldID, KeyID: writeID})
+	require.Nil(t, err)
+	_, err = repo.BunchKeys().Insert(ctx, storage.BunchKey{BunchID: adminID, KeyID: writeID})
+	require.Nil(t, err)
+
+	_, err = repo.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: staffID})
+	require.Nil(t, err)
+	_, err = repo.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: userID, BunchID: oldID})
+	require.Nil(t, err)
+	_, err = repo.UserBunches().Insert(ctx, storage.CreateUserBunch{UserID: otherID, BunchID: adminID})
+	require.Nil(t, err)
+
+	user, err := repo.Users().Get(ctx, userID)
+	require.Nil(t, err)
+
+	return repo, user
+}
+
+func newTestSigners(t *testing.T) map[string]*Signer {
+	hs, err := NewHS256Signer(testSecret)
+	require.Nil(t, err)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.Nil(t, err)
+	rs, err := NewRS256Signer(rsaKey)
+	require.Nil(t, err)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	require.Nil(t, err)
+	ed, err := NewEdDSASigner(edKey)
+	require.Nil(t, err)
+
+	return map[string]*Signer{HS256: hs, RS256: rs, EdDSA: ed}
+}
+
+func TestIssuer_Issue(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	for alg, signer := range newTestSigners(t) {
+		alg, signer := alg, signer
+
+		t.Run("success_issue_with_"+alg, func(t *testing.T) {
+			repo, user := newTestRepo(t)
+			issuer := NewIssuer(repo, signer, Config{TTL: time.Hour, Issuer: "test", Audience: []string{"api"}})
+
+			client := Client{RemoteAddr: "10.0.0.1:4000", XForwardedFor: "1.2.3.4", XRealIP: "1.2.3.4",
+				UserAgent: strings.Repeat("a", maxClientField+1)}
+			tok, err := issuer.Issue(ctx, user, client)
+			require.Nil(t, err)
+			require.Equal(t, alg, signer.Algorithm())
+
+			claims, err := issuer.Verify(ctx, tok.AccessToken)
+			require.Nil(t, err)
+			require.Equal(t, strconv.FormatInt(user.ID, 10), claims.Subject)
+			require.Equal(t, "alice", claims.Username)
+			require.Equal(t, []string{"staff"}, claims.Bunches)
+			require.Equal(t, []string{"read"}, claims.Keys)
+			require.Equal(t, "test", claims.Issuer)
+			require.Equal(t, time.Hour, claims.ExpiresAt.Sub(claims.IssuedAt.Time))
+
+			id, err := claims.UserID()
+			require.Nil(t, err)
+			require.Equal(t, user.ID, id)
+
+			history, err := repo.TokenHistories().Get(ctx, claims.ID)
+			require.Nil(t, err)
+			require.Equal(t, user.ID, history.UserID)
+			require.Equal(t, Digest(tok.AccessToken), history.AccessToken)
+			require.Equal(t, "10.0.0.1:4000", history.RemoteAddr)
+			require.Equal(t, "1.2.3.4", history.XRealIP)
+			require.Len(t, history.UserAgent, maxClientField)
+			require.True(t, history.ExpiredAt.Equal(tok.ExpiresAt))
+		})
+	}
+}
+
+func TestIssuer_Purge(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	repo, user := newTestRepo(t)
+	signer, err := NewHS256Signer(testSecret)
+	require.Nil(t, err)
+	issuer := NewIssuer(repo, signer, Config{})
+	var advance func(time.Duration)
+	issuer.now, advance = newTestClock()
+
+	tok, err := issuer.Issue(ctx, user, Client{})
+	require.Nil(t, err)
+
+	// the refresh token outlives the access token
+	advance(time.Hour)
+	require.Nil(t, issuer.Purge(ctx))
+	_, err = repo.TokenHistories().Get(ctx, tok.Claims.ID)
+	require.Nil(t, err)
+
+	advance(DefaultRefreshIdleTTL)
+	require.Nil(t, issuer.Purge(ctx))
+	_, err = repo.TokenHistories().Get(ctx, tok.Claims.ID)
+	require.True(t, errors.Is(err, storage.ErrNotFound))
+}
+
+func TestIssuer_Verify(t *testing.T) {
+	t.Parallel()
+	ctx := context.Background()
+
+	signers := newTestSigners(t)
+	repo, user := newTestRepo(t)
+	issuer := NewIssuer(repo, signers[HS256], Config{TTL: time.Minute, Audience: []string{"api"}})
+
+	tok, err := issuer.Issue(ctx, user, Client{})
+	require.Nil(t, err)
+
+	t.Run("fail_verify_expired_token", func(t *testing.T) {
+		later := NewIssuer(repo, signers[HS256], Config{Audience: []string{"api"}})
+		later.now = func() time.Time { return time.Now().Add(2 * time.Minute) }
+
+		_, err := later.Verify(ctx, tok.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("fail_verify_other_audience_or_issuer", func(t *testing.T) {
+		_, err := NewIssuer(repo, signers[HS256], Config{Audience: []string{"web"}}).Verify(ctx, tok.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+
+		_, err = NewIssuer(repo, signers[HS256], Config{Issuer: "other"}).Verify(ctx, tok.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("fail_verify_other_key_or_algorithm", func(t *testing.T) {
+		other, err := NewHS256Signer([]byte(strings.Repeat("x", minSecretLength)))
+		require.Nil(t, err)
+
+		_, err = NewIssuer(repo, other, Config{Audience: []string{"api"}}).Verify(ctx, tok.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+
+		_, err = NewIssuer(repo, signers[EdDSA], Config{Audience: []string{"api"}}).Verify(ctx, tok.AccessToken)
+		require.Equal(t, ErrInvalidToken, err)
+	})
+
+	t.Run("fail_verify_tampered_token", func(t *testing.T) {
+		parts := strings.Split(tok.AccessToken, ".")
+		unsigned := "eyJhbGciOiJub25lIiwidHlwIjoiSldUIn0." + parts[1] + "."
+
+		_, err := issuer.Verify(ctx, unsigned)
+		require.Equal(t, ErrInvalidToken, err)
+
+		_, err = issuer.Verify(ctx, parts[0]+"."+parts[1]+"x."+parts[2])
+		require.Equal(t, ErrInvalidToken, err)
+	})
+}
+
+func TestParseSigner(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_parse_pem_keys", func(t *testing.T) {
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+		require.Nil(t, err)
+		rsaPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+
+		signer, err := ParseSigner("rs256", rsaPEM)
+		require.Nil(t, err)
+		require.Equal(t, RS256, signer.Algorithm())
+
+		_, edKey, err := ed25519.GenerateKey(rand.Reader)
+		require.Nil(t, err)
+		der, err := x509.MarshalPKCS8PrivateKey(edKey)
+		require.Nil(t, err)
+
+		signer, err = ParseSigner(EdDSA, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+		require.Nil(t, err)
+		require.Equal(t, EdDSA, signer.Algorithm())
+	})
+
+	t.Run("fail_parse_weak_or_unknown_keys", func(t *testing.T) {
+		_, err := ParseSigner(HS256, []byte("short"))
+		require.Equal(t, ErrWeakKey, err)
+
+		rsaKey, err := rsa.GenerateKey(rand.Reader, 1024)
+		require.Nil(t, err)
+		_, err = NewRS256Signer(rsaKey)
+		require.Equal(t, ErrWeakKey, err)
+
+		_, err = ParseSigner(RS256, []byte("not a pem"))
+		require.NotNil(t, err)
+
+		_, err = ParseSigner("none", testSecret)
+		require.True(t, errors.Is(err, ErrUnknownAlgorithm))
+	})
+}
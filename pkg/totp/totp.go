@@ -0,0 +1,137 @@
+// Package totp implements the time-based one-time passwords of RFC 6238 as authenticator apps expect them:
+// HMAC-SHA1 over 30 seconds steps, truncated to 6 digits.
+//
+// Secrets are shared with the app as base32 strings, usually through the otpauth:// URI of a QR code. A code
+// is valid for its step and, to absorb clock drift, for a few steps around it. Callers prevent replays by
+// remembering the step of the last accepted code.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Digits is the length of a code
+	Digits = 6
+
+	// Period is how long a step lasts
+	Period = 30 * time.Second
+
+	// DefaultSkew is how many steps before and after the current one a code is accepted for
+	DefaultSkew = 1
+
+	// secretSize is the size of generated secrets, the 160 bits RFC 4226 recommends for HMAC-SHA1
+	secretSize = 20
+)
+
+// ErrInvalidSecret is returned for secrets which are not base32
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret returns a new random secret, base32 encoded without padding
+func GenerateSecret() (string, error) {
+	key := make([]byte, secretSize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	return encoding.EncodeToString(key), nil
+}
+
+// URI returns the otpauth:// URI which provisions secret in an authenticator app, labelled by the issuer and
+// the account
+func URI(secret string, issuer string, account string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(Digits))
+	query.Set("period", fmt.Sprint(int64(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// Step returns the time step t falls in
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Code returns the code of secret for a time step
+func Code(secret string, step int64) (string, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return "", err
+	}
+
+	return hotp(key, step, Digits), nil
+}
+
+// Validate tells whether code is the code of secret for the step of at or for one of the skew steps around
+// it, and returns the step it matched
+func Validate(secret string, code string, at time.Time, skew int) (int64, bool, error) {
+	key, err := decode(secret)
+	if err != nil {
+		return 0, false, err
+	}
+
+	code = strings.Replace(code, " ", "", -1)
+	if len(code) != Digits {
+		return 0, false, nil
+	}
+
+	current := Step(at)
+	for i := -int64(skew); i <= int64(skew); i++ {
+		step := current + i
+		if subtle.ConstantTimeCompare([]byte(hotp(key, step, Digits)), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// decode decodes a base32 secret, ignoring case, spaces and padding as apps display secrets loosely
+func decode(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.Replace(secret, " ", "", -1))
+	secret = strings.TrimRight(secret, "=")
+
+	key, err := encoding.DecodeString(secret)
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+
+	return key, nil
+}
+
+// hotp computes the HOTP value of RFC 4226 for a counter
+func hotp(key []byte, counter int64, digits int) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(counter))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
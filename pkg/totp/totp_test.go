@@ -0,0 +1,106 @@
+package totp
+
+import (
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// rfcSecret is the base32 encoding of "12345678901234567890", the SHA1 key of the RFC 6238 test vectors
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_match_the_rfc_test_vectors", func(t *testing.T) {
+		key, err := decode(rfcSecret)
+		require.Nil(t, err)
+
+		tests := []struct {
+			unix int64
+			want string
+		}{
+			{59, "94287082"},
+			{1111111109, "07081804"},
+			{1111111111, "14050471"},
+			{1234567890, "89005924"},
+			{2000000000, "69279037"},
+			{20000000000, "65353130"},
+		}
+		for _, tt := range tests {
+			require.Equal(t, tt.want, hotp(key, Step(time.Unix(tt.unix, 0)), 8), "time %d", tt.unix)
+		}
+
+		code, err := Code(rfcSecret, Step(time.Unix(59, 0)))
+		require.Nil(t, err)
+		require.Equal(t, "287082", code)
+	})
+
+	t.Run("fail_code_an_invalid_secret", func(t *testing.T) {
+		_, err := Code("not base32!", 1)
+		require.Equal(t, ErrInvalidSecret, err)
+
+		_, err = Code("", 1)
+		require.Equal(t, ErrInvalidSecret, err)
+	})
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_validate_within_the_skew", func(t *testing.T) {
+		secret, err := GenerateSecret()
+		require.Nil(t, err)
+		require.Len(t, secret, 32)
+
+		now := time.Now()
+		code, err := Code(secret, Step(now)-1)
+		require.Nil(t, err)
+
+		step, ok, err := Validate(secret, code, now, DefaultSkew)
+		require.Nil(t, err)
+		require.True(t, ok)
+		require.Equal(t, Step(now)-1, step)
+
+		// apps show secrets grouped and in lowercase
+		_, ok, err = Validate("gezd gnbv gy3t qojq gezd gnbv gy3t qojq", "287 082", time.Unix(59, 0), 0)
+		require.Nil(t, err)
+		require.True(t, ok)
+	})
+
+	t.Run("fail_validate_outside_the_skew_or_a_wrong_code", func(t *testing.T) {
+		now := time.Now()
+		code, err := Code(rfcSecret, Step(now)-2)
+		require.Nil(t, err)
+
+		_, ok, err := Validate(rfcSecret, code, now, DefaultSkew)
+		require.Nil(t, err)
+		require.False(t, ok)
+
+		for _, code := range []string{"", "12345", "1234567", "abcdef"} {
+			_, ok, err = Validate(rfcSecret, code, now, DefaultSkew)
+			require.Nil(t, err)
+			require.False(t, ok, code)
+		}
+	})
+}
+
+func TestURI(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_build_a_provisioning_uri", func(t *testing.T) {
+		uri, err := url.Parse(URI("JBSWY3DPEHPK3PXP", "Auth Service", "alice@test.com"))
+		require.Nil(t, err)
+		require.Equal(t, "otpauth", uri.Scheme)
+		require.Equal(t, "totp", uri.Host)
+		require.Equal(t, "/Auth Service:alice@test.com", uri.Path)
+
+		query := uri.Query()
+		require.Equal(t, "JBSWY3DPEHPK3PXP", query.Get("secret"))
+		require.Equal(t, "Auth Service", query.Get("issuer"))
+		require.Equal(t, "6", query.Get("digits"))
+		require.Equal(t, "30", query.Get("period"))
+	})
+}
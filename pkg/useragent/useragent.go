@@ -0,0 +1,158 @@
+// Package useragent tells the browser and the operating system of a client from its User-Agent header.
+//
+// It knows the common browsers and operating systems only, which is enough to label a session the way users
+// recognise it ("Chrome 118 on Windows 10"). Unknown agents are left unnamed rather than guessed.
+package useragent
+
+import (
+	"strings"
+)
+
+// UserAgent is what a User-Agent header tells about a client, its fields are empty when unknown
+type UserAgent struct {
+	Browser        string
+	BrowserVersion string
+	OS             string
+	OSVersion      string
+}
+
+// browser names a browser by the product token it announces itself with
+type browser struct {
+	marker string
+	name   string
+}
+
+// browsers in the order they are looked for, browsers built on Chrome or Safari announce those too so they
+// come first
+var browsers = []browser{
+	{"Edg/", "Edge"},
+	{"EdgA/", "Edge"},
+	{"EdgiOS/", "Edge"},
+	{"Edge/", "Edge"},
+	{"OPR/", "Opera"},
+	{"OPiOS/", "Opera"},
+	{"SamsungBrowser/", "Samsung Internet"},
+	{"Vivaldi/", "Vivaldi"},
+	{"Firefox/", "Firefox"},
+	{"FxiOS/", "Firefox"},
+	{"CriOS/", "Chrome"},
+	{"Chrome/", "Chrome"},
+	{"MSIE ", "Internet Explorer"},
+	{"curl/", "curl"},
+	{"Wget/", "Wget"},
+	{"okhttp/", "OkHttp"},
+	{"Go-http-client/", "Go"},
+}
+
+// windows names the releases of Windows NT
+var windows = map[string]string{
+	"10.0": "10",
+	"6.3":  "8.1",
+	"6.2":  "8",
+	"6.1":  "7",
+	"6.0":  "Vista",
+	"5.1":  "XP",
+}
+
+// Parse parses a User-Agent header
+func Parse(header string) UserAgent {
+	var ua UserAgent
+	ua.Browser, ua.BrowserVersion = parseBrowser(header)
+	ua.OS, ua.OSVersion = parseOS(header)
+
+	return ua
+}
+
+// String names the browser and its operating system, the empty string when neither is known
+func (ua UserAgent) String() string {
+	b := strings.TrimSpace(ua.Browser + " " + ua.BrowserVersion)
+	os := strings.TrimSpace(ua.OS + " " + ua.OSVersion)
+
+	switch {
+	case len(b) > 0 && len(os) > 0:
+		return b + " on " + os
+	case len(b) > 0:
+		return b
+	default:
+		return os
+	}
+}
+
+func parseBrowser(header string) (string, string) {
+	for _, b := range browsers {
+		if v, ok := versionAfter(header, b.marker); ok {
+			return b.name, major(v)
+		}
+	}
+
+	// Internet Explorer 11 dropped MSIE for the Trident engine and its revision
+	if strings.Contains(header, "Trident/") {
+		v, _ := versionAfter(header, "rv:")
+		return "Internet Explorer", major(v)
+	}
+
+	// Safari tells its own version apart from the WebKit one it announces as Safari/
+	if strings.Contains(header, "Safari/") {
+		if v, ok := versionAfter(header, "Version/"); ok {
+			return "Safari", major(v)
+		}
+	}
+
+	return "", ""
+}
+
+func parseOS(header string) (string, string) {
+	if v, ok := versionAfter(header, "Windows NT "); ok {
+		return "Windows", windows[v]
+	}
+
+	// iOS announces itself like Mac OS X, it is looked for first
+	if strings.Contains(header, "iPhone") || strings.Contains(header, "iPad") || strings.Contains(header, "iPod") {
+		v, _ := versionAfter(header, " OS ")
+		return "iOS", v
+	}
+
+	if v, ok := versionAfter(header, "Android"); ok {
+		return "Android", strings.TrimSpace(v)
+	}
+
+	if strings.Contains(header, "CrOS") {
+		return "Chrome OS", ""
+	}
+
+	if v, ok := versionAfter(header, "Mac OS X"); ok {
+		return "macOS", strings.TrimSpace(v)
+	}
+
+	if strings.Contains(header, "Linux") {
+		return "Linux", ""
+	}
+
+	return "", ""
+}
+
+// versionAfter returns the dotted version following marker in header, underscores read as dots. It tells
+// whether marker was found, the version may be empty.
+func versionAfter(header string, marker string) (string, bool) {
+	i := strings.Index(header, marker)
+	if i < 0 {
+		return "", false
+	}
+
+	rest := header[i+len(marker):]
+	if strings.HasPrefix(rest, " ") {
+		rest = rest[1:]
+	}
+
+	end := 0
+	for end < len(rest) && (rest[end] >= '0' && rest[end] <= '9' || rest[end] == '.' || rest[end] == '_') {
+		end++
+	}
+
+	return strings.Trim(strings.Replace(rest[:end], "_", ".", -1), "."), true
+}
+
+// major returns the major number of a dotted version
+func major(v string) string {
+	return strings.SplitN(v, ".", 2)[0]
+}
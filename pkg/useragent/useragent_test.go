@@ -0,0 +1,94 @@
+package useragent
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	t.Parallel()
+
+	t.Run("success_parse_common_agents", func(t *testing.T) {
+		tests := []struct {
+			header string
+			want   UserAgent
+			label  string
+		}{
+			{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+				UserAgent{"Chrome", "118", "Windows", "10"},
+				"Chrome 118 on Windows 10",
+			},
+			{
+				"Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 Edg/118.0.2088.46",
+				UserAgent{"Edge", "118", "Windows", "10"},
+				"Edge 118 on Windows 10",
+			},
+			{
+				"Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.0 Safari/605.1.15",
+				UserAgent{"Safari", "17", "macOS", "10.15.7"},
+				"Safari 17 on macOS 10.15.7",
+			},
+			{
+				"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:109.0) Gecko/20100101 Firefox/118.0",
+				UserAgent{"Firefox", "118", "macOS", "10.15"},
+				"Firefox 118 on macOS 10.15",
+			},
+			{
+				"Mozilla/5.0 (iPhone; CPU iPhone OS 17_1 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Mobile/15E148 Safari/604.1",
+				UserAgent{"Safari", "17", "iOS", "17.1"},
+				"Safari 17 on iOS 17.1",
+			},
+			{
+				"Mozilla/5.0 (iPad; CPU OS 16_6 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) CriOS/118.0.5993.92 Mobile/15E148 Safari/604.1",
+				UserAgent{"Chrome", "118", "iOS", "16.6"},
+				"Chrome 118 on iOS 16.6",
+			},
+			{
+				"Mozilla/5.0 (Linux; Android 14; Pixel 8) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.5993.111 Mobile Safari/537.36",
+				UserAgent{"Chrome", "118", "Android", "14"},
+				"Chrome 118 on Android 14",
+			},
+			{
+				"Mozilla/5.0 (Linux; Android 13; SM-S918B) AppleWebKit/537.36 (KHTML, like Gecko) SamsungBrowser/23.0 Chrome/115.0.0.0 Mobile Safari/537.36",
+				UserAgent{"Samsung Internet", "23", "Android", "13"},
+				"Samsung Internet 23 on Android 13",
+			},
+			{
+				"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36 OPR/104.0.0.0",
+				UserAgent{"Opera", "104", "Linux", ""},
+				"Opera 104 on Linux",
+			},
+			{
+				"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/118.0.0.0 Safari/537.36",
+				UserAgent{"Chrome", "118", "Chrome OS", ""},
+				"Chrome 118 on Chrome OS",
+			},
+			{
+				"Mozilla/5.0 (Windows NT 6.1; Trident/7.0; rv:11.0) like Gecko",
+				UserAgent{"Internet Explorer", "11", "Windows", "7"},
+				"Internet Explorer 11 on Windows 7",
+			},
+			{
+				"curl/8.4.0",
+				UserAgent{"curl", "8", "", ""},
+				"curl 8",
+			},
+		}
+
+		for _, test := range tests {
+			ua := Parse(test.header)
+			require.Equal(t, test.want, ua, test.header)
+			require.Equal(t, test.label, ua.String(), test.header)
+		}
+	})
+
+	t.Run("success_parse_unknown_agents", func(t *testing.T) {
+		for _, header := range []string{"", "Mozilla/5.0", "some-robot"} {
+			ua := Parse(header)
+			require.Equal(t, UserAgent{}, ua, header)
+			require.Empty(t, ua.String())
+		}
+	})
+}